@@ -0,0 +1,631 @@
+// anthropic package provides middleware for partial compatibility with the Anthropic Messages API
+package anthropic
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"math/rand"
+	"net/http"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+type Error struct {
+	Type    string `json:"type"`
+	Message string `json:"message"`
+}
+
+type ErrorResponse struct {
+	Type  string `json:"type"`
+	Error Error  `json:"error"`
+}
+
+// ContentBlock is a block of message content. Requests may contain text,
+// image, tool_use and tool_result blocks; responses contain text and tool_use
+// blocks.
+type ContentBlock struct {
+	Type string `json:"type"`
+
+	// text
+	Text *string `json:"text,omitempty"`
+
+	// image
+	Source *ImageSource `json:"source,omitempty"`
+
+	// tool_use
+	ID    string `json:"id,omitempty"`
+	Name  string `json:"name,omitempty"`
+	Input any    `json:"input,omitempty"`
+
+	// tool_result
+	ToolUseID string `json:"tool_use_id,omitempty"`
+	Content   any    `json:"content,omitempty"`
+	IsError   bool   `json:"is_error,omitempty"`
+}
+
+type ImageSource struct {
+	Type      string `json:"type"`
+	MediaType string `json:"media_type"`
+	Data      string `json:"data"`
+}
+
+type Message struct {
+	Role    string `json:"role"`
+	Content any    `json:"content"`
+}
+
+type Tool struct {
+	Name        string          `json:"name"`
+	Description string          `json:"description"`
+	InputSchema json.RawMessage `json:"input_schema"`
+}
+
+type MessagesRequest struct {
+	Model         string    `json:"model"`
+	Messages      []Message `json:"messages"`
+	System        any       `json:"system"`
+	MaxTokens     int       `json:"max_tokens"`
+	StopSequences []string  `json:"stop_sequences"`
+	Stream        bool      `json:"stream"`
+	Temperature   *float64  `json:"temperature"`
+	TopP          *float64  `json:"top_p"`
+	TopK          *int      `json:"top_k"`
+	Tools         []Tool    `json:"tools"`
+}
+
+type Usage struct {
+	InputTokens  int `json:"input_tokens"`
+	OutputTokens int `json:"output_tokens"`
+}
+
+type MessagesResponse struct {
+	ID           string         `json:"id"`
+	Type         string         `json:"type"`
+	Role         string         `json:"role"`
+	Model        string         `json:"model"`
+	Content      []ContentBlock `json:"content"`
+	StopReason   *string        `json:"stop_reason"`
+	StopSequence *string        `json:"stop_sequence"`
+	Usage        Usage          `json:"usage"`
+}
+
+type MessageStartEvent struct {
+	Type    string           `json:"type"`
+	Message MessagesResponse `json:"message"`
+}
+
+type ContentBlockStartEvent struct {
+	Type         string       `json:"type"`
+	Index        int          `json:"index"`
+	ContentBlock ContentBlock `json:"content_block"`
+}
+
+type ContentBlockDeltaEvent struct {
+	Type  string `json:"type"`
+	Index int    `json:"index"`
+	Delta Delta  `json:"delta"`
+}
+
+type Delta struct {
+	Type        string `json:"type"`
+	Text        string `json:"text,omitempty"`
+	PartialJSON string `json:"partial_json,omitempty"`
+}
+
+type ContentBlockStopEvent struct {
+	Type  string `json:"type"`
+	Index int    `json:"index"`
+}
+
+type MessageDeltaEvent struct {
+	Type  string       `json:"type"`
+	Delta MessageDelta `json:"delta"`
+	Usage Usage        `json:"usage"`
+}
+
+type MessageDelta struct {
+	StopReason   *string `json:"stop_reason"`
+	StopSequence *string `json:"stop_sequence"`
+}
+
+type MessageStopEvent struct {
+	Type string `json:"type"`
+}
+
+func NewError(code int, message string) ErrorResponse {
+	var etype string
+	switch code {
+	case http.StatusBadRequest:
+		etype = "invalid_request_error"
+	case http.StatusUnauthorized:
+		etype = "authentication_error"
+	case http.StatusForbidden:
+		etype = "permission_error"
+	case http.StatusNotFound:
+		etype = "not_found_error"
+	case http.StatusTooManyRequests:
+		etype = "rate_limit_error"
+	case http.StatusServiceUnavailable:
+		etype = "overloaded_error"
+	default:
+		etype = "api_error"
+	}
+
+	return ErrorResponse{Type: "error", Error: Error{Type: etype, Message: message}}
+}
+
+func randomID(prefix string) string {
+	const letterBytes = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, 24)
+	for i := range b {
+		b[i] = letterBytes[rand.Intn(len(letterBytes))]
+	}
+	return prefix + string(b)
+}
+
+// contentBlocks returns content, which is either a string or a list of
+// blocks, as a list of blocks
+func contentBlocks(content any) ([]ContentBlock, error) {
+	switch content := content.(type) {
+	case nil:
+		return nil, nil
+	case string:
+		return []ContentBlock{{Type: "text", Text: &content}}, nil
+	case []any:
+		b, err := json.Marshal(content)
+		if err != nil {
+			return nil, err
+		}
+
+		var blocks []ContentBlock
+		if err := json.Unmarshal(b, &blocks); err != nil {
+			return nil, errors.New("invalid message format")
+		}
+		return blocks, nil
+	default:
+		return nil, errors.New("invalid message format")
+	}
+}
+
+// contentText joins the text of content blocks
+func contentText(content any) (string, error) {
+	blocks, err := contentBlocks(content)
+	if err != nil {
+		return "", err
+	}
+
+	var texts []string
+	for _, block := range blocks {
+		if block.Type != "text" || block.Text == nil {
+			return "", errors.New("invalid message format")
+		}
+		texts = append(texts, *block.Text)
+	}
+
+	return strings.Join(texts, "\n\n"), nil
+}
+
+func fromImageSource(source *ImageSource) (api.ImageData, error) {
+	if source == nil || source.Type != "base64" {
+		return nil, errors.New("only base64 image sources are supported")
+	}
+
+	switch source.MediaType {
+	case "image/jpeg", "image/png":
+	default:
+		return nil, errors.New("invalid image input")
+	}
+
+	img, err := base64.StdEncoding.DecodeString(source.Data)
+	if err != nil {
+		return nil, errors.New("invalid image input")
+	}
+
+	return img, nil
+}
+
+func fromMessagesRequest(r MessagesRequest) (*api.ChatRequest, error) {
+	if r.MaxTokens <= 0 {
+		return nil, errors.New("max_tokens is required")
+	}
+
+	var messages []api.Message
+	if r.System != nil {
+		system, err := contentText(r.System)
+		if err != nil {
+			return nil, fmt.Errorf("invalid system prompt: %w", err)
+		}
+
+		messages = append(messages, api.Message{Role: "system", Content: system})
+	}
+
+	for _, msg := range r.Messages {
+		if msg.Role != "user" && msg.Role != "assistant" {
+			return nil, fmt.Errorf("invalid role %q", msg.Role)
+		}
+
+		blocks, err := contentBlocks(msg.Content)
+		if err != nil {
+			return nil, err
+		}
+
+		m := api.Message{Role: msg.Role}
+		var texts []string
+		for _, block := range blocks {
+			switch block.Type {
+			case "text":
+				if block.Text == nil {
+					return nil, errors.New("invalid message format")
+				}
+				texts = append(texts, *block.Text)
+			case "image":
+				img, err := fromImageSource(block.Source)
+				if err != nil {
+					return nil, err
+				}
+				m.Images = append(m.Images, img)
+			case "tool_use":
+				args, ok := block.Input.(map[string]any)
+				if !ok && block.Input != nil {
+					return nil, errors.New("invalid tool_use input")
+				}
+
+				m.ToolCalls = append(m.ToolCalls, api.ToolCall{
+					Function: api.ToolCallFunction{Name: block.Name, Arguments: args},
+				})
+			case "tool_result":
+				result, err := contentText(block.Content)
+				if err != nil {
+					return nil, fmt.Errorf("invalid tool_result: %w", err)
+				}
+
+				// tool results are sent before any other content of the turn
+				messages = append(messages, api.Message{Role: "tool", Content: result})
+			default:
+				return nil, fmt.Errorf("unsupported content block type %q", block.Type)
+			}
+		}
+
+		m.Content = strings.Join(texts, "\n\n")
+		if m.Content != "" || len(m.Images) > 0 || len(m.ToolCalls) > 0 {
+			messages = append(messages, m)
+		}
+	}
+
+	options := map[string]any{
+		"num_predict": r.MaxTokens,
+	}
+
+	if len(r.StopSequences) > 0 {
+		options["stop"] = r.StopSequences
+	}
+
+	if r.Temperature != nil {
+		options["temperature"] = *r.Temperature
+	}
+
+	if r.TopP != nil {
+		options["top_p"] = *r.TopP
+	}
+
+	if r.TopK != nil {
+		options["top_k"] = *r.TopK
+	}
+
+	var tools []api.Tool
+	for _, t := range r.Tools {
+		tool := api.Tool{Type: "function"}
+		tool.Function.Name = t.Name
+		tool.Function.Description = t.Description
+		if len(t.InputSchema) > 0 {
+			if err := json.Unmarshal(t.InputSchema, &tool.Function.Parameters); err != nil {
+				return nil, fmt.Errorf("invalid input_schema for tool %q", t.Name)
+			}
+		}
+		tools = append(tools, tool)
+	}
+
+	return &api.ChatRequest{
+		Model:    r.Model,
+		Messages: messages,
+		Options:  options,
+		Stream:   &r.Stream,
+		Tools:    tools,
+	}, nil
+}
+
+func toStopReason(r api.ChatResponse) *string {
+	var reason string
+	switch {
+	case len(r.Message.ToolCalls) > 0:
+		reason = "tool_use"
+	case r.DoneReason == "length":
+		reason = "max_tokens"
+	case r.StopSequence != "":
+		reason = "stop_sequence"
+	case r.DoneReason != "":
+		reason = "end_turn"
+	default:
+		return nil
+	}
+
+	return &reason
+}
+
+// toStopSequence returns the stop sequence which ended r, if any
+func toStopSequence(r api.ChatResponse) *string {
+	if r.StopSequence == "" || len(r.Message.ToolCalls) > 0 {
+		return nil
+	}
+
+	return &r.StopSequence
+}
+
+func toToolUse(tc api.ToolCall) ContentBlock {
+	input := map[string]any(tc.Function.Arguments)
+	if input == nil {
+		input = map[string]any{}
+	}
+
+	return ContentBlock{Type: "tool_use", ID: randomID("toolu_"), Name: tc.Function.Name, Input: input}
+}
+
+func toMessagesResponse(id string, r api.ChatResponse) MessagesResponse {
+	content := []ContentBlock{}
+	if r.Message.Content != "" {
+		content = append(content, ContentBlock{Type: "text", Text: &r.Message.Content})
+	}
+
+	for _, tc := range r.Message.ToolCalls {
+		content = append(content, toToolUse(tc))
+	}
+
+	return MessagesResponse{
+		ID:           id,
+		Type:         "message",
+		Role:         "assistant",
+		Model:        r.Model,
+		Content:      content,
+		StopReason:   toStopReason(r),
+		StopSequence: toStopSequence(r),
+		Usage: Usage{
+			InputTokens:  r.PromptEvalCount,
+			OutputTokens: r.EvalCount,
+		},
+	}
+}
+
+type MessagesWriter struct {
+	gin.ResponseWriter
+	stream bool
+	id     string
+
+	// started is set once message_start has been written
+	started bool
+
+	// index is the index of the current content block and open is set
+	// while it hasn't been stopped
+	index int
+	open  bool
+}
+
+func (w *MessagesWriter) writeError(code int, data []byte) (int, error) {
+	var serr api.StatusError
+	err := json.Unmarshal(data, &serr)
+	if err != nil {
+		return 0, err
+	}
+
+	w.ResponseWriter.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w.ResponseWriter).Encode(NewError(code, serr.Error()))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func (w *MessagesWriter) writeEvent(event string, data any) error {
+	d, err := json.Marshal(data)
+	if err != nil {
+		return err
+	}
+
+	_, err = w.ResponseWriter.Write([]byte(fmt.Sprintf("event: %s\ndata: %s\n\n", event, d)))
+	return err
+}
+
+// stopBlock ends the current content block, if any
+func (w *MessagesWriter) stopBlock() error {
+	if !w.open {
+		return nil
+	}
+
+	w.open = false
+	if err := w.writeEvent("content_block_stop", ContentBlockStopEvent{Type: "content_block_stop", Index: w.index}); err != nil {
+		return err
+	}
+
+	w.index++
+	return nil
+}
+
+func (w *MessagesWriter) writeEvents(r api.ChatResponse) error {
+	if !w.started {
+		w.started = true
+		message := MessagesResponse{
+			ID:      w.id,
+			Type:    "message",
+			Role:    "assistant",
+			Model:   r.Model,
+			Content: []ContentBlock{},
+			Usage:   Usage{InputTokens: r.PromptEvalCount},
+		}
+
+		if err := w.writeEvent("message_start", MessageStartEvent{Type: "message_start", Message: message}); err != nil {
+			return err
+		}
+	}
+
+	if r.Message.Content != "" {
+		if !w.open {
+			w.open = true
+			empty := ""
+			if err := w.writeEvent("content_block_start", ContentBlockStartEvent{
+				Type:         "content_block_start",
+				Index:        w.index,
+				ContentBlock: ContentBlock{Type: "text", Text: &empty},
+			}); err != nil {
+				return err
+			}
+		}
+
+		if err := w.writeEvent("content_block_delta", ContentBlockDeltaEvent{
+			Type:  "content_block_delta",
+			Index: w.index,
+			Delta: Delta{Type: "text_delta", Text: r.Message.Content},
+		}); err != nil {
+			return err
+		}
+	}
+
+	for _, tc := range r.Message.ToolCalls {
+		if err := w.stopBlock(); err != nil {
+			return err
+		}
+
+		block := toToolUse(tc)
+		input, err := json.Marshal(block.Input)
+		if err != nil {
+			return err
+		}
+
+		// the input is sent in the delta rather than the start event
+		block.Input = map[string]any{}
+		w.open = true
+		if err := w.writeEvent("content_block_start", ContentBlockStartEvent{Type: "content_block_start", Index: w.index, ContentBlock: block}); err != nil {
+			return err
+		}
+
+		if err := w.writeEvent("content_block_delta", ContentBlockDeltaEvent{
+			Type:  "content_block_delta",
+			Index: w.index,
+			Delta: Delta{Type: "input_json_delta", PartialJSON: string(input)},
+		}); err != nil {
+			return err
+		}
+	}
+
+	if !r.Done {
+		return nil
+	}
+
+	if err := w.stopBlock(); err != nil {
+		return err
+	}
+
+	if err := w.writeEvent("message_delta", MessageDeltaEvent{
+		Type:  "message_delta",
+		Delta: MessageDelta{StopReason: toStopReason(r), StopSequence: toStopSequence(r)},
+		Usage: Usage{InputTokens: r.PromptEvalCount, OutputTokens: r.EvalCount},
+	}); err != nil {
+		return err
+	}
+
+	return w.writeEvent("message_stop", MessageStopEvent{Type: "message_stop"})
+}
+
+func (w *MessagesWriter) writeResponse(data []byte) (int, error) {
+	var chatResponse struct {
+		api.ChatResponse
+		Error string `json:"error"`
+	}
+	err := json.Unmarshal(data, &chatResponse)
+	if err != nil {
+		return 0, err
+	}
+
+	// message events
+	if w.stream {
+		w.ResponseWriter.Header().Set("Content-Type", "text/event-stream")
+
+		// the status has already been sent, so an error is an event
+		if chatResponse.Error != "" {
+			if err := w.writeEvent("error", NewError(http.StatusInternalServerError, chatResponse.Error)); err != nil {
+				return 0, err
+			}
+
+			return len(data), nil
+		}
+
+		if err := w.writeEvents(chatResponse.ChatResponse); err != nil {
+			return 0, err
+		}
+
+		return len(data), nil
+	}
+
+	// message
+	w.ResponseWriter.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w.ResponseWriter).Encode(toMessagesResponse(w.id, chatResponse.ChatResponse))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func (w *MessagesWriter) Write(data []byte) (int, error) {
+	code := w.ResponseWriter.Status()
+	if code != http.StatusOK {
+		return w.writeError(code, data)
+	}
+
+	return w.writeResponse(data)
+}
+
+func MessagesMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req MessagesRequest
+		err := c.ShouldBindJSON(&req)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		if len(req.Messages) == 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, "messages: at least one message is required"))
+			return
+		}
+
+		chatReq, err := fromMessagesRequest(req)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(chatReq); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
+			return
+		}
+
+		c.Request.Body = io.NopCloser(&b)
+
+		w := &MessagesWriter{
+			ResponseWriter: c.Writer,
+			stream:         req.Stream,
+			id:             randomID("msg_"),
+		}
+
+		c.Writer = w
+		c.Next()
+	}
+}
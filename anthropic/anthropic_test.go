@@ -0,0 +1,463 @@
+package anthropic
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+const image = `iVBORw0KGgoAAAANSUhEUgAAAAEAAAABCAQAAAC1HAwCAAAAC0lEQVR42mNk+A8AAQUBAScY42YAAAAASUVORK5CYII=`
+
+var (
+	False = false
+	True  = true
+)
+
+func captureRequestMiddleware(capturedRequest any) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		bodyBytes, _ := io.ReadAll(c.Request.Body)
+		c.Request.Body = io.NopCloser(bytes.NewReader(bodyBytes))
+		err := json.Unmarshal(bodyBytes, capturedRequest)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, "failed to unmarshal request")
+		}
+		c.Next()
+	}
+}
+
+func TestMessagesMiddleware(t *testing.T) {
+	type testCase struct {
+		name string
+		body string
+		req  api.ChatRequest
+		err  ErrorResponse
+	}
+
+	var capturedRequest *api.ChatRequest
+
+	testCases := []testCase{
+		{
+			name: "messages handler",
+			body: `{
+				"model": "test-model",
+				"max_tokens": 100,
+				"messages": [
+					{"role": "user", "content": "Hello"}
+				]
+			}`,
+			req: api.ChatRequest{
+				Model:    "test-model",
+				Messages: []api.Message{{Role: "user", Content: "Hello"}},
+				Options:  map[string]any{"num_predict": 100.0},
+				Stream:   &False,
+			},
+		},
+		{
+			name: "messages handler with options",
+			body: `{
+				"model": "test-model",
+				"max_tokens": 100,
+				"system": [{"type": "text", "text": "Be brief."}, {"type": "text", "text": "Be kind."}],
+				"messages": [
+					{"role": "user", "content": "Hello"}
+				],
+				"stream": true,
+				"stop_sequences": ["\n", "stop"],
+				"temperature": 0.5,
+				"top_p": 0.9,
+				"top_k": 40
+			}`,
+			req: api.ChatRequest{
+				Model: "test-model",
+				Messages: []api.Message{
+					{Role: "system", Content: "Be brief.\n\nBe kind."},
+					{Role: "user", Content: "Hello"},
+				},
+				Options: map[string]any{
+					"num_predict": 100.0,
+					"stop":        []any{"\n", "stop"},
+					"temperature": 0.5,
+					"top_p":       0.9,
+					"top_k":       40.0,
+				},
+				Stream: &True,
+			},
+		},
+		{
+			name: "messages handler with image content",
+			body: `{
+				"model": "test-model",
+				"max_tokens": 100,
+				"messages": [
+					{"role": "user", "content": [
+						{"type": "image", "source": {"type": "base64", "media_type": "image/png", "data": "` + image + `"}},
+						{"type": "text", "text": "What's in this image?"}
+					]}
+				]
+			}`,
+			req: api.ChatRequest{
+				Model: "test-model",
+				Messages: []api.Message{
+					{
+						Role:    "user",
+						Content: "What's in this image?",
+						Images: []api.ImageData{
+							func() []byte {
+								img, _ := base64.StdEncoding.DecodeString(image)
+								return img
+							}(),
+						},
+					},
+				},
+				Options: map[string]any{"num_predict": 100.0},
+				Stream:  &False,
+			},
+		},
+		{
+			name: "messages handler with tools",
+			body: `{
+				"model": "test-model",
+				"max_tokens": 100,
+				"system": "You are a weather bot.",
+				"tools": [{
+					"name": "get_weather",
+					"description": "Get the weather",
+					"input_schema": {"type": "object", "required": ["location"], "properties": {"location": {"type": "string", "description": "The city"}}}
+				}],
+				"messages": [
+					{"role": "user", "content": "What's the weather in Paris?"},
+					{"role": "assistant", "content": [
+						{"type": "text", "text": "Let me check."},
+						{"type": "tool_use", "id": "toolu_1", "name": "get_weather", "input": {"location": "Paris"}}
+					]},
+					{"role": "user", "content": [
+						{"type": "tool_result", "tool_use_id": "toolu_1", "content": "Sunny"},
+						{"type": "text", "text": "Thanks"}
+					]}
+				]
+			}`,
+			req: api.ChatRequest{
+				Model: "test-model",
+				Messages: []api.Message{
+					{Role: "system", Content: "You are a weather bot."},
+					{Role: "user", Content: "What's the weather in Paris?"},
+					{
+						Role:    "assistant",
+						Content: "Let me check.",
+						ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{
+							Name:      "get_weather",
+							Arguments: api.ToolCallFunctionArguments{"location": "Paris"},
+						}}},
+					},
+					{Role: "tool", Content: "Sunny"},
+					{Role: "user", Content: "Thanks"},
+				},
+				Options: map[string]any{"num_predict": 100.0},
+				Stream:  &False,
+				Tools: func() []api.Tool {
+					tool := api.Tool{Type: "function"}
+					tool.Function.Name = "get_weather"
+					tool.Function.Description = "Get the weather"
+					if err := json.Unmarshal([]byte(`{"type": "object", "required": ["location"], "properties": {"location": {"type": "string", "description": "The city"}}}`), &tool.Function.Parameters); err != nil {
+						panic(err)
+					}
+					return []api.Tool{tool}
+				}(),
+			},
+		},
+		{
+			name: "missing max_tokens",
+			body: `{
+				"model": "test-model",
+				"messages": [
+					{"role": "user", "content": "Hello"}
+				]
+			}`,
+			err: NewError(http.StatusBadRequest, "max_tokens is required"),
+		},
+		{
+			name: "missing messages",
+			body: `{
+				"model": "test-model",
+				"max_tokens": 100,
+				"messages": []
+			}`,
+			err: NewError(http.StatusBadRequest, "messages: at least one message is required"),
+		},
+		{
+			name: "invalid role",
+			body: `{
+				"model": "test-model",
+				"max_tokens": 100,
+				"messages": [
+					{"role": "system", "content": "Hello"}
+				]
+			}`,
+			err: NewError(http.StatusBadRequest, `invalid role "system"`),
+		},
+		{
+			name: "url image source",
+			body: `{
+				"model": "test-model",
+				"max_tokens": 100,
+				"messages": [
+					{"role": "user", "content": [
+						{"type": "image", "source": {"type": "url", "url": "https://example.com/image.png"}}
+					]}
+				]
+			}`,
+			err: NewError(http.StatusBadRequest, "only base64 image sources are supported"),
+		},
+	}
+
+	endpoint := func(c *gin.Context) {
+		c.Status(http.StatusOK)
+	}
+
+	gin.SetMode(gin.TestMode)
+	router := gin.New()
+	router.Use(MessagesMiddleware(), captureRequestMiddleware(&capturedRequest))
+	router.Handle(http.MethodPost, "/v1/messages", endpoint)
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(tc.body))
+			req.Header.Set("Content-Type", "application/json")
+
+			defer func() { capturedRequest = nil }()
+
+			resp := httptest.NewRecorder()
+			router.ServeHTTP(resp, req)
+
+			var errResp ErrorResponse
+			if resp.Code != http.StatusOK {
+				if err := json.Unmarshal(resp.Body.Bytes(), &errResp); err != nil {
+					t.Fatal(err)
+				}
+			}
+
+			if capturedRequest != nil {
+				if diff := cmp.Diff(tc.req, *capturedRequest); diff != "" {
+					t.Errorf("request mismatch (-want +got):\n%s", diff)
+				}
+			}
+
+			if diff := cmp.Diff(tc.err, errResp); diff != "" {
+				t.Errorf("error mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMessagesResponse(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	body := `{"model": "test-model", "max_tokens": 100, "messages": [{"role": "user", "content": "Hello"}]}`
+
+	t.Run("message", func(t *testing.T) {
+		router := gin.New()
+		router.Use(MessagesMiddleware())
+		router.Handle(http.MethodPost, "/v1/messages", func(c *gin.Context) {
+			c.JSON(http.StatusOK, api.ChatResponse{
+				Model:      "test-model",
+				Message:    api.Message{Role: "assistant", Content: "Hi!"},
+				Done:       true,
+				DoneReason: "length",
+				Metrics:    api.Metrics{PromptEvalCount: 5, EvalCount: 2},
+			})
+		})
+
+		req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		var msg MessagesResponse
+		if err := json.Unmarshal(resp.Body.Bytes(), &msg); err != nil {
+			t.Fatal(err)
+		}
+
+		text := "Hi!"
+		reason := "max_tokens"
+		expect := MessagesResponse{
+			ID:         msg.ID,
+			Type:       "message",
+			Role:       "assistant",
+			Model:      "test-model",
+			Content:    []ContentBlock{{Type: "text", Text: &text}},
+			StopReason: &reason,
+			Usage:      Usage{InputTokens: 5, OutputTokens: 2},
+		}
+
+		if diff := cmp.Diff(expect, msg); diff != "" {
+			t.Errorf("response mismatch (-want +got):\n%s", diff)
+		}
+
+		if !strings.HasPrefix(msg.ID, "msg_") {
+			t.Errorf("unexpected id %q", msg.ID)
+		}
+	})
+
+	t.Run("tool use", func(t *testing.T) {
+		msg := toMessagesResponse("msg_1", api.ChatResponse{
+			Message: api.Message{Role: "assistant", ToolCalls: []api.ToolCall{{Function: api.ToolCallFunction{
+				Name:      "get_weather",
+				Arguments: api.ToolCallFunctionArguments{"location": "Paris"},
+			}}}},
+			Done:       true,
+			DoneReason: "stop",
+		})
+
+		if len(msg.Content) != 1 || msg.Content[0].Type != "tool_use" || msg.Content[0].Name != "get_weather" {
+			t.Fatalf("unexpected content %+v", msg.Content)
+		}
+
+		if *msg.StopReason != "tool_use" {
+			t.Errorf("expected stop reason tool_use, got %s", *msg.StopReason)
+		}
+	})
+
+	t.Run("stop sequence", func(t *testing.T) {
+		msg := toMessagesResponse("msg_1", api.ChatResponse{
+			Message:      api.Message{Role: "assistant", Content: "Hi"},
+			Done:         true,
+			DoneReason:   "stop",
+			StopSequence: "\n\nHuman:",
+		})
+
+		if msg.StopReason == nil || *msg.StopReason != "stop_sequence" {
+			t.Errorf("expected stop reason stop_sequence, got %v", msg.StopReason)
+		}
+
+		if msg.StopSequence == nil || *msg.StopSequence != "\n\nHuman:" {
+			t.Errorf("expected the stop sequence, got %v", msg.StopSequence)
+		}
+	})
+
+	t.Run("error", func(t *testing.T) {
+		router := gin.New()
+		router.Use(MessagesMiddleware())
+		router.Handle(http.MethodPost, "/v1/messages", func(c *gin.Context) {
+			c.JSON(http.StatusNotFound, gin.H{"error": "model \"test-model\" not found"})
+		})
+
+		req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(body))
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		var errResp ErrorResponse
+		if err := json.Unmarshal(resp.Body.Bytes(), &errResp); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(NewError(http.StatusNotFound, "model \"test-model\" not found"), errResp); diff != "" {
+			t.Errorf("error mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("stream", func(t *testing.T) {
+		router := gin.New()
+		router.Use(MessagesMiddleware())
+		router.Handle(http.MethodPost, "/v1/messages", func(c *gin.Context) {
+			for _, r := range []api.ChatResponse{
+				{Model: "test-model", Message: api.Message{Role: "assistant", Content: "Hel"}},
+				{Model: "test-model", Message: api.Message{Role: "assistant", Content: "lo"}},
+				{Model: "test-model", Message: api.Message{Role: "assistant"}, Done: true, DoneReason: "stop", StopSequence: "END", Metrics: api.Metrics{PromptEvalCount: 5, EvalCount: 2}},
+			} {
+				d, _ := json.Marshal(r)
+				c.Writer.Write(d)
+			}
+		})
+
+		req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(strings.Replace(body, `"max_tokens"`, `"stream": true, "max_tokens"`, 1)))
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		if ct := resp.Header().Get("Content-Type"); ct != "text/event-stream" {
+			t.Errorf("unexpected content type %q", ct)
+		}
+
+		var events []string
+		var text string
+		var delta MessageDeltaEvent
+		for _, e := range strings.Split(strings.TrimSpace(resp.Body.String()), "\n\n") {
+			name, data, ok := strings.Cut(e, "\n")
+			if !ok {
+				t.Fatalf("malformed event %q", e)
+			}
+
+			name = strings.TrimPrefix(name, "event: ")
+			data = strings.TrimPrefix(data, "data: ")
+			events = append(events, name)
+
+			switch name {
+			case "content_block_delta":
+				var e ContentBlockDeltaEvent
+				if err := json.Unmarshal([]byte(data), &e); err != nil {
+					t.Fatal(err)
+				}
+				text += e.Delta.Text
+			case "message_delta":
+				if err := json.Unmarshal([]byte(data), &delta); err != nil {
+					t.Fatal(err)
+				}
+			}
+		}
+
+		expect := []string{"message_start", "content_block_start", "content_block_delta", "content_block_delta", "content_block_stop", "message_delta", "message_stop"}
+		if diff := cmp.Diff(expect, events); diff != "" {
+			t.Errorf("events mismatch (-want +got):\n%s", diff)
+		}
+
+		if text != "Hello" {
+			t.Errorf("expected text Hello, got %q", text)
+		}
+
+		if delta.Delta.StopReason == nil || *delta.Delta.StopReason != "stop_sequence" ||
+			delta.Delta.StopSequence == nil || *delta.Delta.StopSequence != "END" || delta.Usage.OutputTokens != 2 {
+			t.Errorf("unexpected message delta %+v", delta)
+		}
+	})
+
+	t.Run("stream error", func(t *testing.T) {
+		router := gin.New()
+		router.Use(MessagesMiddleware())
+		router.Handle(http.MethodPost, "/v1/messages", func(c *gin.Context) {
+			for _, r := range []any{
+				api.ChatResponse{Model: "test-model", Message: api.Message{Role: "assistant", Content: "Hel"}},
+				gin.H{"error": "runner crashed"},
+			} {
+				d, _ := json.Marshal(r)
+				c.Writer.Write(d)
+			}
+		})
+
+		req, _ := http.NewRequest(http.MethodPost, "/v1/messages", strings.NewReader(strings.Replace(body, `"max_tokens"`, `"stream": true, "max_tokens"`, 1)))
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		events := strings.Split(strings.TrimSpace(resp.Body.String()), "\n\n")
+		name, data, _ := strings.Cut(events[len(events)-1], "\n")
+		if name != "event: error" {
+			t.Fatalf("expected an error event, got %q", events[len(events)-1])
+		}
+
+		var errResp ErrorResponse
+		if err := json.Unmarshal([]byte(strings.TrimPrefix(data, "data: ")), &errResp); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(NewError(http.StatusInternalServerError, "runner crashed"), errResp); diff != "" {
+			t.Errorf("error mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
@@ -105,6 +105,9 @@ func (c *Client) do(ctx context.Context, method, path string, reqData, respData
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/json")
 	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	if key := envconfig.APIKey(); key != "" {
+		request.Header.Set("Authorization", "Bearer "+key)
+	}
 
 	respObj, err := c.http.Do(request)
 	if err != nil {
@@ -151,6 +154,9 @@ func (c *Client) stream(ctx context.Context, method, path string, data any, fn f
 	request.Header.Set("Content-Type", "application/json")
 	request.Header.Set("Accept", "application/x-ndjson")
 	request.Header.Set("User-Agent", fmt.Sprintf("ollama/%s (%s %s) Go/%s", version.Version, runtime.GOARCH, runtime.GOOS, runtime.Version()))
+	if key := envconfig.APIKey(); key != "" {
+		request.Header.Set("Authorization", "Bearer "+key)
+	}
 
 	response, err := c.http.Do(request)
 	if err != nil {
@@ -351,6 +357,183 @@ func (c *Client) Embed(ctx context.Context, req *EmbedRequest) (*EmbedResponse,
 	return &resp, nil
 }
 
+// PurgeEmbedCache removes the cached embeddings of a model.
+func (c *Client) PurgeEmbedCache(ctx context.Context, req *PurgeEmbedCacheRequest) (*PurgeEmbedCacheResponse, error) {
+	var resp PurgeEmbedCacheResponse
+	if err := c.do(ctx, http.MethodDelete, "/api/embed/cache", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Rerank orders documents by their relevance to a query.
+func (c *Client) Rerank(ctx context.Context, req *RerankRequest) (*RerankResponse, error) {
+	var resp RerankResponse
+	if err := c.do(ctx, http.MethodPost, "/api/rerank", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Tokenize converts a prompt, or chat messages rendered with the model's
+// template, into tokens.
+func (c *Client) Tokenize(ctx context.Context, req *TokenizeRequest) (*TokenizeResponse, error) {
+	var resp TokenizeResponse
+	if err := c.do(ctx, http.MethodPost, "/api/tokenize", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Cache evaluates a prompt and saves it to disk so that later requests
+// starting with it skip evaluating it.
+func (c *Client) Cache(ctx context.Context, req *CacheRequest) (*CacheResponse, error) {
+	var resp CacheResponse
+	if err := c.do(ctx, http.MethodPost, "/api/cache", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Detokenize converts tokens back into text.
+func (c *Client) Detokenize(ctx context.Context, req *DetokenizeRequest) (*DetokenizeResponse, error) {
+	var resp DetokenizeResponse
+	if err := c.do(ctx, http.MethodPost, "/api/detokenize", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreateSession creates a chat session whose messages are stored by the
+// server. Pass its ID as [ChatRequest.Session] to continue it.
+func (c *Client) CreateSession(ctx context.Context, req *CreateSessionRequest) (*Session, error) {
+	var resp Session
+	if err := c.do(ctx, http.MethodPost, "/api/sessions", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Session returns a chat session and its messages.
+func (c *Client) Session(ctx context.Context, id string) (*Session, error) {
+	var resp Session
+	if err := c.do(ctx, http.MethodGet, "/api/sessions/"+url.PathEscape(id), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListSessions lists the chat sessions stored by the server.
+func (c *Client) ListSessions(ctx context.Context) (*ListSessionsResponse, error) {
+	var resp ListSessionsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/sessions", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteSession deletes a chat session.
+func (c *Client) DeleteSession(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/sessions/"+url.PathEscape(id), nil, nil)
+}
+
+// CreateCollection creates an empty collection of documents.
+func (c *Client) CreateCollection(ctx context.Context, req *CreateCollectionRequest) (*Collection, error) {
+	var resp Collection
+	if err := c.do(ctx, http.MethodPost, "/api/collections", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// Collection returns a collection of documents.
+func (c *Client) Collection(ctx context.Context, name string) (*Collection, error) {
+	var resp Collection
+	if err := c.do(ctx, http.MethodGet, "/api/collections/"+url.PathEscape(name), nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListCollections lists the collections of documents.
+func (c *Client) ListCollections(ctx context.Context) (*ListCollectionsResponse, error) {
+	var resp ListCollectionsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/collections", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteCollection deletes a collection and its documents.
+func (c *Client) DeleteCollection(ctx context.Context, name string) error {
+	return c.do(ctx, http.MethodDelete, "/api/collections/"+url.PathEscape(name), nil, nil)
+}
+
+// AddDocuments embeds documents with the model of a collection and adds
+// them to it.
+func (c *Client) AddDocuments(ctx context.Context, name string, req *AddDocumentsRequest) (*AddDocumentsResponse, error) {
+	var resp AddDocumentsResponse
+	if err := c.do(ctx, http.MethodPost, "/api/collections/"+url.PathEscape(name)+"/documents", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteDocuments deletes documents from a collection.
+func (c *Client) DeleteDocuments(ctx context.Context, name string, req *DeleteDocumentsRequest) error {
+	return c.do(ctx, http.MethodDelete, "/api/collections/"+url.PathEscape(name)+"/documents", req, nil)
+}
+
+// QueryCollection returns the documents of a collection most similar to a
+// query.
+func (c *Client) QueryCollection(ctx context.Context, name string, req *QueryCollectionRequest) (*QueryCollectionResponse, error) {
+	var resp QueryCollectionResponse
+	if err := c.do(ctx, http.MethodPost, "/api/collections/"+url.PathEscape(name)+"/query", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CreateAlias creates an alias, replacing the alias with the same name.
+func (c *Client) CreateAlias(ctx context.Context, req *CreateAliasRequest) (*Alias, error) {
+	var resp Alias
+	if err := c.do(ctx, http.MethodPost, "/api/aliases", req, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// ListAliases lists the aliases.
+func (c *Client) ListAliases(ctx context.Context) (*ListAliasesResponse, error) {
+	var resp ListAliasesResponse
+	if err := c.do(ctx, http.MethodGet, "/api/aliases", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// DeleteAlias deletes an alias.
+func (c *Client) DeleteAlias(ctx context.Context, req *DeleteAliasRequest) error {
+	return c.do(ctx, http.MethodDelete, "/api/aliases", req, nil)
+}
+
+// ListRequests lists the generate, chat and embed requests that are queued
+// or running.
+func (c *Client) ListRequests(ctx context.Context) (*ListRequestsResponse, error) {
+	var resp ListRequestsResponse
+	if err := c.do(ctx, http.MethodGet, "/api/requests", nil, &resp); err != nil {
+		return nil, err
+	}
+	return &resp, nil
+}
+
+// CancelRequest cancels a queued or running request by the ID the server
+// returned for it in the X-Request-Id header. A cancelled generation ends
+// with the done reason "cancelled".
+func (c *Client) CancelRequest(ctx context.Context, id string) error {
+	return c.do(ctx, http.MethodDelete, "/api/requests/"+url.PathEscape(id), nil, nil)
+}
+
 // Embeddings generates an embedding from a model.
 func (c *Client) Embeddings(ctx context.Context, req *EmbeddingRequest) (*EmbeddingResponse, error) {
 	var resp EmbeddingResponse
@@ -66,8 +66,18 @@ type GenerateRequest struct {
 	// Raw set to true means that no formatting will be applied to the prompt.
 	Raw bool `json:"raw,omitempty"`
 
-	// Format specifies the format to return a response in.
-	Format string `json:"format"`
+	// Format specifies the format to return a response in. It is either
+	// the string "json" or a JSON schema object the response must match.
+	Format json.RawMessage `json:"format,omitempty"`
+
+	// Grammar is a GBNF grammar the response must match.
+	Grammar string `json:"grammar,omitempty"`
+
+	// Regex is a regular expression the whole response must match.
+	Regex string `json:"regex,omitempty"`
+
+	// Choices restricts the response to exactly one of its strings.
+	Choices []string `json:"choices,omitempty"`
 
 	// KeepAlive controls how long the model will stay loaded in memory following
 	// this request.
@@ -77,6 +87,18 @@ type GenerateRequest struct {
 	// request, for multimodal models.
 	Images []ImageData `json:"images,omitempty"`
 
+	// Logprobs returns the log probability of each generated token.
+	Logprobs bool `json:"logprobs,omitempty"`
+
+	// TopLogprobs is the number of most likely alternative tokens to return
+	// with each generated token. It requires Logprobs.
+	TopLogprobs int `json:"top_logprobs,omitempty"`
+
+	// N is the number of independent completions to generate for the
+	// request; 1 by default. Each response carries the Index of its
+	// completion.
+	N int `json:"n,omitempty"`
+
 	// Options lists model-specific options. For example, temperature can be
 	// set through this field, if the model supports it.
 	Options map[string]interface{} `json:"options"`
@@ -93,8 +115,18 @@ type ChatRequest struct {
 	// Stream enable streaming of returned response; true by default.
 	Stream *bool `json:"stream,omitempty"`
 
-	// Format is the format to return the response in (e.g. "json").
-	Format string `json:"format"`
+	// Format is the format to return the response in, either "json" or a
+	// JSON schema object.
+	Format json.RawMessage `json:"format,omitempty"`
+
+	// Grammar is a GBNF grammar the response must match.
+	Grammar string `json:"grammar,omitempty"`
+
+	// Regex is a regular expression the whole response must match.
+	Regex string `json:"regex,omitempty"`
+
+	// Choices restricts the response to exactly one of its strings.
+	Choices []string `json:"choices,omitempty"`
 
 	// KeepAlive controls how long the model will stay loaded into memory
 	// followin the request.
@@ -103,6 +135,23 @@ type ChatRequest struct {
 	// Tools is an optional list of tools the model has access to.
 	Tools `json:"tools,omitempty"`
 
+	// Logprobs returns the log probability of each generated token.
+	Logprobs bool `json:"logprobs,omitempty"`
+
+	// TopLogprobs is the number of most likely alternative tokens to return
+	// with each generated token. It requires Logprobs.
+	TopLogprobs int `json:"top_logprobs,omitempty"`
+
+	// N is the number of independent completions to generate for the
+	// request; 1 by default. Each response carries the Index of its
+	// completion.
+	N int `json:"n,omitempty"`
+
+	// Session is the ID of a session created with [Client.CreateSession].
+	// Its stored messages are sent before Messages, and Messages and the
+	// reply are added to it once the response is done.
+	Session string `json:"session,omitempty"`
+
 	// Options lists model-specific options.
 	Options map[string]interface{} `json:"options"`
 }
@@ -189,11 +238,39 @@ type ChatResponse struct {
 	Message    Message   `json:"message"`
 	DoneReason string    `json:"done_reason,omitempty"`
 
+	// StopSequence is the stop sequence which ended generation, if any.
+	StopSequence string `json:"stop_sequence,omitempty"`
+
 	Done bool `json:"done"`
 
+	// Logprobs are the log probabilities of the tokens in Message if they
+	// were requested.
+	Logprobs []TokenLogprob `json:"logprobs,omitempty"`
+
+	// Index identifies the completion this response belongs to when more
+	// than one is requested.
+	Index int `json:"index,omitempty"`
+
+	// Choices holds every completion of a non-streaming request for more
+	// than one completion. The other fields hold the first completion.
+	Choices []ChatResponse `json:"choices,omitempty"`
+
 	Metrics
 }
 
+// TokenLogprob is the log probability of a generated token.
+type TokenLogprob struct {
+	// Token is the text of the token.
+	Token string `json:"token"`
+
+	// Logprob is the natural log of the token's probability.
+	Logprob float64 `json:"logprob"`
+
+	// TopLogprobs are the most likely tokens at this position, in order of
+	// decreasing probability.
+	TopLogprobs []TokenLogprob `json:"top_logprobs,omitempty"`
+}
+
 type Metrics struct {
 	TotalDuration      time.Duration `json:"total_duration,omitempty"`
 	LoadDuration       time.Duration `json:"load_duration,omitempty"`
@@ -227,6 +304,10 @@ type Options struct {
 	MirostatEta      float32  `json:"mirostat_eta,omitempty"`
 	PenalizeNewline  bool     `json:"penalize_newline,omitempty"`
 	Stop             []string `json:"stop,omitempty"`
+	Grammar          string   `json:"grammar,omitempty"`
+
+	// NumDraft is the most tokens the draft model proposes at a time
+	NumDraft int `json:"num_draft,omitempty"`
 }
 
 // Runner options which must be set when the model is loaded into memory
@@ -242,6 +323,11 @@ type Runner struct {
 	UseMMap   *bool `json:"use_mmap,omitempty"`
 	UseMLock  bool  `json:"use_mlock,omitempty"`
 	NumThread int   `json:"num_thread,omitempty"`
+
+	// DraftModel is the name of a smaller model sharing the vocabulary of
+	// the model which is loaded alongside it to propose tokens for the
+	// model to verify
+	DraftModel string `json:"draft_model,omitempty"`
 }
 
 // EmbedRequest is the request passed to [Client.Embed].
@@ -258,6 +344,17 @@ type EmbedRequest struct {
 
 	Truncate *bool `json:"truncate,omitempty"`
 
+	// Dimensions shortens embeddings to their first Dimensions values. It is
+	// meant for models trained so that shortened embeddings stay useful.
+	Dimensions int `json:"dimensions,omitempty"`
+
+	// Normalize scales embeddings to unit length. Defaults to true.
+	Normalize *bool `json:"normalize,omitempty"`
+
+	// EncodingFormat is the format of the embeddings in the response: float
+	// (the default), base64, int8 or binary.
+	EncodingFormat string `json:"encoding_format,omitempty"`
+
 	// Options lists model-specific options.
 	Options map[string]interface{} `json:"options"`
 }
@@ -267,11 +364,379 @@ type EmbedResponse struct {
 	Model      string      `json:"model"`
 	Embeddings [][]float32 `json:"embeddings"`
 
+	// EncodedEmbeddings holds the embeddings as base64 strings instead of
+	// Embeddings when the request's EncodingFormat isn't float.
+	EncodedEmbeddings []string `json:"encoded_embeddings,omitempty"`
+
+	TotalDuration   time.Duration `json:"total_duration,omitempty"`
+	LoadDuration    time.Duration `json:"load_duration,omitempty"`
+	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
+
+	// Cached is the number of inputs whose embeddings came from the
+	// embedding cache. PromptEvalCount only counts the other inputs.
+	Cached int `json:"cached,omitempty"`
+}
+
+// PurgeEmbedCacheRequest is the request passed to [Client.PurgeEmbedCache].
+type PurgeEmbedCacheRequest struct {
+	// Model is the model whose cached embeddings are removed.
+	Model string `json:"model"`
+}
+
+// PurgeEmbedCacheResponse is the response from [Client.PurgeEmbedCache].
+type PurgeEmbedCacheResponse struct {
+	// Purged is the number of embeddings removed from the cache.
+	Purged int `json:"purged"`
+}
+
+// RerankRequest is the request passed to [Client.Rerank].
+type RerankRequest struct {
+	// Model is the model name. It must be a reranking model.
+	Model string `json:"model"`
+
+	// Query is the text the documents are ranked against.
+	Query string `json:"query"`
+
+	// Documents are the texts to rank.
+	Documents []string `json:"documents"`
+
+	// TopN limits the response to the most relevant documents. Every
+	// document is returned if zero.
+	TopN int `json:"top_n,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// RerankResult is the relevance of a document to the query of a
+// [RerankRequest].
+type RerankResult struct {
+	// Index is the position of the document in the request.
+	Index    int    `json:"index"`
+	Document string `json:"document"`
+
+	// RelevanceScore is between 0 and 1, higher for more relevant documents.
+	RelevanceScore float32 `json:"relevance_score"`
+}
+
+// RerankResponse is the response from [Client.Rerank].
+type RerankResponse struct {
+	Model string `json:"model"`
+
+	// Results are ordered from the most to the least relevant document.
+	Results []RerankResult `json:"results"`
+
 	TotalDuration   time.Duration `json:"total_duration,omitempty"`
 	LoadDuration    time.Duration `json:"load_duration,omitempty"`
 	PromptEvalCount int           `json:"prompt_eval_count,omitempty"`
 }
 
+// TokenizeRequest is the request passed to [Client.Tokenize].
+type TokenizeRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Prompt is the text to tokenize.
+	Prompt string `json:"prompt,omitempty"`
+
+	// Messages are rendered with the model's chat template, as they would be
+	// for a chat request, and the resulting prompt is tokenized. Prompt and
+	// Messages are mutually exclusive.
+	Messages []Message `json:"messages,omitempty"`
+
+	// Tools are included when rendering Messages.
+	Tools []Tool `json:"tools,omitempty"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// TokenizeResponse is the response from [Client.Tokenize].
+type TokenizeResponse struct {
+	Model  string `json:"model"`
+	Tokens []int  `json:"tokens"`
+
+	// Count is the number of tokens in the prompt
+	Count int `json:"count"`
+}
+
+// CacheRequest is the request passed to [Client.Cache].
+type CacheRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Prompt is the raw prompt, with no template applied, to save. Later
+	// requests whose prompts start with it restore it instead of
+	// evaluating it again, including after the model is reloaded.
+	Prompt string `json:"prompt"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// CacheResponse is the response from [Client.Cache].
+type CacheResponse struct {
+	Model string `json:"model"`
+
+	// Tokens is the number of tokens of the prompt that were saved. The last
+	// token is left out since it may be tokenized differently when followed
+	// by more text.
+	Tokens int `json:"tokens"`
+
+	TotalDuration      time.Duration `json:"total_duration,omitempty"`
+	LoadDuration       time.Duration `json:"load_duration,omitempty"`
+	PromptEvalCount    int           `json:"prompt_eval_count,omitempty"`
+	PromptEvalDuration time.Duration `json:"prompt_eval_duration,omitempty"`
+}
+
+// DetokenizeRequest is the request passed to [Client.Detokenize].
+type DetokenizeRequest struct {
+	// Model is the model name.
+	Model string `json:"model"`
+
+	// Tokens are the tokens to convert back to text.
+	Tokens []int `json:"tokens"`
+
+	// KeepAlive controls how long the model will stay loaded in memory following
+	// this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+
+	// Options lists model-specific options.
+	Options map[string]interface{} `json:"options"`
+}
+
+// DetokenizeResponse is the response from [Client.Detokenize].
+type DetokenizeResponse struct {
+	Model   string `json:"model"`
+	Content string `json:"content"`
+}
+
+// CreateSessionRequest is the request passed to [Client.CreateSession].
+type CreateSessionRequest struct {
+	// Model is the model name the session chats with.
+	Model string `json:"model"`
+
+	// Messages is the history the session starts with.
+	Messages []Message `json:"messages,omitempty"`
+}
+
+// Session is a chat whose messages are stored by the server. It is returned
+// by [Client.CreateSession] and [Client.Session].
+type Session struct {
+	ID        string    `json:"id"`
+	Model     string    `json:"model"`
+	Messages  []Message `json:"messages,omitempty"`
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// ExpiresAt is when the session will be deleted unless it is used
+	// again. It is unset if sessions never expire.
+	ExpiresAt *time.Time `json:"expires_at,omitempty"`
+}
+
+// ListSessionsResponse is the response from [Client.ListSessions]. Messages
+// are left out of each session.
+type ListSessionsResponse struct {
+	Sessions []Session `json:"sessions"`
+}
+
+// CreateCollectionRequest is the request passed to [Client.CreateCollection].
+type CreateCollectionRequest struct {
+	// Name is the name of the collection.
+	Name string `json:"name"`
+
+	// Model is the embedding model which embeds the collection's documents
+	// and queries.
+	Model string `json:"model"`
+}
+
+// Collection is a set of documents stored with their embeddings so that they
+// can be searched by similarity. It is returned by [Client.CreateCollection]
+// and [Client.Collection].
+type Collection struct {
+	Name  string `json:"name"`
+	Model string `json:"model"`
+
+	// Documents is the number of documents in the collection.
+	Documents int `json:"documents"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
+// ListCollectionsResponse is the response from [Client.ListCollections].
+type ListCollectionsResponse struct {
+	Collections []Collection `json:"collections"`
+}
+
+// CollectionDocument is a document in a collection.
+type CollectionDocument struct {
+	// ID identifies the document in its collection. Adding a document with
+	// the ID of another replaces it. An ID is generated if it is empty.
+	ID string `json:"id,omitempty"`
+
+	Text string `json:"text"`
+
+	// Metadata holds values by which queries can filter documents.
+	Metadata map[string]any `json:"metadata,omitempty"`
+}
+
+// AddDocumentsRequest is the request passed to [Client.AddDocuments].
+type AddDocumentsRequest struct {
+	Documents []CollectionDocument `json:"documents"`
+
+	// KeepAlive controls how long the embedding model will stay loaded in
+	// memory following this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+}
+
+// AddDocumentsResponse is the response from [Client.AddDocuments].
+type AddDocumentsResponse struct {
+	// IDs are the IDs of the added documents in the order of the request.
+	IDs []string `json:"ids"`
+
+	PromptEvalCount int `json:"prompt_eval_count,omitempty"`
+}
+
+// DeleteDocumentsRequest is the request passed to [Client.DeleteDocuments].
+type DeleteDocumentsRequest struct {
+	IDs []string `json:"ids"`
+}
+
+// QueryCollectionRequest is the request passed to [Client.QueryCollection].
+type QueryCollectionRequest struct {
+	Query string `json:"query"`
+
+	// TopK is the maximum number of documents returned. Defaults to 10.
+	TopK int `json:"top_k,omitempty"`
+
+	// Filter limits the query to documents with all of its metadata.
+	Filter map[string]any `json:"filter,omitempty"`
+
+	// KeepAlive controls how long the embedding model will stay loaded in
+	// memory following this request.
+	KeepAlive *Duration `json:"keep_alive,omitempty"`
+}
+
+// CollectionResult is a document found by a query and its cosine similarity
+// to the query.
+type CollectionResult struct {
+	CollectionDocument
+	Score float32 `json:"score"`
+}
+
+// QueryCollectionResponse is the response from [Client.QueryCollection].
+type QueryCollectionResponse struct {
+	// Results are the most similar documents, most similar first.
+	Results []CollectionResult `json:"results"`
+}
+
+// AliasTarget is a model that an alias resolves to.
+type AliasTarget struct {
+	Model string `json:"model"`
+
+	// Weight is the share of requests sent to the model relative to the
+	// other targets of the alias. It defaults to 1 and must be positive.
+	Weight *int `json:"weight,omitempty"`
+}
+
+// CreateAliasRequest is the request passed to [Client.CreateAlias].
+type CreateAliasRequest struct {
+	// Name is the name that the alias answers to.
+	Name string `json:"name"`
+
+	// Model is shorthand for a single target.
+	Model string `json:"model,omitempty"`
+
+	// Targets are the models that requests for the alias are split between.
+	Targets []AliasTarget `json:"targets,omitempty"`
+
+	// Fallbacks are the models tried in order when the chosen target fails
+	// to load.
+	Fallbacks []string `json:"fallbacks,omitempty"`
+}
+
+// Alias is a name that resolves to other models.
+type Alias struct {
+	Name      string        `json:"name"`
+	Targets   []AliasTarget `json:"targets"`
+	Fallbacks []string      `json:"fallbacks,omitempty"`
+	CreatedAt time.Time     `json:"created_at"`
+	UpdatedAt time.Time     `json:"updated_at"`
+}
+
+// ListAliasesResponse is the response from [Client.ListAliases].
+type ListAliasesResponse struct {
+	Aliases []Alias `json:"aliases"`
+}
+
+// DeleteAliasRequest is the request passed to [Client.DeleteAlias].
+type DeleteAliasRequest struct {
+	Name string `json:"name"`
+}
+
+// ActiveRequest describes a generate, chat or embed request that is queued
+// or running.
+type ActiveRequest struct {
+	ID       string `json:"id"`
+	Endpoint string `json:"endpoint"`
+	Model    string `json:"model,omitempty"`
+	Priority string `json:"priority"`
+
+	// Status is queued while the request waits for its model to be scheduled
+	// and running after
+	Status string `json:"status"`
+
+	CreatedAt time.Time  `json:"created_at"`
+	StartedAt *time.Time `json:"started_at,omitempty"`
+}
+
+// ListRequestsResponse is the response from [Client.ListRequests].
+type ListRequestsResponse struct {
+	Requests []ActiveRequest `json:"requests"`
+}
+
+// WebSocketRequest is a message sent by the client of the /api/ws WebSocket.
+type WebSocketRequest struct {
+	// ID identifies the request among those in progress on the connection.
+	// Responses to the request carry the same ID.
+	ID string `json:"id,omitempty"`
+
+	// Type is one of "chat", "generate", "cancel" or "ping".
+	Type string `json:"type"`
+
+	// Chat is the request of a "chat" message.
+	Chat *ChatRequest `json:"chat,omitempty"`
+
+	// Generate is the request of a "generate" message.
+	Generate *GenerateRequest `json:"generate,omitempty"`
+}
+
+// WebSocketResponse is a message sent by the server over the /api/ws
+// WebSocket.
+type WebSocketResponse struct {
+	ID string `json:"id,omitempty"`
+
+	// Type is one of "chat", "generate", "error" or "pong".
+	Type string `json:"type"`
+
+	Chat     *ChatResponse     `json:"chat,omitempty"`
+	Generate *GenerateResponse `json:"generate,omitempty"`
+	Error    string            `json:"error,omitempty"`
+}
+
 // EmbeddingRequest is the request passed to [Client.Embeddings].
 type EmbeddingRequest struct {
 	// Model is the model name.
@@ -291,6 +756,9 @@ type EmbeddingRequest struct {
 // EmbeddingResponse is the response from [Client.Embeddings].
 type EmbeddingResponse struct {
 	Embedding []float64 `json:"embedding"`
+
+	// Cached is 1 if the embedding came from the embedding cache.
+	Cached int `json:"cached,omitempty"`
 }
 
 // CreateRequest is the request passed to [Client.Create].
@@ -394,6 +862,10 @@ type ListResponse struct {
 // ProcessResponse is the response from [Client.Process].
 type ProcessResponse struct {
 	Models []ProcessModelResponse `json:"models"`
+
+	// Queue is the number of requests waiting for a model or for a slot on
+	// one, by priority
+	Queue map[string]int `json:"queue,omitempty"`
 }
 
 // ListModelResponse is a single model description in [ListResponse].
@@ -404,6 +876,10 @@ type ListModelResponse struct {
 	Size       int64        `json:"size"`
 	Digest     string       `json:"digest"`
 	Details    ModelDetails `json:"details,omitempty"`
+
+	// Targets and Fallbacks are set when the model is an alias.
+	Targets   []AliasTarget `json:"targets,omitempty"`
+	Fallbacks []string      `json:"fallbacks,omitempty"`
 }
 
 // ProcessModelResponse is a single model description in [ProcessResponse].
@@ -415,6 +891,22 @@ type ProcessModelResponse struct {
 	Details   ModelDetails `json:"details,omitempty"`
 	ExpiresAt time.Time    `json:"expires_at"`
 	SizeVRAM  int64        `json:"size_vram"`
+
+	// Draft describes the draft model loaded alongside the model, if any
+	Draft *ProcessDraftResponse `json:"draft,omitempty"`
+}
+
+// ProcessDraftResponse describes the draft model of a [ProcessModelResponse].
+type ProcessDraftResponse struct {
+	Model string `json:"model"`
+
+	// Proposed is the number of tokens proposed by the draft model and
+	// Accepted the number of those the model generated as well
+	Proposed int `json:"proposed"`
+	Accepted int `json:"accepted"`
+
+	// AcceptanceRate is Accepted divided by Proposed
+	AcceptanceRate float64 `json:"acceptance_rate"`
 }
 
 type RetrieveModelResponse struct {
@@ -445,10 +937,25 @@ type GenerateResponse struct {
 	// DoneReason is the reason the model stopped generating text.
 	DoneReason string `json:"done_reason,omitempty"`
 
+	// StopSequence is the stop sequence which ended generation, if any.
+	StopSequence string `json:"stop_sequence,omitempty"`
+
 	// Context is an encoding of the conversation used in this response; this
 	// can be sent in the next request to keep a conversational memory.
 	Context []int `json:"context,omitempty"`
 
+	// Logprobs are the log probabilities of the tokens in Response if they
+	// were requested.
+	Logprobs []TokenLogprob `json:"logprobs,omitempty"`
+
+	// Index identifies the completion this response belongs to when more
+	// than one is requested.
+	Index int `json:"index,omitempty"`
+
+	// Choices holds every completion of a non-streaming request for more
+	// than one completion. The other fields hold the first completion.
+	Choices []GenerateResponse `json:"choices,omitempty"`
+
 	Metrics
 }
 
@@ -605,6 +1112,7 @@ func DefaultOptions() Options {
 		MirostatEta:      0.1,
 		PenalizeNewline:  true,
 		Seed:             -1,
+		NumDraft:         4,
 
 		Runner: Runner{
 			// options set when the model is loaded
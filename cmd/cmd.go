@@ -8,6 +8,7 @@ import (
 	"crypto/ed25519"
 	"crypto/rand"
 	"crypto/sha256"
+	"encoding/json"
 	"encoding/pem"
 	"errors"
 	"fmt"
@@ -21,6 +22,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync/atomic"
@@ -391,6 +393,14 @@ func RunHandler(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
+	// format is either a JSON schema or a string such as json
+	if format != "" && !json.Valid([]byte(format)) {
+		bts, err := json.Marshal(format)
+		if err != nil {
+			return err
+		}
+		format = string(bts)
+	}
 	opts.Format = format
 
 	keepAlive, err := cmd.Flags().GetString("keepalive")
@@ -600,9 +610,21 @@ func ListHandler(cmd *cobra.Command, args []string) error {
 	var data [][]string
 
 	for _, m := range models.Models {
-		if len(args) == 0 || strings.HasPrefix(m.Name, args[0]) {
-			data = append(data, []string{m.Name, m.Digest[:12], format.HumanBytes(m.Size), format.HumanTime(m.ModifiedAt, "Never")})
+		if len(args) > 0 && !strings.HasPrefix(m.Name, args[0]) {
+			continue
 		}
+
+		if len(m.Targets) > 0 {
+			targets := make([]string, len(m.Targets))
+			for i, t := range m.Targets {
+				targets[i] = t.Model
+			}
+
+			data = append(data, []string{fmt.Sprintf("%s -> %s", m.Name, strings.Join(targets, ", ")), "alias", "-", format.HumanTime(m.ModifiedAt, "Never")})
+			continue
+		}
+
+		data = append(data, []string{m.Name, m.Digest[:12], format.HumanBytes(m.Size), format.HumanTime(m.ModifiedAt, "Never")})
 	}
 
 	table := tablewriter.NewWriter(os.Stdout)
@@ -1040,7 +1062,7 @@ func chat(cmd *cobra.Command, opts runOptions) (*api.Message, error) {
 	req := &api.ChatRequest{
 		Model:    opts.Model,
 		Messages: opts.Messages,
-		Format:   opts.Format,
+		Format:   json.RawMessage(opts.Format),
 		Options:  opts.Options,
 	}
 
@@ -1127,7 +1149,7 @@ func generate(cmd *cobra.Command, opts runOptions) error {
 		Prompt:    opts.Prompt,
 		Context:   generateContext,
 		Images:    opts.Images,
-		Format:    opts.Format,
+		Format:    json.RawMessage(opts.Format),
 		System:    opts.System,
 		Options:   opts.Options,
 		KeepAlive: opts.KeepAlive,
@@ -1164,7 +1186,11 @@ func generate(cmd *cobra.Command, opts runOptions) error {
 	return nil
 }
 
-func RunServer(_ *cobra.Command, _ []string) error {
+func RunServer(cmd *cobra.Command, _ []string) error {
+	if err := loadConfigFile(cmd); err != nil {
+		return err
+	}
+
 	if err := initializeKeypair(); err != nil {
 		return err
 	}
@@ -1182,6 +1208,53 @@ func RunServer(_ *cobra.Command, _ []string) error {
 	return err
 }
 
+// loadConfigFile applies the configuration file named by the --config flag
+func loadConfigFile(cmd *cobra.Command) error {
+	path, err := cmd.Flags().GetString("config")
+	if err != nil || path == "" {
+		return err
+	}
+
+	return envconfig.LoadFile(path)
+}
+
+func ConfigShowHandler(cmd *cobra.Command, _ []string) error {
+	if err := loadConfigFile(cmd); err != nil {
+		return err
+	}
+
+	vars := envconfig.AsMap()
+	keys := make([]string, 0, len(vars))
+	for k := range vars {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	var data [][]string
+	for _, k := range keys {
+		value := fmt.Sprintf("%v", vars[k].Value)
+		if list, ok := vars[k].Value.([]string); ok {
+			value = strings.Join(list, ",")
+		}
+
+		data = append(data, []string{k, value, envconfig.Source(k)})
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"KEY", "VALUE", "SOURCE"})
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetNoWhiteSpace(true)
+	table.SetTablePadding("    ")
+	table.SetAutoWrapText(false)
+	table.AppendBulk(data)
+	table.Render()
+
+	return nil
+}
+
 func initializeKeypair() error {
 	home, err := os.UserHomeDir()
 	if err != nil {
@@ -1344,7 +1417,7 @@ func NewCLI() *cobra.Command {
 	runCmd.Flags().Bool("verbose", false, "Show timings for response")
 	runCmd.Flags().Bool("insecure", false, "Use an insecure registry")
 	runCmd.Flags().Bool("nowordwrap", false, "Don't wrap words to the next line automatically")
-	runCmd.Flags().String("format", "", "Response format (e.g. json)")
+	runCmd.Flags().String("format", "", "Response format (json or a JSON schema)")
 
 	stopCmd := &cobra.Command{
 		Use:     "stop MODEL",
@@ -1362,6 +1435,8 @@ func NewCLI() *cobra.Command {
 		RunE:    RunServer,
 	}
 
+	serveCmd.Flags().String("config", "", "Path to a TOML configuration file")
+
 	pullCmd := &cobra.Command{
 		Use:     "pull MODEL",
 		Short:   "Pull a model from a registry",
@@ -1413,6 +1488,82 @@ func NewCLI() *cobra.Command {
 		RunE:    DeleteHandler,
 	}
 
+	collectionCmd := &cobra.Command{
+		Use:   "collection",
+		Short: "Manage document collections",
+	}
+
+	collectionCreateCmd := &cobra.Command{
+		Use:     "create NAME MODEL",
+		Short:   "Create a collection embedded by a model",
+		Args:    cobra.ExactArgs(2),
+		PreRunE: checkServerHeartbeat,
+		RunE:    CreateCollectionHandler,
+	}
+
+	collectionListCmd := &cobra.Command{
+		Use:     "list",
+		Aliases: []string{"ls"},
+		Short:   "List collections",
+		Args:    cobra.ExactArgs(0),
+		PreRunE: checkServerHeartbeat,
+		RunE:    ListCollectionsHandler,
+	}
+
+	collectionDeleteCmd := &cobra.Command{
+		Use:     "rm NAME [NAME...]",
+		Short:   "Remove a collection",
+		Args:    cobra.MinimumNArgs(1),
+		PreRunE: checkServerHeartbeat,
+		RunE:    DeleteCollectionHandler,
+	}
+
+	collectionAddCmd := &cobra.Command{
+		Use:     "add NAME FILE [FILE...]",
+		Short:   "Add files to a collection (use - for stdin)",
+		Args:    cobra.MinimumNArgs(2),
+		PreRunE: checkServerHeartbeat,
+		RunE:    AddDocumentsHandler,
+	}
+
+	collectionAddCmd.Flags().Int("chunk-size", 1000, "Maximum size of each document in bytes")
+	collectionAddCmd.Flags().StringArray("metadata", nil, "Metadata of the documents (key=value)")
+
+	collectionQueryCmd := &cobra.Command{
+		Use:     "query NAME QUERY",
+		Short:   "Find the documents most similar to a query",
+		Args:    cobra.MinimumNArgs(2),
+		PreRunE: checkServerHeartbeat,
+		RunE:    QueryCollectionHandler,
+	}
+
+	collectionQueryCmd.Flags().Int("top-k", 10, "Number of documents to return")
+	collectionQueryCmd.Flags().StringArray("filter", nil, "Only return documents with this metadata (key=value)")
+
+	collectionCmd.AddCommand(
+		collectionCreateCmd,
+		collectionListCmd,
+		collectionDeleteCmd,
+		collectionAddCmd,
+		collectionQueryCmd,
+	)
+
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Inspect the server configuration",
+	}
+
+	configShowCmd := &cobra.Command{
+		Use:   "show",
+		Short: "Show the configuration and the source of each value",
+		Args:  cobra.ExactArgs(0),
+		RunE:  ConfigShowHandler,
+	}
+
+	configShowCmd.Flags().String("config", "", "Path to a TOML configuration file")
+
+	configCmd.AddCommand(configShowCmd)
+
 	envVars := envconfig.AsMap()
 
 	envs := []envconfig.EnvVar{envVars["OLLAMA_HOST"]}
@@ -1428,6 +1579,11 @@ func NewCLI() *cobra.Command {
 		psCmd,
 		copyCmd,
 		deleteCmd,
+		collectionCreateCmd,
+		collectionListCmd,
+		collectionDeleteCmd,
+		collectionAddCmd,
+		collectionQueryCmd,
 		serveCmd,
 	} {
 		switch cmd {
@@ -1468,6 +1624,8 @@ func NewCLI() *cobra.Command {
 		psCmd,
 		copyCmd,
 		deleteCmd,
+		collectionCmd,
+		configCmd,
 	)
 
 	return rootCmd
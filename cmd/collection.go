@@ -0,0 +1,286 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+
+	"github.com/olekukonko/tablewriter"
+	"github.com/spf13/cobra"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/format"
+)
+
+// addDocumentsBatch is the number of documents sent in each request when
+// adding files to a collection
+const addDocumentsBatch = 64
+
+func CreateCollectionHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	if _, err := client.CreateCollection(cmd.Context(), &api.CreateCollectionRequest{Name: args[0], Model: args[1]}); err != nil {
+		return err
+	}
+
+	fmt.Printf("created collection '%s'\n", args[0])
+	return nil
+}
+
+func ListCollectionsHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.ListCollections(cmd.Context())
+	if err != nil {
+		return err
+	}
+
+	var data [][]string
+	for _, c := range resp.Collections {
+		data = append(data, []string{c.Name, c.Model, strconv.Itoa(c.Documents), format.HumanTime(c.UpdatedAt, "Never")})
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"NAME", "MODEL", "DOCUMENTS", "MODIFIED"})
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetNoWhiteSpace(true)
+	table.SetTablePadding("    ")
+	table.AppendBulk(data)
+	table.Render()
+
+	return nil
+}
+
+func DeleteCollectionHandler(cmd *cobra.Command, args []string) error {
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	for _, name := range args {
+		if err := client.DeleteCollection(cmd.Context(), name); err != nil {
+			return err
+		}
+		fmt.Printf("deleted collection '%s'\n", name)
+	}
+
+	return nil
+}
+
+func AddDocumentsHandler(cmd *cobra.Command, args []string) error {
+	chunkSize, err := cmd.Flags().GetInt("chunk-size")
+	if err != nil {
+		return err
+	} else if chunkSize <= 0 {
+		return errors.New("chunk size must be positive")
+	}
+
+	pairs, err := cmd.Flags().GetStringArray("metadata")
+	if err != nil {
+		return err
+	}
+
+	metadata, err := parseKeyValues(pairs)
+	if err != nil {
+		return err
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	var docs []api.CollectionDocument
+	for _, path := range args[1:] {
+		var b []byte
+		if path == "-" {
+			b, err = io.ReadAll(os.Stdin)
+		} else {
+			b, err = os.ReadFile(path)
+		}
+		if err != nil {
+			return err
+		}
+
+		for i, chunk := range chunkText(string(b), chunkSize) {
+			doc := api.CollectionDocument{
+				Text:     chunk,
+				Metadata: map[string]any{"source": path},
+			}
+
+			// files keep the IDs of their chunks so that adding them again
+			// replaces them
+			if path != "-" {
+				doc.ID = fmt.Sprintf("%s#%d", path, i)
+			}
+
+			for k, v := range metadata {
+				doc.Metadata[k] = v
+			}
+
+			docs = append(docs, doc)
+		}
+	}
+
+	var count int
+	for len(docs) > 0 {
+		n := min(len(docs), addDocumentsBatch)
+		if _, err := client.AddDocuments(cmd.Context(), args[0], &api.AddDocumentsRequest{Documents: docs[:n]}); err != nil {
+			return err
+		}
+
+		docs = docs[n:]
+		count += n
+	}
+
+	fmt.Printf("added %d documents to '%s'\n", count, args[0])
+	return nil
+}
+
+func QueryCollectionHandler(cmd *cobra.Command, args []string) error {
+	topK, err := cmd.Flags().GetInt("top-k")
+	if err != nil {
+		return err
+	}
+
+	pairs, err := cmd.Flags().GetStringArray("filter")
+	if err != nil {
+		return err
+	}
+
+	filter, err := parseKeyValues(pairs)
+	if err != nil {
+		return err
+	}
+
+	client, err := api.ClientFromEnvironment()
+	if err != nil {
+		return err
+	}
+
+	resp, err := client.QueryCollection(cmd.Context(), args[0], &api.QueryCollectionRequest{
+		Query:  strings.Join(args[1:], " "),
+		TopK:   topK,
+		Filter: filter,
+	})
+	if err != nil {
+		return err
+	}
+
+	var data [][]string
+	for _, r := range resp.Results {
+		data = append(data, []string{fmt.Sprintf("%.4f", r.Score), r.ID, truncateText(r.Text, 60)})
+	}
+
+	table := tablewriter.NewWriter(os.Stdout)
+	table.SetHeader([]string{"SCORE", "ID", "TEXT"})
+	table.SetHeaderAlignment(tablewriter.ALIGN_LEFT)
+	table.SetAlignment(tablewriter.ALIGN_LEFT)
+	table.SetHeaderLine(false)
+	table.SetBorder(false)
+	table.SetNoWhiteSpace(true)
+	table.SetTablePadding("    ")
+	table.AppendBulk(data)
+	table.Render()
+
+	return nil
+}
+
+// parseKeyValues parses key=value pairs. Values which are valid JSON, such as
+// numbers and booleans, are decoded so that they match metadata of the same
+// type.
+func parseKeyValues(pairs []string) (map[string]any, error) {
+	if len(pairs) == 0 {
+		return nil, nil
+	}
+
+	m := make(map[string]any, len(pairs))
+	for _, pair := range pairs {
+		k, v, ok := strings.Cut(pair, "=")
+		if !ok || k == "" {
+			return nil, fmt.Errorf("expected key=value, got %q", pair)
+		}
+
+		var value any
+		if err := json.Unmarshal([]byte(v), &value); err != nil {
+			value = v
+		}
+
+		m[k] = value
+	}
+
+	return m, nil
+}
+
+// chunkText splits text into chunks of at most size bytes, breaking between
+// paragraphs where it can and otherwise at whitespace
+func chunkText(text string, size int) []string {
+	var chunks []string
+	var chunk strings.Builder
+	flush := func() {
+		if s := strings.TrimSpace(chunk.String()); s != "" {
+			chunks = append(chunks, s)
+		}
+		chunk.Reset()
+	}
+
+	for _, paragraph := range strings.Split(text, "\n\n") {
+		paragraph = strings.TrimSpace(paragraph)
+		if paragraph == "" {
+			continue
+		}
+
+		if chunk.Len() > 0 && chunk.Len()+len(paragraph)+2 > size {
+			flush()
+		}
+
+		for len(paragraph) > size {
+			i := strings.LastIndexAny(paragraph[:size], " \t\n")
+			if i <= 0 {
+				// break inside a word, but not inside a character
+				for i = size; i > 0 && !utf8.RuneStart(paragraph[i]); i-- {
+				}
+
+				if i == 0 {
+					_, i = utf8.DecodeRuneInString(paragraph)
+				}
+			}
+
+			chunk.WriteString(paragraph[:i])
+			flush()
+			paragraph = strings.TrimSpace(paragraph[i:])
+		}
+
+		if chunk.Len() > 0 {
+			chunk.WriteString("\n\n")
+		}
+		chunk.WriteString(paragraph)
+	}
+
+	flush()
+	return chunks
+}
+
+// truncateText shortens text to one line of at most n characters
+func truncateText(text string, n int) string {
+	text = strings.Join(strings.Fields(text), " ")
+	if utf8.RuneCountInString(text) <= n {
+		return text
+	}
+
+	return string([]rune(text)[:n-3]) + "..."
+}
@@ -0,0 +1,67 @@
+package cmd
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestChunkText(t *testing.T) {
+	cases := []struct {
+		name string
+		text string
+		size int
+		want []string
+	}{
+		{
+			name: "paragraphs",
+			text: "one two\n\nthree\n\n\n\nfour five six",
+			size: 14,
+			want: []string{"one two\n\nthree", "four five six"},
+		},
+		{
+			name: "long paragraph",
+			text: "one two three four",
+			size: 9,
+			want: []string{"one two", "three", "four"},
+		},
+		{
+			name: "long word",
+			text: "ééééé",
+			size: 5,
+			want: []string{"éé", "éé", "é"},
+		},
+		{
+			name: "empty",
+			text: "\n\n  \n",
+			size: 10,
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			if diff := cmp.Diff(chunkText(tt.text, tt.size), tt.want); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestParseKeyValues(t *testing.T) {
+	got, err := parseKeyValues([]string{"lang=en", "year=2024", "draft=false", "title=a=b"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]any{"lang": "en", "year": float64(2024), "draft": false, "title": "a=b"}
+	if diff := cmp.Diff(got, want); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	for _, pair := range []string{"lang", "=en"} {
+		if _, err := parseKeyValues([]string{pair}); err == nil || !strings.Contains(err.Error(), "key=value") {
+			t.Errorf("expected an error for %q, got %v", pair, err)
+		}
+	}
+}
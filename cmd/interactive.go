@@ -264,7 +264,7 @@ func generateInteractive(cmd *cobra.Command, opts runOptions) error {
 					if len(args) < 3 || args[2] != "json" {
 						fmt.Println("Invalid or missing format. For 'json' mode use '/set format json'")
 					} else {
-						opts.Format = args[2]
+						opts.Format = `"json"`
 						fmt.Printf("Set format to '%s' mode.\n", args[2])
 					}
 				case "noformat":
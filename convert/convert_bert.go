@@ -3,6 +3,7 @@ package convert
 import (
 	"cmp"
 	"encoding/json"
+	"fmt"
 	"io/fs"
 	"path/filepath"
 	"slices"
@@ -29,7 +30,13 @@ type bertModel struct {
 	LayerNormEpsilon      float32 `json:"layer_norm_epsilon"`
 	NormEpsilon           float32 `json:"norm_epsilon"`
 
+	ID2Label map[string]string `json:"id2label"`
+
 	PoolingType uint32
+
+	// classifier is set for cross-encoders, which score a pair of texts with
+	// a classification head instead of pooling an embedding
+	classifier bool
 }
 
 var (
@@ -38,6 +45,15 @@ var (
 )
 
 func (p *bertModel) parseMore(fsys fs.FS) error {
+	if p.classifier {
+		if len(p.ID2Label) > 1 {
+			return fmt.Errorf("unsupported classifier with %d labels", len(p.ID2Label))
+		}
+
+		p.PoolingType = 4
+		return nil
+	}
+
 	bts, err := fs.ReadFile(fsys, "modules.json")
 	if err != nil {
 		return err
@@ -135,11 +151,12 @@ func (p *bertModel) KV(t *Tokenizer) llm.KV {
 func (p *bertModel) Tensors(ts []Tensor) []llm.Tensor {
 	var out []llm.Tensor
 	for _, t := range ts {
-		if slices.Contains([]string{
-			"embeddings.position_ids",
-			"pooler.dense.weight",
-			"pooler.dense.bias",
-		}, t.Name()) {
+		if t.Name() == "embeddings.position_ids" {
+			continue
+		}
+
+		// the pooler is only part of the classification head
+		if !p.classifier && slices.Contains([]string{"cls.weight", "cls.bias"}, t.Name()) {
 			continue
 		}
 
@@ -156,6 +173,9 @@ func (p *bertModel) Tensors(ts []Tensor) []llm.Tensor {
 
 func (bertModel) Replacements() []string {
 	return []string{
+		"bert.", "",
+		"pooler.dense", "cls",
+		"classifier", "cls.output",
 		"encoder.layer", "blk",
 		"encoder.layers", "blk",
 		"embeddings.word_embeddings", "token_embd",
@@ -474,3 +474,35 @@ func generateLoraTestData(t *testing.T, tempDir string) {
 		t.Fatal(err)
 	}
 }
+
+func TestConvertBertClassifier(t *testing.T) {
+	r := strings.NewReplacer((&bertModel{}).Replacements()...)
+	for name, want := range map[string]string{
+		"bert.embeddings.word_embeddings.weight":             "token_embd.weight",
+		"bert.encoder.layer.0.attention.self.query.weight":   "blk.0.attn_q.weight",
+		"bert.encoder.layer.0.attention.output.dense.weight": "blk.0.attn_output.weight",
+		"bert.encoder.layer.0.output.dense.weight":           "blk.0.ffn_down.weight",
+		"bert.pooler.dense.weight":                           "cls.weight",
+		"classifier.weight":                                  "cls.output.weight",
+		"classifier.bias":                                    "cls.output.bias",
+		"encoder.layer.0.attention.output.LayerNorm.weight":  "blk.0.attn_output_norm.weight",
+	} {
+		if got := r.Replace(name); got != want {
+			t.Errorf("%s: expected %s, got %s", name, want, got)
+		}
+	}
+
+	p := bertModel{classifier: true, ID2Label: map[string]string{"0": "LABEL_0"}}
+	if err := p.parseMore(os.DirFS(t.TempDir())); err != nil {
+		t.Fatal(err)
+	}
+
+	if p.PoolingType != 4 {
+		t.Errorf("expected rank pooling, got %d", p.PoolingType)
+	}
+
+	p = bertModel{classifier: true, ID2Label: map[string]string{"0": "negative", "1": "positive"}}
+	if err := p.parseMore(os.DirFS(t.TempDir())); err == nil {
+		t.Error("expected error for a classifier with several labels")
+	}
+}
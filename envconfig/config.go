@@ -93,6 +93,67 @@ func Models() string {
 	return filepath.Join(home, ".ollama", "models")
 }
 
+// Files returns the directory storing files uploaded through the OpenAI compatible files API and the
+// state of batches. Files directory can be configured via the OLLAMA_FILES environment variable.
+// Default is $OLLAMA_MODELS/files
+func Files() string {
+	if s := Var("OLLAMA_FILES"); s != "" {
+		return s
+	}
+
+	return filepath.Join(Models(), "files")
+}
+
+// Sessions returns the directory storing chat sessions. Sessions directory can be configured via the OLLAMA_SESSIONS environment variable.
+// Default is $OLLAMA_MODELS/sessions
+func Sessions() string {
+	if s := Var("OLLAMA_SESSIONS"); s != "" {
+		return s
+	}
+
+	return filepath.Join(Models(), "sessions")
+}
+
+// Collections returns the directory storing collections of documents and their embeddings. Collections directory can be configured via the OLLAMA_COLLECTIONS environment variable.
+// Default is $OLLAMA_MODELS/collections
+func Collections() string {
+	if s := Var("OLLAMA_COLLECTIONS"); s != "" {
+		return s
+	}
+
+	return filepath.Join(Models(), "collections")
+}
+
+// EmbedCache returns the directory of the embedding cache. EmbedCache directory can be configured via the OLLAMA_EMBED_CACHE environment variable.
+// Default is $OLLAMA_MODELS/embeddings
+func EmbedCache() string {
+	if s := Var("OLLAMA_EMBED_CACHE"); s != "" {
+		return s
+	}
+
+	return filepath.Join(Models(), "embeddings")
+}
+
+// SessionTTL returns how long a chat session may go unused before it is deleted. SessionTTL can be configured via the OLLAMA_SESSION_TTL environment variable.
+// Zero or Negative values are treated as infinite.
+// Default is 24 hours.
+func SessionTTL() (ttl time.Duration) {
+	ttl = 24 * time.Hour
+	if s := Var("OLLAMA_SESSION_TTL"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil {
+			ttl = d
+		} else if n, err := strconv.ParseInt(s, 10, 64); err == nil {
+			ttl = time.Duration(n) * time.Second
+		}
+	}
+
+	if ttl <= 0 {
+		return time.Duration(math.MaxInt64)
+	}
+
+	return ttl
+}
+
 // KeepAlive returns the duration that models stay loaded in memory. KeepAlive can be configured via the OLLAMA_KEEP_ALIVE environment variable.
 // Negative values are treated as infinite. Zero is treated as no keep alive.
 // Default is 5 minutes.
@@ -133,6 +194,21 @@ func LoadTimeout() (loadTimeout time.Duration) {
 	return loadTimeout
 }
 
+// TokenQuotaWindow returns the period over which per client token quotas are measured. TokenQuotaWindow can be configured via the OLLAMA_TOKEN_QUOTA_WINDOW environment variable.
+// Default is 1 hour.
+func TokenQuotaWindow() (window time.Duration) {
+	window = time.Hour
+	if s := Var("OLLAMA_TOKEN_QUOTA_WINDOW"); s != "" {
+		if d, err := time.ParseDuration(s); err == nil && d > 0 {
+			window = d
+		} else if n, err := strconv.ParseInt(s, 10, 64); err == nil && n > 0 {
+			window = time.Duration(n) * time.Second
+		}
+	}
+
+	return window
+}
+
 func Bool(k string) func() bool {
 	return func() bool {
 		if s := Var(k); s != "" {
@@ -163,6 +239,8 @@ var (
 	IntelGPU = Bool("OLLAMA_INTEL_GPU")
 	// MultiUserCache optimizes prompt caching for multi-user scenarios
 	MultiUserCache = Bool("OLLAMA_MULTIUSER_CACHE")
+	// AuditLogBodies includes prompts and responses in the audit log.
+	AuditLogBodies = Bool("OLLAMA_AUDIT_LOG_BODIES")
 )
 
 func String(s string) func() string {
@@ -175,6 +253,13 @@ var (
 	LLMLibrary = String("OLLAMA_LLM_LIBRARY")
 	TmpDir     = String("OLLAMA_TMPDIR")
 
+	// APIKeysFile is the path to a JSON file of API keys. When set, requests must present a key with a matching scope.
+	APIKeysFile = String("OLLAMA_API_KEYS_FILE")
+	// APIKey is the key sent by the client in the Authorization header.
+	APIKey = String("OLLAMA_API_KEY")
+	// AuditLog is the path to a JSONL file recording every request. Auditing is disabled when unset.
+	AuditLog = String("OLLAMA_AUDIT_LOG")
+
 	CudaVisibleDevices    = String("CUDA_VISIBLE_DEVICES")
 	HipVisibleDevices     = String("HIP_VISIBLE_DEVICES")
 	RocrVisibleDevices    = String("ROCR_VISIBLE_DEVICES")
@@ -205,6 +290,12 @@ var (
 	MaxQueue = Uint("OLLAMA_MAX_QUEUE", 512)
 	// MaxVRAM sets a maximum VRAM override in bytes. MaxVRAM can be configured via the OLLAMA_MAX_VRAM environment variable.
 	MaxVRAM = Uint("OLLAMA_MAX_VRAM", 0)
+	// RateLimit sets the maximum number of requests per minute from a single client. RateLimit can be configured via the OLLAMA_RATE_LIMIT environment variable.
+	RateLimit = Uint("OLLAMA_RATE_LIMIT", 0)
+	// MaxClientRequests sets the maximum number of in-flight requests from a single client. MaxClientRequests can be configured via the OLLAMA_MAX_CLIENT_REQUESTS environment variable.
+	MaxClientRequests = Uint("OLLAMA_MAX_CLIENT_REQUESTS", 0)
+	// TokenQuota sets the maximum number of prompt and generated tokens a single client may use per OLLAMA_TOKEN_QUOTA_WINDOW. TokenQuota can be configured via the OLLAMA_TOKEN_QUOTA environment variable.
+	TokenQuota = Uint64("OLLAMA_TOKEN_QUOTA", 0)
 )
 
 func Uint64(key string, defaultValue uint64) func() uint64 {
@@ -224,6 +315,15 @@ func Uint64(key string, defaultValue uint64) func() uint64 {
 // Set aside VRAM per GPU
 var GpuOverhead = Uint64("OLLAMA_GPU_OVERHEAD", 0)
 
+// AuditLogMaxSize is the size in bytes at which the audit log is rotated
+var AuditLogMaxSize = Uint64("OLLAMA_AUDIT_LOG_MAX_SIZE", 100*1024*1024)
+
+// EmbedCacheSize is the maximum size in bytes of the embedding cache. Zero disables the cache.
+var EmbedCacheSize = Uint64("OLLAMA_EMBED_CACHE_SIZE", 0)
+
+// PromptCacheSize is the maximum size in bytes of the prompts saved for each model
+var PromptCacheSize = Uint64("OLLAMA_PROMPT_CACHE_SIZE", 10*1024*1024*1024)
+
 type EnvVar struct {
 	Name        string
 	Value       any
@@ -232,23 +332,38 @@ type EnvVar struct {
 
 func AsMap() map[string]EnvVar {
 	ret := map[string]EnvVar{
-		"OLLAMA_DEBUG":             {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
-		"OLLAMA_FLASH_ATTENTION":   {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
-		"OLLAMA_GPU_OVERHEAD":      {"OLLAMA_GPU_OVERHEAD", GpuOverhead(), "Reserve a portion of VRAM per GPU (bytes)"},
-		"OLLAMA_HOST":              {"OLLAMA_HOST", Host(), "IP Address for the ollama server (default 127.0.0.1:11434)"},
-		"OLLAMA_KEEP_ALIVE":        {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
-		"OLLAMA_LLM_LIBRARY":       {"OLLAMA_LLM_LIBRARY", LLMLibrary(), "Set LLM library to bypass autodetection"},
-		"OLLAMA_LOAD_TIMEOUT":      {"OLLAMA_LOAD_TIMEOUT", LoadTimeout(), "How long to allow model loads to stall before giving up (default \"5m\")"},
-		"OLLAMA_MAX_LOADED_MODELS": {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
-		"OLLAMA_MAX_QUEUE":         {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
-		"OLLAMA_MODELS":            {"OLLAMA_MODELS", Models(), "The path to the models directory"},
-		"OLLAMA_NOHISTORY":         {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
-		"OLLAMA_NOPRUNE":           {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
-		"OLLAMA_NUM_PARALLEL":      {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
-		"OLLAMA_ORIGINS":           {"OLLAMA_ORIGINS", Origins(), "A comma separated list of allowed origins"},
-		"OLLAMA_SCHED_SPREAD":      {"OLLAMA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
-		"OLLAMA_TMPDIR":            {"OLLAMA_TMPDIR", TmpDir(), "Location for temporary files"},
-		"OLLAMA_MULTIUSER_CACHE":   {"OLLAMA_MULTIUSER_CACHE", MultiUserCache(), "Optimize prompt caching for multi-user scenarios"},
+		"OLLAMA_API_KEYS_FILE":       {"OLLAMA_API_KEYS_FILE", APIKeysFile(), "Path to a JSON file of API keys and their scopes"},
+		"OLLAMA_AUDIT_LOG":           {"OLLAMA_AUDIT_LOG", AuditLog(), "Path to a JSONL file recording every request"},
+		"OLLAMA_AUDIT_LOG_BODIES":    {"OLLAMA_AUDIT_LOG_BODIES", AuditLogBodies(), "Include prompts and responses in the audit log"},
+		"OLLAMA_AUDIT_LOG_MAX_SIZE":  {"OLLAMA_AUDIT_LOG_MAX_SIZE", AuditLogMaxSize(), "Size in bytes at which the audit log is rotated (default 100MiB)"},
+		"OLLAMA_COLLECTIONS":         {"OLLAMA_COLLECTIONS", Collections(), "The path to the directory of document collections"},
+		"OLLAMA_DEBUG":               {"OLLAMA_DEBUG", Debug(), "Show additional debug information (e.g. OLLAMA_DEBUG=1)"},
+		"OLLAMA_EMBED_CACHE":         {"OLLAMA_EMBED_CACHE", EmbedCache(), "The path to the embedding cache"},
+		"OLLAMA_EMBED_CACHE_SIZE":    {"OLLAMA_EMBED_CACHE_SIZE", EmbedCacheSize(), "Maximum size in bytes of the embedding cache (default 0, disabled)"},
+		"OLLAMA_FLASH_ATTENTION":     {"OLLAMA_FLASH_ATTENTION", FlashAttention(), "Enabled flash attention"},
+		"OLLAMA_FILES":               {"OLLAMA_FILES", Files(), "The path to the directory of uploaded files and batches"},
+		"OLLAMA_GPU_OVERHEAD":        {"OLLAMA_GPU_OVERHEAD", GpuOverhead(), "Reserve a portion of VRAM per GPU (bytes)"},
+		"OLLAMA_HOST":                {"OLLAMA_HOST", Host(), "IP Address for the ollama server (default 127.0.0.1:11434)"},
+		"OLLAMA_KEEP_ALIVE":          {"OLLAMA_KEEP_ALIVE", KeepAlive(), "The duration that models stay loaded in memory (default \"5m\")"},
+		"OLLAMA_LLM_LIBRARY":         {"OLLAMA_LLM_LIBRARY", LLMLibrary(), "Set LLM library to bypass autodetection"},
+		"OLLAMA_LOAD_TIMEOUT":        {"OLLAMA_LOAD_TIMEOUT", LoadTimeout(), "How long to allow model loads to stall before giving up (default \"5m\")"},
+		"OLLAMA_MAX_LOADED_MODELS":   {"OLLAMA_MAX_LOADED_MODELS", MaxRunners(), "Maximum number of loaded models per GPU"},
+		"OLLAMA_MAX_QUEUE":           {"OLLAMA_MAX_QUEUE", MaxQueue(), "Maximum number of queued requests"},
+		"OLLAMA_MAX_CLIENT_REQUESTS": {"OLLAMA_MAX_CLIENT_REQUESTS", MaxClientRequests(), "Maximum number of in-flight requests per client"},
+		"OLLAMA_PROMPT_CACHE_SIZE":   {"OLLAMA_PROMPT_CACHE_SIZE", PromptCacheSize(), "Maximum size in bytes of the prompts saved for each model (default 10GiB)"},
+		"OLLAMA_RATE_LIMIT":          {"OLLAMA_RATE_LIMIT", RateLimit(), "Maximum number of requests per minute per client"},
+		"OLLAMA_TOKEN_QUOTA":         {"OLLAMA_TOKEN_QUOTA", TokenQuota(), "Maximum number of tokens per client per quota window"},
+		"OLLAMA_TOKEN_QUOTA_WINDOW":  {"OLLAMA_TOKEN_QUOTA_WINDOW", TokenQuotaWindow(), "Period over which token quotas are measured (default \"1h\")"},
+		"OLLAMA_MODELS":              {"OLLAMA_MODELS", Models(), "The path to the models directory"},
+		"OLLAMA_NOHISTORY":           {"OLLAMA_NOHISTORY", NoHistory(), "Do not preserve readline history"},
+		"OLLAMA_NOPRUNE":             {"OLLAMA_NOPRUNE", NoPrune(), "Do not prune model blobs on startup"},
+		"OLLAMA_NUM_PARALLEL":        {"OLLAMA_NUM_PARALLEL", NumParallel(), "Maximum number of parallel requests"},
+		"OLLAMA_ORIGINS":             {"OLLAMA_ORIGINS", Origins(), "A comma separated list of allowed origins"},
+		"OLLAMA_SESSIONS":            {"OLLAMA_SESSIONS", Sessions(), "The path to the directory of chat sessions"},
+		"OLLAMA_SESSION_TTL":         {"OLLAMA_SESSION_TTL", SessionTTL(), "How long chat sessions are kept after their last use (default \"24h\")"},
+		"OLLAMA_SCHED_SPREAD":        {"OLLAMA_SCHED_SPREAD", SchedSpread(), "Always schedule model across all GPUs"},
+		"OLLAMA_TMPDIR":              {"OLLAMA_TMPDIR", TmpDir(), "Location for temporary files"},
+		"OLLAMA_MULTIUSER_CACHE":     {"OLLAMA_MULTIUSER_CACHE", MultiUserCache(), "Optimize prompt caching for multi-user scenarios"},
 
 		// Informational
 		"HTTP_PROXY":  {"HTTP_PROXY", String("HTTP_PROXY")(), "HTTP proxy"},
@@ -253,6 +253,29 @@ func TestLoadTimeout(t *testing.T) {
 	}
 }
 
+func TestSessionTTL(t *testing.T) {
+	defaultTTL := 24 * time.Hour
+	cases := map[string]time.Duration{
+		"":     defaultTTL,
+		"30m":  30 * time.Minute,
+		"3600": time.Hour,
+		"0":    time.Duration(math.MaxInt64),
+		"-1h":  time.Duration(math.MaxInt64),
+		// invalid values
+		"???": defaultTTL,
+		"1d":  defaultTTL,
+	}
+
+	for tt, expect := range cases {
+		t.Run(tt, func(t *testing.T) {
+			t.Setenv("OLLAMA_SESSION_TTL", tt)
+			if actual := SessionTTL(); actual != expect {
+				t.Errorf("%s: expected %s, got %s", tt, expect, actual)
+			}
+		})
+	}
+}
+
 func TestVar(t *testing.T) {
 	cases := map[string]string{
 		"value":       "value",
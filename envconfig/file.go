@@ -0,0 +1,150 @@
+package envconfig
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/pelletier/go-toml/v2"
+)
+
+var (
+	fileMu sync.Mutex
+	// fileKeys are the keys set from a configuration file by LoadFile
+	fileKeys = make(map[string]bool)
+)
+
+// LoadFile reads a TOML configuration file whose keys are the names of the
+// variables in AsMap, such as
+//
+//	OLLAMA_HOST = "0.0.0.0:11434"
+//	OLLAMA_KEEP_ALIVE = "10m"
+//	OLLAMA_ORIGINS = ["https://example.com"]
+//
+// Every key and value is validated before any is applied. Values are set in
+// the environment of the process, so that runners and GPU libraries see them
+// too, unless the environment already sets them.
+func LoadFile(path string) error {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return err
+	}
+
+	var raw map[string]any
+	if err := toml.Unmarshal(b, &raw); err != nil {
+		var derr *toml.DecodeError
+		if errors.As(err, &derr) {
+			row, col := derr.Position()
+			return fmt.Errorf("%s:%d:%d: %w", path, row, col, err)
+		}
+		return fmt.Errorf("%s: %w", path, err)
+	}
+
+	vars := AsMap()
+	values := make(map[string]string, len(raw))
+	for k, v := range raw {
+		ev, ok := vars[k]
+		if !ok {
+			return fmt.Errorf("%s: unknown key %q", path, k)
+		}
+
+		s, err := fileValue(v)
+		if err != nil {
+			return fmt.Errorf("%s: %s: %w", path, k, err)
+		}
+
+		if err := validate(ev.Value, s); err != nil {
+			return fmt.Errorf("%s: %s: invalid value %q: %w", path, k, s, err)
+		}
+
+		values[k] = s
+	}
+
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	for k, v := range values {
+		if Var(k) != "" && !fileKeys[k] {
+			continue
+		}
+
+		if err := os.Setenv(k, v); err != nil {
+			return err
+		}
+
+		fileKeys[k] = true
+	}
+
+	return nil
+}
+
+// fileValue returns a value of a configuration file in the form of an
+// environment variable. Lists are joined with commas.
+func fileValue(v any) (string, error) {
+	switch v := v.(type) {
+	case string:
+		return v, nil
+	case bool:
+		return strconv.FormatBool(v), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case float64:
+		return strconv.FormatFloat(v, 'f', -1, 64), nil
+	case []any:
+		s := make([]string, len(v))
+		for i, e := range v {
+			if _, ok := e.([]any); ok {
+				return "", errors.New("nested lists are not supported")
+			}
+
+			var err error
+			if s[i], err = fileValue(e); err != nil {
+				return "", err
+			}
+		}
+		return strings.Join(s, ","), nil
+	default:
+		return "", fmt.Errorf("unsupported value of type %T", v)
+	}
+}
+
+// validate checks that s parses as the type of value, the current value of
+// the variable
+func validate(value any, s string) error {
+	switch value.(type) {
+	case bool:
+		_, err := strconv.ParseBool(s)
+		return err
+	case uint, uint64:
+		_, err := strconv.ParseUint(s, 10, 64)
+		return err
+	case time.Duration:
+		if _, err := time.ParseDuration(s); err != nil {
+			if _, ierr := strconv.ParseInt(s, 10, 64); ierr != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
+
+// Source returns where the value of a variable comes from: "config file",
+// "environment" or "default"
+func Source(key string) string {
+	fileMu.Lock()
+	defer fileMu.Unlock()
+
+	switch {
+	case fileKeys[key]:
+		return "config file"
+	case Var(key) != "":
+		return "environment"
+	default:
+		return "default"
+	}
+}
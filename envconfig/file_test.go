@@ -0,0 +1,94 @@
+package envconfig
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestLoadFile(t *testing.T) {
+	for _, k := range []string{"OLLAMA_KEEP_ALIVE", "OLLAMA_ORIGINS", "OLLAMA_NUM_PARALLEL", "OLLAMA_DEBUG", "OLLAMA_MAX_QUEUE"} {
+		t.Setenv(k, "")
+	}
+	t.Cleanup(func() { clear(fileKeys) })
+
+	write := func(t *testing.T, content string) string {
+		t.Helper()
+		path := filepath.Join(t.TempDir(), "config.toml")
+		if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	t.Run("invalid", func(t *testing.T) {
+		cases := map[string]string{
+			"unknown key":  "OLLAMA_HSOT = \"0.0.0.0\"\nOLLAMA_MAX_QUEUE = 1\n",
+			"invalid uint": "OLLAMA_NUM_PARALLEL = \"four\"\nOLLAMA_MAX_QUEUE = 1\n",
+			"invalid bool": "OLLAMA_DEBUG = \"maybe\"\nOLLAMA_MAX_QUEUE = 1\n",
+			"invalid time": "OLLAMA_KEEP_ALIVE = \"soon\"\nOLLAMA_MAX_QUEUE = 1\n",
+			"table":        "[OLLAMA_ORIGINS]\nurl = \"x\"\n",
+			"invalid toml": "OLLAMA_MAX_QUEUE =\n",
+		}
+
+		for name, content := range cases {
+			t.Run(name, func(t *testing.T) {
+				if err := LoadFile(write(t, content)); err == nil {
+					t.Fatal("expected an error")
+				}
+
+				// nothing is applied from a file with an error
+				if MaxQueue() != 512 {
+					t.Errorf("expected the default queue size, got %d", MaxQueue())
+				}
+			})
+		}
+
+		err := LoadFile(write(t, "OLLAMA_NUM_PARALLEL = -1\n"))
+		if err == nil || !strings.Contains(err.Error(), "OLLAMA_NUM_PARALLEL") {
+			t.Errorf("expected an error naming the key, got %v", err)
+		}
+	})
+
+	t.Run("valid", func(t *testing.T) {
+		t.Setenv("OLLAMA_NUM_PARALLEL", "2")
+
+		if err := LoadFile(write(t, `
+OLLAMA_KEEP_ALIVE = "10m"
+OLLAMA_ORIGINS = ["https://a.example", "https://b.example"]
+OLLAMA_NUM_PARALLEL = 4
+OLLAMA_DEBUG = true
+`)); err != nil {
+			t.Fatal(err)
+		}
+
+		if KeepAlive() != 10*time.Minute {
+			t.Errorf("expected keep alive from the file, got %s", KeepAlive())
+		}
+
+		if origins := Origins(); origins[0] != "https://a.example" || origins[1] != "https://b.example" {
+			t.Errorf("expected origins from the file, got %v", origins[:2])
+		}
+
+		if !Debug() {
+			t.Error("expected debug from the file")
+		}
+
+		// the environment takes precedence
+		if NumParallel() != 2 {
+			t.Errorf("expected parallel from the environment, got %d", NumParallel())
+		}
+
+		for k, want := range map[string]string{
+			"OLLAMA_KEEP_ALIVE":   "config file",
+			"OLLAMA_NUM_PARALLEL": "environment",
+			"OLLAMA_MAX_QUEUE":    "default",
+		} {
+			if got := Source(k); got != want {
+				t.Errorf("expected source of %s to be %q, got %q", k, want, got)
+			}
+		}
+	})
+}
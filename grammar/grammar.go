@@ -0,0 +1,225 @@
+// Package grammar builds GBNF grammars which constrain sampling to regular
+// expressions or a fixed set of choices.
+package grammar
+
+import (
+	"errors"
+	"fmt"
+	"regexp/syntax"
+	"strings"
+	"unicode"
+)
+
+// FromChoices returns a grammar matching exactly one of choices
+func FromChoices(choices []string) (string, error) {
+	if len(choices) == 0 {
+		return "", errors.New("choices must not be empty")
+	}
+
+	alts := make([]string, len(choices))
+	for i, c := range choices {
+		alts[i] = literal(c)
+	}
+
+	return "root ::= " + strings.Join(alts, " | ") + "\n", nil
+}
+
+// FromRegex returns a grammar matching the regular expression pattern. The
+// whole response must match so ^ and $ anchors are implied. Word boundaries
+// are not supported.
+func FromRegex(pattern string) (string, error) {
+	re, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return "", fmt.Errorf("invalid regex: %w", err)
+	}
+
+	expr, err := fromRegexp(re)
+	if err != nil {
+		return "", fmt.Errorf("invalid regex: %w", err)
+	}
+
+	if expr == "" {
+		expr = `""`
+	}
+
+	return "root ::= " + expr + "\n", nil
+}
+
+func fromRegexp(re *syntax.Regexp) (string, error) {
+	switch re.Op {
+	case syntax.OpNoMatch:
+		return "", errors.New("pattern never matches")
+	case syntax.OpEmptyMatch, syntax.OpBeginLine, syntax.OpEndLine, syntax.OpBeginText, syntax.OpEndText:
+		return "", nil
+	case syntax.OpWordBoundary, syntax.OpNoWordBoundary:
+		return "", errors.New("word boundaries are not supported")
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase == 0 {
+			return literal(string(re.Rune)), nil
+		}
+
+		// match each rune in any case
+		parts := make([]string, len(re.Rune))
+		for i, r := range re.Rune {
+			ranges := []rune{r, r}
+			for f := unicode.SimpleFold(r); f != r; f = unicode.SimpleFold(f) {
+				ranges = append(ranges, f, f)
+			}
+			parts[i] = class(ranges)
+		}
+		return strings.Join(parts, " "), nil
+	case syntax.OpCharClass:
+		if len(re.Rune) == 0 {
+			return "", errors.New("pattern never matches")
+		}
+		return class(re.Rune), nil
+	case syntax.OpAnyCharNotNL:
+		return `[^\n]`, nil
+	case syntax.OpAnyChar:
+		return ".", nil
+	case syntax.OpCapture:
+		return group(re.Sub[0])
+	case syntax.OpStar, syntax.OpPlus, syntax.OpQuest:
+		sub, err := group(re.Sub[0])
+		if err != nil {
+			return "", err
+		}
+
+		switch re.Op {
+		case syntax.OpStar:
+			return sub + "*", nil
+		case syntax.OpPlus:
+			return sub + "+", nil
+		default:
+			return sub + "?", nil
+		}
+	case syntax.OpRepeat:
+		sub, err := group(re.Sub[0])
+		if err != nil {
+			return "", err
+		}
+
+		switch {
+		case re.Max == -1:
+			return fmt.Sprintf("%s{%d,}", sub, re.Min), nil
+		case re.Min == re.Max:
+			return fmt.Sprintf("%s{%d}", sub, re.Min), nil
+		default:
+			return fmt.Sprintf("%s{%d,%d}", sub, re.Min, re.Max), nil
+		}
+	case syntax.OpConcat:
+		var parts []string
+		for _, sub := range re.Sub {
+			s, err := fromRegexp(sub)
+			if err != nil {
+				return "", err
+			}
+
+			if s != "" {
+				parts = append(parts, s)
+			}
+		}
+		return strings.Join(parts, " "), nil
+	case syntax.OpAlternate:
+		alts := make([]string, len(re.Sub))
+		for i, sub := range re.Sub {
+			s, err := fromRegexp(sub)
+			if err != nil {
+				return "", err
+			}
+
+			if s == "" {
+				s = `""`
+			}
+			alts[i] = s
+		}
+		return "(" + strings.Join(alts, " | ") + ")", nil
+	default:
+		return "", fmt.Errorf("unsupported operator %s", re.Op)
+	}
+}
+
+// group returns re as a single term which can be repeated
+func group(re *syntax.Regexp) (string, error) {
+	s, err := fromRegexp(re)
+	if err != nil {
+		return "", err
+	}
+
+	switch {
+	case s == "":
+		return `""`, nil
+	case re.Op == syntax.OpCapture, re.Op == syntax.OpAlternate:
+		// already grouped
+		return s, nil
+	default:
+		return "(" + s + ")", nil
+	}
+}
+
+// literal quotes s as a GBNF string literal
+func literal(s string) string {
+	var sb strings.Builder
+	sb.WriteByte('"')
+	for _, r := range s {
+		switch r {
+		case '"', '\\':
+			sb.WriteByte('\\')
+			sb.WriteRune(r)
+		default:
+			sb.WriteString(escape(r))
+		}
+	}
+	sb.WriteByte('"')
+	return sb.String()
+}
+
+// class returns a GBNF character class from pairs of inclusive rune ranges
+func class(ranges []rune) string {
+	var sb strings.Builder
+	sb.WriteByte('[')
+	for i := 0; i+1 < len(ranges); i += 2 {
+		lo, hi := ranges[i], ranges[i+1]
+		sb.WriteString(classRune(lo))
+		if hi != lo {
+			sb.WriteByte('-')
+			sb.WriteString(classRune(hi))
+		}
+	}
+	sb.WriteByte(']')
+	return sb.String()
+}
+
+func classRune(r rune) string {
+	switch r {
+	case '[', ']', '\\':
+		return `\` + string(r)
+	case '-', '^':
+		// the grammar parser has no escapes for these
+		return fmt.Sprintf(`\x%02X`, r)
+	default:
+		return escape(r)
+	}
+}
+
+// escape returns r as it appears in a GBNF literal or character class,
+// escaping control characters
+func escape(r rune) string {
+	switch {
+	case r == '\n':
+		return `\n`
+	case r == '\r':
+		return `\r`
+	case r == '\t':
+		return `\t`
+	case r < 0x20 || r == 0x7f:
+		return fmt.Sprintf(`\x%02X`, r)
+	case r >= 0x80 && !unicode.IsPrint(r):
+		if r > 0xffff {
+			return fmt.Sprintf(`\U%08X`, r)
+		}
+		return fmt.Sprintf(`\u%04X`, r)
+	default:
+		return string(r)
+	}
+}
@@ -0,0 +1,79 @@
+package grammar
+
+import (
+	"testing"
+)
+
+func TestFromChoices(t *testing.T) {
+	cases := []struct {
+		choices []string
+		expect  string
+		err     bool
+	}{
+		{choices: []string{"positive", "negative"}, expect: "root ::= \"positive\" | \"negative\"\n"},
+		{choices: []string{`say "hi"`, `a\b`, "line\nbreak"}, expect: `root ::= "say \"hi\"" | "a\\b" | "line\nbreak"` + "\n"},
+		{choices: []string{""}, expect: "root ::= \"\"\n"},
+		{choices: nil, err: true},
+	}
+
+	for _, tt := range cases {
+		g, err := FromChoices(tt.choices)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%q: expected error, got %q", tt.choices, g)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g != tt.expect {
+			t.Errorf("%q: expected %q, got %q", tt.choices, tt.expect, g)
+		}
+	}
+}
+
+func TestFromRegex(t *testing.T) {
+	cases := []struct {
+		pattern string
+		expect  string
+		err     bool
+	}{
+		{pattern: `abc`, expect: `root ::= "abc"`},
+		{pattern: `^[0-9]{3}-[0-9]{4}$`, expect: `root ::= ([0-9]){3} "-" ([0-9]){4}`},
+		{pattern: `(yes|no)`, expect: `root ::= ("yes" | "no")`},
+		{pattern: `[a-z]+@[a-z]+\.com`, expect: `root ::= ([a-z])+ "@" ([a-z])+ ".com"`},
+		{pattern: `a*b?c{2,}d{1,3}`, expect: `root ::= ("a")* ("b")? ("c"){2,} ("d"){1,3}`},
+		{pattern: `(?i)ok`, expect: "root ::= [Oo] [Kk\u212A]"},
+		{pattern: `[-^\]]`, expect: `root ::= [\x2D\]-\x5E]`},
+		{pattern: `.\n`, expect: `root ::= [^\n] "\n"`},
+		{pattern: `(?s).`, expect: `root ::= .`},
+		{pattern: `^$`, expect: `root ::= ""`},
+		{pattern: `a|`, expect: `root ::= ("a" | "")`},
+		{pattern: `(ab|c)+`, expect: `root ::= ("ab" | "c")+`},
+		{pattern: `(ab)*`, expect: `root ::= ("ab")*`},
+		{pattern: `"\\`, expect: `root ::= "\"\\"`},
+		{pattern: `\bword\b`, err: true},
+		{pattern: `[`, err: true},
+	}
+
+	for _, tt := range cases {
+		g, err := FromRegex(tt.pattern)
+		if tt.err {
+			if err == nil {
+				t.Errorf("%s: expected error, got %q", tt.pattern, g)
+			}
+			continue
+		}
+
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if g != tt.expect+"\n" {
+			t.Errorf("%s: expected %q, got %q", tt.pattern, tt.expect+"\n", g)
+		}
+	}
+}
@@ -136,6 +136,12 @@ func (c *Context) Model() *Model {
 	return &Model{c: C.llama_get_model(c.c)}
 }
 
+// NumUbatch returns the largest number of tokens decoded at once. Models
+// without causal attention can't decode a batch larger than this.
+func (c *Context) NumUbatch() int {
+	return int(C.llama_n_ubatch(c.c))
+}
+
 func (c *Context) GetLogitsIth(i int) []float32 {
 	return unsafe.Slice((*float32)(unsafe.Pointer(C.llama_get_logits_ith(c.c, C.int(i)))), c.Model().NumVocab())
 }
@@ -152,13 +158,65 @@ func (c *Context) KvCacheSeqCp(srcSeqId int, dstSeqId int, p0 int, p1 int) {
 	C.llama_kv_cache_seq_cp(c.c, C.int(srcSeqId), C.int(dstSeqId), C.int(p0), C.int(p1))
 }
 
-// Get the embeddings for a sequence id
+// StateSeqSaveFile writes the KV cache of a sequence to a file along with
+// the tokens it holds
+func (c *Context) StateSeqSaveFile(path string, seqId int, tokens []int) error {
+	if len(tokens) == 0 {
+		return errors.New("no tokens to save")
+	}
+
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	cTokens := make([]C.llama_token, len(tokens))
+	for i, t := range tokens {
+		cTokens[i] = C.llama_token(t)
+	}
+
+	if C.llama_state_seq_save_file(c.c, cPath, C.llama_seq_id(seqId), &cTokens[0], C.size_t(len(tokens))) == 0 {
+		return fmt.Errorf("failed to save state of sequence %d", seqId)
+	}
+
+	return nil
+}
+
+// StateSeqLoadFile restores the KV cache of a sequence from a file written by
+// StateSeqSaveFile and returns its tokens, of which there may be at most
+// maxTokens
+func (c *Context) StateSeqLoadFile(path string, seqId int, maxTokens int) ([]int, error) {
+	if maxTokens <= 0 {
+		return nil, errors.New("no room for tokens")
+	}
+
+	cPath := C.CString(path)
+	defer C.free(unsafe.Pointer(cPath))
+
+	cTokens := make([]C.llama_token, maxTokens)
+	var n C.size_t
+	if C.llama_state_seq_load_file(c.c, cPath, C.llama_seq_id(seqId), &cTokens[0], C.size_t(maxTokens), &n) == 0 {
+		return nil, fmt.Errorf("failed to load state of sequence %d", seqId)
+	}
+
+	tokens := make([]int, n)
+	for i := range tokens {
+		tokens[i] = int(cTokens[i])
+	}
+
+	return tokens, nil
+}
+
+// Get the embeddings for a sequence id. Reranking models return a single
+// relevance score.
 func (c *Context) GetEmbeddingsSeq(seqId int) []float32 {
 	embeddings := unsafe.Pointer(C.llama_get_embeddings_seq(c.c, C.int(seqId)))
 	if embeddings == nil {
 		return nil
 	}
 
+	if C.llama_pooling_type(c.c) == C.LLAMA_POOLING_TYPE_RANK {
+		return unsafe.Slice((*float32)(embeddings), 1)
+	}
+
 	return unsafe.Slice((*float32)(embeddings), c.Model().NEmbd())
 }
 
@@ -184,7 +242,7 @@ func llamaProgressCallback(progress C.float, userData unsafe.Pointer) C.bool {
 	return true
 }
 
-func LoadModelFromFile(modelPath string, params ModelParams) *Model {
+func LoadModelFromFile(modelPath string, params ModelParams) (*Model, error) {
 	cparams := C.llama_model_default_params()
 	cparams.n_gpu_layers = C.int(params.NumGpuLayers)
 	cparams.main_gpu = C.int32_t(params.MainGpu)
@@ -214,7 +272,12 @@ func LoadModelFromFile(modelPath string, params ModelParams) *Model {
 		cparams.progress_callback_user_data = unsafe.Pointer(&handle)
 	}
 
-	return &Model{c: C.llama_load_model_from_file(C.CString(modelPath), cparams)}
+	m := Model{c: C.llama_load_model_from_file(C.CString(modelPath), cparams)}
+	if m.c == nil {
+		return nil, fmt.Errorf("unable to load model: %s", modelPath)
+	}
+
+	return &m, nil
 }
 
 func FreeModel(model *Model) {
@@ -595,3 +658,37 @@ func (s *SamplingContext) Sample(llamaContext *Context, idx int) int {
 func (s *SamplingContext) Accept(id int, applyGrammar bool) {
 	C.gpt_sampler_caccept(s.c, C.llama_token(id), C.bool(applyGrammar))
 }
+
+// SchemaToGrammar converts a JSON schema to a grammar which constrains
+// sampling to JSON matching the schema
+func SchemaToGrammar(schema []byte) (string, error) {
+	cSchema := C.CString(string(schema))
+	defer C.free(unsafe.Pointer(cSchema))
+
+	buf := make([]byte, 32*1024)
+	for {
+		n := int(C.schema_to_grammar(cSchema, (*C.char)(unsafe.Pointer(&buf[0])), C.size_t(len(buf))))
+		switch {
+		case n < 0:
+			return "", fmt.Errorf("invalid JSON schema: %s", C.GoString((*C.char)(unsafe.Pointer(&buf[0]))))
+		case n < len(buf):
+			return string(buf[:n]), nil
+		}
+
+		buf = make([]byte, n+1)
+	}
+}
+
+// ValidateGrammar checks that grammar is a valid GBNF grammar with a root
+// rule which can be used for sampling
+func ValidateGrammar(grammar string) error {
+	cGrammar := C.CString(grammar)
+	defer C.free(unsafe.Pointer(cGrammar))
+
+	var buf [512]C.char
+	if !C.grammar_validate(cGrammar, &buf[0], C.size_t(len(buf))) {
+		return fmt.Errorf("invalid grammar: %s", C.GoString(&buf[0]))
+	}
+
+	return nil
+}
@@ -1 +1,83 @@
 package llama
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestSchemaToGrammar(t *testing.T) {
+	cases := []struct {
+		schema string
+		prefix string
+		err    bool
+	}{
+		{
+			schema: `{"type": "object", "properties": {"name": {"type": "string"}}, "required": ["name"]}`,
+			prefix: `name-kv ::= "\"name\"" space ":" space string`,
+		},
+		{
+			schema: `{"type": "array", "items": {"type": "integer"}}`,
+			prefix: `integral-part ::=`,
+		},
+		{schema: `{"type": `, err: true},
+		{schema: `{"$ref": "#/definitions/missing"}`, err: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.schema, func(t *testing.T) {
+			g, err := SchemaToGrammar([]byte(tt.schema))
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, got grammar %q", g)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if !strings.Contains(g, "root ::=") || !strings.Contains(g, tt.prefix) {
+				t.Errorf("unexpected grammar %q", g)
+			}
+		})
+	}
+}
+
+func TestValidateGrammar(t *testing.T) {
+	cases := []struct {
+		grammar string
+		err     string
+	}{
+		{grammar: `root ::= "yes" | "no"`},
+		{grammar: "# answers\nroot ::= answer\nanswer ::= [0-9]+ \".\"\n"},
+		{grammar: ``, err: "invalid grammar: missing 'root' rule"},
+		{grammar: `answer ::= "yes"`, err: "invalid grammar: missing 'root' rule"},
+		{grammar: `root ::= answer`, err: "invalid grammar: undefined rule 'answer'"},
+		{grammar: `root ::= root "a" | "a"`, err: "invalid grammar: left recursion is not supported"},
+		{grammar: `root ::= "yes`, err: "invalid grammar: unexpected end of input"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.grammar, func(t *testing.T) {
+			err := ValidateGrammar(tt.grammar)
+			if tt.err == "" {
+				if err != nil {
+					t.Fatal(err)
+				}
+				return
+			}
+
+			if err == nil || err.Error() != tt.err {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
+
+func TestLoadModelFromFileMissing(t *testing.T) {
+	if _, err := LoadModelFromFile(filepath.Join(t.TempDir(), "missing.gguf"), ModelParams{VocabOnly: true}); err == nil {
+		t.Error("expected an error loading a missing model")
+	}
+}
@@ -1,10 +1,20 @@
 package main
 
 import (
+	"cmp"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"errors"
+	"fmt"
 	"hash/maphash"
 	"log/slog"
+	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
+	"strconv"
+	"strings"
 	"time"
 
 	"github.com/ollama/ollama/llama"
@@ -24,6 +34,13 @@ type InputCache struct {
 	images    []imageCache
 	imageHash maphash.Hash
 
+	// directory of prompts saved to disk, longest first, and the total
+	// size they may take up before the least recently used are removed
+	promptDir    string
+	saved        []savedPrompt
+	savedSize    int64
+	maxSavedSize int64
+
 	lc *llama.Context
 }
 
@@ -62,9 +79,15 @@ type InputCacheSlot struct {
 
 	// last time this cache was used (as of start of processing)
 	lastUsed time.Time
+
+	// chat session that last used this cache, if any
+	session string
 }
 
-func (c *InputCache) LoadCacheSlot(prompt []input, cachePrompt bool) (*InputCacheSlot, []input, int, error) {
+// LoadCacheSlot finds a slot for prompt. A request that is part of a chat
+// session goes back to the slot the session last used if it is free, so its
+// turns keep reusing the same KV cache.
+func (c *InputCache) LoadCacheSlot(prompt []input, session string, cachePrompt bool) (*InputCacheSlot, []input, int, error) {
 	var slot *InputCacheSlot
 	var numPast int
 	var err error
@@ -75,7 +98,13 @@ func (c *InputCache) LoadCacheSlot(prompt []input, cachePrompt bool) (*InputCach
 	// For multiple users, the "best" cache slot produces better input cache hit rates
 	// at the cost of worse performance when we miss the input cache (because it causes
 	// GPU L2 cache misses due to spreading out accesses across VRAM).
-	if !c.multiUserCache {
+	if session != "" {
+		slot, numPast = c.findSessionCacheSlot(prompt, session)
+	}
+
+	if slot != nil {
+		slog.Debug("reusing session cache slot", "id", slot.Id, "session", session)
+	} else if !c.multiUserCache {
 		slot, numPast, err = c.findLongestCacheSlot(prompt)
 	} else {
 		slot, numPast, err = c.findBestCacheSlot(prompt)
@@ -84,12 +113,22 @@ func (c *InputCache) LoadCacheSlot(prompt []input, cachePrompt bool) (*InputCach
 		return nil, nil, 0, err
 	}
 
-	if !cachePrompt {
+	if cachePrompt {
+		if saved, ok := c.findSavedPrompt(prompt, numPast); ok {
+			if err := c.restoreSavedPrompt(slot, saved); err != nil {
+				slog.Warn("failed to restore saved prompt", "inputs", saved.numTokens, "error", err)
+				numPast = 0
+			} else {
+				numPast = saved.numTokens
+			}
+		}
+	} else {
 		numPast = 0
 	}
 
 	slot.InUse = true
 	slot.lastUsed = time.Now()
+	slot.session = session
 
 	if numPast == len(prompt) {
 		// Leave one input to sample so we can get a response
@@ -111,6 +150,18 @@ func (c *InputCache) LoadCacheSlot(prompt []input, cachePrompt bool) (*InputCach
 	return slot, prompt, numPast, nil
 }
 
+// findSessionCacheSlot returns the free slot last used by session, or nil if
+// there is none
+func (c *InputCache) findSessionCacheSlot(prompt []input, session string) (*InputCacheSlot, int) {
+	for i, s := range c.slots {
+		if s.session == session && !s.InUse {
+			return &c.slots[i], countCommonPrefix(s.Inputs, prompt)
+		}
+	}
+
+	return nil, 0
+}
+
 func (c *InputCache) findLongestCacheSlot(prompt []input) (*InputCacheSlot, int, error) {
 	longest := -1
 	var longestSlot *InputCacheSlot
@@ -263,3 +314,232 @@ func (c *InputCache) AddImage(hash uint64, embed [][]float32) {
 	c.images[bestImage].val = embed
 	c.images[bestImage].lastUsed = time.Now()
 }
+
+// Saved prompts are KV caches of prompt prefixes written to disk so that
+// they outlive the runner. Each is stored in the directory passed to
+// LoadSavedPrompts as <number of tokens>-<hash of tokens>.kv, and its
+// modification time records when it was last used. Locking: as for
+// InputCacheSlot.
+
+type savedPrompt struct {
+	numTokens int
+	hash      string
+	size      int64
+	lastUsed  time.Time
+}
+
+// hashInputs returns the hash of a prefix of tokens under which its KV
+// cache is saved
+func hashInputs(inputs []input) string {
+	h := sha256.New()
+	b := make([]byte, 0, 4*len(inputs))
+	for _, input := range inputs {
+		b = binary.LittleEndian.AppendUint32(b, uint32(input.token))
+	}
+	h.Write(b)
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+func hasEmbeddings(inputs []input) bool {
+	return slices.ContainsFunc(inputs, func(i input) bool { return i.embed != nil })
+}
+
+// LoadSavedPrompts indexes the prompts saved in dir, creating it if needed.
+// Prompts are only saved and restored once it has been called. If maxSize is
+// positive, the least recently used prompts are removed to keep the total
+// size of the directory within it.
+func (c *InputCache) LoadSavedPrompts(dir string, maxSize int64) error {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return err
+	}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return err
+	}
+
+	c.promptDir = dir
+	c.maxSavedSize = maxSize
+	c.saved = nil
+	c.savedSize = 0
+	for _, entry := range entries {
+		name, ok := strings.CutSuffix(entry.Name(), ".kv")
+		if !ok || entry.IsDir() {
+			continue
+		}
+
+		n, hash, ok := strings.Cut(name, "-")
+		if !ok {
+			continue
+		}
+
+		numTokens, err := strconv.Atoi(n)
+		if err != nil || numTokens <= 0 {
+			continue
+		}
+
+		fi, err := entry.Info()
+		if err != nil {
+			continue
+		}
+
+		c.saved = append(c.saved, savedPrompt{numTokens: numTokens, hash: hash, size: fi.Size(), lastUsed: fi.ModTime()})
+		c.savedSize += fi.Size()
+	}
+
+	c.sortSavedPrompts()
+	c.evictSavedPrompts()
+	slog.Debug("loaded saved prompts", "dir", dir, "count", len(c.saved))
+	return nil
+}
+
+// sortSavedPrompts orders saved prompts longest first
+func (c *InputCache) sortSavedPrompts() {
+	slices.SortFunc(c.saved, func(a, b savedPrompt) int {
+		return cmp.Or(cmp.Compare(b.numTokens, a.numTokens), strings.Compare(a.hash, b.hash))
+	})
+}
+
+func (c *InputCache) savedPromptPath(p savedPrompt) string {
+	return filepath.Join(c.promptDir, fmt.Sprintf("%d-%s.kv", p.numTokens, p.hash))
+}
+
+// evictSavedPrompts removes the least recently used saved prompts until
+// they fit in maxSavedSize
+func (c *InputCache) evictSavedPrompts() {
+	for c.maxSavedSize > 0 && c.savedSize > c.maxSavedSize && len(c.saved) > 0 {
+		oldest := 0
+		for i := range c.saved {
+			if c.saved[i].lastUsed.Before(c.saved[oldest].lastUsed) {
+				oldest = i
+			}
+		}
+
+		p := c.saved[oldest]
+		if err := os.Remove(c.savedPromptPath(p)); err != nil && !errors.Is(err, os.ErrNotExist) {
+			slog.Warn("failed to remove saved prompt", "error", err)
+		}
+
+		c.saved = slices.Delete(c.saved, oldest, oldest+1)
+		c.savedSize -= p.size
+		slog.Debug("evicted saved prompt", "inputs", p.numTokens, "size", p.size)
+	}
+}
+
+// findSavedPrompt returns the longest saved prompt that is a prefix of
+// prompt and longer than numPast
+func (c *InputCache) findSavedPrompt(prompt []input, numPast int) (savedPrompt, bool) {
+	for _, p := range c.saved {
+		if p.numTokens <= numPast {
+			break
+		}
+
+		if p.numTokens > len(prompt) || hasEmbeddings(prompt[:p.numTokens]) {
+			continue
+		}
+
+		if hashInputs(prompt[:p.numTokens]) == p.hash {
+			return p, true
+		}
+	}
+
+	return savedPrompt{}, false
+}
+
+// restoreSavedPrompt replaces the contents of slot with a saved prompt. If
+// it fails the slot is left empty.
+func (c *InputCache) restoreSavedPrompt(slot *InputCacheSlot, p savedPrompt) error {
+	c.lc.KvCacheSeqRm(slot.Id, 0, -1)
+	slot.Inputs = slot.Inputs[:0]
+
+	tokens, err := c.lc.StateSeqLoadFile(c.savedPromptPath(p), slot.Id, p.numTokens)
+	if err == nil && len(tokens) != p.numTokens {
+		err = fmt.Errorf("expected %d tokens, got %d", p.numTokens, len(tokens))
+	}
+
+	if err != nil {
+		c.lc.KvCacheSeqRm(slot.Id, 0, -1)
+		return err
+	}
+
+	for _, t := range tokens {
+		slot.Inputs = append(slot.Inputs, input{token: t})
+	}
+
+	c.touchSavedPrompt(p)
+
+	slog.Debug("restored saved prompt", "id", slot.Id, "inputs", p.numTokens)
+	return nil
+}
+
+// SavePrompt writes the KV cache of the first numTokens inputs of slot to
+// disk so later requests starting with them can restore it instead of
+// evaluating them. The rest of the slot is discarded.
+func (c *InputCache) SavePrompt(slot *InputCacheSlot, numTokens int) error {
+	if c.promptDir == "" {
+		return errors.New("saving prompts is not enabled")
+	}
+
+	if numTokens <= 0 || numTokens > len(slot.Inputs) {
+		return fmt.Errorf("cannot save %d of %d inputs", numTokens, len(slot.Inputs))
+	}
+
+	if hasEmbeddings(slot.Inputs[:numTokens]) {
+		return errors.New("prompts with images cannot be saved")
+	}
+
+	c.lc.KvCacheSeqRm(slot.Id, numTokens, -1)
+	slot.Inputs = slot.Inputs[:numTokens]
+
+	p := savedPrompt{numTokens: numTokens, hash: hashInputs(slot.Inputs)}
+	if slices.ContainsFunc(c.saved, func(s savedPrompt) bool { return s.numTokens == p.numTokens && s.hash == p.hash }) {
+		c.touchSavedPrompt(p)
+		return nil
+	}
+
+	tokens := make([]int, numTokens)
+	for i, input := range slot.Inputs {
+		tokens[i] = input.token
+	}
+
+	path := c.savedPromptPath(p)
+	if err := c.lc.StateSeqSaveFile(path+".tmp", slot.Id, tokens); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+
+	fi, err := os.Stat(path + ".tmp")
+	if err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return err
+	}
+
+	p.size = fi.Size()
+	p.lastUsed = time.Now()
+	c.saved = append(c.saved, p)
+	c.savedSize += p.size
+	c.sortSavedPrompts()
+	c.evictSavedPrompts()
+
+	slog.Debug("saved prompt", "id", slot.Id, "inputs", numTokens, "path", path)
+	return nil
+}
+
+// touchSavedPrompt marks a saved prompt as used so that it is evicted last
+func (c *InputCache) touchSavedPrompt(p savedPrompt) {
+	now := time.Now()
+	for i := range c.saved {
+		if c.saved[i].numTokens == p.numTokens && c.saved[i].hash == p.hash {
+			c.saved[i].lastUsed = now
+		}
+	}
+
+	if err := os.Chtimes(c.savedPromptPath(p), now, now); err != nil {
+		slog.Debug("failed to update saved prompt", "error", err)
+	}
+}
@@ -1,7 +1,12 @@
 package main
 
 import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
 	"reflect"
+	"slices"
 	"testing"
 	"time"
 )
@@ -229,6 +234,41 @@ func TestFindCacheSlot(t *testing.T) {
 	}
 }
 
+func TestFindSessionCacheSlot(t *testing.T) {
+	cache := InputCache{slots: []InputCacheSlot{
+		{
+			Id:      0,
+			Inputs:  []input{{token: 1}, {token: 2}, {token: 3}},
+			session: "a",
+		},
+		{
+			Id:      1,
+			Inputs:  []input{{token: 1}, {token: 2}},
+			session: "b",
+		},
+		{
+			Id:      2,
+			Inputs:  []input{{token: 1}},
+			InUse:   true,
+			session: "c",
+		},
+	}}
+
+	prompt := []input{{token: 1}, {token: 2}, {token: 3}, {token: 4}}
+
+	if slot, n := cache.findSessionCacheSlot(prompt, "b"); slot == nil || slot.Id != 1 || n != 2 {
+		t.Errorf("session b: slot have %v, want 1 len have %v, want 2", slot, n)
+	}
+
+	if slot, _ := cache.findSessionCacheSlot(prompt, "c"); slot != nil {
+		t.Errorf("session c: expected no slot while in use, got %v", slot.Id)
+	}
+
+	if slot, _ := cache.findSessionCacheSlot(prompt, "d"); slot != nil {
+		t.Errorf("session d: expected no slot, got %v", slot.Id)
+	}
+}
+
 func TestImageCache(t *testing.T) {
 	cache := NewInputCache(nil, 2048, 4, false)
 
@@ -302,3 +342,110 @@ func TestImageCache(t *testing.T) {
 		t.Errorf("failed to find expected value: result %v, err %v", result, err)
 	}
 }
+
+func TestSavedPrompts(t *testing.T) {
+	dir := t.TempDir()
+
+	prefix := []input{{token: 1}, {token: 2}, {token: 3}}
+	longer := append(slices.Clone(prefix), input{token: 4}, input{token: 5})
+
+	for _, name := range []string{
+		fmt.Sprintf("%d-%s.kv", len(prefix), hashInputs(prefix)),
+		fmt.Sprintf("%d-%s.kv", len(longer), hashInputs(longer)),
+		"unrelated.txt",
+		"x-abc.kv",
+	} {
+		if err := os.WriteFile(filepath.Join(dir, name), nil, 0o644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var c InputCache
+	if err := c.LoadSavedPrompts(dir, 0); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.saved) != 2 || c.saved[0].numTokens != 5 || c.saved[1].numTokens != 3 {
+		t.Fatalf("unexpected saved prompts %+v", c.saved)
+	}
+
+	tests := []struct {
+		name    string
+		prompt  []input
+		numPast int
+		want    int
+	}{
+		{"longest", []input{{token: 1}, {token: 2}, {token: 3}, {token: 4}, {token: 5}, {token: 6}}, 0, 5},
+		{"prefix", []input{{token: 1}, {token: 2}, {token: 3}, {token: 7}}, 0, 3},
+		{"already cached", []input{{token: 1}, {token: 2}, {token: 3}, {token: 7}}, 3, 0},
+		{"no match", []input{{token: 2}, {token: 3}, {token: 4}}, 0, 0},
+		{"image", []input{{token: 1}, {embed: []float32{0.1}}, {token: 3}, {token: 7}}, 0, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p, ok := c.findSavedPrompt(tt.prompt, tt.numPast)
+			if tt.want == 0 && ok {
+				t.Errorf("expected no saved prompt, got %+v", p)
+			} else if tt.want > 0 && (!ok || p.numTokens != tt.want) {
+				t.Errorf("expected saved prompt of %d tokens, got %+v", tt.want, p)
+			}
+		})
+	}
+}
+
+func TestSavedPromptsEvict(t *testing.T) {
+	dir := t.TempDir()
+
+	prompts := [][]input{
+		{{token: 1}},
+		{{token: 1}, {token: 2}},
+		{{token: 1}, {token: 2}, {token: 3}},
+	}
+
+	names := make([]string, len(prompts))
+	now := time.Now()
+	for i, prompt := range prompts {
+		names[i] = fmt.Sprintf("%d-%s.kv", len(prompt), hashInputs(prompt))
+		path := filepath.Join(dir, names[i])
+		if err := os.WriteFile(path, make([]byte, 10), 0o644); err != nil {
+			t.Fatal(err)
+		}
+
+		// the shortest prompt was used most recently
+		used := now.Add(-time.Duration(i+1) * time.Hour)
+		if err := os.Chtimes(path, used, used); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	var c InputCache
+	if err := c.LoadSavedPrompts(dir, 25); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.saved) != 2 || c.savedSize != 20 {
+		t.Fatalf("expected the least recently used prompt to be removed, got %+v", c.saved)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, names[2])); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected %s to be removed, got %v", names[2], err)
+	}
+
+	// using a prompt moves it to the back of the queue
+	c.touchSavedPrompt(c.saved[0])
+	c.maxSavedSize = 15
+	c.evictSavedPrompts()
+
+	if len(c.saved) != 1 || c.saved[0].numTokens != 2 {
+		t.Fatalf("expected the touched prompt to be kept, got %+v", c.saved)
+	}
+
+	if err := c.LoadSavedPrompts(dir, 15); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(c.saved) != 1 || c.saved[0].numTokens != 2 {
+		t.Errorf("expected the touched prompt to be kept after reloading, got %+v", c.saved)
+	}
+}
@@ -0,0 +1,191 @@
+package main
+
+import (
+	"log/slog"
+	"slices"
+	"sync/atomic"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llama"
+)
+
+// drafter runs a smaller draft model alongside the main model to propose
+// tokens for speculative decoding. The main model then verifies all of the
+// proposed tokens in a single batch.
+type drafter struct {
+	model *llama.Model
+	lc    *llama.Context
+	batch *llama.Batch
+
+	batchSize int
+
+	// inputs in the draft KV cache for each cache slot
+	inputs [][]input
+
+	// running totals of proposed and accepted tokens, reported by /health
+	proposed atomic.Int64
+	accepted atomic.Int64
+}
+
+func newDrafter(model *llama.Model, lc *llama.Context, batchSize, parallel int) *drafter {
+	return &drafter{
+		model:     model,
+		lc:        lc,
+		batch:     llama.NewBatch(batchSize, 0, 1),
+		batchSize: batchSize,
+		inputs:    make([][]input, parallel),
+	}
+}
+
+// propose returns up to n tokens that the draft model predicts will follow
+// inputs in cache slot id. Drafting is greedy and stops early at the end of
+// generation.
+func (d *drafter) propose(id int, inputs []input, n int) []int {
+	// reuse whatever the draft KV cache has in common with inputs, but always
+	// decode the last input again to have its logits
+	var numPast int
+	for numPast < min(len(d.inputs[id]), len(inputs)-1) && d.inputs[id][numPast].token == inputs[numPast].token {
+		numPast++
+	}
+
+	if !d.lc.KvCacheSeqRm(id, numPast, -1) {
+		d.lc.KvCacheSeqRm(id, 0, -1)
+		numPast = 0
+	}
+	d.inputs[id] = d.inputs[id][:numPast]
+
+	if err := d.decode(id, inputs[numPast:]); err != nil {
+		slog.Debug("failed to decode draft", "error", err)
+		return nil
+	}
+
+	var draft []int
+	for {
+		logits := d.lc.GetLogitsIth(d.batch.NumTokens() - 1)
+		token := argmax(logits)
+		if d.model.TokenIsEog(token) {
+			break
+		}
+
+		draft = append(draft, token)
+		if len(draft) >= n {
+			break
+		}
+
+		if err := d.decode(id, []input{{token: token}}); err != nil {
+			slog.Debug("failed to decode draft", "error", err)
+			break
+		}
+	}
+
+	return draft
+}
+
+// decode evaluates inputs in cache slot id, keeping the logits of the last
+func (d *drafter) decode(id int, inputs []input) error {
+	for i := 0; i < len(inputs); i += d.batchSize {
+		d.batch.Clear()
+
+		chunk := inputs[i:min(i+d.batchSize, len(inputs))]
+		for j, input := range chunk {
+			d.batch.Add(input.token, nil, len(d.inputs[id])+j, []int{id}, i+j+1 == len(inputs))
+		}
+
+		if err := d.lc.Decode(d.batch); err != nil {
+			return err
+		}
+
+		d.inputs[id] = append(d.inputs[id], chunk...)
+	}
+
+	return nil
+}
+
+// record adds the outcome of verifying a draft to the running totals
+func (d *drafter) record(proposed, accepted int) {
+	d.proposed.Add(int64(proposed))
+	d.accepted.Add(int64(accepted))
+}
+
+func argmax(logits []float32) int {
+	var best int
+	for i, logit := range logits {
+		if logit > logits[best] {
+			best = i
+		}
+	}
+
+	return best
+}
+
+// proposeDraft adds tokens from the draft model to the inputs of a sequence
+// that is generating, to be verified in the next batch
+func (s *Server) proposeDraft(seq *Sequence) {
+	if s.draft == nil || seq.numDraft <= 0 || seq.numPredicted == 0 || seq.embeddingOnly || seq.prefill {
+		return
+	}
+
+	// the draft model only sees tokens so it can't draft after images
+	if len(seq.inputs) != 1 || seq.inputs[0].embed != nil || hasEmbeddings(seq.cache.Inputs) {
+		return
+	}
+
+	n := min(seq.numDraft, s.cache.numCtx-seq.numPast-1, s.batchSize-1)
+	if seq.numPredict > 0 {
+		n = min(n, seq.numPredict-seq.numPredicted)
+	}
+
+	if n <= 0 {
+		return
+	}
+
+	seq.draft = s.draft.propose(seq.cache.Id, append(slices.Clone(seq.cache.Inputs), seq.inputs[0]), n)
+	for _, token := range seq.draft {
+		seq.inputs = append(seq.inputs, input{token: token})
+	}
+}
+
+// verifyDraft samples the tokens of a sequence that was decoded with a
+// draft, accepting draft tokens for as long as they match what the main model
+// samples. Rejected draft tokens are removed from the KV cache.
+func (s *Server) verifyDraft(i int, seq *Sequence) {
+	n := len(seq.draft)
+
+	var tokens []int
+	var lps []api.TokenLogprob
+	for j := 0; j <= n; j++ {
+		idx := seq.iBatch - n + j
+
+		token := seq.samplingCtx.Sample(s.lc, idx)
+		seq.samplingCtx.Accept(token, true)
+		tokens = append(tokens, token)
+
+		var logprob api.TokenLogprob
+		if seq.logprobs {
+			logprob = logprobs(s.lc.GetLogitsIth(idx), token, seq.topLogprobs, s.model.TokenToPiece)
+		}
+		lps = append(lps, logprob)
+
+		if j == n || token != seq.draft[j] {
+			break
+		}
+	}
+
+	accepted := len(tokens) - 1
+	rejected := n - accepted
+	s.draft.record(n, accepted)
+	seq.draft = nil
+	seq.numDecoded += accepted
+
+	seq.numPast -= rejected
+	s.lc.KvCacheSeqRm(seq.cache.Id, seq.numPast, -1)
+	inputs := seq.cache.Inputs[:len(seq.cache.Inputs)-rejected]
+
+	for j, token := range tokens {
+		// as with a single token, the cache holds every input before this one
+		seq.cache.Inputs = inputs[:len(inputs)-accepted+j]
+		if !s.emitToken(i, seq, token, lps[j]) {
+			return
+		}
+	}
+}
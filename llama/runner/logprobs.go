@@ -0,0 +1,74 @@
+package main
+
+import (
+	"math"
+	"slices"
+
+	"github.com/ollama/ollama/api"
+)
+
+// maxTopLogprobs limits the number of alternatives returned per token
+const maxTopLogprobs = 20
+
+// logprobs computes the log probability of token from the raw logits along
+// with the topN most likely tokens. Probabilities are taken before sampling
+// parameters such as temperature are applied.
+func logprobs(logits []float32, token int, topN int, piece func(int) string) api.TokenLogprob {
+	// log-sum-exp, offset by the max logit for numerical stability
+	maxLogit := float32(math.Inf(-1))
+	for _, l := range logits {
+		maxLogit = max(maxLogit, l)
+	}
+
+	var sum float64
+	for _, l := range logits {
+		sum += math.Exp(float64(l - maxLogit))
+	}
+
+	lse := float64(maxLogit) + math.Log(sum)
+
+	lp := api.TokenLogprob{
+		Token:   piece(token),
+		Logprob: float64(logits[token]) - lse,
+	}
+
+	topN = min(topN, maxTopLogprobs, len(logits))
+	if topN <= 0 {
+		return lp
+	}
+
+	// keep the indices of the topN logits in descending order
+	top := make([]int, 0, topN+1)
+	for i, l := range logits {
+		if len(top) == topN && l <= logits[top[topN-1]] {
+			continue
+		}
+
+		j, _ := slices.BinarySearchFunc(top, l, func(idx int, l float32) int {
+			// descending order; equal logits keep the lower token id first
+			switch {
+			case logits[idx] > l:
+				return -1
+			case logits[idx] < l:
+				return 1
+			default:
+				return -1
+			}
+		})
+
+		top = slices.Insert(top, j, i)
+		if len(top) > topN {
+			top = top[:topN]
+		}
+	}
+
+	lp.TopLogprobs = make([]api.TokenLogprob, len(top))
+	for i, t := range top {
+		lp.TopLogprobs[i] = api.TokenLogprob{
+			Token:   piece(t),
+			Logprob: float64(logits[t]) - lse,
+		}
+	}
+
+	return lp
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"fmt"
+	"math"
+	"testing"
+)
+
+func TestLogprobs(t *testing.T) {
+	piece := func(i int) string { return fmt.Sprintf("t%d", i) }
+
+	// probabilities 0.1, 0.2, 0.3, 0.4
+	logits := []float32{
+		float32(math.Log(0.1)),
+		float32(math.Log(0.2)),
+		float32(math.Log(0.3)),
+		float32(math.Log(0.4)),
+	}
+
+	tests := []struct {
+		name     string
+		logits   []float32
+		token    int
+		topN     int
+		expected []string
+	}{
+		{"no alternatives", logits, 1, 0, nil},
+		{"top 2", logits, 1, 2, []string{"t3", "t2"}},
+		{"top more than vocab", logits, 0, 10, []string{"t3", "t2", "t1", "t0"}},
+		{"shifted logits", []float32{100, 101, 102, 103}, 3, 1, []string{"t3"}},
+		{"ties", []float32{1, 2, 2, 0}, 0, 2, []string{"t1", "t2"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			lp := logprobs(tt.logits, tt.token, tt.topN, piece)
+			if lp.Token != piece(tt.token) {
+				t.Errorf("expected token %q, got %q", piece(tt.token), lp.Token)
+			}
+
+			if len(lp.TopLogprobs) != len(tt.expected) {
+				t.Fatalf("expected %d alternatives, got %d", len(tt.expected), len(lp.TopLogprobs))
+			}
+
+			var sum float64
+			for i, top := range lp.TopLogprobs {
+				if top.Token != tt.expected[i] {
+					t.Errorf("expected alternative %d to be %q, got %q", i, tt.expected[i], top.Token)
+				}
+
+				if i > 0 && top.Logprob > lp.TopLogprobs[i-1].Logprob {
+					t.Errorf("alternatives are not in descending order")
+				}
+
+				sum += math.Exp(top.Logprob)
+			}
+
+			if len(lp.TopLogprobs) == len(tt.logits) && math.Abs(sum-1) > 1e-5 {
+				t.Errorf("expected probabilities to sum to 1, got %f", sum)
+			}
+		})
+	}
+
+	lp := logprobs(logits, 1, 0, piece)
+	if math.Abs(lp.Logprob-math.Log(0.2)) > 1e-5 {
+		t.Errorf("expected logprob %f, got %f", math.Log(0.2), lp.Logprob)
+	}
+
+	lp = logprobs([]float32{100, 101, 102, 103}, 3, 0, piece)
+	if expect := 103 - math.Log(math.Exp(100)+math.Exp(101)+math.Exp(102)+math.Exp(103)); math.Abs(lp.Logprob-expect) > 1e-5 {
+		t.Errorf("expected logprob %f, got %f", expect, lp.Logprob)
+	}
+}
@@ -14,6 +14,7 @@ import (
 	"path/filepath"
 	"regexp"
 	"runtime"
+	"slices"
 	"strconv"
 	"strings"
 	"sync"
@@ -48,11 +49,19 @@ type Sequence struct {
 	// tokens that have been generated but not returned yet (e.g. for stop sequences)
 	pendingResponses []string
 
+	// log probabilities of pendingResponses, if requested
+	pendingLogprobs []api.TokenLogprob
+
 	// input cache being used by this sequence
 	cache *InputCacheSlot
 
+	// parent is set while this sequence waits to fork the prompt parent
+	// is processing and forks are the sequences waiting on this one
+	parent *Sequence
+	forks  []*Sequence
+
 	// channel to send responses over
-	responses chan string
+	responses chan response
 
 	// channel to stop decoding (such as if the remote connection is closed)
 	quit chan bool
@@ -60,6 +69,12 @@ type Sequence struct {
 	// number of tokens to predict
 	numPredict int
 
+	// most tokens the draft model can propose at a time
+	numDraft int
+
+	// tokens proposed by the draft model that are being verified
+	draft []int
+
 	samplingCtx *llama.SamplingContext
 
 	// channel to send back the embedding if embedding only
@@ -74,8 +89,18 @@ type Sequence struct {
 	// true if an embedding are to be returned instead of text generation
 	embeddingOnly bool
 
+	// true if the prompt is only evaluated and saved to disk
+	prefill bool
+
+	// return the log probability of each token and this many alternatives
+	logprobs    bool
+	topLogprobs int
+
 	doneReason string
 
+	// stop sequence which ended generation, if any
+	stopSequence string
+
 	// Metrics
 	startProcessingTime time.Time
 	startGenerationTime time.Time
@@ -85,10 +110,24 @@ type Sequence struct {
 
 type NewSequenceParams struct {
 	numPredict     int
+	numDraft       int
 	stop           []string
 	numKeep        int
 	samplingParams *llama.SamplingParams
 	embedding      bool
+	prefill        bool
+	logprobs       bool
+	topLogprobs    int
+
+	// document is paired with the prompt for a reranking model to score
+	document string
+}
+
+// response is a piece of generated text along with its log probability
+// if requested
+type response struct {
+	content string
+	logprob *api.TokenLogprob
 }
 
 func (s *Server) NewSequence(prompt string, images []ImageData, params NewSequenceParams) (*Sequence, error) {
@@ -99,8 +138,26 @@ func (s *Server) NewSequence(prompt string, images []ImageData, params NewSequen
 	inputs, err := s.inputs(prompt, images)
 	if err != nil {
 		return nil, fmt.Errorf("failed to process inputs: %w", err)
-	} else if len(inputs) == 0 {
+	}
+
+	if params.document != "" {
+		document, err := s.inputs(params.document, nil)
+		if err != nil {
+			return nil, fmt.Errorf("failed to process document: %w", err)
+		}
+
+		// the pair shares the classification token which starts the prompt
+		if len(inputs) > 0 && len(document) > 0 && document[0].token == inputs[0].token {
+			document = document[1:]
+		}
+
+		inputs = append(inputs, document...)
+	}
+
+	if len(inputs) == 0 {
 		return nil, errors.New("no input provided")
+	} else if params.prefill && len(inputs) > s.cache.numCtx {
+		return nil, fmt.Errorf("prompt of %d inputs does not fit in the context of %d", len(inputs), s.cache.numCtx)
 	}
 
 	if params.numKeep < 0 {
@@ -139,14 +196,18 @@ func (s *Server) NewSequence(prompt string, images []ImageData, params NewSequen
 		numPromptInputs:     len(inputs),
 		startProcessingTime: startTime,
 		numPredict:          params.numPredict,
+		numDraft:            params.numDraft,
 		pendingResponses:    make([]string, 0),
-		responses:           make(chan string, 100),
+		responses:           make(chan response, 100),
 		quit:                make(chan bool, 1),
 		embedding:           make(chan []float32, 1),
 		samplingCtx:         sc,
 		embeddingOnly:       params.embedding,
+		prefill:             params.prefill,
 		stop:                params.stop,
 		numKeep:             params.numKeep,
+		logprobs:            params.logprobs,
+		topLogprobs:         params.topLogprobs,
 	}, nil
 }
 
@@ -241,6 +302,9 @@ type Server struct {
 	// required for image embeddings
 	clip clip
 
+	// proposes tokens for speculative decoding, if a draft model is loaded
+	draft *drafter
+
 	batchSize int
 
 	// parallel is the number of parallel requests to handle
@@ -266,6 +330,9 @@ type Server struct {
 
 	cond *sync.Cond
 
+	// free is broadcast when a sequence finishes and frees its slot
+	free *sync.Cond
+
 	progress float32
 
 	status ServerStatus
@@ -292,17 +359,43 @@ func (s *Server) shiftContext(seq *Sequence) {
 	seq.numPast -= numDiscard
 }
 
+// fork starts the sequences waiting on parent now that it has processed
+// its prompt. The prompt is copied in the KV cache except for the last
+// input, which each fork decodes again to sample its own first token.
+func (s *Server) fork(parent *Sequence) {
+	numPast := len(parent.cache.Inputs) - 1
+	for _, seq := range parent.forks {
+		s.lc.KvCacheSeqRm(seq.cache.Id, 0, -1)
+		s.lc.KvCacheSeqCp(parent.cache.Id, seq.cache.Id, 0, numPast)
+
+		seq.cache.Inputs = slices.Clone(parent.cache.Inputs[:numPast])
+		seq.inputs = []input{parent.cache.Inputs[numPast]}
+		seq.numPast = numPast
+		seq.parent = nil
+	}
+
+	parent.forks = nil
+}
+
 func flushPending(seq *Sequence) bool {
-	for _, p := range seq.pendingResponses {
+	defer func() {
+		seq.pendingResponses = []string{}
+		seq.pendingLogprobs = nil
+	}()
+
+	for i, p := range seq.pendingResponses {
+		resp := response{content: p}
+		if i < len(seq.pendingLogprobs) {
+			resp.logprob = &seq.pendingLogprobs[i]
+		}
+
 		select {
-		case seq.responses <- p:
+		case seq.responses <- resp:
 		case <-seq.quit:
-			seq.pendingResponses = []string{}
 			return false
 		}
 	}
 
-	seq.pendingResponses = []string{}
 	return true
 }
 
@@ -314,10 +407,24 @@ func (s *Server) removeSequence(seqIndex int, reason string) {
 	close(seq.responses)
 	close(seq.embedding)
 	seq.cache.InUse = false
+
+	// sequences waiting to fork this one process their own prompt instead,
+	// and a sequence removed while waiting is no longer forked
+	for _, fork := range seq.forks {
+		fork.parent = nil
+	}
+	seq.forks = nil
+
+	if seq.parent != nil {
+		seq.parent.forks = slices.DeleteFunc(seq.parent.forks, func(fork *Sequence) bool { return fork == seq })
+		seq.parent = nil
+	}
+
 	if s.clip.cc != nil {
 		llama.MllamaSetCrossAttn(s.lc, s.clip.cc, nil)
 	}
 	s.seqs[seqIndex] = nil
+	s.free.Broadcast()
 }
 
 func (s *Server) run(ctx context.Context) {
@@ -368,16 +475,41 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 			continue
 		}
 
+		// the request was cancelled, so free its slot now rather than
+		// once the next token can't be sent
+		select {
+		case <-seq.quit:
+			s.removeSequence(seqIdx, "cancelled")
+			continue
+		default:
+		}
+
+		if seq.parent != nil {
+			continue
+		}
+
 		// if past the num predict limit
 		if seq.numPredict > 0 && seq.numPredicted > seq.numPredict {
 			s.removeSequence(seqIdx, "limit")
 			continue
 		}
 
+		// embedding models without causal attention must decode the whole
+		// batch at once, so sequences which don't fit wait for the next one
+		if seq.embeddingOnly && batch != nil && batch.NumTokens() > 0 &&
+			batch.NumTokens()+min(len(seq.inputs), s.batchSize) > s.lc.NumUbatch() {
+			s.nextSeq = seqIdx
+			continue
+		}
+
 		if seq.numPast+len(seq.inputs) > s.cache.numCtx {
 			s.shiftContext(seq)
 		}
 
+		if batch == nil || !batch.IsEmbedding() {
+			s.proposeDraft(seq)
+		}
+
 		var numInputsProcessed int
 		for i, input := range seq.inputs {
 			embedding := input.embed != nil
@@ -402,7 +534,8 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 				break
 			}
 
-			batch.Add(input.token, input.embed, seq.numPast, []int{seq.cache.Id}, numInputsProcessed+1 == len(seq.inputs))
+			// every draft token needs logits to be verified
+			batch.Add(input.token, input.embed, seq.numPast, []int{seq.cache.Id}, numInputsProcessed+1 == len(seq.inputs) || seq.draft != nil)
 			seq.numPast++
 			numInputsProcessed++
 		}
@@ -425,7 +558,7 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 	}
 
 	for i, seq := range s.seqs {
-		if seq == nil {
+		if seq == nil || seq.parent != nil {
 			continue
 		}
 
@@ -434,6 +567,10 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 			continue
 		}
 
+		if len(seq.forks) > 0 {
+			s.fork(seq)
+		}
+
 		seq.numDecoded += 1
 		if seq.numDecoded == 1 {
 			seq.startGenerationTime = time.Now()
@@ -451,66 +588,108 @@ func (s *Server) processBatch(tokenBatch *llama.Batch, embedBatch *llama.Batch)
 			continue
 		}
 
+		// if done processing the prompt, save all but its last input, which
+		// may be tokenized differently when followed by more text
+		if seq.prefill {
+			if err := s.cache.SavePrompt(seq.cache, len(seq.cache.Inputs)-1); err != nil {
+				slog.Error("failed to save prompt", "error", err)
+				s.removeSequence(i, "error")
+				continue
+			}
+
+			s.removeSequence(i, "")
+			continue
+		}
+
+		if seq.draft != nil {
+			s.verifyDraft(i, seq)
+			continue
+		}
+
 		// sample a token
 		token := seq.samplingCtx.Sample(s.lc, seq.iBatch)
 		seq.samplingCtx.Accept(token, true)
-		piece := s.model.TokenToPiece(token)
 
-		seq.numPredicted++
+		var logprob api.TokenLogprob
+		if seq.logprobs {
+			logprob = logprobs(s.lc.GetLogitsIth(seq.iBatch), token, seq.topLogprobs, s.model.TokenToPiece)
+		}
 
-		// if it's an end of sequence token, break
-		if s.model.TokenIsEog(token) {
-			// TODO (jmorganca): we should send this back
-			// as it's important for the /api/generate context
-			// seq.responses <- piece
+		s.emitToken(i, seq, token, logprob)
+	}
+}
 
-			s.removeSequence(i, "stop")
-			continue
-		}
+// emitToken adds a sampled token to a sequence, sending it back once it
+// can't be part of a stop sequence. It reports whether the sequence is still
+// generating.
+func (s *Server) emitToken(i int, seq *Sequence, token int, logprob api.TokenLogprob) bool {
+	piece := s.model.TokenToPiece(token)
 
-		seq.inputs = []input{{token: token}}
+	seq.numPredicted++
 
-		seq.pendingResponses = append(seq.pendingResponses, piece)
-		sequence := strings.Join(seq.pendingResponses, "")
+	// if it's an end of sequence token, break
+	if s.model.TokenIsEog(token) {
+		// TODO (jmorganca): we should send this back
+		// as it's important for the /api/generate context
+		// seq.responses <- piece
 
-		if ok, stop := findStop(sequence, seq.stop); ok {
-			slog.Debug("hit stop token", "pending", seq.pendingResponses, "stop", stop)
+		s.removeSequence(i, "stop")
+		return false
+	}
 
-			var tokenTruncated bool
-			origLen := len(seq.pendingResponses)
-			seq.pendingResponses, tokenTruncated = truncateStop(seq.pendingResponses, stop)
-			newLen := len(seq.pendingResponses)
+	seq.inputs = []input{{token: token}}
 
-			// Update the cache based on the tokens that will be returned:
-			// - We have 1 token more than is currently in the cache because
-			// the last one generated wasn't submitted to Decode
-			// - Remove any stop sequences that we stripped out
-			// - If truncateStop removed a portion of a token, drop that
-			// - As defense-in-depth, if truncatedToken didn't find a stop token
-			// remove the extra one that we added to the cache len
-			tokenLen := len(seq.cache.Inputs) + 1
-			tokenLen -= origLen - newLen
-			if tokenTruncated || origLen == newLen {
-				tokenLen--
-			}
-			seq.cache.Inputs = seq.cache.Inputs[:tokenLen]
+	seq.pendingResponses = append(seq.pendingResponses, piece)
+	if seq.logprobs {
+		seq.pendingLogprobs = append(seq.pendingLogprobs, logprob)
+	}
 
-			s.removeSequence(i, "stop")
-			continue
-		}
+	sequence := strings.Join(seq.pendingResponses, "")
 
-		if containsStopSuffix(sequence, seq.stop) {
-			continue
-		}
+	if ok, stop := findStop(sequence, seq.stop); ok {
+		slog.Debug("hit stop token", "pending", seq.pendingResponses, "stop", stop)
 
-		if incompleteUnicode(sequence) {
-			continue
+		var tokenTruncated bool
+		origLen := len(seq.pendingResponses)
+		seq.pendingResponses, tokenTruncated = truncateStop(seq.pendingResponses, stop)
+		newLen := len(seq.pendingResponses)
+		if len(seq.pendingLogprobs) > newLen {
+			seq.pendingLogprobs = seq.pendingLogprobs[:newLen]
 		}
 
-		if !flushPending(seq) {
-			s.removeSequence(i, "connection")
+		// Update the cache based on the tokens that will be returned:
+		// - We have 1 token more than is currently in the cache because
+		// the last one generated wasn't submitted to Decode
+		// - Remove any stop sequences that we stripped out
+		// - If truncateStop removed a portion of a token, drop that
+		// - As defense-in-depth, if truncatedToken didn't find a stop token
+		// remove the extra one that we added to the cache len
+		tokenLen := len(seq.cache.Inputs) + 1
+		tokenLen -= origLen - newLen
+		if tokenTruncated || origLen == newLen {
+			tokenLen--
 		}
+		seq.cache.Inputs = seq.cache.Inputs[:tokenLen]
+
+		seq.stopSequence = stop
+		s.removeSequence(i, "stop")
+		return false
 	}
+
+	if containsStopSuffix(sequence, seq.stop) {
+		return true
+	}
+
+	if incompleteUnicode(sequence) {
+		return true
+	}
+
+	if !flushPending(seq) {
+		s.removeSequence(i, "connection")
+		return false
+	}
+
+	return true
 }
 
 // TODO (jmorganca): use structs from the api package to avoid duplication
@@ -537,6 +716,12 @@ type Options struct {
 	MirostatEta      float32  `json:"mirostat_eta"`
 	PenalizeNewline  bool     `json:"penalize_nl"`
 	Stop             []string `json:"stop"`
+
+	// Grammar is only here to match api.Options; the grammar is sent as
+	// CompletionRequest.Grammar
+	Grammar string `json:"-"`
+
+	NumDraft int `json:"n_draft"`
 }
 
 type ImageData struct {
@@ -550,6 +735,15 @@ type CompletionRequest struct {
 	Images      []ImageData `json:"image_data"`
 	Grammar     string      `json:"grammar"`
 	CachePrompt bool        `json:"cache_prompt"`
+	Logprobs    bool        `json:"logprobs"`
+	TopLogprobs int         `json:"top_logprobs"`
+
+	// N is the number of independent completions to generate
+	N int `json:"n"`
+
+	// Session identifies the chat session the prompt continues so that it
+	// can reuse the session's cache slot
+	Session string `json:"session"`
 
 	Options
 }
@@ -562,12 +756,15 @@ type Timings struct {
 }
 
 type CompletionResponse struct {
-	Content string `json:"content"`
-	Stop    bool   `json:"stop"`
+	Index    int                `json:"index"`
+	Content  string             `json:"content"`
+	Logprobs []api.TokenLogprob `json:"logprobs,omitempty"`
+	Stop     bool               `json:"stop"`
 
 	Model        string  `json:"model,omitempty"`
 	Prompt       string  `json:"prompt,omitempty"`
 	StoppedLimit bool    `json:"stopped_limit,omitempty"`
+	StopSequence string  `json:"stop_sequence,omitempty"`
 	PredictedN   int     `json:"predicted_n,omitempty"`
 	PredictedMS  float64 `json:"predicted_ms,omitempty"`
 	PromptN      int     `json:"prompt_n,omitempty"`
@@ -612,57 +809,140 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 	samplingParams.Seed = uint32(req.Seed)
 	samplingParams.Grammar = req.Grammar
 
-	seq, err := s.NewSequence(req.Prompt, req.Images, NewSequenceParams{
-		numPredict:     req.NumPredict,
-		stop:           req.Stop,
-		numKeep:        req.NumKeep,
-		samplingParams: &samplingParams,
-		embedding:      false,
-	})
-	if err != nil {
-		http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
+	n := max(req.N, 1)
+	if n > 1 && s.clip.cc != nil && s.clip.cc.IsMllama {
+		http.Error(w, "Multiple completions are not supported by this model", http.StatusBadRequest)
 		return
 	}
 
+	seqs := make([]*Sequence, n)
+	for i := range seqs {
+		params := samplingParams
+		if req.Seed >= 0 {
+			// vary fixed seeds so completions differ
+			params.Seed += uint32(i)
+		}
+
+		seq, err := s.NewSequence(req.Prompt, req.Images, NewSequenceParams{
+			numPredict:     req.NumPredict,
+			numDraft:       req.NumDraft,
+			stop:           req.Stop,
+			numKeep:        req.NumKeep,
+			samplingParams: &params,
+			embedding:      false,
+			logprobs:       req.Logprobs,
+			topLogprobs:    req.TopLogprobs,
+		})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		seqs[i] = seq
+	}
+
 	// TODO (jmorganca): add to sequence queue instead of
 	// failing if a slot isn't available
 	s.mu.Lock()
+	var free []int
 	for i, sq := range s.seqs {
 		if sq == nil {
-			seq.cache, seq.inputs, seq.numPast, err = s.cache.LoadCacheSlot(seq.inputs, req.CachePrompt)
-			if err != nil {
-				s.mu.Unlock()
-				http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
-				return
+			free = append(free, i)
+		}
+	}
+
+	if len(free) < n {
+		s.mu.Unlock()
+		http.Error(w, fmt.Sprintf("Not enough free slots for %d sequences", n), http.StatusServiceUnavailable)
+		return
+	}
+
+	for i, seq := range seqs {
+		// only the first completion continues the session
+		var session string
+		if i == 0 {
+			session = req.Session
+		}
+
+		var err error
+		seq.cache, seq.inputs, seq.numPast, err = s.cache.LoadCacheSlot(seq.inputs, session, req.CachePrompt)
+		if err != nil {
+			for _, seq := range seqs[:i] {
+				seq.cache.InUse = false
 			}
-			s.seqs[i] = seq
-			s.cond.Signal()
-			break
+			s.mu.Unlock()
+			http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		// the rest wait to share the first sequence's prompt
+		if i > 0 {
+			seq.parent = seqs[0]
+			seqs[0].forks = append(seqs[0].forks, seq)
 		}
 	}
+
+	for i, seq := range seqs {
+		s.seqs[free[i]] = seq
+	}
+	s.cond.Signal()
 	s.mu.Unlock()
 
+	var mu sync.Mutex
+	enc := json.NewEncoder(w)
+	send := func(resp *CompletionResponse) error {
+		mu.Lock()
+		defer mu.Unlock()
+		if err := enc.Encode(resp); err != nil {
+			return err
+		}
+
+		flusher.Flush()
+		return nil
+	}
+
+	var wg sync.WaitGroup
+	for i, seq := range seqs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			s.streamSequence(r.Context(), i, seq, send)
+		}()
+	}
+	wg.Wait()
+}
+
+// streamSequence sends the responses of seq until it completes or ctx
+// is cancelled
+func (s *Server) streamSequence(ctx context.Context, index int, seq *Sequence, send func(*CompletionResponse) error) {
 	for {
 		select {
-		case <-r.Context().Done():
+		case <-ctx.Done():
 			close(seq.quit)
 			return
-		case content, ok := <-seq.responses:
+		case resp, ok := <-seq.responses:
 			if ok {
-				if err := json.NewEncoder(w).Encode(&CompletionResponse{
-					Content: content,
+				var logprobs []api.TokenLogprob
+				if resp.logprob != nil {
+					logprobs = []api.TokenLogprob{*resp.logprob}
+				}
+
+				if err := send(&CompletionResponse{
+					Index:    index,
+					Content:  resp.content,
+					Logprobs: logprobs,
 				}); err != nil {
-					http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+					slog.Error("failed to encode response", "error", err)
 					close(seq.quit)
 					return
 				}
-
-				flusher.Flush()
 			} else {
 				// Send the final response
-				if err := json.NewEncoder(w).Encode(&CompletionResponse{
+				if err := send(&CompletionResponse{
+					Index:        index,
 					Stop:         true,
 					StoppedLimit: seq.doneReason == "limit",
+					StopSequence: seq.stopSequence,
 					Timings: Timings{
 						PromptN:     seq.numPromptInputs,
 						PromptMS:    float64(seq.startGenerationTime.Sub(seq.startProcessingTime).Milliseconds()),
@@ -670,7 +950,7 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 						PredictedMS: float64(time.Since(seq.startGenerationTime).Milliseconds()),
 					},
 				}); err != nil {
-					http.Error(w, fmt.Sprintf("failed to encode final response: %v", err), http.StatusInternalServerError)
+					slog.Error("failed to encode final response", "error", err)
 				}
 
 				return
@@ -680,12 +960,12 @@ func (s *Server) completion(w http.ResponseWriter, r *http.Request) {
 }
 
 type EmbeddingRequest struct {
-	Content     string `json:"content"`
-	CachePrompt bool   `json:"cache_prompt"`
+	Content     []string `json:"content"`
+	CachePrompt bool     `json:"cache_prompt"`
 }
 
 type EmbeddingResponse struct {
-	Embedding []float32 `json:"embedding"`
+	Embeddings [][]float32 `json:"embeddings"`
 }
 
 func (s *Server) embeddings(w http.ResponseWriter, r *http.Request) {
@@ -699,17 +979,193 @@ func (s *Server) embeddings(w http.ResponseWriter, r *http.Request) {
 
 	slog.Debug("embedding request", "content", req.Content)
 
-	seq, err := s.NewSequence(req.Content, nil, NewSequenceParams{embedding: true})
+	seqs := make([]*Sequence, len(req.Content))
+	for i, content := range req.Content {
+		seq, err := s.NewSequence(content, nil, NewSequenceParams{embedding: true})
+		if err != nil {
+			http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
+			return
+		}
+
+		seqs[i] = seq
+	}
+
+	embeddings, err := s.embed(r.Context(), seqs, req.CachePrompt)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	if slices.ContainsFunc(embeddings, func(e []float32) bool { return e == nil }) {
+		http.Error(w, "failed to generate embedding", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(&EmbeddingResponse{
+		Embeddings: embeddings,
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+	}
+}
+
+// embed evaluates embedding sequences and returns their embeddings. The
+// sequences take every free slot so that they are decoded in the same batch,
+// and the rest wait for slots to free up. If ctx is cancelled, the sequences
+// already added are stopped.
+func (s *Server) embed(ctx context.Context, seqs []*Sequence, cachePrompt bool) ([][]float32, error) {
+	// wake up the wait for a free slot once ctx is cancelled
+	stop := context.AfterFunc(ctx, func() {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		s.free.Broadcast()
+	})
+	defer stop()
+
+	s.mu.Lock()
+	for n, seq := range seqs {
+		i := slices.Index(s.seqs, nil)
+		for i < 0 && ctx.Err() == nil {
+			s.free.Wait()
+			i = slices.Index(s.seqs, nil)
+		}
+
+		if ctx.Err() != nil {
+			s.mu.Unlock()
+
+			for _, seq := range seqs[:n] {
+				close(seq.quit)
+			}
+
+			return nil, ctx.Err()
+		}
+
+		var err error
+		seq.cache, seq.inputs, seq.numPast, err = s.cache.LoadCacheSlot(seq.inputs, "", cachePrompt)
+		if err != nil {
+			s.mu.Unlock()
+
+			// the sequences already added still finish
+			for _, seq := range seqs[:n] {
+				<-seq.embedding
+			}
+
+			return nil, fmt.Errorf("Failed to load cache: %v", err)
+		}
+
+		s.seqs[i] = seq
+		s.cond.Signal()
+	}
+	s.mu.Unlock()
+
+	embeddings := make([][]float32, len(seqs))
+	for i, seq := range seqs {
+		select {
+		case embeddings[i] = <-seq.embedding:
+		case <-ctx.Done():
+			for _, seq := range seqs[i:] {
+				close(seq.quit)
+			}
+
+			return nil, ctx.Err()
+		}
+	}
+
+	return embeddings, nil
+}
+
+type RerankRequest struct {
+	Query    string `json:"query"`
+	Document string `json:"document"`
+}
+
+type RerankResponse struct {
+	Score float32 `json:"score"`
+}
+
+// rerank scores the relevance of a document to a query with a reranking model
+func (s *Server) rerank(w http.ResponseWriter, r *http.Request) {
+	var req RerankRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	seq, err := s.NewSequence(req.Query, nil, NewSequenceParams{embedding: true, document: req.Document})
 	if err != nil {
 		http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// TODO (jessegross): Wait for a free slot instead of failing and blocking forever
+	scores, err := s.embed(r.Context(), []*Sequence{seq}, false)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	score := scores[0]
+	if score == nil {
+		http.Error(w, "failed to score document", http.StatusInternalServerError)
+		return
+	} else if len(score) != 1 {
+		http.Error(w, "model does not support reranking", http.StatusBadRequest)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(&RerankResponse{
+		Score: score[0],
+	}); err != nil {
+		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
+	}
+}
+
+type CacheRequest struct {
+	Prompt string `json:"prompt"`
+}
+
+type CacheResponse struct {
+	// Tokens is the number of tokens of the prompt saved to disk
+	Tokens int `json:"tokens"`
+
+	// PromptN is the number of inputs evaluated to save them
+	PromptN  int     `json:"prompt_n"`
+	PromptMS float64 `json:"prompt_ms"`
+}
+
+// cachePrompt evaluates a prompt and saves its KV cache to disk so that later
+// requests starting with it do not evaluate it again
+func (s *Server) cachePrompt(w http.ResponseWriter, r *http.Request) {
+	var req CacheRequest
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		http.Error(w, fmt.Sprintf("bad request: %s", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+
+	seq, err := s.NewSequence(req.Prompt, nil, NewSequenceParams{prefill: true})
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to create new sequence: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	if len(seq.inputs) < 2 {
+		http.Error(w, "prompt is too short to save", http.StatusBadRequest)
+		return
+	}
+
 	s.mu.Lock()
+	if s.cache.promptDir == "" {
+		s.mu.Unlock()
+		http.Error(w, "saving prompts is not supported by this model", http.StatusBadRequest)
+		return
+	}
+
+	var found bool
 	for i, sq := range s.seqs {
 		if sq == nil {
-			seq.cache, seq.inputs, seq.numPast, err = s.cache.LoadCacheSlot(seq.inputs, req.CachePrompt)
+			seq.cache, seq.inputs, seq.numPast, err = s.cache.LoadCacheSlot(seq.inputs, "", true)
 			if err != nil {
 				s.mu.Unlock()
 				http.Error(w, fmt.Sprintf("Failed to load cache: %v", err), http.StatusInternalServerError)
@@ -717,23 +1173,40 @@ func (s *Server) embeddings(w http.ResponseWriter, r *http.Request) {
 			}
 			s.seqs[i] = seq
 			s.cond.Signal()
+			found = true
 			break
 		}
 	}
+	numInputs := len(seq.inputs)
 	s.mu.Unlock()
 
-	embedding := <-seq.embedding
+	if !found {
+		http.Error(w, "Not enough free slots", http.StatusServiceUnavailable)
+		return
+	}
+
+	for range seq.responses {
+	}
 
-	if err := json.NewEncoder(w).Encode(&EmbeddingResponse{
-		Embedding: embedding,
+	if seq.doneReason != "" {
+		http.Error(w, "failed to save prompt", http.StatusInternalServerError)
+		return
+	}
+
+	if err := json.NewEncoder(w).Encode(&CacheResponse{
+		Tokens:   seq.numPromptInputs - 1,
+		PromptN:  numInputs,
+		PromptMS: float64(time.Since(seq.startProcessingTime).Milliseconds()),
 	}); err != nil {
 		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
 	}
 }
 
 type HealthResponse struct {
-	Status   string  `json:"status"`
-	Progress float32 `json:"progress"`
+	Status        string  `json:"status"`
+	Progress      float32 `json:"progress"`
+	DraftProposed int64   `json:"draft_proposed,omitempty"`
+	DraftAccepted int64   `json:"draft_accepted,omitempty"`
 }
 
 type ServerStatus int
@@ -756,11 +1229,18 @@ func (s ServerStatus) ToString() string {
 }
 
 func (s *Server) health(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	if err := json.NewEncoder(w).Encode(&HealthResponse{
+	resp := HealthResponse{
 		Status:   s.status.ToString(),
 		Progress: s.progress,
-	}); err != nil {
+	}
+
+	if s.draft != nil {
+		resp.DraftProposed = s.draft.proposed.Load()
+		resp.DraftAccepted = s.draft.accepted.Load()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(&resp); err != nil {
 		http.Error(w, fmt.Sprintf("failed to encode response: %v", err), http.StatusInternalServerError)
 	}
 }
@@ -774,10 +1254,18 @@ func (s *Server) loadModel(
 	flashAttention bool,
 	threads int,
 	multiUserCache bool,
+	promptDir string,
+	promptCacheSize int64,
+	draftPath string,
+	draftParams llama.ModelParams,
 ) {
 	llama.BackendInit()
 
-	s.model = llama.LoadModelFromFile(mpath, params)
+	var err error
+	s.model, err = llama.LoadModelFromFile(mpath, params)
+	if err != nil {
+		panic(err)
+	}
 
 	ctxParams := llama.NewContextParams(kvSize, s.batchSize*s.parallel, s.parallel, threads, flashAttention)
 	s.lc = llama.NewContextWithModel(s.model, ctxParams)
@@ -794,14 +1282,32 @@ func (s *Server) loadModel(
 	}
 
 	if ppath != "" {
-		var err error
 		s.clip.cc, err = llama.NewClipContext(ppath)
 		if err != nil {
 			panic(err)
 		}
 	}
 
+	if draftPath != "" {
+		// the main model is still served if the draft model can't be used
+		model, err := llama.LoadModelFromFile(draftPath, draftParams)
+		if err != nil {
+			slog.Warn("failed to load draft model, speculative decoding is disabled", "draft", draftPath, "error", err)
+		} else if model.NumVocab() != s.model.NumVocab() {
+			slog.Warn("draft model vocabulary does not match, speculative decoding is disabled", "draft", draftPath)
+			llama.FreeModel(model)
+		} else {
+			lc := llama.NewContextWithModel(model, llama.NewContextParams(kvSize, s.batchSize, s.parallel, threads, flashAttention))
+			s.draft = newDrafter(model, lc, s.batchSize, s.parallel)
+		}
+	}
+
 	s.cache = NewInputCache(s.lc, kvSize, s.parallel, multiUserCache)
+	if promptDir != "" {
+		if err := s.cache.LoadSavedPrompts(promptDir, promptCacheSize); err != nil {
+			slog.Warn("saved prompts are disabled", "dir", promptDir, "error", err)
+		}
+	}
 
 	s.status = ServerStatusReady
 	s.ready.Done()
@@ -824,6 +1330,10 @@ func main() {
 	mlock := flag.Bool("mlock", false, "force system to keep model in RAM rather than swapping or compressing")
 	tensorSplit := flag.String("tensor-split", "", "fraction of the model to offload to each GPU, comma-separated list of proportions")
 	multiUserCache := flag.Bool("multiuser-cache", false, "optimize input cache algorithm for multiple users")
+	promptDir := flag.String("prompt-cache-dir", "", "directory to save and restore prompt KV caches")
+	promptCacheSize := flag.Int64("prompt-cache-size", 0, "maximum size in bytes of the prompt cache directory (0 for no limit)")
+	draftPath := flag.String("draft-model", "", "Path to draft model binary file for speculative decoding")
+	draftGpuLayers := flag.Int("draft-n-gpu-layers", 0, "Number of draft model layers to offload to GPU")
 	// Expose requirements as a JSON output to stdout
 	requirements := flag.Bool("requirements", false, "print json requirement information")
 
@@ -885,10 +1395,18 @@ func main() {
 		},
 	}
 
+	draftParams := llama.ModelParams{
+		NumGpuLayers: *draftGpuLayers,
+		MainGpu:      *mainGpu,
+		UseMmap:      !*noMmap,
+		UseMlock:     *mlock,
+	}
+
 	server.ready.Add(1)
-	go server.loadModel(params, *mpath, *lpath, *ppath, *kvSize, *flashAttention, *threads, *multiUserCache)
+	go server.loadModel(params, *mpath, *lpath, *ppath, *kvSize, *flashAttention, *threads, *multiUserCache, *promptDir, *promptCacheSize, *draftPath, draftParams)
 
 	server.cond = sync.NewCond(&server.mu)
+	server.free = sync.NewCond(&server.mu)
 
 	ctx, cancel := context.WithCancel(context.Background())
 	go server.run(ctx)
@@ -903,7 +1421,9 @@ func main() {
 
 	mux := http.NewServeMux()
 	mux.HandleFunc("/embedding", server.embeddings)
+	mux.HandleFunc("/rerank", server.rerank)
 	mux.HandleFunc("/completion", server.completion)
+	mux.HandleFunc("/cache", server.cachePrompt)
 	mux.HandleFunc("/health", server.health)
 
 	httpServer := http.Server{
@@ -0,0 +1,41 @@
+package main
+
+import (
+	"sync"
+	"testing"
+)
+
+func TestRemoveSequenceForks(t *testing.T) {
+	newSequence := func(id int) *Sequence {
+		return &Sequence{
+			inputs:    []input{{token: id}},
+			responses: make(chan response, 1),
+			quit:      make(chan bool, 1),
+			embedding: make(chan []float32, 1),
+			cache:     &InputCacheSlot{Id: id, InUse: true},
+		}
+	}
+
+	parent, a, b := newSequence(0), newSequence(1), newSequence(2)
+	a.parent, b.parent = parent, parent
+	parent.forks = []*Sequence{a, b}
+
+	s := Server{seqs: []*Sequence{parent, a, b}}
+	s.free = sync.NewCond(&s.mu)
+
+	// a cancelled fork is no longer copied into
+	s.removeSequence(1, "cancelled")
+	if len(parent.forks) != 1 || parent.forks[0] != b {
+		t.Errorf("expected only b to wait on the parent, got %v", parent.forks)
+	}
+
+	// the rest start on their own prompt once the parent has gone
+	s.removeSequence(0, "cancelled")
+	if b.parent != nil {
+		t.Error("expected b to no longer wait on the parent")
+	}
+
+	if len(b.inputs) != 1 || b.inputs[0].token != 2 {
+		t.Errorf("expected b to keep its own prompt, got %v", b.inputs)
+	}
+}
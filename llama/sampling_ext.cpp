@@ -1,6 +1,10 @@
 // TODO: this is a temporary wrapper to allow calling C++ code from CGo
 #include "sampling.h"
 #include "sampling_ext.h"
+#include "json-schema-to-grammar.h"
+#include "llama-grammar.h"
+
+#include <cstring>
 
 struct gpt_sampler *gpt_sampler_cinit(
     const struct llama_model *model, struct gpt_sampler_cparams *params)
@@ -50,3 +54,101 @@ void gpt_sampler_caccept(
 {
     gpt_sampler_accept(sampler, id, apply_grammar);
 }
+
+int schema_to_grammar(const char *json_schema, char *grammar, size_t max_len)
+{
+    std::string result;
+    try
+    {
+        result = json_schema_to_grammar(nlohmann::ordered_json::parse(json_schema));
+    }
+    catch (const std::exception &e)
+    {
+        if (max_len > 0)
+        {
+            strncpy(grammar, e.what(), max_len - 1);
+            grammar[max_len - 1] = '\0';
+        }
+        return -1;
+    }
+
+    if (result.size() < max_len)
+    {
+        memcpy(grammar, result.c_str(), result.size() + 1);
+    }
+
+    return (int)result.size();
+}
+
+// skip_space skips whitespace and comments preceding the first rule
+static const char *skip_space(const char *pos)
+{
+    while (*pos)
+    {
+        if (*pos == ' ' || *pos == '\t' || *pos == '\n' || *pos == '\r')
+        {
+            pos++;
+        }
+        else if (*pos == '#')
+        {
+            while (*pos && *pos != '\n')
+            {
+                pos++;
+            }
+        }
+        else
+        {
+            break;
+        }
+    }
+    return pos;
+}
+
+bool grammar_validate(const char *grammar, char *err, size_t err_len)
+{
+    std::string msg;
+    try
+    {
+        // parse rule by rule rather than with llama_grammar_parser::parse
+        // so syntax errors can be returned instead of printed
+        llama_grammar_parser parser;
+        const char *pos = skip_space(grammar);
+        while (*pos)
+        {
+            pos = parser.parse_rule(pos);
+        }
+
+        for (const auto &kv : parser.symbol_ids)
+        {
+            if (kv.second >= parser.rules.size() || parser.rules[kv.second].empty())
+            {
+                throw std::runtime_error("undefined rule '" + kv.first + "'");
+            }
+        }
+
+        if (parser.symbol_ids.find("root") == parser.symbol_ids.end())
+        {
+            throw std::runtime_error("missing 'root' rule");
+        }
+
+        // llama_grammar_init_impl rejects left recursive grammars
+        struct llama_grammar *g = llama_grammar_init_impl(nullptr, grammar, "root");
+        if (g == nullptr)
+        {
+            throw std::runtime_error("left recursion is not supported");
+        }
+        llama_grammar_free_impl(g);
+        return true;
+    }
+    catch (const std::exception &e)
+    {
+        msg = e.what();
+    }
+
+    if (err_len > 0)
+    {
+        strncpy(err, msg.c_str(), err_len - 1);
+        err[err_len - 1] = '\0';
+    }
+    return false;
+}
@@ -47,6 +47,16 @@ extern "C"
         llama_token id,
         bool apply_grammar);
 
+    // schema_to_grammar converts a JSON schema to a grammar, writing it to
+    // grammar if it fits in max_len bytes including the terminator. It
+    // returns the length of the grammar or -1 on error, in which case the
+    // error message is written to grammar instead.
+    int schema_to_grammar(const char *json_schema, char *grammar, size_t max_len);
+
+    // grammar_validate checks that grammar can be used for sampling. It
+    // returns false and writes the reason to err if it cannot.
+    bool grammar_validate(const char *grammar, char *err, size_t err_len);
+
 #ifdef __cplusplus
 }
 #endif
@@ -0,0 +1,138 @@
+package llm
+
+import (
+	"context"
+	"slices"
+	"sync"
+	"time"
+)
+
+// embedBatchWindow is how long a request for embeddings waits for concurrent
+// requests to join it before it is sent to the runner
+const embedBatchWindow = 5 * time.Millisecond
+
+type embedCall struct {
+	ctx    context.Context
+	inputs []string
+	done   chan struct{}
+
+	embeddings [][]float32
+	err        error
+}
+
+// embedBatch is a set of calls sent to the runner together
+type embedBatch struct {
+	calls []*embedCall
+	n     int
+	// full is closed when the batch has size inputs
+	full chan struct{}
+
+	// ctx is cancelled once every call has gone away. It carries none of
+	// the values of the calls' requests.
+	ctx     context.Context
+	cancel  context.CancelFunc
+	waiting int
+}
+
+// embedBatcher combines concurrent requests for embeddings into one request
+// to the runner so that their inputs are decoded in the same batch. A batch is
+// sent once embedBatchWindow passes or it has size inputs.
+type embedBatcher struct {
+	size int
+	send func(context.Context, []string) ([][]float32, error)
+
+	mu sync.Mutex
+	// pending is the batch new requests join
+	pending *embedBatch
+}
+
+// embed returns the embeddings of inputs
+func (b *embedBatcher) embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	call := &embedCall{ctx: ctx, inputs: inputs, done: make(chan struct{})}
+
+	b.mu.Lock()
+	batch := b.pending
+	if batch == nil {
+		// the batch is sent on behalf of every request in it, so it outlives
+		// the request which started it
+		batch = &embedBatch{full: make(chan struct{})}
+		batch.ctx, batch.cancel = context.WithCancel(context.Background())
+		b.pending = batch
+		go b.wait(batch)
+	}
+
+	batch.calls = append(batch.calls, call)
+	batch.n += len(inputs)
+	batch.waiting++
+	if batch.n >= b.size {
+		close(batch.full)
+		b.pending = nil
+	}
+	b.mu.Unlock()
+
+	select {
+	case <-call.done:
+		return call.embeddings, call.err
+	case <-ctx.Done():
+		b.mu.Lock()
+		batch.waiting--
+		if batch.waiting == 0 {
+			batch.cancel()
+		}
+		b.mu.Unlock()
+
+		return nil, ctx.Err()
+	}
+}
+
+// wait sends batch once it is full or the window passes
+func (b *embedBatcher) wait(batch *embedBatch) {
+	defer batch.cancel()
+
+	timer := time.NewTimer(embedBatchWindow)
+	defer timer.Stop()
+
+	select {
+	case <-batch.full:
+	case <-timer.C:
+	}
+
+	b.mu.Lock()
+	if b.pending == batch {
+		b.pending = nil
+	}
+
+	// requests which have gone away aren't sent
+	calls := slices.DeleteFunc(batch.calls, func(call *embedCall) bool {
+		return call.ctx.Err() != nil
+	})
+	b.mu.Unlock()
+
+	if len(calls) == 0 {
+		return
+	}
+
+	// the batch waits for a slot at the highest priority of its requests
+	var inputs []string
+	priority := PriorityLow
+	for _, call := range calls {
+		inputs = append(inputs, call.inputs...)
+		priority = min(priority, PriorityFromContext(call.ctx))
+	}
+
+	embeddings, err := b.send(WithPriority(batch.ctx, priority), inputs)
+	for _, call := range calls {
+		switch {
+		case err == nil:
+			call.embeddings, embeddings = embeddings[:len(call.inputs)], embeddings[len(call.inputs):]
+		case len(calls) > 1 && call.ctx.Err() == nil:
+			// retry each request alone so that one bad input doesn't fail
+			// the others
+			call.embeddings, call.err = b.send(call.ctx, call.inputs)
+		default:
+			call.err = err
+		}
+
+		close(call.done)
+	}
+}
@@ -0,0 +1,190 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"slices"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEmbedBatcher(t *testing.T) {
+	var mu sync.Mutex
+	var batches [][]string
+
+	b := embedBatcher{size: 4, send: func(_ context.Context, inputs []string) ([][]float32, error) {
+		mu.Lock()
+		batches = append(batches, inputs)
+		mu.Unlock()
+
+		if slices.Contains(inputs, "bad") {
+			return nil, errors.New("bad input")
+		}
+
+		embeddings := make([][]float32, len(inputs))
+		for i, input := range inputs {
+			embeddings[i] = []float32{float32(len(input))}
+		}
+
+		return embeddings, nil
+	}}
+
+	embed := func(inputs ...string) ([][]float32, error) {
+		return b.embed(context.Background(), inputs)
+	}
+
+	t.Run("combined", func(t *testing.T) {
+		batches = nil
+
+		// a full batch is sent without waiting for the window
+		var wg sync.WaitGroup
+		results := make([][][]float32, 2)
+		for i, inputs := range [][]string{{"a", "bb"}, {"ccc", "dddd"}} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+
+				embeddings, err := embed(inputs...)
+				if err != nil {
+					t.Error(err)
+				}
+
+				results[i] = embeddings
+			}()
+		}
+		wg.Wait()
+
+		if len(batches) != 1 || len(batches[0]) != 4 {
+			t.Errorf("expected one batch of 4 inputs, got %v", batches)
+		}
+
+		if diff := cmp.Diff(results, [][][]float32{{{1}, {2}}, {{3}, {4}}}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("window", func(t *testing.T) {
+		batches = nil
+
+		embeddings, err := embed("a")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(embeddings, [][]float32{{1}}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if len(batches) != 1 {
+			t.Errorf("expected one batch, got %v", batches)
+		}
+	})
+
+	t.Run("retry", func(t *testing.T) {
+		batches = nil
+
+		var wg sync.WaitGroup
+		errs := make([]error, 2)
+		for i, inputs := range [][]string{{"a", "bad"}, {"c", "d"}} {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				_, errs[i] = embed(inputs...)
+			}()
+		}
+		wg.Wait()
+
+		if errs[0] == nil || errs[1] != nil {
+			t.Errorf("expected only the bad input to fail, got %v", errs)
+		}
+
+		if len(batches) != 3 {
+			t.Errorf("expected the batch and each request to be sent, got %v", batches)
+		}
+	})
+
+	t.Run("cancel", func(t *testing.T) {
+		sent := make(chan struct{})
+		cancelled := make(chan struct{})
+		b := embedBatcher{size: 4, send: func(ctx context.Context, inputs []string) ([][]float32, error) {
+			close(sent)
+			<-ctx.Done()
+			close(cancelled)
+			return nil, ctx.Err()
+		}}
+
+		var wg sync.WaitGroup
+		cancels := make([]context.CancelFunc, 2)
+		for i := range cancels {
+			ctx, cancel := context.WithCancel(context.Background())
+			defer cancel()
+			cancels[i] = cancel
+
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if _, err := b.embed(ctx, []string{"a", "b"}); !errors.Is(err, context.Canceled) {
+					t.Errorf("expected the request to be cancelled, got %v", err)
+				}
+			}()
+		}
+
+		<-sent
+
+		// the batch keeps going while any request in it is waiting
+		cancels[0]()
+		select {
+		case <-cancelled:
+			t.Fatal("expected the batch to continue for the other request")
+		case <-time.After(50 * time.Millisecond):
+		}
+
+		// and stops once they have all gone away
+		cancels[1]()
+		select {
+		case <-cancelled:
+		case <-time.After(time.Second):
+			t.Error("expected the batch to be cancelled")
+		}
+
+		wg.Wait()
+	})
+
+	t.Run("priority", func(t *testing.T) {
+		type requestKey struct{}
+
+		var got []Priority
+		var values []any
+		b := embedBatcher{size: 1, send: func(ctx context.Context, inputs []string) ([][]float32, error) {
+			got = append(got, PriorityFromContext(ctx))
+			values = append(values, ctx.Value(requestKey{}))
+			return make([][]float32, len(inputs)), nil
+		}}
+
+		// the batch doesn't carry the values of the request which opened it
+		low := WithPriority(context.WithValue(context.Background(), requestKey{}, "low"), PriorityLow)
+		if _, err := b.embed(low, []string{"a"}); err != nil {
+			t.Fatal(err)
+		}
+
+		if values[0] != nil {
+			t.Errorf("expected the batch not to carry request values, got %v", values[0])
+		}
+
+		// and is sent at the highest priority of the requests in it
+		batch := &embedBatch{full: make(chan struct{})}
+		batch.ctx, batch.cancel = context.WithCancel(context.Background())
+		for _, ctx := range []context.Context{low, WithPriority(context.Background(), PriorityHigh)} {
+			batch.calls = append(batch.calls, &embedCall{ctx: ctx, inputs: []string{"b"}, done: make(chan struct{})})
+		}
+		close(batch.full)
+		b.wait(batch)
+
+		if diff := cmp.Diff([]Priority{PriorityLow, PriorityHigh}, got); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+}
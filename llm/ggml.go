@@ -122,6 +122,16 @@ func (kv KV) ChatTemplate() string {
 	return s
 }
 
+// VocabSize returns the number of tokens in the vocabulary, which is zero for
+// models without one
+func (kv KV) VocabSize() uint64 {
+	if a, ok := kv["tokenizer.ggml.tokens"].(*array); ok {
+		return uint64(a.size)
+	}
+
+	return 0
+}
+
 type Tensors struct {
 	Items  []*Tensor
 	Offset uint64
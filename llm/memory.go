@@ -14,12 +14,12 @@ import (
 )
 
 // This algorithm looks for a complete fit to determine if we need to unload other models
-func PredictServerFit(allGpus discover.GpuInfoList, ggml *GGML, adapters, projectors []string, opts api.Options) (bool, uint64) {
+func PredictServerFit(allGpus discover.GpuInfoList, ggml *GGML, adapters, projectors []string, draft string, opts api.Options) (bool, uint64) {
 	// Split up the GPUs by type and try them
 	var estimatedVRAM uint64
 	for _, gpus := range allGpus.ByLibrary() {
 		var layerCount int
-		estimate := EstimateGPULayers(gpus, ggml, projectors, opts)
+		estimate := EstimateGPULayers(gpus, ggml, projectors, draft, opts)
 		layerCount, estimatedVRAM = estimate.Layers, estimate.VRAMSize
 		if opts.NumGPU < 0 {
 			if layerCount > 0 && layerCount >= int(ggml.KV().BlockCount()+1) {
@@ -65,12 +65,14 @@ type MemoryEstimate struct {
 	graphFullOffload    uint64
 	graphPartialOffload uint64
 
-	projectorWeights, projectorGraph uint64
+	projectorWeights, projectorGraph  uint64
+	draftWeights, draftKV, draftGraph uint64
 }
 
 // Given a model and one or more GPU targets, predict how many layers and bytes we can load, and the total size
-// The GPUs provided must all be the same Library
-func EstimateGPULayers(gpus []discover.GpuInfo, ggml *GGML, projectors []string, opts api.Options) MemoryEstimate {
+// The GPUs provided must all be the same Library. A draft model, if any, is
+// loaded entirely into the first GPU alongside the projectors.
+func EstimateGPULayers(gpus []discover.GpuInfo, ggml *GGML, projectors []string, draft string, opts api.Options) MemoryEstimate {
 	// Graph size for a partial offload, applies to all GPUs
 	var graphPartialOffload uint64
 
@@ -84,6 +86,9 @@ func EstimateGPULayers(gpus []discover.GpuInfo, ggml *GGML, projectors []string,
 	var projectorWeights uint64
 	var projectorGraph uint64
 
+	// Draft model loaded into GPU0 only
+	var draftWeights, draftKV, draftGraph uint64
+
 	// Conditional output size on GPU 0
 	var memoryLayerOutput uint64
 
@@ -115,6 +120,10 @@ func EstimateGPULayers(gpus []discover.GpuInfo, ggml *GGML, projectors []string,
 		opts.NumCtx = max(opts.NumCtx, 2048)
 	}
 
+	if draft != "" {
+		draftWeights, draftKV, draftGraph = draftMemoryRequirements(draft, opts)
+	}
+
 	layers := ggml.Tensors().Layers()
 	// add one layer worth of memory as a buffer
 	if blk0, ok := layers["blk.0"]; ok {
@@ -155,7 +164,7 @@ func EstimateGPULayers(gpus []discover.GpuInfo, ggml *GGML, projectors []string,
 	}
 
 	// Output layer handled at the end if we have space
-	gpuZeroOverhead := projectorWeights + projectorGraph
+	gpuZeroOverhead := projectorWeights + projectorGraph + draftWeights + draftKV + draftGraph
 
 	// Reduce set of GPUs to only those that have sufficient space to fit overhead and at least one layer
 	var layerCount int
@@ -311,6 +320,9 @@ func EstimateGPULayers(gpus []discover.GpuInfo, ggml *GGML, projectors []string,
 		graphPartialOffload: graphPartialOffload,
 		projectorWeights:    projectorWeights,
 		projectorGraph:      projectorGraph,
+		draftWeights:        draftWeights,
+		draftKV:             draftKV,
+		draftGraph:          draftGraph,
 	}
 
 	if gpus[0].Library == "cpu" {
@@ -343,6 +355,17 @@ func (m MemoryEstimate) log() {
 		)
 	}
 
+	if m.draftWeights > 0 {
+		log = log.With(
+			slog.Group(
+				"draft",
+				"weights", format.HumanBytes2(m.draftWeights),
+				"kv", format.HumanBytes2(m.draftKV),
+				"graph", format.HumanBytes2(m.draftGraph),
+			),
+		)
+	}
+
 	log.Info(
 		"offload to "+m.inferenceLibrary,
 		slog.Group(
@@ -440,3 +463,26 @@ func projectorMemoryRequirements(filename string) (weights, graphSize uint64) {
 
 	return weights, graphSize
 }
+
+// draftMemoryRequirements returns the memory needed to fully load a draft
+// model with the same context as the model it drafts for
+func draftMemoryRequirements(filename string, opts api.Options) (weights, kv, graphSize uint64) {
+	ggml, err := LoadModel(filename, 0)
+	if err != nil {
+		slog.Warn("failed to estimate draft model memory", "model", filename, "error", err)
+		return 0, 0, 0
+	}
+
+	for _, layer := range ggml.Tensors().Layers() {
+		weights += layer.size()
+	}
+
+	kv = 2 * uint64(opts.NumCtx) * ggml.KV().BlockCount() * (ggml.KV().EmbeddingHeadCountK() + ggml.KV().EmbeddingHeadCountV()) * ggml.KV().HeadCountKV()
+
+	_, graphSize = ggml.GraphSize(uint64(opts.NumCtx), uint64(min(opts.NumCtx, opts.NumBatch)))
+	if graphSize == 0 {
+		graphSize = ggml.KV().GQA() * kv / 6
+	}
+
+	return weights, kv, graphSize
+}
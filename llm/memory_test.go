@@ -11,6 +11,7 @@ import (
 
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/format"
 )
 
 func TestEstimateGPULayers(t *testing.T) {
@@ -58,7 +59,7 @@ func TestEstimateGPULayers(t *testing.T) {
 	projectors := []string{}
 	opts := api.DefaultOptions()
 	t.Run("cpu", func(t *testing.T) {
-		estimate := EstimateGPULayers(gpus, ggml, projectors, opts)
+		estimate := EstimateGPULayers(gpus, ggml, projectors, "", opts)
 		assert.Equal(t, 0, estimate.Layers)
 		assert.Equal(t, uint64(0), estimate.Graph)
 	})
@@ -109,7 +110,7 @@ func TestEstimateGPULayers(t *testing.T) {
 			gpus[1].FreeMemory += gpuMinimumMemory + layerSize + s.layer1*layerSize + 1
 			gpus[0].FreeMemory += max(graphFullOffload, graphPartialOffload)
 			gpus[1].FreeMemory += max(graphFullOffload, graphPartialOffload)
-			estimate := EstimateGPULayers(gpus, ggml, projectors, opts)
+			estimate := EstimateGPULayers(gpus, ggml, projectors, "", opts)
 			assert.Equal(t, int(s.expect0+s.expect1), estimate.Layers, "scenario %d: %v", i, s)
 			assert.Equal(t, fmt.Sprintf("%d,%d", s.expect0, s.expect1), estimate.TensorSplit, "scenario %d: %v", i, s)
 			var layerSums uint64
@@ -125,4 +126,20 @@ func TestEstimateGPULayers(t *testing.T) {
 			}
 		})
 	}
+
+	t.Run("draft", func(t *testing.T) {
+		gpus := []discover.GpuInfo{{Library: "cuda", MinimumMemory: 457 * format.MebiByte}}
+		gpus[0].FreeMemory = 64 * format.GibiByte
+
+		estimate := EstimateGPULayers(gpus, ggml, projectors, "", opts)
+		withDraft := EstimateGPULayers(gpus, ggml, projectors, f.Name(), opts)
+
+		weights, kv, graph := draftMemoryRequirements(f.Name(), opts)
+		assert.Positive(t, weights)
+		assert.Equal(t, estimate.Layers, withDraft.Layers)
+		assert.Equal(t, estimate.VRAMSize+weights+kv+graph, withDraft.VRAMSize)
+
+		missing := EstimateGPULayers(gpus, ggml, projectors, "does-not-exist", opts)
+		assert.Equal(t, estimate.VRAMSize, missing.VRAMSize)
+	})
 }
@@ -0,0 +1,200 @@
+package llm
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+)
+
+// Priority is the class of a request when it waits for a model or for a
+// slot on a runner. Requests of a higher priority go first and requests of
+// the same priority go in the order they arrived.
+type Priority int
+
+const (
+	PriorityHigh Priority = iota
+	PriorityNormal
+	PriorityLow
+)
+
+// Priorities lists every priority from highest to lowest
+var Priorities = []Priority{PriorityHigh, PriorityNormal, PriorityLow}
+
+// PriorityAging is how long a request waits before it is treated as the
+// next higher priority, so that low priority requests still complete while
+// higher priority requests keep arriving
+var PriorityAging = 30 * time.Second
+
+func ParsePriority(s string) (Priority, error) {
+	switch s {
+	case "high":
+		return PriorityHigh, nil
+	case "", "normal":
+		return PriorityNormal, nil
+	case "low":
+		return PriorityLow, nil
+	default:
+		return PriorityNormal, fmt.Errorf("invalid priority %q, must be one of high, normal or low", s)
+	}
+}
+
+func (p Priority) String() string {
+	switch p {
+	case PriorityHigh:
+		return "high"
+	case PriorityLow:
+		return "low"
+	default:
+		return "normal"
+	}
+}
+
+// Aged returns the priority of a request that has been waiting since
+// queuedAt
+func (p Priority) Aged(queuedAt time.Time) Priority {
+	if PriorityAging <= 0 {
+		return p
+	}
+
+	return max(PriorityHigh, p-Priority(time.Since(queuedAt)/PriorityAging))
+}
+
+type priorityContextKey struct{}
+
+// WithPriority returns a copy of ctx carrying the priority of its request
+func WithPriority(ctx context.Context, p Priority) context.Context {
+	return context.WithValue(ctx, priorityContextKey{}, p)
+}
+
+// PriorityFromContext returns the priority of the request of ctx, or
+// PriorityNormal if it has none
+func PriorityFromContext(ctx context.Context) Priority {
+	if p, ok := ctx.Value(priorityContextKey{}).(Priority); ok {
+		return p
+	}
+
+	return PriorityNormal
+}
+
+// slotWaiter is a request waiting for slots on a runner
+type slotWaiter struct {
+	n        int
+	priority Priority
+	queuedAt time.Time
+	ready    chan struct{}
+}
+
+// slots limits how many sequences a runner processes at once. Unlike a
+// plain semaphore it admits waiting requests by priority.
+type slots struct {
+	size int
+
+	mu      sync.Mutex
+	cur     int
+	waiters []*slotWaiter
+}
+
+func newSlots(size int) *slots {
+	return &slots{size: size}
+}
+
+// Acquire waits for n slots, taking the priority of the request from ctx
+func (s *slots) Acquire(ctx context.Context, n int) error {
+	s.mu.Lock()
+	if s.cur+n <= s.size && len(s.waiters) == 0 {
+		s.cur += n
+		s.mu.Unlock()
+		return nil
+	}
+
+	w := &slotWaiter{
+		n:        n,
+		priority: PriorityFromContext(ctx),
+		queuedAt: time.Now(),
+		ready:    make(chan struct{}),
+	}
+	s.waiters = append(s.waiters, w)
+	s.mu.Unlock()
+
+	select {
+	case <-w.ready:
+		return nil
+	case <-ctx.Done():
+		s.mu.Lock()
+		defer s.mu.Unlock()
+
+		select {
+		case <-w.ready:
+			// acquired just as ctx was done so give the slots back
+			s.cur -= n
+			s.notify()
+		default:
+			for i, other := range s.waiters {
+				if other == w {
+					s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+					break
+				}
+			}
+			s.notify()
+		}
+
+		return ctx.Err()
+	}
+}
+
+// Release returns n slots, admitting waiting requests
+func (s *slots) Release(n int) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.cur -= n
+	if s.cur < 0 {
+		panic("llm: released more slots than acquired")
+	}
+
+	s.notify()
+}
+
+// notify admits the waiting requests that fit, highest priority first. A
+// request that does not fit blocks the ones after it so that requests for
+// many slots are not starved. It must be called with mu held.
+func (s *slots) notify() {
+	for len(s.waiters) > 0 {
+		i := s.next()
+		w := s.waiters[i]
+		if s.cur+w.n > s.size {
+			return
+		}
+
+		s.cur += w.n
+		s.waiters = append(s.waiters[:i], s.waiters[i+1:]...)
+		close(w.ready)
+	}
+}
+
+// next returns the index of the waiter to admit next. It must be called
+// with mu held.
+func (s *slots) next() int {
+	var best int
+	for i, w := range s.waiters[1:] {
+		if w.priority.Aged(w.queuedAt) < s.waiters[best].priority.Aged(s.waiters[best].queuedAt) {
+			best = i + 1
+		}
+	}
+
+	return best
+}
+
+// Queued counts the requests waiting for slots by priority
+func (s *slots) Queued() map[Priority]int {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	queued := make(map[Priority]int)
+	for _, w := range s.waiters {
+		queued[w.priority]++
+	}
+
+	return queued
+}
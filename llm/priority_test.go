@@ -0,0 +1,136 @@
+package llm
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+func TestParsePriority(t *testing.T) {
+	cases := map[string]Priority{
+		"":       PriorityNormal,
+		"high":   PriorityHigh,
+		"normal": PriorityNormal,
+		"low":    PriorityLow,
+	}
+
+	for s, want := range cases {
+		got, err := ParsePriority(s)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if got != want {
+			t.Errorf("%q: expected %v, got %v", s, want, got)
+		}
+	}
+
+	if _, err := ParsePriority("urgent"); err == nil {
+		t.Error("expected error for unknown priority")
+	}
+}
+
+func TestPriorityAged(t *testing.T) {
+	if got := PriorityLow.Aged(time.Now()); got != PriorityLow {
+		t.Errorf("expected low, got %v", got)
+	}
+
+	if got := PriorityLow.Aged(time.Now().Add(-PriorityAging)); got != PriorityNormal {
+		t.Errorf("expected normal, got %v", got)
+	}
+
+	if got := PriorityLow.Aged(time.Now().Add(-10 * PriorityAging)); got != PriorityHigh {
+		t.Errorf("expected high, got %v", got)
+	}
+}
+
+func TestSlots(t *testing.T) {
+	s := newSlots(1)
+	if err := s.Acquire(context.Background(), 1); err != nil {
+		t.Fatal(err)
+	}
+
+	waiting := func() int {
+		s.mu.Lock()
+		defer s.mu.Unlock()
+		return len(s.waiters)
+	}
+
+	order := make(chan string, 4)
+	acquire := func(name string, p Priority) {
+		// wait for each request to queue so arrival order is known
+		n := waiting()
+		go func() {
+			if err := s.Acquire(WithPriority(context.Background(), p), 1); err != nil {
+				t.Error(err)
+				return
+			}
+
+			order <- name
+		}()
+
+		for waiting() == n {
+			time.Sleep(time.Millisecond)
+		}
+	}
+
+	acquire("low", PriorityLow)
+	acquire("normal 1", PriorityNormal)
+	acquire("high", PriorityHigh)
+	acquire("normal 2", PriorityNormal)
+
+	if got := s.Queued(); got[PriorityLow] != 1 || got[PriorityNormal] != 2 || got[PriorityHigh] != 1 {
+		t.Errorf("unexpected queue %v", got)
+	}
+
+	for _, want := range []string{"high", "normal 1", "normal 2", "low"} {
+		s.Release(1)
+		if got := <-order; got != want {
+			t.Errorf("expected %s, got %s", want, got)
+		}
+	}
+
+	s.Release(1)
+
+	t.Run("aging", func(t *testing.T) {
+		s := newSlots(1)
+		s.cur = 1
+		s.waiters = []*slotWaiter{
+			{n: 1, priority: PriorityNormal, queuedAt: time.Now(), ready: make(chan struct{})},
+			{n: 1, priority: PriorityLow, queuedAt: time.Now().Add(-2 * PriorityAging), ready: make(chan struct{})},
+		}
+
+		old := s.waiters[1]
+		s.Release(1)
+
+		select {
+		case <-old.ready:
+		default:
+			t.Error("expected the aged request to be admitted first")
+		}
+	})
+
+	t.Run("canceled", func(t *testing.T) {
+		s := newSlots(1)
+		if err := s.Acquire(context.Background(), 1); err != nil {
+			t.Fatal(err)
+		}
+
+		ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+		defer cancel()
+
+		if err := s.Acquire(ctx, 1); !errors.Is(err, context.DeadlineExceeded) {
+			t.Fatalf("expected deadline exceeded, got %v", err)
+		}
+
+		if len(s.Queued()) != 0 {
+			t.Errorf("expected no waiters, got %v", s.Queued())
+		}
+
+		s.Release(1)
+		if err := s.Acquire(context.Background(), 1); err != nil {
+			t.Fatal(err)
+		}
+	})
+}
@@ -22,8 +22,6 @@ import (
 	"sync"
 	"time"
 
-	"golang.org/x/sync/semaphore"
-
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/build"
 	"github.com/ollama/ollama/discover"
@@ -37,7 +35,11 @@ type LlamaServer interface {
 	Ping(ctx context.Context) error
 	WaitUntilRunning(ctx context.Context) error
 	Completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error
-	Embedding(ctx context.Context, input string) ([]float32, error)
+	Embedding(ctx context.Context, inputs []string) ([][]float32, error)
+	Rerank(ctx context.Context, query, document string) (float32, error)
+	CachePrompt(ctx context.Context, prompt string) (*CacheResponse, error)
+	DraftStats(ctx context.Context) (*DraftStats, error)
+	Queued() map[Priority]int
 	Tokenize(ctx context.Context, content string) ([]int, error)
 	Detokenize(ctx context.Context, tokens []int) (string, error)
 	Close() error
@@ -60,12 +62,15 @@ type llmServer struct {
 
 	estimate    MemoryEstimate
 	totalLayers uint64
+	vocabSize   uint64
 	// gpuCount     int
 	gpus         discover.GpuInfoList // Recorded just before the model loaded, free space will be incorrect
 	loadDuration time.Duration        // Record how long it took the model to load
 	loadProgress float32
 
-	sem *semaphore.Weighted
+	sem *slots
+
+	embeds embedBatcher
 }
 
 // LoadModel will load a model from disk. The model must be in the GGML format.
@@ -90,7 +95,7 @@ func LoadModel(model string, maxArraySize int) (*GGML, error) {
 
 // NewLlamaServer will run a server for the given GPUs
 // The gpu list must be a single family.
-func NewLlamaServer(gpus discover.GpuInfoList, model string, ggml *GGML, adapters, projectors []string, opts api.Options, numParallel int) (LlamaServer, error) {
+func NewLlamaServer(gpus discover.GpuInfoList, model string, ggml *GGML, adapters, projectors []string, draft string, opts api.Options, numParallel int) (LlamaServer, error) {
 	var err error
 	var cpuRunner string
 	var estimate MemoryEstimate
@@ -110,9 +115,9 @@ func NewLlamaServer(gpus discover.GpuInfoList, model string, ggml *GGML, adapter
 	}
 	if len(gpus) == 1 && gpus[0].Library == "cpu" {
 		cpuRunner = runners.ServerForCpu()
-		estimate = EstimateGPULayers(gpus, ggml, projectors, opts)
+		estimate = EstimateGPULayers(gpus, ggml, projectors, draft, opts)
 	} else {
-		estimate = EstimateGPULayers(gpus, ggml, projectors, opts)
+		estimate = EstimateGPULayers(gpus, ggml, projectors, draft, opts)
 
 		switch {
 		case gpus[0].Library == "metal" && estimate.VRAMSize > systemTotalMemory:
@@ -211,6 +216,15 @@ func NewLlamaServer(gpus discover.GpuInfoList, model string, ggml *GGML, adapter
 		params = append(params, "--mmproj", projectors[0])
 	}
 
+	if draft != "" {
+		params = append(params, "--draft-model", draft)
+
+		// the draft model is fully offloaded along with any of the model
+		if opts.NumGPU > 0 {
+			params = append(params, "--draft-n-gpu-layers", "999")
+		}
+	}
+
 	defaultThreads := systemInfo.GetOptimalThreadCount()
 	if opts.NumThread > 0 {
 		params = append(params, "--threads", strconv.Itoa(opts.NumThread))
@@ -269,6 +283,14 @@ func NewLlamaServer(gpus discover.GpuInfoList, model string, ggml *GGML, adapter
 		params = append(params, "--multiuser-cache")
 	}
 
+	// saved prompts are keyed by the model's digest and are only valid
+	// without adapters
+	if len(adapters) == 0 {
+		params = append(params,
+			"--prompt-cache-dir", filepath.Join(envconfig.Models(), "kvcache", filepath.Base(model)),
+			"--prompt-cache-size", strconv.FormatUint(envconfig.PromptCacheSize(), 10))
+	}
+
 	for i := range servers {
 		dir := availableServers[servers[i]]
 		if dir == "" {
@@ -341,11 +363,13 @@ func NewLlamaServer(gpus discover.GpuInfoList, model string, ggml *GGML, adapter
 			modelPath:   model,
 			estimate:    estimate,
 			numParallel: numParallel,
-			sem:         semaphore.NewWeighted(int64(numParallel)),
+			sem:         newSlots(numParallel),
 			totalLayers: ggml.KV().BlockCount() + 1,
+			vocabSize:   ggml.KV().VocabSize(),
 			gpus:        gpus,
 			done:        make(chan error, 1),
 		}
+		s.embeds = embedBatcher{size: max(numParallel, 1), send: s.embed}
 
 		s.cmd.Env = os.Environ()
 		s.cmd.Stdout = os.Stdout
@@ -473,6 +497,8 @@ type ServerStatusResp struct {
 	SlotsProcessing int     `json:"slots_processing"`
 	Error           string  `json:"error"`
 	Progress        float32 `json:"progress"`
+	DraftProposed   int     `json:"draft_proposed"`
+	DraftAccepted   int     `json:"draft_accepted"`
 }
 
 func (s *llmServer) getServerStatus(ctx context.Context) (ServerStatus, error) {
@@ -650,6 +676,23 @@ number ::= ("-"? ([0-9] | [1-9] [0-9]*)) ("." [0-9]+)? ([eE] [-+]? [0-9]+)? ws
 ws ::= ([ \t\n] ws)?
 `
 
+// FormatGrammar returns the grammar constraining output to format, which is
+// either the string "json" or a JSON schema object. An empty or null format
+// has no grammar.
+func FormatGrammar(format json.RawMessage) (string, error) {
+	format = bytes.TrimSpace(format)
+	switch {
+	case len(format) == 0, bytes.Equal(format, []byte("null")), bytes.Equal(format, []byte(`""`)):
+		return "", nil
+	case bytes.Equal(format, []byte(`"json"`)):
+		return jsonGrammar, nil
+	case format[0] == '{':
+		return llama.SchemaToGrammar(format)
+	default:
+		return "", fmt.Errorf("invalid format %s: must be \"json\" or a JSON schema object", format)
+	}
+}
+
 const maxBufferSize = 512 * format.KiloByte
 
 type ImageData struct {
@@ -659,11 +702,15 @@ type ImageData struct {
 }
 
 type completion struct {
+	Index        int    `json:"index"`
 	Content      string `json:"content"`
 	Model        string `json:"model"`
 	Prompt       string `json:"prompt"`
 	Stop         bool   `json:"stop"`
 	StoppedLimit bool   `json:"stopped_limit"`
+	StopSequence string `json:"stop_sequence"`
+
+	Logprobs []api.TokenLogprob `json:"logprobs"`
 
 	Timings struct {
 		PredictedN  int     `json:"predicted_n"`
@@ -675,14 +722,34 @@ type completion struct {
 
 type CompletionRequest struct {
 	Prompt  string
-	Format  string
+	Format  json.RawMessage
 	Images  []ImageData
 	Options *api.Options
+
+	// Grammar constrains sampling, usually to the grammar of Format as
+	// returned by FormatGrammar
+	Grammar string
+
+	// Logprobs requests the log probability of each generated token and
+	// TopLogprobs the number of alternatives returned with it
+	Logprobs    bool
+	TopLogprobs int
+
+	// N is the number of independent completions to generate. Responses
+	// of each completion are identified by their Index.
+	N int
+
+	// Session is the ID of the chat session the prompt continues. The
+	// runner keeps a session's turns in the same cache slot.
+	Session string
 }
 
 type CompletionResponse struct {
+	Index              int
 	Content            string
+	Logprobs           []api.TokenLogprob
 	DoneReason         string
+	StopSequence       string
 	Done               bool
 	PromptEvalCount    int
 	PromptEvalDuration time.Duration
@@ -690,12 +757,45 @@ type CompletionResponse struct {
 	EvalDuration       time.Duration
 }
 
+// ErrCancelled is the cause of a context cancelled to stop its request.
+// Completions stopped this way end with the done reason "cancelled" instead
+// of an error.
+var ErrCancelled = errors.New("request cancelled")
+
 func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error {
-	if err := s.sem.Acquire(ctx, 1); err != nil {
+	done := make([]bool, max(req.N, 1))
+	err := s.complete(ctx, req, func(cr CompletionResponse) {
+		if cr.Done {
+			done[cr.Index] = true
+		}
+
+		fn(cr)
+	})
+
+	if err != nil && errors.Is(context.Cause(ctx), ErrCancelled) {
+		for i := range done {
+			if !done[i] {
+				fn(CompletionResponse{Index: i, Done: true, DoneReason: "cancelled"})
+			}
+		}
+
+		return nil
+	}
+
+	return err
+}
+
+func (s *llmServer) complete(ctx context.Context, req CompletionRequest, fn func(CompletionResponse)) error {
+	// generate up to numParallel completions at a time so the runner can
+	// share the prompt between them
+	n := max(req.N, 1)
+	parallel := max(min(n, s.numParallel), 1)
+
+	if err := s.sem.Acquire(ctx, parallel); err != nil {
 		slog.Error("Failed to acquire semaphore", "error", err)
 		return err
 	}
-	defer s.sem.Release(1)
+	defer s.sem.Release(parallel)
 
 	// put an upper limit on num_predict to avoid the model running on forever
 	if req.Options.NumPredict < 0 || req.Options.NumPredict > 10*s.options.NumCtx {
@@ -726,6 +826,10 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		"stop":              req.Options.Stop,
 		"image_data":        req.Images,
 		"cache_prompt":      true,
+		"logprobs":          req.Logprobs,
+		"top_logprobs":      req.TopLogprobs,
+		"session":           req.Session,
+		"n_draft":           req.Options.NumDraft,
 	}
 
 	// Make sure the server is ready
@@ -736,13 +840,31 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 		return fmt.Errorf("unexpected server status: %s", status.ToString())
 	}
 
-	if req.Format == "json" {
-		request["grammar"] = jsonGrammar
-		if !strings.Contains(strings.ToLower(req.Prompt), "json") {
+	if req.Grammar != "" {
+		request["grammar"] = req.Grammar
+		if len(req.Format) > 0 && !strings.Contains(strings.ToLower(req.Prompt), "json") {
 			slog.Warn("Prompt does not specify that the LLM should response in JSON, but JSON format is expected. For best results specify that JSON is expected in the system prompt.")
 		}
 	}
 
+	for offset := 0; offset < n; offset += parallel {
+		count := min(parallel, n-offset)
+		request["n"] = count
+		if req.Options.Seed >= 0 {
+			request["seed"] = req.Options.Seed + offset
+		}
+
+		if err := s.completion(ctx, request, offset, count, fn); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// completion streams count completions of request from the runner, offsetting
+// the index of each response by offset
+func (s *llmServer) completion(ctx context.Context, request map[string]any, offset, count int, fn func(CompletionResponse)) error {
 	// Handling JSON marshaling with special characters unescaped.
 	buffer := &bytes.Buffer{}
 	enc := json.NewEncoder(buffer)
@@ -779,8 +901,10 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 	scanner.Buffer(buf, maxBufferSize)
 
 	// keep track of the last token generated, this is used to abort if the model starts looping
-	var lastToken string
-	var tokenRepeat int
+	lastToken := make([]string, count)
+	tokenRepeat := make([]int, count)
+
+	var done int
 
 	for scanner.Scan() {
 		select {
@@ -803,23 +927,29 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 			if err := json.Unmarshal(evt, &c); err != nil {
 				return fmt.Errorf("error unmarshalling llm prediction response: %v", err)
 			}
+			if c.Index < 0 || c.Index >= count {
+				return fmt.Errorf("unexpected completion index %d", c.Index)
+			}
+
 			switch {
-			case strings.TrimSpace(c.Content) == lastToken:
-				tokenRepeat++
+			case strings.TrimSpace(c.Content) == lastToken[c.Index]:
+				tokenRepeat[c.Index]++
 			default:
-				lastToken = strings.TrimSpace(c.Content)
-				tokenRepeat = 0
+				lastToken[c.Index] = strings.TrimSpace(c.Content)
+				tokenRepeat[c.Index] = 0
 			}
 
 			// 30 picked as an arbitrary max token repeat limit, modify as needed
-			if tokenRepeat > 30 {
+			if tokenRepeat[c.Index] > 30 {
 				slog.Debug("prediction aborted, token repeat limit reached")
 				return ctx.Err()
 			}
 
-			if c.Content != "" {
+			if c.Content != "" || len(c.Logprobs) > 0 {
 				fn(CompletionResponse{
-					Content: c.Content,
+					Index:    offset + c.Index,
+					Content:  c.Content,
+					Logprobs: c.Logprobs,
 				})
 			}
 
@@ -830,14 +960,19 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 				}
 
 				fn(CompletionResponse{
+					Index:              offset + c.Index,
 					Done:               true,
 					DoneReason:         doneReason,
+					StopSequence:       c.StopSequence,
 					PromptEvalCount:    c.Timings.PromptN,
 					PromptEvalDuration: parseDurationMs(c.Timings.PromptMS),
 					EvalCount:          c.Timings.PredictedN,
 					EvalDuration:       parseDurationMs(c.Timings.PredictedMS),
 				})
-				return nil
+
+				if done++; done == count {
+					return nil
+				}
 			}
 		}
 	}
@@ -859,19 +994,28 @@ func (s *llmServer) Completion(ctx context.Context, req CompletionRequest, fn fu
 }
 
 type EmbeddingRequest struct {
-	Content string `json:"content"`
+	Content []string `json:"content"`
 }
 
 type EmbeddingResponse struct {
-	Embedding []float32 `json:"embedding"`
+	Embeddings [][]float32 `json:"embeddings"`
 }
 
-func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, error) {
-	if err := s.sem.Acquire(ctx, 1); err != nil {
+// Embedding returns the embeddings of inputs. Concurrent calls are combined
+// so that the runner decodes their inputs together.
+func (s *llmServer) Embedding(ctx context.Context, inputs []string) ([][]float32, error) {
+	return s.embeds.embed(ctx, inputs)
+}
+
+func (s *llmServer) embed(ctx context.Context, inputs []string) ([][]float32, error) {
+	// the runner evaluates up to numParallel inputs at a time
+	parallel := max(min(len(inputs), s.numParallel), 1)
+
+	if err := s.sem.Acquire(ctx, parallel); err != nil {
 		slog.Error("Failed to acquire semaphore", "error", err)
 		return nil, err
 	}
-	defer s.sem.Release(1)
+	defer s.sem.Release(parallel)
 
 	// Make sure the server is ready
 	status, err := s.getServerStatusRetry(ctx)
@@ -881,7 +1025,7 @@ func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, err
 		return nil, fmt.Errorf("unexpected server status: %s", status.ToString())
 	}
 
-	data, err := json.Marshal(EmbeddingRequest{Content: input})
+	data, err := json.Marshal(EmbeddingRequest{Content: inputs})
 	if err != nil {
 		return nil, fmt.Errorf("error marshaling embed data: %w", err)
 	}
@@ -913,7 +1057,168 @@ func (s *llmServer) Embedding(ctx context.Context, input string) ([]float32, err
 		return nil, fmt.Errorf("unmarshal tokenize response: %w", err)
 	}
 
-	return e.Embedding, nil
+	if len(e.Embeddings) != len(inputs) {
+		return nil, fmt.Errorf("expected %d embeddings, got %d", len(inputs), len(e.Embeddings))
+	}
+
+	return e.Embeddings, nil
+}
+
+type RerankRequest struct {
+	Query    string `json:"query"`
+	Document string `json:"document"`
+}
+
+type RerankResponse struct {
+	Score float32 `json:"score"`
+}
+
+// Rerank scores the relevance of document to query with a reranking model
+func (s *llmServer) Rerank(ctx context.Context, query, document string) (float32, error) {
+	if err := s.sem.Acquire(ctx, 1); err != nil {
+		slog.Error("Failed to acquire semaphore", "error", err)
+		return 0, err
+	}
+	defer s.sem.Release(1)
+
+	// Make sure the server is ready
+	status, err := s.getServerStatusRetry(ctx)
+	if err != nil {
+		return 0, err
+	} else if status != ServerStatusReady {
+		return 0, fmt.Errorf("unexpected server status: %s", status.ToString())
+	}
+
+	data, err := json.Marshal(RerankRequest{Query: query, Document: document})
+	if err != nil {
+		return 0, fmt.Errorf("error marshaling rerank data: %w", err)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/rerank", s.port), bytes.NewBuffer(data))
+	if err != nil {
+		return 0, fmt.Errorf("error creating rerank request: %w", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return 0, fmt.Errorf("do rerank request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return 0, fmt.Errorf("error reading rerank response: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		return 0, fmt.Errorf("%s", bytes.TrimSpace(body))
+	}
+
+	var rr RerankResponse
+	if err := json.Unmarshal(body, &rr); err != nil {
+		return 0, fmt.Errorf("unmarshal rerank response: %w", err)
+	}
+
+	return rr.Score, nil
+}
+
+type CacheRequest struct {
+	Prompt string `json:"prompt"`
+}
+
+type CacheResponse struct {
+	// Tokens is the number of tokens of the prompt saved to disk
+	Tokens int `json:"tokens"`
+
+	// PromptN is the number of tokens evaluated to save them
+	PromptN  int     `json:"prompt_n"`
+	PromptMS float64 `json:"prompt_ms"`
+}
+
+// CachePrompt evaluates prompt and saves its KV cache to disk so that later
+// requests starting with it, even after the model is reloaded, do not
+// evaluate it again
+func (s *llmServer) CachePrompt(ctx context.Context, prompt string) (*CacheResponse, error) {
+	if err := s.sem.Acquire(ctx, 1); err != nil {
+		slog.Error("Failed to acquire semaphore", "error", err)
+		return nil, err
+	}
+	defer s.sem.Release(1)
+
+	// Make sure the server is ready
+	status, err := s.getServerStatusRetry(ctx)
+	if err != nil {
+		return nil, err
+	} else if status != ServerStatusReady {
+		return nil, fmt.Errorf("unexpected server status: %s", status.ToString())
+	}
+
+	data, err := json.Marshal(CacheRequest{Prompt: prompt})
+	if err != nil {
+		return nil, fmt.Errorf("error marshaling cache data: %w", err)
+	}
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, fmt.Sprintf("http://127.0.0.1:%d/cache", s.port), bytes.NewBuffer(data))
+	if err != nil {
+		return nil, fmt.Errorf("error creating cache request: %w", err)
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("do cache request: %w", err)
+	}
+	defer resp.Body.Close()
+
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return nil, fmt.Errorf("error reading cache response: %w", err)
+	}
+
+	if resp.StatusCode >= 400 {
+		log.Printf("llm cache error: %s", body)
+		return nil, fmt.Errorf("%s", strings.TrimSpace(string(body)))
+	}
+
+	var c CacheResponse
+	if err := json.Unmarshal(body, &c); err != nil {
+		return nil, fmt.Errorf("unmarshal cache response: %w", err)
+	}
+
+	return &c, nil
+}
+
+// DraftStats counts the tokens proposed by the draft model since it was
+// loaded and how many of them the model accepted
+type DraftStats struct {
+	Proposed int
+	Accepted int
+}
+
+func (s *llmServer) DraftStats(ctx context.Context) (*DraftStats, error) {
+	r, err := http.NewRequestWithContext(ctx, http.MethodGet, fmt.Sprintf("http://127.0.0.1:%d/health", s.port), nil)
+	if err != nil {
+		return nil, fmt.Errorf("error creating health request: %w", err)
+	}
+
+	resp, err := http.DefaultClient.Do(r)
+	if err != nil {
+		return nil, fmt.Errorf("health resp: %w", err)
+	}
+	defer resp.Body.Close()
+
+	var status ServerStatusResp
+	if err := json.NewDecoder(resp.Body).Decode(&status); err != nil {
+		return nil, fmt.Errorf("health unmarshal encode response: %w", err)
+	}
+
+	return &DraftStats{Proposed: status.DraftProposed, Accepted: status.DraftAccepted}, nil
+}
+
+// Queued counts the requests waiting for a slot on the runner by priority
+func (s *llmServer) Queued() map[Priority]int {
+	return s.sem.Queued()
 }
 
 type TokenizeRequest struct {
@@ -958,7 +1263,10 @@ func (s *llmServer) Tokenize(ctx context.Context, content string) ([]int, error)
 	if resp.StatusCode == http.StatusNotFound {
 		if s.model == nil {
 			slog.Debug("new runner detected, loading model for cgo tokenization")
-			m := llama.LoadModelFromFile(s.modelPath, llama.ModelParams{VocabOnly: true})
+			m, err := llama.LoadModelFromFile(s.modelPath, llama.ModelParams{VocabOnly: true})
+			if err != nil {
+				return nil, err
+			}
 			s.model = m
 		}
 		return s.model.Tokenize(content, false, true)
@@ -982,6 +1290,17 @@ func (s *llmServer) Tokenize(ctx context.Context, content string) ([]int, error)
 	return encoded.Tokens, nil
 }
 
+// InvalidTokenError is returned when detokenizing a token ID outside the
+// vocabulary of the model
+type InvalidTokenError struct {
+	Token     int
+	VocabSize uint64
+}
+
+func (e *InvalidTokenError) Error() string {
+	return fmt.Sprintf("token %d is outside the vocabulary of %d tokens", e.Token, e.VocabSize)
+}
+
 type DetokenizeRequest struct {
 	Tokens []int `json:"tokens"`
 }
@@ -991,6 +1310,14 @@ type DetokenizeResponse struct {
 }
 
 func (s *llmServer) Detokenize(ctx context.Context, tokens []int) (string, error) {
+	// the runner doesn't check token IDs, so ones outside the vocabulary
+	// must not reach it
+	for _, token := range tokens {
+		if token < 0 || uint64(token) >= s.vocabSize {
+			return "", &InvalidTokenError{Token: token, VocabSize: s.vocabSize}
+		}
+	}
+
 	s.modelLock.Lock()
 	defer s.modelLock.Unlock()
 	if s.model != nil {
@@ -1027,7 +1354,10 @@ func (s *llmServer) Detokenize(ctx context.Context, tokens []int) (string, error
 	if resp.StatusCode == http.StatusNotFound {
 		if s.model == nil {
 			slog.Debug("new runner detected, loading model for cgo tokenization")
-			m := llama.LoadModelFromFile(s.modelPath, llama.ModelParams{VocabOnly: true})
+			m, err := llama.LoadModelFromFile(s.modelPath, llama.ModelParams{VocabOnly: true})
+			if err != nil {
+				return "", err
+			}
 			s.model = m
 		}
 		var resp string
@@ -0,0 +1,58 @@
+package llm
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"testing"
+)
+
+func TestFormatGrammar(t *testing.T) {
+	cases := []struct {
+		format   json.RawMessage
+		contains string
+		err      bool
+	}{
+		{format: nil},
+		{format: json.RawMessage(`null`)},
+		{format: json.RawMessage(`""`)},
+		{format: json.RawMessage(`"json"`), contains: jsonGrammar},
+		{format: json.RawMessage(`{"type": "string"}`), contains: "root ::="},
+		{format: json.RawMessage(`"xml"`), err: true},
+		{format: json.RawMessage(`["json"]`), err: true},
+	}
+
+	for _, tt := range cases {
+		t.Run(string(tt.format), func(t *testing.T) {
+			g, err := FormatGrammar(tt.format)
+			if tt.err {
+				if err == nil {
+					t.Fatalf("expected error, got grammar %q", g)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.contains == "" && g != "" {
+				t.Errorf("expected no grammar, got %q", g)
+			} else if !strings.Contains(g, tt.contains) {
+				t.Errorf("expected grammar containing %q, got %q", tt.contains, g)
+			}
+		})
+	}
+}
+
+func TestDetokenizeInvalidToken(t *testing.T) {
+	// tokens are checked before the runner is used
+	s := &llmServer{vocabSize: 4}
+	for _, tokens := range [][]int{{-1}, {1, 4}} {
+		var invalid *InvalidTokenError
+		if _, err := s.Detokenize(context.Background(), tokens); !errors.As(err, &invalid) || invalid.Token != tokens[len(tokens)-1] {
+			t.Errorf("expected an invalid token error for %v, got %v", tokens, err)
+		}
+	}
+}
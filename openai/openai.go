@@ -38,21 +38,48 @@ type Message struct {
 }
 
 type Choice struct {
-	Index        int     `json:"index"`
-	Message      Message `json:"message"`
-	FinishReason *string `json:"finish_reason"`
+	Index        int             `json:"index"`
+	Message      Message         `json:"message"`
+	Logprobs     *ChoiceLogprobs `json:"logprobs,omitempty"`
+	FinishReason *string         `json:"finish_reason"`
 }
 
 type ChunkChoice struct {
-	Index        int     `json:"index"`
-	Delta        Message `json:"delta"`
-	FinishReason *string `json:"finish_reason"`
+	Index        int             `json:"index"`
+	Delta        Message         `json:"delta"`
+	Logprobs     *ChoiceLogprobs `json:"logprobs,omitempty"`
+	FinishReason *string         `json:"finish_reason"`
 }
 
 type CompleteChunkChoice struct {
-	Text         string  `json:"text"`
-	Index        int     `json:"index"`
-	FinishReason *string `json:"finish_reason"`
+	Text         string              `json:"text"`
+	Index        int                 `json:"index"`
+	Logprobs     *CompletionLogprobs `json:"logprobs,omitempty"`
+	FinishReason *string             `json:"finish_reason"`
+}
+
+type ChoiceLogprobs struct {
+	Content []ChatLogprob `json:"content"`
+}
+
+type ChatLogprob struct {
+	Token       string       `json:"token"`
+	Logprob     float64      `json:"logprob"`
+	Bytes       []int        `json:"bytes"`
+	TopLogprobs []TopLogprob `json:"top_logprobs"`
+}
+
+type TopLogprob struct {
+	Token   string  `json:"token"`
+	Logprob float64 `json:"logprob"`
+	Bytes   []int   `json:"bytes"`
+}
+
+type CompletionLogprobs struct {
+	Tokens        []string             `json:"tokens"`
+	TokenLogprobs []float64            `json:"token_logprobs"`
+	TopLogprobs   []map[string]float64 `json:"top_logprobs"`
+	TextOffset    []int                `json:"text_offset"`
 }
 
 type Usage struct {
@@ -62,12 +89,33 @@ type Usage struct {
 }
 
 type ResponseFormat struct {
-	Type string `json:"type"`
+	Type       string      `json:"type"`
+	JSONSchema *JSONSchema `json:"json_schema,omitempty"`
+}
+
+type JSONSchema struct {
+	Name   string          `json:"name"`
+	Schema json.RawMessage `json:"schema"`
+	Strict *bool           `json:"strict,omitempty"`
 }
 
 type EmbedRequest struct {
-	Input any    `json:"input"`
+	Input          any    `json:"input"`
+	Model          string `json:"model"`
+	Dimensions     int    `json:"dimensions,omitempty"`
+	EncodingFormat string `json:"encoding_format,omitempty"`
+}
+
+// RerankRequest is a request to rank documents in the format shared by
+// Cohere and Jina compatible rerank APIs
+type RerankRequest struct {
 	Model string `json:"model"`
+	Query string `json:"query"`
+
+	// Documents are strings or objects with a text field
+	Documents       []any `json:"documents"`
+	TopN            int   `json:"top_n"`
+	ReturnDocuments bool  `json:"return_documents"`
 }
 
 type ChatCompletionRequest struct {
@@ -83,6 +131,9 @@ type ChatCompletionRequest struct {
 	TopP             *float64        `json:"top_p"`
 	ResponseFormat   *ResponseFormat `json:"response_format"`
 	Tools            []api.Tool      `json:"tools"`
+	Logprobs         *bool           `json:"logprobs"`
+	TopLogprobs      *int            `json:"top_logprobs"`
+	N                *int            `json:"n"`
 }
 
 type ChatCompletion struct {
@@ -117,6 +168,8 @@ type CompletionRequest struct {
 	Temperature      *float32 `json:"temperature"`
 	TopP             float32  `json:"top_p"`
 	Suffix           string   `json:"suffix"`
+	Logprobs         *int     `json:"logprobs"`
+	N                *int     `json:"n"`
 }
 
 type Completion struct {
@@ -152,12 +205,18 @@ type Model struct {
 	Object  string `json:"object"`
 	Created int64  `json:"created"`
 	OwnedBy string `json:"owned_by"`
+
+	// Targets and Fallbacks are set when the model is an alias
+	Targets   []api.AliasTarget `json:"targets,omitempty"`
+	Fallbacks []string          `json:"fallbacks,omitempty"`
 }
 
 type Embedding struct {
-	Object    string    `json:"object"`
-	Embedding []float32 `json:"embedding"`
-	Index     int       `json:"index"`
+	Object string `json:"object"`
+	// Embedding is a list of floats, or a base64 string when the request
+	// sets an encoding format other than float
+	Embedding any `json:"embedding"`
+	Index     int `json:"index"`
 }
 
 type ListCompletion struct {
@@ -172,6 +231,23 @@ type EmbeddingList struct {
 	Usage  EmbeddingUsage `json:"usage,omitempty"`
 }
 
+type RerankDocument struct {
+	Text string `json:"text"`
+}
+
+type RerankResult struct {
+	Index          int             `json:"index"`
+	RelevanceScore float32         `json:"relevance_score"`
+	Document       *RerankDocument `json:"document,omitempty"`
+}
+
+type RerankResponse struct {
+	Object  string         `json:"object"`
+	Model   string         `json:"model"`
+	Results []RerankResult `json:"results"`
+	Usage   EmbeddingUsage `json:"usage,omitempty"`
+}
+
 type EmbeddingUsage struct {
 	PromptTokens int `json:"prompt_tokens"`
 	TotalTokens  int `json:"total_tokens"`
@@ -182,8 +258,14 @@ func NewError(code int, message string) ErrorResponse {
 	switch code {
 	case http.StatusBadRequest:
 		etype = "invalid_request_error"
+	case http.StatusUnauthorized:
+		etype = "authentication_error"
+	case http.StatusForbidden:
+		etype = "permission_error"
 	case http.StatusNotFound:
 		etype = "not_found_error"
+	case http.StatusTooManyRequests:
+		etype = "rate_limit_error"
 	default:
 		etype = "api_error"
 	}
@@ -200,7 +282,98 @@ func toolCallId() string {
 	return "call_" + strings.ToLower(string(b))
 }
 
+func tokenBytes(token string) []int {
+	b := make([]int, len(token))
+	for i := range len(token) {
+		b[i] = int(token[i])
+	}
+	return b
+}
+
+func toChoiceLogprobs(lps []api.TokenLogprob) *ChoiceLogprobs {
+	if len(lps) == 0 {
+		return nil
+	}
+
+	content := make([]ChatLogprob, len(lps))
+	for i, lp := range lps {
+		content[i] = ChatLogprob{
+			Token:       lp.Token,
+			Logprob:     lp.Logprob,
+			Bytes:       tokenBytes(lp.Token),
+			TopLogprobs: make([]TopLogprob, len(lp.TopLogprobs)),
+		}
+
+		for j, top := range lp.TopLogprobs {
+			content[i].TopLogprobs[j] = TopLogprob{
+				Token:   top.Token,
+				Logprob: top.Logprob,
+				Bytes:   tokenBytes(top.Token),
+			}
+		}
+	}
+
+	return &ChoiceLogprobs{Content: content}
+}
+
+// toCompletionLogprobs converts logprobs to the legacy completions format.
+// offset is the position of the first token in the completion text.
+func toCompletionLogprobs(lps []api.TokenLogprob, offset int) *CompletionLogprobs {
+	if len(lps) == 0 {
+		return nil
+	}
+
+	c := CompletionLogprobs{
+		Tokens:        make([]string, len(lps)),
+		TokenLogprobs: make([]float64, len(lps)),
+		TopLogprobs:   make([]map[string]float64, len(lps)),
+		TextOffset:    make([]int, len(lps)),
+	}
+
+	for i, lp := range lps {
+		c.Tokens[i] = lp.Token
+		c.TokenLogprobs[i] = lp.Logprob
+		c.TextOffset[i] = offset
+		offset += len(lp.Token)
+
+		c.TopLogprobs[i] = make(map[string]float64, len(lp.TopLogprobs))
+		for _, top := range lp.TopLogprobs {
+			c.TopLogprobs[i][top.Token] = top.Logprob
+		}
+	}
+
+	return &c
+}
+
 func toChatCompletion(id string, r api.ChatResponse) ChatCompletion {
+	responses := r.Choices
+	if len(responses) == 0 {
+		responses = []api.ChatResponse{r}
+	}
+
+	choices := make([]Choice, len(responses))
+	var completionTokens int
+	for i, resp := range responses {
+		choices[i] = toChoice(resp)
+		completionTokens += resp.EvalCount
+	}
+
+	return ChatCompletion{
+		Id:                id,
+		Object:            "chat.completion",
+		Created:           r.CreatedAt.Unix(),
+		Model:             r.Model,
+		SystemFingerprint: "fp_ollama",
+		Choices:           choices,
+		Usage: Usage{
+			PromptTokens:     r.PromptEvalCount,
+			CompletionTokens: completionTokens,
+			TotalTokens:      r.PromptEvalCount + completionTokens,
+		},
+	}
+}
+
+func toChoice(r api.ChatResponse) Choice {
 	toolCalls := make([]ToolCall, len(r.Message.ToolCalls))
 	for i, tc := range r.Message.ToolCalls {
 		toolCalls[i].ID = toolCallId()
@@ -216,30 +389,19 @@ func toChatCompletion(id string, r api.ChatResponse) ChatCompletion {
 		toolCalls[i].Function.Arguments = string(args)
 	}
 
-	return ChatCompletion{
-		Id:                id,
-		Object:            "chat.completion",
-		Created:           r.CreatedAt.Unix(),
-		Model:             r.Model,
-		SystemFingerprint: "fp_ollama",
-		Choices: []Choice{{
-			Index:   0,
-			Message: Message{Role: r.Message.Role, Content: r.Message.Content, ToolCalls: toolCalls},
-			FinishReason: func(reason string) *string {
-				if len(toolCalls) > 0 {
-					reason = "tool_calls"
-				}
-				if len(reason) > 0 {
-					return &reason
-				}
-				return nil
-			}(r.DoneReason),
-		}},
-		Usage: Usage{
-			PromptTokens:     r.PromptEvalCount,
-			CompletionTokens: r.EvalCount,
-			TotalTokens:      r.PromptEvalCount + r.EvalCount,
-		},
+	return Choice{
+		Index:    r.Index,
+		Message:  Message{Role: r.Message.Role, Content: r.Message.Content, ToolCalls: toolCalls},
+		Logprobs: toChoiceLogprobs(r.Logprobs),
+		FinishReason: func(reason string) *string {
+			if len(toolCalls) > 0 {
+				reason = "tool_calls"
+			}
+			if len(reason) > 0 {
+				return &reason
+			}
+			return nil
+		}(r.DoneReason),
 	}
 }
 
@@ -251,8 +413,9 @@ func toChunk(id string, r api.ChatResponse) ChatCompletionChunk {
 		Model:             r.Model,
 		SystemFingerprint: "fp_ollama",
 		Choices: []ChunkChoice{{
-			Index: 0,
-			Delta: Message{Role: "assistant", Content: r.Message.Content},
+			Index:    r.Index,
+			Delta:    Message{Role: "assistant", Content: r.Message.Content},
+			Logprobs: toChoiceLogprobs(r.Logprobs),
 			FinishReason: func(reason string) *string {
 				if len(reason) > 0 {
 					return &reason
@@ -264,31 +427,46 @@ func toChunk(id string, r api.ChatResponse) ChatCompletionChunk {
 }
 
 func toCompletion(id string, r api.GenerateResponse) Completion {
+	responses := r.Choices
+	if len(responses) == 0 {
+		responses = []api.GenerateResponse{r}
+	}
+
+	choices := make([]CompleteChunkChoice, len(responses))
+	var completionTokens int
+	for i, resp := range responses {
+		choices[i] = CompleteChunkChoice{
+			Text:     resp.Response,
+			Index:    resp.Index,
+			Logprobs: toCompletionLogprobs(resp.Logprobs, 0),
+			FinishReason: func(reason string) *string {
+				if len(reason) > 0 {
+					return &reason
+				}
+				return nil
+			}(resp.DoneReason),
+		}
+		completionTokens += resp.EvalCount
+	}
+
 	return Completion{
 		Id:                id,
 		Object:            "text_completion",
 		Created:           r.CreatedAt.Unix(),
 		Model:             r.Model,
 		SystemFingerprint: "fp_ollama",
-		Choices: []CompleteChunkChoice{{
-			Text:  r.Response,
-			Index: 0,
-			FinishReason: func(reason string) *string {
-				if len(reason) > 0 {
-					return &reason
-				}
-				return nil
-			}(r.DoneReason),
-		}},
+		Choices:           choices,
 		Usage: Usage{
 			PromptTokens:     r.PromptEvalCount,
-			CompletionTokens: r.EvalCount,
-			TotalTokens:      r.PromptEvalCount + r.EvalCount,
+			CompletionTokens: completionTokens,
+			TotalTokens:      r.PromptEvalCount + completionTokens,
 		},
 	}
 }
 
-func toCompleteChunk(id string, r api.GenerateResponse) CompletionChunk {
+// toCompleteChunk converts a streamed response. offset is the length of the
+// text streamed before this chunk.
+func toCompleteChunk(id string, r api.GenerateResponse, offset int) CompletionChunk {
 	return CompletionChunk{
 		Id:                id,
 		Object:            "text_completion",
@@ -296,8 +474,9 @@ func toCompleteChunk(id string, r api.GenerateResponse) CompletionChunk {
 		Model:             r.Model,
 		SystemFingerprint: "fp_ollama",
 		Choices: []CompleteChunkChoice{{
-			Text:  r.Response,
-			Index: 0,
+			Text:     r.Response,
+			Index:    r.Index,
+			Logprobs: toCompletionLogprobs(r.Logprobs, offset),
 			FinishReason: func(reason string) *string {
 				if len(reason) > 0 {
 					return &reason
@@ -312,10 +491,12 @@ func toListCompletion(r api.ListResponse) ListCompletion {
 	var data []Model
 	for _, m := range r.Models {
 		data = append(data, Model{
-			Id:      m.Name,
-			Object:  "model",
-			Created: m.ModifiedAt.Unix(),
-			OwnedBy: model.ParseName(m.Name).Namespace,
+			Id:        m.Name,
+			Object:    "model",
+			Created:   m.ModifiedAt.Unix(),
+			OwnedBy:   model.ParseName(m.Name).Namespace,
+			Targets:   m.Targets,
+			Fallbacks: m.Fallbacks,
 		})
 	}
 
@@ -325,8 +506,32 @@ func toListCompletion(r api.ListResponse) ListCompletion {
 	}
 }
 
+func toRerankResponse(model string, r api.RerankResponse, returnDocuments bool) RerankResponse {
+	results := make([]RerankResult, len(r.Results))
+	for i, result := range r.Results {
+		results[i] = RerankResult{
+			Index:          result.Index,
+			RelevanceScore: result.RelevanceScore,
+		}
+
+		if returnDocuments {
+			results[i].Document = &RerankDocument{Text: result.Document}
+		}
+	}
+
+	return RerankResponse{
+		Object:  "list",
+		Model:   model,
+		Results: results,
+		Usage: EmbeddingUsage{
+			PromptTokens: r.PromptEvalCount,
+			TotalTokens:  r.PromptEvalCount,
+		},
+	}
+}
+
 func toEmbeddingList(model string, r api.EmbedResponse) EmbeddingList {
-	if r.Embeddings != nil {
+	if r.Embeddings != nil || r.EncodedEmbeddings != nil {
 		var data []Embedding
 		for i, e := range r.Embeddings {
 			data = append(data, Embedding{
@@ -336,6 +541,14 @@ func toEmbeddingList(model string, r api.EmbedResponse) EmbeddingList {
 			})
 		}
 
+		for i, e := range r.EncodedEmbeddings {
+			data = append(data, Embedding{
+				Object:    "embedding",
+				Embedding: e,
+				Index:     i,
+			})
+		}
+
 		return EmbeddingList{
 			Object: "list",
 			Data:   data,
@@ -475,18 +688,44 @@ func fromChatRequest(r ChatCompletionRequest) (*api.ChatRequest, error) {
 		options["top_p"] = 1.0
 	}
 
-	var format string
-	if r.ResponseFormat != nil && r.ResponseFormat.Type == "json_object" {
-		format = "json"
+	var format json.RawMessage
+	if r.ResponseFormat != nil {
+		switch r.ResponseFormat.Type {
+		case "json_object":
+			format = json.RawMessage(`"json"`)
+		case "json_schema":
+			if r.ResponseFormat.JSONSchema == nil || len(r.ResponseFormat.JSONSchema.Schema) == 0 {
+				return nil, errors.New("response_format json_schema requires a schema")
+			}
+			format = r.ResponseFormat.JSONSchema.Schema
+		}
+	}
+
+	var logprobs bool
+	if r.Logprobs != nil {
+		logprobs = *r.Logprobs
+	}
+
+	var topLogprobs int
+	if r.TopLogprobs != nil {
+		topLogprobs = *r.TopLogprobs
+	}
+
+	var n int
+	if r.N != nil {
+		n = *r.N
 	}
 
 	return &api.ChatRequest{
-		Model:    r.Model,
-		Messages: messages,
-		Format:   format,
-		Options:  options,
-		Stream:   &r.Stream,
-		Tools:    r.Tools,
+		Model:       r.Model,
+		Messages:    messages,
+		Format:      format,
+		Options:     options,
+		Stream:      &r.Stream,
+		Tools:       r.Tools,
+		Logprobs:    logprobs,
+		TopLogprobs: topLogprobs,
+		N:           n,
 	}, nil
 }
 
@@ -532,12 +771,28 @@ func fromCompleteRequest(r CompletionRequest) (api.GenerateRequest, error) {
 		options["top_p"] = 1.0
 	}
 
+	// the legacy completions API sets logprobs to the number of alternatives
+	var logprobs bool
+	var topLogprobs int
+	if r.Logprobs != nil {
+		logprobs = true
+		topLogprobs = *r.Logprobs
+	}
+
+	var n int
+	if r.N != nil {
+		n = *r.N
+	}
+
 	return api.GenerateRequest{
-		Model:   r.Model,
-		Prompt:  r.Prompt,
-		Options: options,
-		Stream:  &r.Stream,
-		Suffix:  r.Suffix,
+		Model:       r.Model,
+		Prompt:      r.Prompt,
+		Options:     options,
+		Stream:      &r.Stream,
+		Suffix:      r.Suffix,
+		Logprobs:    logprobs,
+		TopLogprobs: topLogprobs,
+		N:           n,
 	}, nil
 }
 
@@ -549,12 +804,21 @@ type ChatWriter struct {
 	stream bool
 	id     string
 	BaseWriter
+
+	// pending is the number of choices still streaming
+	pending int
 }
 
 type CompleteWriter struct {
 	stream bool
 	id     string
 	BaseWriter
+
+	// pending is the number of choices still streaming
+	pending int
+
+	// offsets holds the length of the text streamed so far for each choice
+	offsets map[int]int
 }
 
 type ListWriter struct {
@@ -571,6 +835,12 @@ type EmbedWriter struct {
 	model string
 }
 
+type RerankWriter struct {
+	BaseWriter
+	model           string
+	returnDocuments bool
+}
+
 func (w *BaseWriter) writeError(code int, data []byte) (int, error) {
 	var serr api.StatusError
 	err := json.Unmarshal(data, &serr)
@@ -608,9 +878,12 @@ func (w *ChatWriter) writeResponse(data []byte) (int, error) {
 		}
 
 		if chatResponse.Done {
-			_, err = w.ResponseWriter.Write([]byte("data: [DONE]\n\n"))
-			if err != nil {
-				return 0, err
+			w.pending--
+			if w.pending <= 0 {
+				_, err = w.ResponseWriter.Write([]byte("data: [DONE]\n\n"))
+				if err != nil {
+					return 0, err
+				}
 			}
 		}
 
@@ -645,11 +918,16 @@ func (w *CompleteWriter) writeResponse(data []byte) (int, error) {
 
 	// completion chunk
 	if w.stream {
-		d, err := json.Marshal(toCompleteChunk(w.id, generateResponse))
+		d, err := json.Marshal(toCompleteChunk(w.id, generateResponse, w.offsets[generateResponse.Index]))
 		if err != nil {
 			return 0, err
 		}
 
+		if w.offsets == nil {
+			w.offsets = make(map[int]int)
+		}
+		w.offsets[generateResponse.Index] += len(generateResponse.Response)
+
 		w.ResponseWriter.Header().Set("Content-Type", "text/event-stream")
 		_, err = w.ResponseWriter.Write([]byte(fmt.Sprintf("data: %s\n\n", d)))
 		if err != nil {
@@ -657,9 +935,12 @@ func (w *CompleteWriter) writeResponse(data []byte) (int, error) {
 		}
 
 		if generateResponse.Done {
-			_, err = w.ResponseWriter.Write([]byte("data: [DONE]\n\n"))
-			if err != nil {
-				return 0, err
+			w.pending--
+			if w.pending <= 0 {
+				_, err = w.ResponseWriter.Write([]byte("data: [DONE]\n\n"))
+				if err != nil {
+					return 0, err
+				}
 			}
 		}
 
@@ -761,6 +1042,31 @@ func (w *EmbedWriter) Write(data []byte) (int, error) {
 	return w.writeResponse(data)
 }
 
+func (w *RerankWriter) writeResponse(data []byte) (int, error) {
+	var rerankResponse api.RerankResponse
+	err := json.Unmarshal(data, &rerankResponse)
+	if err != nil {
+		return 0, err
+	}
+
+	w.ResponseWriter.Header().Set("Content-Type", "application/json")
+	err = json.NewEncoder(w.ResponseWriter).Encode(toRerankResponse(w.model, rerankResponse, w.returnDocuments))
+	if err != nil {
+		return 0, err
+	}
+
+	return len(data), nil
+}
+
+func (w *RerankWriter) Write(data []byte) (int, error) {
+	code := w.ResponseWriter.Status()
+	if code != http.StatusOK {
+		return w.writeError(code, data)
+	}
+
+	return w.writeResponse(data)
+}
+
 func ListMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		w := &ListWriter{
@@ -822,6 +1128,7 @@ func CompletionsMiddleware() gin.HandlerFunc {
 			BaseWriter: BaseWriter{ResponseWriter: c.Writer},
 			stream:     req.Stream,
 			id:         fmt.Sprintf("cmpl-%d", rand.Intn(999)),
+			pending:    max(genReq.N, 1),
 		}
 
 		c.Writer = w
@@ -853,7 +1160,7 @@ func EmbeddingsMiddleware() gin.HandlerFunc {
 		}
 
 		var b bytes.Buffer
-		if err := json.NewEncoder(&b).Encode(api.EmbedRequest{Model: req.Model, Input: req.Input}); err != nil {
+		if err := json.NewEncoder(&b).Encode(api.EmbedRequest{Model: req.Model, Input: req.Input, Dimensions: req.Dimensions, EncodingFormat: req.EncodingFormat}); err != nil {
 			c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
 			return
 		}
@@ -871,6 +1178,53 @@ func EmbeddingsMiddleware() gin.HandlerFunc {
 	}
 }
 
+func RerankMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		var req RerankRequest
+		err := c.ShouldBindJSON(&req)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, err.Error()))
+			return
+		}
+
+		documents := make([]string, len(req.Documents))
+		for i, d := range req.Documents {
+			switch d := d.(type) {
+			case string:
+				documents[i] = d
+			case map[string]any:
+				text, ok := d["text"].(string)
+				if !ok {
+					c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, "invalid document"))
+					return
+				}
+				documents[i] = text
+			default:
+				c.AbortWithStatusJSON(http.StatusBadRequest, NewError(http.StatusBadRequest, "invalid document"))
+				return
+			}
+		}
+
+		var b bytes.Buffer
+		if err := json.NewEncoder(&b).Encode(api.RerankRequest{Model: req.Model, Query: req.Query, Documents: documents, TopN: req.TopN}); err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, NewError(http.StatusInternalServerError, err.Error()))
+			return
+		}
+
+		c.Request.Body = io.NopCloser(&b)
+
+		w := &RerankWriter{
+			BaseWriter:      BaseWriter{ResponseWriter: c.Writer},
+			model:           req.Model,
+			returnDocuments: req.ReturnDocuments,
+		}
+
+		c.Writer = w
+
+		c.Next()
+	}
+}
+
 func ChatMiddleware() gin.HandlerFunc {
 	return func(c *gin.Context) {
 		var req ChatCompletionRequest
@@ -904,6 +1258,7 @@ func ChatMiddleware() gin.HandlerFunc {
 			BaseWriter: BaseWriter{ResponseWriter: c.Writer},
 			stream:     req.Stream,
 			id:         fmt.Sprintf("chatcmpl-%d", rand.Intn(999)),
+			pending:    max(chatReq.N, 1),
 		}
 
 		c.Writer = w
@@ -107,7 +107,7 @@ func TestChatMiddleware(t *testing.T) {
 					"presence_penalty":  5.0,
 					"top_p":             6.0,
 				},
-				Format: "json",
+				Format: json.RawMessage(`"json"`),
 				Stream: &True,
 			},
 		},
@@ -195,6 +195,81 @@ func TestChatMiddleware(t *testing.T) {
 				Stream: &False,
 			},
 		},
+		{
+			name: "chat handler with logprobs",
+			body: `{
+				"model": "test-model",
+				"messages": [
+					{"role": "user", "content": "Hello"}
+				],
+				"logprobs": true,
+				"top_logprobs": 3
+			}`,
+			req: api.ChatRequest{
+				Model: "test-model",
+				Messages: []api.Message{
+					{
+						Role:    "user",
+						Content: "Hello",
+					},
+				},
+				Options: map[string]any{
+					"temperature": 1.0,
+					"top_p":       1.0,
+				},
+				Stream:      &False,
+				Logprobs:    true,
+				TopLogprobs: 3,
+			},
+		},
+
+		{
+			name: "chat handler with json schema",
+			body: `{
+				"model": "test-model",
+				"messages": [
+					{"role": "user", "content": "Hello"}
+				],
+				"response_format": {
+					"type": "json_schema",
+					"json_schema": {
+						"name": "person",
+						"schema": {"type":"object","properties":{"name":{"type":"string"}}}
+					}
+				}
+			}`,
+			req: api.ChatRequest{
+				Model: "test-model",
+				Messages: []api.Message{
+					{
+						Role:    "user",
+						Content: "Hello",
+					},
+				},
+				Options: map[string]any{
+					"temperature": 1.0,
+					"top_p":       1.0,
+				},
+				Format: json.RawMessage(`{"type":"object","properties":{"name":{"type":"string"}}}`),
+				Stream: &False,
+			},
+		},
+		{
+			name: "chat handler with json schema missing schema",
+			body: `{
+				"model": "test-model",
+				"messages": [
+					{"role": "user", "content": "Hello"}
+				],
+				"response_format": {"type": "json_schema"}
+			}`,
+			err: ErrorResponse{
+				Error: Error{
+					Message: "response_format json_schema requires a schema",
+					Type:    "invalid_request_error",
+				},
+			},
+		},
 
 		{
 			name: "chat handler error forwarding",
@@ -283,6 +358,47 @@ func TestCompletionsMiddleware(t *testing.T) {
 				Stream: &False,
 			},
 		},
+		{
+			name: "completions handler with logprobs",
+			body: `{
+				"model": "test-model",
+				"prompt": "Hello",
+				"logprobs": 2
+			}`,
+			req: api.GenerateRequest{
+				Model:  "test-model",
+				Prompt: "Hello",
+				Options: map[string]any{
+					"frequency_penalty": 0.0,
+					"presence_penalty":  0.0,
+					"temperature":       1.0,
+					"top_p":             1.0,
+				},
+				Stream:      &False,
+				Logprobs:    true,
+				TopLogprobs: 2,
+			},
+		},
+		{
+			name: "completions handler with n",
+			body: `{
+				"model": "test-model",
+				"prompt": "Hello",
+				"n": 3
+			}`,
+			req: api.GenerateRequest{
+				Model:  "test-model",
+				Prompt: "Hello",
+				Options: map[string]any{
+					"frequency_penalty": 0.0,
+					"presence_penalty":  0.0,
+					"temperature":       1.0,
+					"top_p":             1.0,
+				},
+				Stream: &False,
+				N:      3,
+			},
+		},
 		{
 			name: "completions handler error forwarding",
 			body: `{
@@ -371,6 +487,21 @@ func TestEmbeddingsMiddleware(t *testing.T) {
 				Model: "test-model",
 			},
 		},
+		{
+			name: "embed handler dimensions and encoding format",
+			body: `{
+				"input": "Hello",
+				"model": "test-model",
+				"dimensions": 256,
+				"encoding_format": "base64"
+			}`,
+			req: api.EmbedRequest{
+				Input:          "Hello",
+				Model:          "test-model",
+				Dimensions:     256,
+				EncodingFormat: "base64",
+			},
+		},
 		{
 			name: "embed handler error forwarding",
 			body: `{
@@ -423,6 +554,8 @@ func TestEmbeddingsMiddleware(t *testing.T) {
 }
 
 func TestListMiddleware(t *testing.T) {
+	weight := 3
+
 	type testCase struct {
 		name     string
 		endpoint func(c *gin.Context)
@@ -454,6 +587,34 @@ func TestListMiddleware(t *testing.T) {
 				]
 			}`,
 		},
+		{
+			name: "list handler alias",
+			endpoint: func(c *gin.Context) {
+				c.JSON(http.StatusOK, api.ListResponse{
+					Models: []api.ListModelResponse{
+						{
+							Name:       "gpt-4o",
+							ModifiedAt: time.Unix(int64(1686935002), 0).UTC(),
+							Targets:    []api.AliasTarget{{Model: "llama3.2", Weight: &weight}, {Model: "qwen2.5"}},
+							Fallbacks:  []string{"llama3.2:1b"},
+						},
+					},
+				})
+			},
+			resp: `{
+				"object": "list",
+				"data": [
+					{
+						"id": "gpt-4o",
+						"object": "model",
+						"created": 1686935002,
+						"owned_by": "library",
+						"targets": [{"model": "llama3.2", "weight": 3}, {"model": "qwen2.5"}],
+						"fallbacks": ["llama3.2:1b"]
+					}
+				]
+			}`,
+		},
 		{
 			name: "list handler empty output",
 			endpoint: func(c *gin.Context) {
@@ -559,3 +720,152 @@ func TestRetrieveMiddleware(t *testing.T) {
 		}
 	}
 }
+
+func TestLogprobsResponses(t *testing.T) {
+	logprobs := []api.TokenLogprob{
+		{Token: "Hi", Logprob: -0.1, TopLogprobs: []api.TokenLogprob{{Token: "Hi", Logprob: -0.1}, {Token: "Hello", Logprob: -2.5}}},
+		{Token: "!", Logprob: -0.5, TopLogprobs: []api.TokenLogprob{{Token: "!", Logprob: -0.5}}},
+	}
+
+	t.Run("chat completion", func(t *testing.T) {
+		c := toChatCompletion("id", api.ChatResponse{
+			Message:  api.Message{Role: "assistant", Content: "Hi!"},
+			Logprobs: logprobs,
+		})
+
+		expect := &ChoiceLogprobs{Content: []ChatLogprob{
+			{Token: "Hi", Logprob: -0.1, Bytes: []int{72, 105}, TopLogprobs: []TopLogprob{{Token: "Hi", Logprob: -0.1, Bytes: []int{72, 105}}, {Token: "Hello", Logprob: -2.5, Bytes: []int{72, 101, 108, 108, 111}}}},
+			{Token: "!", Logprob: -0.5, Bytes: []int{33}, TopLogprobs: []TopLogprob{{Token: "!", Logprob: -0.5, Bytes: []int{33}}}},
+		}}
+
+		if !reflect.DeepEqual(expect, c.Choices[0].Logprobs) {
+			t.Errorf("expected %+v, got %+v", expect, c.Choices[0].Logprobs)
+		}
+	})
+
+	t.Run("chat chunk", func(t *testing.T) {
+		c := toChunk("id", api.ChatResponse{Message: api.Message{Role: "assistant", Content: "!"}, Logprobs: logprobs[1:]})
+		if c.Choices[0].Logprobs == nil || len(c.Choices[0].Logprobs.Content) != 1 || c.Choices[0].Logprobs.Content[0].Token != "!" {
+			t.Errorf("unexpected logprobs %+v", c.Choices[0].Logprobs)
+		}
+	})
+
+	t.Run("chat completion without logprobs", func(t *testing.T) {
+		c := toChatCompletion("id", api.ChatResponse{Message: api.Message{Role: "assistant", Content: "Hi!"}})
+		if c.Choices[0].Logprobs != nil {
+			t.Errorf("expected no logprobs, got %+v", c.Choices[0].Logprobs)
+		}
+
+		b, err := json.Marshal(c)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if strings.Contains(string(b), "logprobs") {
+			t.Errorf("expected logprobs to be omitted, got %s", b)
+		}
+	})
+
+	t.Run("completion", func(t *testing.T) {
+		c := toCompletion("id", api.GenerateResponse{Response: "Hi!", Logprobs: logprobs})
+
+		expect := &CompletionLogprobs{
+			Tokens:        []string{"Hi", "!"},
+			TokenLogprobs: []float64{-0.1, -0.5},
+			TopLogprobs:   []map[string]float64{{"Hi": -0.1, "Hello": -2.5}, {"!": -0.5}},
+			TextOffset:    []int{0, 2},
+		}
+
+		if !reflect.DeepEqual(expect, c.Choices[0].Logprobs) {
+			t.Errorf("expected %+v, got %+v", expect, c.Choices[0].Logprobs)
+		}
+	})
+
+	t.Run("completion chunk offset", func(t *testing.T) {
+		c := toCompleteChunk("id", api.GenerateResponse{Response: "!", Logprobs: logprobs[1:]}, 2)
+		if c.Choices[0].Logprobs == nil || !reflect.DeepEqual([]int{2}, c.Choices[0].Logprobs.TextOffset) {
+			t.Errorf("unexpected logprobs %+v", c.Choices[0].Logprobs)
+		}
+	})
+}
+
+func TestMultipleChoices(t *testing.T) {
+	t.Run("chat completion", func(t *testing.T) {
+		c := toChatCompletion("id", api.ChatResponse{
+			Message: api.Message{Role: "assistant", Content: "Hi!"},
+			Metrics: api.Metrics{PromptEvalCount: 5, EvalCount: 2},
+			Choices: []api.ChatResponse{
+				{Message: api.Message{Role: "assistant", Content: "Hi!"}, DoneReason: "stop", Metrics: api.Metrics{PromptEvalCount: 5, EvalCount: 2}},
+				{Index: 1, Message: api.Message{Role: "assistant", Content: "Hello there"}, DoneReason: "length", Metrics: api.Metrics{PromptEvalCount: 5, EvalCount: 3}},
+			},
+		})
+
+		if len(c.Choices) != 2 {
+			t.Fatalf("expected 2 choices, got %d", len(c.Choices))
+		}
+
+		if c.Choices[1].Index != 1 || c.Choices[1].Message.Content != "Hello there" || *c.Choices[1].FinishReason != "length" {
+			t.Errorf("unexpected choice %+v", c.Choices[1])
+		}
+
+		if expect := (Usage{PromptTokens: 5, CompletionTokens: 5, TotalTokens: 10}); c.Usage != expect {
+			t.Errorf("expected usage %+v, got %+v", expect, c.Usage)
+		}
+	})
+
+	t.Run("completion", func(t *testing.T) {
+		c := toCompletion("id", api.GenerateResponse{
+			Response: "a",
+			Choices:  []api.GenerateResponse{{Response: "a"}, {Index: 1, Response: "b"}},
+		})
+
+		if len(c.Choices) != 2 || c.Choices[0].Text != "a" || c.Choices[1].Index != 1 || c.Choices[1].Text != "b" {
+			t.Errorf("unexpected choices %+v", c.Choices)
+		}
+	})
+
+	t.Run("chat chunks", func(t *testing.T) {
+		gin.SetMode(gin.TestMode)
+		router := gin.New()
+		router.Use(ChatMiddleware())
+		router.Handle(http.MethodPost, "/api/chat", func(c *gin.Context) {
+			for i := range 2 {
+				d, _ := json.Marshal(api.ChatResponse{Index: i, Message: api.Message{Role: "assistant", Content: "Hi"}, Done: true, DoneReason: "stop"})
+				c.Writer.Write(d)
+			}
+		})
+
+		body := `{"model": "test-model", "messages": [{"role": "user", "content": "Hello"}], "stream": true, "n": 2}`
+		req, _ := http.NewRequest(http.MethodPost, "/api/chat", strings.NewReader(body))
+		resp := httptest.NewRecorder()
+		router.ServeHTTP(resp, req)
+
+		var indexes []int
+		var done int
+		for _, line := range strings.Split(resp.Body.String(), "\n\n") {
+			data, ok := strings.CutPrefix(line, "data: ")
+			if !ok {
+				continue
+			}
+
+			if data == "[DONE]" {
+				done++
+				continue
+			}
+
+			var chunk ChatCompletionChunk
+			if err := json.Unmarshal([]byte(data), &chunk); err != nil {
+				t.Fatal(err)
+			}
+			indexes = append(indexes, chunk.Choices[0].Index)
+		}
+
+		if !reflect.DeepEqual([]int{0, 1}, indexes) {
+			t.Errorf("expected chunks for choices 0 and 1, got %v", indexes)
+		}
+
+		if done != 1 {
+			t.Errorf("expected a single [DONE], got %d", done)
+		}
+	})
+}
@@ -0,0 +1,413 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math/rand/v2"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+var errAliasNotFound = errors.New("alias not found")
+
+// aliasStore keeps the aliases of models in a JSON file. Requests for an
+// alias are split between its targets by weight, and fall back to its
+// fallbacks in order when the chosen target fails to load.
+type aliasStore struct {
+	path string
+
+	mu      sync.Mutex
+	aliases map[string]api.Alias
+}
+
+func newAliasStore(path string) (*aliasStore, error) {
+	as := &aliasStore{path: path, aliases: make(map[string]api.Alias)}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return as, nil
+	} else if err != nil {
+		return nil, err
+	}
+
+	var aliases []api.Alias
+	if err := json.Unmarshal(b, &aliases); err != nil {
+		return nil, fmt.Errorf("%s: %w", path, err)
+	}
+
+	for _, a := range aliases {
+		as.aliases[aliasKey(a.Name)] = a
+	}
+
+	return as, nil
+}
+
+// aliasKey is the name an alias is looked up by, so that "name" and
+// "name:latest" are the same alias
+func aliasKey(name string) string {
+	return strings.ToLower(model.ParseName(name).String())
+}
+
+// save writes the aliases to the file atomically. It must be called with mu
+// held.
+func (as *aliasStore) save() error {
+	aliases := make([]api.Alias, 0, len(as.aliases))
+	for _, a := range as.aliases {
+		aliases = append(aliases, a)
+	}
+
+	slices.SortFunc(aliases, func(a, b api.Alias) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	b, err := json.MarshalIndent(aliases, "", "  ")
+	if err != nil {
+		return err
+	}
+
+	if err := os.MkdirAll(filepath.Dir(as.path), 0o755); err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(filepath.Dir(as.path), "aliases-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), as.path)
+}
+
+// set creates or replaces an alias
+func (as *aliasStore) set(a api.Alias) (api.Alias, error) {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	key := aliasKey(a.Name)
+
+	// an alias can't hide an installed model
+	if _, err := ParseNamedManifest(model.ParseName(a.Name)); err == nil {
+		return api.Alias{}, fmt.Errorf("%q is a model", a.Name)
+	}
+
+	// aliases resolve to models, not to other aliases
+	for _, other := range as.aliases {
+		if aliasKey(other.Name) == key {
+			continue
+		}
+
+		for _, t := range other.Targets {
+			if aliasKey(t.Model) == key {
+				return api.Alias{}, fmt.Errorf("%q is a target of alias %q", a.Name, other.Name)
+			}
+		}
+
+		for _, f := range other.Fallbacks {
+			if aliasKey(f) == key {
+				return api.Alias{}, fmt.Errorf("%q is a fallback of alias %q", a.Name, other.Name)
+			}
+		}
+	}
+
+	names := slices.Clone(a.Fallbacks)
+	for _, t := range a.Targets {
+		names = append(names, t.Model)
+	}
+
+	for _, name := range names {
+		if _, ok := as.aliases[aliasKey(name)]; ok || aliasKey(name) == key {
+			return api.Alias{}, fmt.Errorf("%q is an alias", name)
+		}
+	}
+
+	prev, existed := as.aliases[key]
+
+	now := time.Now().UTC()
+	a.CreatedAt, a.UpdatedAt = now, now
+	if existed {
+		a.CreatedAt = prev.CreatedAt
+	}
+
+	as.aliases[key] = a
+	if err := as.save(); err != nil {
+		if existed {
+			as.aliases[key] = prev
+		} else {
+			delete(as.aliases, key)
+		}
+		return api.Alias{}, err
+	}
+
+	return a, nil
+}
+
+func (as *aliasStore) delete(name string) error {
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	key := aliasKey(name)
+	prev, ok := as.aliases[key]
+	if !ok {
+		return errAliasNotFound
+	}
+
+	delete(as.aliases, key)
+	if err := as.save(); err != nil {
+		as.aliases[key] = prev
+		return err
+	}
+
+	return nil
+}
+
+// list returns every alias sorted by name
+func (as *aliasStore) list() []api.Alias {
+	if as == nil {
+		return nil
+	}
+
+	as.mu.Lock()
+	defer as.mu.Unlock()
+
+	aliases := make([]api.Alias, 0, len(as.aliases))
+	for _, a := range as.aliases {
+		aliases = append(aliases, a)
+	}
+
+	slices.SortFunc(aliases, func(a, b api.Alias) int {
+		return strings.Compare(a.Name, b.Name)
+	})
+
+	return aliases
+}
+
+// resolve returns the model a request for name is sent to and the models to
+// fall back to, or name itself if it isn't an alias
+func (as *aliasStore) resolve(name string) (string, []string) {
+	if as == nil {
+		return name, nil
+	}
+
+	as.mu.Lock()
+	a, ok := as.aliases[aliasKey(name)]
+	as.mu.Unlock()
+
+	if !ok || len(a.Targets) == 0 {
+		return name, nil
+	}
+
+	var total int
+	for _, t := range a.Targets {
+		total += targetWeight(t)
+	}
+
+	n := rand.IntN(total)
+	for _, t := range a.Targets {
+		if n -= targetWeight(t); n < 0 {
+			return t.Model, a.Fallbacks
+		}
+	}
+
+	return a.Targets[len(a.Targets)-1].Model, a.Fallbacks
+}
+
+// models returns the targets and fallbacks of alias name, or name itself if
+// it isn't an alias
+func (as *aliasStore) models(name string) []string {
+	if as == nil {
+		return []string{name}
+	}
+
+	as.mu.Lock()
+	a, ok := as.aliases[aliasKey(name)]
+	as.mu.Unlock()
+
+	if !ok {
+		return []string{name}
+	}
+
+	var models []string
+	for _, t := range a.Targets {
+		models = append(models, t.Model)
+	}
+
+	return append(models, a.Fallbacks...)
+}
+
+// resolvesTo reports whether name is an alias with m as a target or fallback
+func (as *aliasStore) resolvesTo(name, m string) bool {
+	if as == nil {
+		return false
+	}
+
+	as.mu.Lock()
+	a, ok := as.aliases[aliasKey(name)]
+	as.mu.Unlock()
+
+	if !ok {
+		return false
+	}
+
+	return slices.ContainsFunc(a.Targets, func(t api.AliasTarget) bool { return sameModel(t.Model, m) }) ||
+		slices.ContainsFunc(a.Fallbacks, func(f string) bool { return sameModel(f, m) })
+}
+
+// targetWeight returns the weight of t, which defaults to 1
+func targetWeight(t api.AliasTarget) int {
+	if t.Weight == nil {
+		return 1
+	}
+
+	return *t.Weight
+}
+
+type aliasFallbacksContextKey struct{}
+
+// resolveModel returns the model a request for name is sent to, keeping the
+// alias's fallbacks in the request's context for scheduleRunner
+func (s *Server) resolveModel(c *gin.Context, name string) string {
+	target, fallbacks := s.aliases.resolve(name)
+	if target != name {
+		slog.Debug("resolved alias", "alias", name, "model", target)
+	}
+
+	if len(fallbacks) > 0 {
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), aliasFallbacksContextKey{}, fallbacks))
+	}
+
+	return target
+}
+
+func aliasFallbacksFromContext(ctx context.Context) []string {
+	fallbacks, _ := ctx.Value(aliasFallbacksContextKey{}).([]string)
+	return fallbacks
+}
+
+// getModel returns the model name returned by resolveModel, or the first of
+// the alias's fallbacks which is installed if name isn't, along with its name
+func getModel(ctx context.Context, name string) (*Model, string, error) {
+	m, err := GetModel(name)
+	if err == nil || !os.IsNotExist(err) {
+		return m, name, err
+	}
+
+	for _, fallback := range aliasFallbacksFromContext(ctx) {
+		if m, err := GetModel(fallback); err == nil {
+			slog.Warn("model not found, using fallback", "model", name, "fallback", fallback)
+			return m, fallback, nil
+		}
+	}
+
+	return nil, name, err
+}
+
+// fallbackable reports whether a request whose model failed to schedule with
+// err can be sent to a fallback model
+func fallbackable(ctx context.Context, err error) bool {
+	return ctx.Err() == nil && !errors.Is(err, errRequired) && !errors.Is(err, ErrMaxQueue)
+}
+
+func (s *Server) CreateAliasHandler(c *gin.Context) {
+	var req api.CreateAliasRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Model != "" {
+		req.Targets = append([]api.AliasTarget{{Model: req.Model}}, req.Targets...)
+	}
+
+	switch {
+	case req.Name == "":
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	case !model.ParseName(req.Name).IsValid():
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid alias name %q", req.Name)})
+		return
+	case len(req.Targets) == 0:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model or targets is required"})
+		return
+	}
+
+	for _, t := range req.Targets {
+		if !model.ParseName(t.Model).IsValid() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid target %q", t.Model)})
+			return
+		} else if t.Weight != nil && *t.Weight <= 0 {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "weight must be positive"})
+			return
+		}
+	}
+
+	for _, f := range req.Fallbacks {
+		if !model.ParseName(f).IsValid() {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid fallback %q", f)})
+			return
+		}
+	}
+
+	a, err := s.aliases.set(api.Alias{Name: req.Name, Targets: req.Targets, Fallbacks: req.Fallbacks})
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, a)
+}
+
+func (s *Server) ListAliasesHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, api.ListAliasesResponse{Aliases: s.aliases.list()})
+}
+
+func (s *Server) DeleteAliasHandler(c *gin.Context) {
+	var req api.DeleteAliasRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Name == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	}
+
+	if err := s.aliases.delete(req.Name); errors.Is(err, errAliasNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("alias %q not found", req.Name)})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,261 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestAliasStore(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "aliases.json")
+
+	as, err := newAliasStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	weight := 3
+	if _, err := as.set(api.Alias{
+		Name:      "gpt-4o",
+		Targets:   []api.AliasTarget{{Model: "llama3.2", Weight: &weight}, {Model: "qwen2.5"}},
+		Fallbacks: []string{"llama3.2:1b"},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	// aliases can't resolve to other aliases
+	if _, err := as.set(api.Alias{Name: "chat", Targets: []api.AliasTarget{{Model: "gpt-4o:latest"}}}); err == nil {
+		t.Error("expected an error for a target which is an alias")
+	}
+
+	if _, err := as.set(api.Alias{Name: "llama3.2", Targets: []api.AliasTarget{{Model: "mistral"}}}); err == nil {
+		t.Error("expected an error for an alias which is a target")
+	}
+
+	as, err = newAliasStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	counts := make(map[string]int)
+	for range 4000 {
+		model, fallbacks := as.resolve("GPT-4o")
+		if len(fallbacks) != 1 || fallbacks[0] != "llama3.2:1b" {
+			t.Fatalf("unexpected fallbacks %v", fallbacks)
+		}
+		counts[model]++
+	}
+
+	if counts["llama3.2"] < 2700 || counts["llama3.2"] > 3300 || counts["llama3.2"]+counts["qwen2.5"] != 4000 {
+		t.Errorf("expected a 3:1 split, got %v", counts)
+	}
+
+	if model, fallbacks := as.resolve("llama3.2"); model != "llama3.2" || fallbacks != nil {
+		t.Errorf("expected a model to resolve to itself, got %q %v", model, fallbacks)
+	}
+
+	if err := as.delete("gpt-4o"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := as.delete("gpt-4o"); err != errAliasNotFound {
+		t.Errorf("expected errAliasNotFound, got %v", err)
+	}
+
+	as, err = newAliasStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if aliases := as.list(); len(aliases) != 0 {
+		t.Errorf("expected no aliases, got %v", aliases)
+	}
+}
+
+func TestAliases(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var mock mockRunner
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, ggml *llm.GGML, gpus discover.GpuInfoList, numParallel int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(context.TODO())
+
+	var err error
+	s.aliases, err = newAliasStore(filepath.Join(t.TempDir(), "aliases.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model: "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, llm.KV{
+			"general.architecture": "bert",
+			"bert.pooling_type":    uint32(1),
+			"bert.context_length":  uint32(512),
+		}, []llm.Tensor{})),
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	embed := func(t *testing.T, model string) *api.EmbedResponse {
+		t.Helper()
+
+		w := createRequest(t, s.EmbedHandler, api.EmbedRequest{Model: model, Input: "hello"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.EmbedResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return &resp
+	}
+
+	t.Run("create", func(t *testing.T) {
+		w := createRequest(t, s.CreateAliasHandler, api.CreateAliasRequest{Name: "text-embedding-3-small", Model: "test"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		negative, zero := -1, 0
+		for _, req := range []api.CreateAliasRequest{
+			{Model: "test"},
+			{Name: "empty"},
+			{Name: "negative", Targets: []api.AliasTarget{{Model: "test", Weight: &negative}}},
+			{Name: "zero", Targets: []api.AliasTarget{{Model: "test", Weight: &zero}}},
+			{Name: "chain", Model: "text-embedding-3-small"},
+			{Name: "test", Model: "missing"},
+		} {
+			w := createRequest(t, s.CreateAliasHandler, req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400 for %+v, got %d", req, w.Code)
+			}
+		}
+	})
+
+	t.Run("resolve", func(t *testing.T) {
+		if resp := embed(t, "text-embedding-3-small"); resp.Model != "test" || len(resp.Embeddings) != 1 {
+			t.Errorf("unexpected response %+v", resp)
+		}
+	})
+
+	t.Run("fallback", func(t *testing.T) {
+		w := createRequest(t, s.CreateAliasHandler, api.CreateAliasRequest{
+			Name:      "flaky",
+			Model:     "missing",
+			Fallbacks: []string{"also-missing", "test"},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		if resp := embed(t, "flaky"); len(resp.Embeddings) != 1 {
+			t.Errorf("unexpected response %+v", resp)
+		}
+
+		// requests checking the model before scheduling fall back too, and
+		// a session stays on the model it was created with
+		sessions, err := newSessionStore(t.TempDir(), time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.sessions = sessions
+		defer func() { s.sessions = nil }()
+
+		w = createRequest(t, s.CreateSessionHandler, api.CreateSessionRequest{Model: "flaky"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var session api.Session
+		if err := json.NewDecoder(w.Body).Decode(&session); err != nil {
+			t.Fatal(err)
+		}
+
+		if session.Model != "test" {
+			t.Errorf("expected the session to use test, got %q", session.Model)
+		}
+
+		w = createRequest(t, s.CreateAliasHandler, api.CreateAliasRequest{Name: "broken", Model: "missing"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		w = createRequest(t, s.EmbedHandler, api.EmbedRequest{Model: "broken", Input: "hello"})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		w := createRequest(t, s.ListHandler, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.ListResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		targets := make(map[string]string)
+		for _, m := range resp.Models {
+			if len(m.Targets) > 0 {
+				targets[m.Name] = m.Targets[0].Model
+			}
+		}
+
+		if len(targets) != 3 || targets["text-embedding-3-small"] != "test" || targets["flaky"] != "missing" {
+			t.Errorf("unexpected aliases %v", targets)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		w := createRequest(t, s.DeleteAliasHandler, api.DeleteAliasRequest{Name: "flaky"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		w = createRequest(t, s.DeleteAliasHandler, api.DeleteAliasRequest{Name: "flaky"})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+
+		w = createRequest(t, s.EmbedHandler, api.EmbedRequest{Model: "flaky", Input: "hello"})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+}
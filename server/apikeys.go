@@ -0,0 +1,178 @@
+package server
+
+import (
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/anthropic"
+	"github.com/ollama/ollama/llm"
+	"github.com/ollama/ollama/openai"
+)
+
+// apiKeyScope grants access to a group of routes
+type apiKeyScope string
+
+const (
+	// scopeAny is satisfied by any valid key. It's used for read only routes
+	// such as listing models.
+	scopeAny apiKeyScope = ""
+
+	scopeInference    apiKeyScope = "inference"
+	scopeEmbed        apiKeyScope = "embed"
+	scopeManageModels apiKeyScope = "manage-models"
+
+	// scopeAdmin grants every other scope
+	scopeAdmin apiKeyScope = "admin"
+)
+
+// apiKeyContextKey is the gin context key holding the name of the
+// authenticated key
+const apiKeyContextKey = "ollama.apiKey"
+
+// runAsContextKey is the request context key holding the name of the key an
+// internal request, such as one of a batch, is made on behalf of
+type runAsContextKey struct{}
+
+type apiKey struct {
+	Name   string        `json:"name"`
+	Key    string        `json:"key"`
+	Scopes []apiKeyScope `json:"scopes"`
+}
+
+func (k apiKey) allows(scope apiKeyScope) bool {
+	return scope == scopeAny || slices.Contains(k.Scopes, scopeAdmin) || slices.Contains(k.Scopes, scope)
+}
+
+// apiKeys is the set of keys accepted by the server, indexed by the SHA-256
+// of the key so lookups don't compare secrets byte by byte
+type apiKeys map[[sha256.Size]byte]apiKey
+
+// loadAPIKeys reads a JSON array of keys from path
+func loadAPIKeys(path string) (apiKeys, error) {
+	bts, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var keys []apiKey
+	if err := json.Unmarshal(bts, &keys); err != nil {
+		return nil, fmt.Errorf("parse api keys %s: %w", path, err)
+	}
+
+	m := make(apiKeys, len(keys))
+	for _, k := range keys {
+		if k.Name == "" || k.Key == "" {
+			return nil, errors.New("api keys must have a name and a key")
+		}
+
+		for _, scope := range k.Scopes {
+			switch scope {
+			case scopeInference, scopeEmbed, scopeManageModels, scopeAdmin:
+			default:
+				return nil, fmt.Errorf("api key %q: unknown scope %q", k.Name, scope)
+			}
+		}
+
+		sum := sha256.Sum256([]byte(k.Key))
+		if _, ok := m[sum]; ok {
+			return nil, fmt.Errorf("api key %q: duplicate key", k.Name)
+		}
+
+		m[sum] = k
+	}
+
+	return m, nil
+}
+
+func (keys apiKeys) lookup(token string) (apiKey, bool) {
+	k, ok := keys[sha256.Sum256([]byte(token))]
+	return k, ok
+}
+
+// named returns the key called name
+func (keys apiKeys) named(name string) (apiKey, bool) {
+	for _, k := range keys {
+		if k.Name == name {
+			return k, true
+		}
+	}
+
+	return apiKey{}, false
+}
+
+// abortWithError aborts the request with an error in the format expected by
+// the route: Anthropic compatible errors for /v1/messages, OpenAI compatible
+// errors for other /v1 routes and native errors otherwise
+func abortWithError(c *gin.Context, code int, message string) {
+	if c.FullPath() == "/v1/messages" {
+		c.AbortWithStatusJSON(code, anthropic.NewError(code, message))
+		return
+	}
+
+	if strings.HasPrefix(c.FullPath(), "/v1/") {
+		c.AbortWithStatusJSON(code, openai.NewError(code, message))
+		return
+	}
+
+	c.AbortWithStatusJSON(code, gin.H{"error": message})
+}
+
+// requireScope rejects requests which don't present a key with scope. It
+// allows every request if no keys are configured. Internal requests are
+// checked against the key they are made on behalf of.
+func (s *Server) requireScope(scope apiKeyScope) gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.apiKeys == nil {
+			c.Next()
+			return
+		}
+
+		var key apiKey
+		if name, ok := c.Request.Context().Value(runAsContextKey{}).(string); ok {
+			if key, ok = s.apiKeys.named(name); !ok {
+				abortWithError(c, http.StatusUnauthorized, fmt.Sprintf("api key %q no longer exists", name))
+				return
+			}
+		} else {
+			token, ok := strings.CutPrefix(c.GetHeader("Authorization"), "Bearer ")
+			if !ok {
+				// Anthropic clients send the key in their own header
+				token = c.GetHeader("X-Api-Key")
+			}
+
+			if token == "" {
+				c.Header("WWW-Authenticate", "Bearer")
+				abortWithError(c, http.StatusUnauthorized, "missing api key")
+				return
+			}
+
+			if key, ok = s.apiKeys.lookup(strings.TrimSpace(token)); !ok {
+				c.Header("WWW-Authenticate", "Bearer")
+				abortWithError(c, http.StatusUnauthorized, "invalid api key")
+				return
+			}
+		}
+
+		if !key.allows(scope) {
+			abortWithError(c, http.StatusForbidden, fmt.Sprintf("api key %q does not have the %q scope", key.Name, scope))
+			return
+		}
+
+		// only keys which manage models may jump the queue
+		if llm.PriorityFromContext(c.Request.Context()) == llm.PriorityHigh && !key.allows(scopeManageModels) {
+			abortWithError(c, http.StatusForbidden, fmt.Sprintf("api key %q does not have the %q scope required for high priority", key.Name, scopeManageModels))
+			return
+		}
+
+		c.Set(apiKeyContextKey, key.Name)
+		c.Next()
+	}
+}
@@ -0,0 +1,170 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadAPIKeys(t *testing.T) {
+	cases := []struct {
+		name    string
+		content string
+		err     string
+	}{
+		{"valid", `[{"name": "a", "key": "k1", "scopes": ["inference", "embed"]}, {"name": "b", "key": "k2", "scopes": ["admin"]}]`, ""},
+		{"invalid json", `{`, "parse api keys"},
+		{"missing key", `[{"name": "a", "scopes": ["inference"]}]`, "must have a name and a key"},
+		{"unknown scope", `[{"name": "a", "key": "k1", "scopes": ["root"]}]`, "unknown scope"},
+		{"duplicate", `[{"name": "a", "key": "k1"}, {"name": "b", "key": "k1"}]`, "duplicate key"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			p := filepath.Join(t.TempDir(), "keys.json")
+			if err := os.WriteFile(p, []byte(tt.content), 0o600); err != nil {
+				t.Fatal(err)
+			}
+
+			keys, err := loadAPIKeys(p)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error containing %q, got %v", tt.err, err)
+				}
+				return
+			}
+
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if len(keys) != 2 {
+				t.Fatalf("expected 2 keys, got %d", len(keys))
+			}
+
+			if k, ok := keys.lookup("k1"); !ok || k.Name != "a" {
+				t.Errorf("expected to find key a, got %v", k)
+			}
+		})
+	}
+}
+
+func TestRequireScope(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	p := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(p, []byte(`[
+		{"name": "team", "key": "inference-key", "scopes": ["inference"]},
+		{"name": "ops", "key": "admin-key", "scopes": ["admin"]}
+	]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := loadAPIKeys(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{apiKeys: keys}
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	cases := []struct {
+		name   string
+		method string
+		path   string
+		key    string
+		status int
+		error  string
+		// errorType is the type of the error of OpenAI and Anthropic
+		// compatible endpoints
+		errorType string
+	}{
+		{"heartbeat without key", http.MethodGet, "/", "", http.StatusOK, "", ""},
+		{"missing key", http.MethodGet, "/api/version", "", http.StatusUnauthorized, "missing api key", ""},
+		{"invalid key", http.MethodGet, "/api/version", "wrong", http.StatusUnauthorized, "invalid api key", ""},
+		{"any scope", http.MethodGet, "/api/version", "inference-key", http.StatusOK, "", ""},
+		{"missing scope", http.MethodDelete, "/api/delete", "inference-key", http.StatusForbidden, `api key "team" does not have the "manage-models" scope`, ""},
+		{"admin scope", http.MethodDelete, "/api/delete", "admin-key", http.StatusBadRequest, "", ""},
+		{"metrics requires admin", http.MethodGet, "/metrics", "inference-key", http.StatusForbidden, `api key "team" does not have the "admin" scope`, ""},
+		{"openai missing scope", http.MethodPost, "/v1/embeddings", "inference-key", http.StatusForbidden, `api key "team" does not have the "embed" scope`, "permission_error"},
+		{"anthropic missing key", http.MethodPost, "/v1/messages", "", http.StatusUnauthorized, "missing api key", "authentication_error"},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			req, err := http.NewRequest(tt.method, srv.URL+tt.path, nil)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if tt.key != "" {
+				req.Header.Set("Authorization", "Bearer "+tt.key)
+			}
+
+			resp, err := http.DefaultClient.Do(req)
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer resp.Body.Close()
+
+			if resp.StatusCode != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, resp.StatusCode)
+			}
+
+			if tt.error == "" {
+				return
+			}
+
+			if strings.HasPrefix(tt.path, "/v1/") {
+				var body struct {
+					Error struct {
+						Message string `json:"message"`
+						Type    string `json:"type"`
+					} `json:"error"`
+				}
+				if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+					t.Fatal(err)
+				}
+
+				if body.Error.Message != tt.error || body.Error.Type != tt.errorType {
+					t.Errorf("unexpected error %+v", body.Error)
+				}
+				return
+			}
+
+			var body struct {
+				Error string `json:"error"`
+			}
+			if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+				t.Fatal(err)
+			}
+
+			if body.Error != tt.error {
+				t.Errorf("expected error %q, got %q", tt.error, body.Error)
+			}
+		})
+	}
+
+	t.Run("x-api-key header", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/version", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("X-Api-Key", "inference-key")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", resp.StatusCode)
+		}
+	})
+}
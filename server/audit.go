@@ -0,0 +1,317 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/envconfig"
+)
+
+// auditLogBackups is the number of rotated audit logs kept alongside the
+// current one
+const auditLogBackups = 5
+
+// auditRecord is a single line of the audit log
+type auditRecord struct {
+	ID        string    `json:"id"`
+	Timestamp time.Time `json:"timestamp"`
+	Client    string    `json:"client"`
+	APIKey    string    `json:"api_key,omitempty"`
+	Method    string    `json:"method"`
+	Route     string    `json:"route"`
+	Status    int       `json:"status"`
+
+	Model   string       `json:"model,omitempty"`
+	Digest  string       `json:"digest,omitempty"`
+	Options *api.Options `json:"options,omitempty"`
+
+	PromptEvalCount    int           `json:"prompt_eval_count,omitempty"`
+	EvalCount          int           `json:"eval_count,omitempty"`
+	TotalDuration      time.Duration `json:"total_duration"`
+	LoadDuration       time.Duration `json:"load_duration,omitempty"`
+	PromptEvalDuration time.Duration `json:"prompt_eval_duration,omitempty"`
+	EvalDuration       time.Duration `json:"eval_duration,omitempty"`
+	DoneReason         string        `json:"done_reason,omitempty"`
+	Error              string        `json:"error,omitempty"`
+
+	// Prompt and Response are only recorded if OLLAMA_AUDIT_LOG_BODIES is set
+	Prompt   string `json:"prompt,omitempty"`
+	Response string `json:"response,omitempty"`
+
+	mu       sync.Mutex
+	bodies   bool
+	response strings.Builder
+}
+
+type auditContextKey struct{}
+
+// auditFromContext returns the audit record of the request or nil if
+// auditing is disabled. All auditRecord methods accept a nil receiver.
+func auditFromContext(ctx context.Context) *auditRecord {
+	rec, _ := ctx.Value(auditContextKey{}).(*auditRecord)
+	return rec
+}
+
+// setModel records the model serving the request and its options after
+// request options have been merged with the model's
+func (r *auditRecord) setModel(name string, m *Model, opts *api.Options) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Model = name
+	r.Digest = m.Digest
+	r.Options = opts
+}
+
+func (r *auditRecord) setMetrics(m api.Metrics, doneReason string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.PromptEvalCount = m.PromptEvalCount
+	r.EvalCount = m.EvalCount
+	r.LoadDuration = m.LoadDuration
+	r.PromptEvalDuration = m.PromptEvalDuration
+	r.EvalDuration = m.EvalDuration
+	r.DoneReason = doneReason
+}
+
+func (r *auditRecord) setError(msg string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Error = msg
+}
+
+func (r *auditRecord) setPrompt(prompt string) {
+	if r == nil || !r.bodies {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.Prompt = prompt
+}
+
+func (r *auditRecord) appendResponse(s string) {
+	if r == nil || !r.bodies {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.response.WriteString(s)
+}
+
+// auditLog writes audit records to a JSONL file, rotating it once it
+// exceeds maxSize bytes
+type auditLog struct {
+	path    string
+	maxSize int64
+	bodies  bool
+
+	mu   sync.Mutex
+	f    *os.File
+	size int64
+}
+
+func openAuditLog(path string, maxSize int64, bodies bool) (*auditLog, error) {
+	l := &auditLog{path: path, maxSize: maxSize, bodies: bodies}
+	if err := l.open(); err != nil {
+		return nil, err
+	}
+
+	return l, nil
+}
+
+// open opens the log file for appending. The caller must hold l.mu unless
+// the log is not yet shared.
+func (l *auditLog) open() error {
+	f, err := os.OpenFile(l.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0o600)
+	if err != nil {
+		return err
+	}
+
+	fi, err := f.Stat()
+	if err != nil {
+		f.Close()
+		return err
+	}
+
+	l.f = f
+	l.size = fi.Size()
+	return nil
+}
+
+// rotate renames the current log to path.1, shifting older logs up and
+// dropping the oldest. The caller must hold l.mu.
+func (l *auditLog) rotate() error {
+	if err := l.f.Close(); err != nil {
+		return err
+	}
+
+	for i := auditLogBackups - 1; i > 0; i-- {
+		if err := os.Rename(fmt.Sprintf("%s.%d", l.path, i), fmt.Sprintf("%s.%d", l.path, i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+
+	if err := os.Rename(l.path, l.path+".1"); err != nil {
+		return err
+	}
+
+	return l.open()
+}
+
+func (l *auditLog) write(r *auditRecord) error {
+	r.mu.Lock()
+	r.Response = r.response.String()
+	bts, err := json.Marshal(r)
+	r.mu.Unlock()
+	if err != nil {
+		return err
+	}
+
+	bts = append(bts, '\n')
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	if l.maxSize > 0 && l.size > 0 && l.size+int64(len(bts)) > l.maxSize {
+		if err := l.rotate(); err != nil {
+			return err
+		}
+	}
+
+	n, err := l.f.Write(bts)
+	l.size += int64(n)
+	return err
+}
+
+func (l *auditLog) Close() error {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	return l.f.Close()
+}
+
+// auditWriter captures error responses so their message can be recorded
+type auditWriter struct {
+	gin.ResponseWriter
+	body bytes.Buffer
+}
+
+// maxAuditErrorSize bounds how much of an error response is captured
+const maxAuditErrorSize = 4096
+
+func (w *auditWriter) capture(b []byte) {
+	if w.Status() >= http.StatusBadRequest && w.body.Len() < maxAuditErrorSize {
+		w.body.Write(b[:min(len(b), maxAuditErrorSize-w.body.Len())])
+	}
+}
+
+func (w *auditWriter) Write(b []byte) (int, error) {
+	w.capture(b)
+	return w.ResponseWriter.Write(b)
+}
+
+func (w *auditWriter) WriteString(s string) (int, error) {
+	w.capture([]byte(s))
+	return w.ResponseWriter.WriteString(s)
+}
+
+// errorMessage extracts the message from native and OpenAI error responses
+func (w *auditWriter) errorMessage() string {
+	var native struct {
+		Error json.RawMessage `json:"error"`
+	}
+	if err := json.Unmarshal(w.body.Bytes(), &native); err != nil || len(native.Error) == 0 {
+		return strings.TrimSpace(w.body.String())
+	}
+
+	var msg string
+	if err := json.Unmarshal(native.Error, &msg); err == nil {
+		return msg
+	}
+
+	var openai struct {
+		Message string `json:"message"`
+	}
+	if err := json.Unmarshal(native.Error, &openai); err == nil {
+		return openai.Message
+	}
+
+	return string(native.Error)
+}
+
+// auditMiddleware assigns each request an ID and writes an audit record
+// once it completes
+func (s *Server) auditMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.audit == nil {
+			c.Next()
+			return
+		}
+
+		start := time.Now()
+		rec := &auditRecord{
+			ID:        uuid.New().String(),
+			Timestamp: start.UTC(),
+			Client:    c.ClientIP(),
+			Method:    c.Request.Method,
+			Route:     c.Request.URL.Path,
+			bodies:    s.audit.bodies,
+		}
+
+		c.Header("X-Request-Id", rec.ID)
+		c.Request = c.Request.WithContext(context.WithValue(c.Request.Context(), auditContextKey{}, rec))
+
+		w := &auditWriter{ResponseWriter: c.Writer}
+		c.Writer = w
+
+		c.Next()
+
+		rec.mu.Lock()
+		rec.APIKey = c.GetString(apiKeyContextKey)
+		rec.Status = c.Writer.Status()
+		rec.TotalDuration = time.Since(start)
+		if rec.Error == "" && rec.Status >= http.StatusBadRequest {
+			rec.Error = w.errorMessage()
+		}
+		rec.mu.Unlock()
+
+		if err := s.audit.write(rec); err != nil {
+			slog.Warn("failed to write audit log", "error", err)
+		}
+	}
+}
+
+// newAuditLog opens the audit log configured in the environment or returns
+// nil if auditing is disabled
+func newAuditLog() (*auditLog, error) {
+	path := envconfig.AuditLog()
+	if path == "" {
+		return nil, nil
+	}
+
+	return openAuditLog(path, int64(envconfig.AuditLogMaxSize()), envconfig.AuditLogBodies())
+}
@@ -0,0 +1,162 @@
+package server
+
+import (
+	"bufio"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func readAuditLog(t *testing.T, path string) []map[string]any {
+	t.Helper()
+
+	f, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	var records []map[string]any
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var m map[string]any
+		if err := json.Unmarshal(scanner.Bytes(), &m); err != nil {
+			t.Fatal(err)
+		}
+		records = append(records, m)
+	}
+
+	if err := scanner.Err(); err != nil {
+		t.Fatal(err)
+	}
+
+	return records
+}
+
+func TestAuditMiddleware(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := openAuditLog(path, 0, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+
+	s := &Server{audit: audit}
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	resp, err := http.Get(srv.URL + "/api/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.Header.Get("X-Request-Id") == "" {
+		t.Error("expected X-Request-Id header")
+	}
+
+	resp, err = http.Post(srv.URL+"/api/generate", "application/json", strings.NewReader(`{"model": ""}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Post(srv.URL+"/v1/chat/completions", "application/json", strings.NewReader(`{"model": "test"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	records := readAuditLog(t, path)
+	if len(records) != 3 {
+		t.Fatalf("expected 3 records, got %d", len(records))
+	}
+
+	if records[0]["route"] != "/api/version" || records[0]["status"] != float64(http.StatusOK) || records[0]["error"] != nil {
+		t.Errorf("unexpected record %v", records[0])
+	}
+
+	if records[0]["id"] == "" || records[0]["client"] == "" {
+		t.Errorf("expected id and client, got %v", records[0])
+	}
+
+	if records[1]["route"] != "/api/generate" || records[1]["status"] != float64(http.StatusNotFound) || records[1]["error"] != "model '' not found" {
+		t.Errorf("unexpected record %v", records[1])
+	}
+
+	if records[2]["route"] != "/v1/chat/completions" || records[2]["error"] != "[] is too short - 'messages'" {
+		t.Errorf("unexpected record %v", records[2])
+	}
+}
+
+func TestAuditRecordBodies(t *testing.T) {
+	cases := []struct {
+		bodies bool
+		expect string
+	}{
+		{false, ""},
+		{true, "hello world"},
+	}
+
+	for _, tt := range cases {
+		path := filepath.Join(t.TempDir(), "audit.jsonl")
+		audit, err := openAuditLog(path, 0, tt.bodies)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rec := &auditRecord{ID: "1", bodies: tt.bodies}
+		rec.setPrompt("hi")
+		rec.appendResponse("hello ")
+		rec.appendResponse("world")
+		if err := audit.write(rec); err != nil {
+			t.Fatal(err)
+		}
+		audit.Close()
+
+		records := readAuditLog(t, path)
+		if response, _ := records[0]["response"].(string); response != tt.expect {
+			t.Errorf("bodies=%t: expected response %q, got %q", tt.bodies, tt.expect, response)
+		}
+
+		if _, ok := records[0]["prompt"]; ok != tt.bodies {
+			t.Errorf("bodies=%t: unexpected prompt %v", tt.bodies, records[0]["prompt"])
+		}
+	}
+}
+
+func TestAuditLogRotate(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "audit.jsonl")
+	audit, err := openAuditLog(path, 200, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer audit.Close()
+
+	for range 20 {
+		if err := audit.write(&auditRecord{ID: "1", Route: "/api/chat"}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	for _, p := range []string{path, path + ".1", path + ".5"} {
+		fi, err := os.Stat(p)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if fi.Size() > 200 {
+			t.Errorf("expected %s to be rotated at 200 bytes, got %d", p, fi.Size())
+		}
+	}
+
+	if _, err := os.Stat(path + ".6"); !os.IsNotExist(err) {
+		t.Errorf("expected at most %d backups", auditLogBackups)
+	}
+}
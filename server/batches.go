@@ -0,0 +1,1000 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"slices"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/openai"
+)
+
+const (
+	batchValidating = "validating"
+	batchFailed     = "failed"
+	batchInProgress = "in_progress"
+	batchFinalizing = "finalizing"
+	batchCompleted  = "completed"
+	batchCancelling = "cancelling"
+	batchCancelled  = "cancelled"
+	batchExpired    = "expired"
+)
+
+// batchEndpoints are the endpoints batches may call
+var batchEndpoints = []string{"/v1/chat/completions", "/v1/completions", "/v1/embeddings"}
+
+// batchScopes are the scopes required to call each of batchEndpoints
+var batchScopes = map[string]apiKeyScope{
+	"/v1/chat/completions": scopeInference,
+	"/v1/completions":      scopeInference,
+	"/v1/embeddings":       scopeEmbed,
+}
+
+// maxBatchErrors is the most validation errors reported for a batch
+const maxBatchErrors = 100
+
+var errBatchNotFound = errors.New("batch not found")
+
+type batchRequestCounts struct {
+	Total     int `json:"total"`
+	Completed int `json:"completed"`
+	Failed    int `json:"failed"`
+}
+
+type batchError struct {
+	Code    string `json:"code"`
+	Message string `json:"message"`
+	Line    *int   `json:"line"`
+}
+
+type batchErrors struct {
+	Object string       `json:"object"`
+	Data   []batchError `json:"data"`
+}
+
+// batch is an OpenAI compatible batch
+type batch struct {
+	ID               string             `json:"id"`
+	Object           string             `json:"object"`
+	Endpoint         string             `json:"endpoint"`
+	Errors           *batchErrors       `json:"errors"`
+	InputFileID      string             `json:"input_file_id"`
+	CompletionWindow string             `json:"completion_window"`
+	Status           string             `json:"status"`
+	OutputFileID     *string            `json:"output_file_id"`
+	ErrorFileID      *string            `json:"error_file_id"`
+	CreatedAt        int64              `json:"created_at"`
+	InProgressAt     *int64             `json:"in_progress_at"`
+	ExpiresAt        *int64             `json:"expires_at"`
+	FinalizingAt     *int64             `json:"finalizing_at"`
+	CompletedAt      *int64             `json:"completed_at"`
+	FailedAt         *int64             `json:"failed_at"`
+	ExpiredAt        *int64             `json:"expired_at"`
+	CancellingAt     *int64             `json:"cancelling_at"`
+	CancelledAt      *int64             `json:"cancelled_at"`
+	RequestCounts    batchRequestCounts `json:"request_counts"`
+	Metadata         map[string]string  `json:"metadata"`
+
+	// apiKey is the name of the key which created the batch. Only requests
+	// made with it can access the batch, and its files are owned by it.
+	apiKey string
+
+	// client is the address the batch was created from. Requests of the
+	// batch are made as if from apiKey and client.
+	client string
+}
+
+// expired reports whether the completion window of bt has ended by now
+func (bt *batch) expired(now time.Time) bool {
+	return bt.ExpiresAt != nil && now.Unix() >= *bt.ExpiresAt
+}
+
+// savedBatch is the state of a batch saved between restarts
+type savedBatch struct {
+	batch
+	APIKey string `json:"api_key,omitempty"`
+	Client string `json:"client,omitempty"`
+}
+
+// batchInput is a line of a batch input file
+type batchInput struct {
+	CustomID string          `json:"custom_id"`
+	Method   string          `json:"method"`
+	URL      string          `json:"url"`
+	Body     json.RawMessage `json:"body"`
+}
+
+type batchResponse struct {
+	StatusCode int             `json:"status_code"`
+	RequestID  string          `json:"request_id"`
+	Body       json.RawMessage `json:"body"`
+}
+
+// batchOutput is a line of a batch output or error file
+type batchOutput struct {
+	ID       string         `json:"id"`
+	CustomID string         `json:"custom_id"`
+	Response *batchResponse `json:"response"`
+	Error    *batchError    `json:"error"`
+}
+
+// batchQueue runs batches one request at a time so that they yield to
+// interactive requests. Batches and their partial results are saved in dir
+// so they resume after a restart.
+type batchQueue struct {
+	dir     string
+	files   *fileStore
+	handler http.Handler
+
+	mu      sync.Mutex
+	batches map[string]*batch
+	queue   []string
+	wake    chan struct{}
+
+	// cancel stops the request of the running batch
+	running string
+	cancel  context.CancelFunc
+}
+
+// newBatchQueue loads the batches saved in dir. handler must be set before
+// the queue is run.
+func newBatchQueue(dir string, files *fileStore) (*batchQueue, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	q := &batchQueue{
+		dir:     dir,
+		files:   files,
+		batches: make(map[string]*batch),
+		wake:    make(chan struct{}, 1),
+	}
+
+	matches, err := filepath.Glob(filepath.Join(dir, "batch_*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	for _, match := range matches {
+		b, err := os.ReadFile(match)
+		if err != nil {
+			return nil, err
+		}
+
+		var saved savedBatch
+		if err := json.Unmarshal(b, &saved); err != nil {
+			slog.Warn("skipping invalid batch", "path", match, "error", err)
+			continue
+		}
+
+		bt := saved.batch
+		bt.apiKey, bt.client = saved.APIKey, saved.Client
+		q.batches[bt.ID] = &bt
+	}
+
+	// resume unfinished batches in the order they were created
+	for _, bt := range q.sorted() {
+		switch bt.Status {
+		case batchValidating, batchInProgress, batchFinalizing, batchCancelling:
+			q.queue = append(q.queue, bt.ID)
+		}
+	}
+	slices.Reverse(q.queue)
+
+	if len(q.queue) > 0 {
+		slog.Info("resuming batches", "count", len(q.queue))
+	}
+
+	return q, nil
+}
+
+// batchRoutes returns the routes batches may call. Requests pass through the
+// same checks as when the key which created the batch calls them directly.
+func (s *Server) batchRoutes() http.Handler {
+	r := gin.New()
+	r.Use(
+		s.auditMiddleware(),
+		metricsMiddleware(),
+		priorityMiddleware(),
+	)
+
+	limit := s.rateLimit()
+	inference := s.requireScope(scopeInference)
+	embed := s.requireScope(scopeEmbed)
+	track := s.trackRequest()
+
+	r.POST("/v1/chat/completions", inference, limit, track, openai.ChatMiddleware(), s.ChatHandler)
+	r.POST("/v1/completions", inference, limit, track, openai.CompletionsMiddleware(), s.GenerateHandler)
+	r.POST("/v1/embeddings", embed, limit, track, openai.EmbeddingsMiddleware(), s.EmbedHandler)
+	return r
+}
+
+// sorted returns every batch, newest first. q.mu must be held or q not yet
+// shared.
+func (q *batchQueue) sorted() []*batch {
+	batches := make([]*batch, 0, len(q.batches))
+	for _, bt := range q.batches {
+		batches = append(batches, bt)
+	}
+
+	slices.SortFunc(batches, func(a, b *batch) int {
+		return cmp.Or(cmp.Compare(b.CreatedAt, a.CreatedAt), cmp.Compare(b.ID, a.ID))
+	})
+
+	return batches
+}
+
+func (q *batchQueue) path(id string) string {
+	return filepath.Join(q.dir, id)
+}
+
+// save writes the state of bt. q.mu must be held.
+func (q *batchQueue) save(bt *batch) error {
+	b, err := json.Marshal(savedBatch{batch: *bt, APIKey: bt.apiKey, Client: bt.client})
+	if err != nil {
+		return err
+	}
+
+	tmp := q.path(bt.ID) + ".json.tmp"
+	if err := os.WriteFile(tmp, b, 0o644); err != nil {
+		return err
+	}
+
+	return os.Rename(tmp, q.path(bt.ID)+".json")
+}
+
+// update applies fn to the batch id and saves it, returning a copy of the
+// updated batch
+func (q *batchQueue) update(id string, fn func(*batch)) (batch, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	bt, ok := q.batches[id]
+	if !ok {
+		return batch{}, errBatchNotFound
+	}
+
+	fn(bt)
+	return *bt, q.save(bt)
+}
+
+func (q *batchQueue) get(id string) (batch, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	bt, ok := q.batches[id]
+	if !ok {
+		return batch{}, errBatchNotFound
+	}
+
+	return *bt, nil
+}
+
+// list returns the batches created by apiKey, newest first
+func (q *batchQueue) list(apiKey string) []batch {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	var batches []batch
+	for _, bt := range q.sorted() {
+		if bt.apiKey == apiKey {
+			batches = append(batches, *bt)
+		}
+	}
+
+	return batches
+}
+
+func (q *batchQueue) create(apiKey, client, endpoint, inputFileID, window string, metadata map[string]string) (batch, error) {
+	now := time.Now()
+	expires := now.Add(24 * time.Hour).Unix()
+	bt := &batch{
+		ID:               newID("batch_"),
+		Object:           "batch",
+		Endpoint:         endpoint,
+		InputFileID:      inputFileID,
+		CompletionWindow: window,
+		Status:           batchValidating,
+		CreatedAt:        now.Unix(),
+		ExpiresAt:        &expires,
+		Metadata:         metadata,
+		apiKey:           apiKey,
+		client:           client,
+	}
+
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if err := q.save(bt); err != nil {
+		return batch{}, err
+	}
+
+	q.batches[bt.ID] = bt
+	q.queue = append(q.queue, bt.ID)
+
+	select {
+	case q.wake <- struct{}{}:
+	default:
+	}
+
+	return *bt, nil
+}
+
+// cancelBatch stops a batch created by apiKey. Results of requests which
+// already completed are kept.
+func (q *batchQueue) cancelBatch(id, apiKey string) (batch, error) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	bt, ok := q.batches[id]
+	if !ok || bt.apiKey != apiKey {
+		return batch{}, errBatchNotFound
+	}
+
+	switch bt.Status {
+	case batchValidating, batchInProgress:
+	case batchCancelling, batchCancelled:
+		return *bt, nil
+	default:
+		return batch{}, fmt.Errorf("cannot cancel a batch with status %q", bt.Status)
+	}
+
+	now := time.Now().Unix()
+	bt.Status = batchCancelling
+	bt.CancellingAt = &now
+	if err := q.save(bt); err != nil {
+		return batch{}, err
+	}
+
+	if q.running == id && q.cancel != nil {
+		q.cancel()
+	}
+
+	return *bt, nil
+}
+
+// next returns the next batch to run
+func (q *batchQueue) next() (string, bool) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	if len(q.queue) == 0 {
+		return "", false
+	}
+
+	id := q.queue[0]
+	q.queue = q.queue[1:]
+	return id, true
+}
+
+// run processes batches until ctx is done
+func (q *batchQueue) run(ctx context.Context) {
+	for {
+		id, ok := q.next()
+		if !ok {
+			select {
+			case <-ctx.Done():
+				return
+			case <-q.wake:
+				continue
+			}
+		}
+
+		if err := q.process(ctx, id); err != nil {
+			if ctx.Err() != nil {
+				// shutting down, the batch resumes on restart
+				return
+			}
+
+			slog.Error("batch failed", "batch", id, "error", err)
+			q.fail(id, batchError{Code: "server_error", Message: err.Error()})
+		}
+	}
+}
+
+// expire records that the batch id expired before all of its requests ran.
+// The batch finishes with the status expired once the remaining requests
+// are written to its error file.
+func (q *batchQueue) expire(id string) error {
+	_, err := q.update(id, func(bt *batch) {
+		if bt.ExpiredAt == nil {
+			now := time.Now().Unix()
+			bt.ExpiredAt = &now
+		}
+	})
+	return err
+}
+
+func (q *batchQueue) fail(id string, errs ...batchError) {
+	if _, err := q.update(id, func(bt *batch) {
+		now := time.Now().Unix()
+		bt.Status = batchFailed
+		bt.FailedAt = &now
+		bt.Errors = &batchErrors{Object: "list", Data: errs}
+	}); err != nil {
+		slog.Error("failed to save batch", "batch", id, "error", err)
+	}
+}
+
+func (q *batchQueue) process(ctx context.Context, id string) error {
+	bt, err := q.get(id)
+	if err != nil {
+		return err
+	}
+
+	if bt.Status == batchValidating {
+		total, errs, err := q.validate(bt)
+		if err != nil {
+			return err
+		}
+
+		if len(errs) > 0 {
+			q.fail(id, errs...)
+			return nil
+		}
+
+		bt, err = q.update(id, func(bt *batch) {
+			bt.RequestCounts.Total = total
+			if bt.Status == batchValidating {
+				now := time.Now().Unix()
+				bt.Status = batchInProgress
+				bt.InProgressAt = &now
+			}
+		})
+		if err != nil {
+			return err
+		}
+	}
+
+	if bt.Status == batchInProgress {
+		if err := q.runRequests(ctx, id); err != nil {
+			return err
+		}
+	}
+
+	return q.finalize(id)
+}
+
+// validate checks every line of the input file of bt, returning the number
+// of requests
+func (q *batchQueue) validate(bt batch) (int, []batchError, error) {
+	f, _, err := q.files.open(bt.InputFileID, bt.apiKey)
+	if errors.Is(err, errFileNotFound) {
+		return 0, []batchError{{Code: "invalid_file", Message: fmt.Sprintf("input file %q not found", bt.InputFileID)}}, nil
+	} else if err != nil {
+		return 0, nil, err
+	}
+	defer f.Close()
+
+	var total int
+	var errs []batchError
+	ids := make(map[string]bool)
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for line := 1; scanner.Scan(); line++ {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		invalid := func(code, format string, args ...any) {
+			if len(errs) < maxBatchErrors {
+				errs = append(errs, batchError{Code: code, Message: fmt.Sprintf(format, args...), Line: &line})
+			}
+		}
+
+		total++
+
+		var in batchInput
+		if err := json.Unmarshal(scanner.Bytes(), &in); err != nil {
+			invalid("invalid_json_line", "line is not valid JSON: %v", err)
+			continue
+		}
+
+		switch {
+		case in.CustomID == "":
+			invalid("missing_required_parameter", "custom_id is required")
+		case ids[in.CustomID]:
+			invalid("duplicate_custom_id", "custom_id %q is not unique", in.CustomID)
+		case in.Method != http.MethodPost:
+			invalid("invalid_method", "method must be POST")
+		case in.URL != bt.Endpoint:
+			invalid("mismatched_endpoint", "url %q does not match the batch endpoint %q", in.URL, bt.Endpoint)
+		case len(in.Body) == 0 || in.Body[0] != '{':
+			invalid("invalid_request", "body must be a JSON object")
+		}
+
+		ids[in.CustomID] = true
+	}
+
+	if err := scanner.Err(); err != nil {
+		return 0, nil, err
+	}
+
+	if total == 0 && len(errs) == 0 {
+		errs = append(errs, batchError{Code: "empty_file", Message: "input file has no requests"})
+	}
+
+	return total, errs, nil
+}
+
+// countLines returns the number of complete lines in the file at path,
+// removing any partial line left by an interrupted write
+func countLines(path string) (int, error) {
+	f, err := os.OpenFile(path, os.O_RDWR|os.O_CREATE, 0o644)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var n int
+	var end int64
+	r := bufio.NewReader(f)
+	for {
+		line, err := r.ReadBytes('\n')
+		if errors.Is(err, io.EOF) {
+			if len(line) > 0 {
+				if err := f.Truncate(end); err != nil {
+					return 0, err
+				}
+			}
+			return n, nil
+		} else if err != nil {
+			return 0, err
+		}
+
+		n++
+		end += int64(len(line))
+	}
+}
+
+// runRequests runs the requests of a batch which have no results yet
+func (q *batchQueue) runRequests(ctx context.Context, id string) error {
+	bt, err := q.get(id)
+	if err != nil {
+		return err
+	}
+
+	outputPath, errorPath := q.path(id)+".output.jsonl", q.path(id)+".error.jsonl"
+
+	completed, err := countLines(outputPath)
+	if err != nil {
+		return err
+	}
+
+	failed, err := countLines(errorPath)
+	if err != nil {
+		return err
+	}
+
+	// the results are the record of which requests have run
+	if _, err := q.update(id, func(bt *batch) {
+		bt.RequestCounts.Completed = completed
+		bt.RequestCounts.Failed = failed
+	}); err != nil {
+		return err
+	}
+
+	output, err := os.OpenFile(outputPath, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer output.Close()
+
+	errorOutput, err := os.OpenFile(errorPath, os.O_WRONLY|os.O_APPEND, 0o644)
+	if err != nil {
+		return err
+	}
+	defer errorOutput.Close()
+
+	f, _, err := q.files.open(bt.InputFileID, bt.apiKey)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	expired := bt.ExpiredAt != nil
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 64*1024*1024)
+	for skip := completed + failed; scanner.Scan(); {
+		if len(bytes.TrimSpace(scanner.Bytes())) == 0 {
+			continue
+		}
+
+		if skip > 0 {
+			// already run before a restart
+			skip--
+			continue
+		}
+
+		var in batchInput
+		if err := json.Unmarshal(scanner.Bytes(), &in); err != nil {
+			return err
+		}
+
+		if !expired && bt.expired(time.Now()) {
+			if err := q.expire(id); err != nil {
+				return err
+			}
+			expired = true
+		}
+
+		var out batchOutput
+		if expired {
+			out = expiredOutput(in)
+		} else {
+			// requests still running when the batch expires are stopped
+			reqCtx, cancel := context.WithDeadline(ctx, time.Unix(*bt.ExpiresAt, 0))
+			q.mu.Lock()
+			cancelled := q.batches[id].Status != batchInProgress
+			q.running, q.cancel = id, cancel
+			q.mu.Unlock()
+
+			if cancelled {
+				cancel()
+				break
+			}
+
+			out, err = q.do(reqCtx, bt, in)
+			deadline := errors.Is(reqCtx.Err(), context.DeadlineExceeded)
+			cancel()
+
+			q.mu.Lock()
+			q.running, q.cancel = "", nil
+			cancelled = q.batches[id].Status != batchInProgress
+			q.mu.Unlock()
+
+			if ctx.Err() != nil {
+				return ctx.Err()
+			} else if cancelled {
+				break
+			} else if deadline {
+				if err := q.expire(id); err != nil {
+					return err
+				}
+				expired = true
+				out = expiredOutput(in)
+			} else if err != nil {
+				return err
+			}
+		}
+
+		b, err := json.Marshal(out)
+		if err != nil {
+			return err
+		}
+
+		ok := out.Response != nil && out.Response.StatusCode == http.StatusOK
+
+		w := output
+		if !ok {
+			w = errorOutput
+		}
+
+		if _, err := w.Write(append(b, '\n')); err != nil {
+			return err
+		}
+
+		if _, err := q.update(id, func(bt *batch) {
+			if ok {
+				bt.RequestCounts.Completed++
+			} else {
+				bt.RequestCounts.Failed++
+			}
+		}); err != nil {
+			return err
+		}
+	}
+
+	return scanner.Err()
+}
+
+// expiredOutput is the result of a request which didn't run before its batch
+// expired
+func expiredOutput(in batchInput) batchOutput {
+	return batchOutput{
+		ID:       newID("batch_req_"),
+		CustomID: in.CustomID,
+		Error:    &batchError{Code: "batch_expired", Message: "the request did not run before the batch expired"},
+	}
+}
+
+// do runs a single request of a batch on behalf of the key and client which
+// created it. Requests which are rate limited are retried once the limit
+// allows.
+func (q *batchQueue) do(ctx context.Context, bt batch, in batchInput) (batchOutput, error) {
+	var body map[string]any
+	if err := json.Unmarshal(in.Body, &body); err != nil {
+		return batchOutput{}, err
+	}
+
+	// results are written whole
+	delete(body, "stream")
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return batchOutput{}, err
+	}
+
+	ctx = context.WithValue(ctx, runAsContextKey{}, bt.apiKey)
+
+	var w *httptest.ResponseRecorder
+	for {
+		r, err := http.NewRequestWithContext(ctx, http.MethodPost, in.URL, bytes.NewReader(b))
+		if err != nil {
+			return batchOutput{}, err
+		}
+		r.Header.Set("Content-Type", "application/json")
+		r.RemoteAddr = net.JoinHostPort(bt.client, "0")
+
+		// batches yield to interactive requests
+		r.Header.Set(priorityHeader, "low")
+
+		w = httptest.NewRecorder()
+		q.handler.ServeHTTP(w, r)
+		if w.Code != http.StatusTooManyRequests {
+			break
+		}
+
+		retryAfter, _ := strconv.Atoi(w.Header().Get("Retry-After"))
+		select {
+		case <-ctx.Done():
+			return batchOutput{}, ctx.Err()
+		case <-time.After(time.Duration(max(retryAfter, 1)) * time.Second):
+		}
+	}
+
+	// share the ID of the audit record if there is one
+	requestID := cmp.Or(w.Header().Get("X-Request-Id"), newID("req_"))
+
+	out := batchOutput{
+		ID:       newID("batch_req_"),
+		CustomID: in.CustomID,
+		Response: &batchResponse{
+			StatusCode: w.Code,
+			RequestID:  requestID,
+			Body:       bytes.TrimSpace(w.Body.Bytes()),
+		},
+	}
+
+	if !json.Valid(out.Response.Body) {
+		out.Response.Body, _ = json.Marshal(openai.NewError(w.Code, w.Body.String()))
+	}
+
+	return out, nil
+}
+
+// finalize stores the results of a batch as files
+func (q *batchQueue) finalize(id string) error {
+	if _, err := q.update(id, func(bt *batch) {
+		if bt.Status == batchInProgress {
+			now := time.Now().Unix()
+			bt.Status = batchFinalizing
+			bt.FinalizingAt = &now
+		}
+	}); err != nil {
+		return err
+	}
+
+	bt, err := q.get(id)
+	if err != nil {
+		return err
+	}
+
+	for _, kind := range []string{"output", "error"} {
+		path := q.path(id) + "." + kind + ".jsonl"
+		fileID, err := q.storeResults(bt, path, kind)
+		if err != nil {
+			return err
+		}
+
+		if _, err := q.update(id, func(bt *batch) {
+			if fileID == "" {
+				return
+			}
+
+			if kind == "output" {
+				bt.OutputFileID = &fileID
+			} else {
+				bt.ErrorFileID = &fileID
+			}
+		}); err != nil {
+			return err
+		}
+
+		if err := os.Remove(path); err != nil && !errors.Is(err, os.ErrNotExist) {
+			return err
+		}
+	}
+
+	bt, err = q.update(id, func(bt *batch) {
+		now := time.Now().Unix()
+		switch {
+		case bt.Status == batchCancelling:
+			bt.Status = batchCancelled
+			bt.CancelledAt = &now
+		case bt.ExpiredAt != nil:
+			bt.Status = batchExpired
+		default:
+			bt.Status = batchCompleted
+			bt.CompletedAt = &now
+		}
+	})
+
+	if err != nil {
+		return err
+	}
+
+	slog.Info("batch finished", "batch", id, "status", bt.Status, "completed", bt.RequestCounts.Completed, "failed", bt.RequestCounts.Failed)
+	return nil
+}
+
+// storeResults creates a file from the results at path, returning its ID or
+// an empty string if there are no results
+func (q *batchQueue) storeResults(bt batch, path, kind string) (string, error) {
+	f, err := os.Open(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return "", nil
+	} else if err != nil {
+		return "", err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return "", err
+	}
+
+	if info.Size() == 0 {
+		return "", nil
+	}
+
+	obj, err := q.files.create(bt.ID+"_"+kind+".jsonl", "batch_output", bt.apiKey, f)
+	if err != nil {
+		return "", err
+	}
+
+	return obj.ID, nil
+}
+
+func (s *Server) CreateBatchHandler(c *gin.Context) {
+	var req struct {
+		InputFileID      string            `json:"input_file_id"`
+		Endpoint         string            `json:"endpoint"`
+		CompletionWindow string            `json:"completion_window"`
+		Metadata         map[string]string `json:"metadata"`
+	}
+
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		abortWithError(c, http.StatusBadRequest, "missing request body")
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusBadRequest, err.Error())
+		return
+	}
+
+	if req.InputFileID == "" {
+		abortWithError(c, http.StatusBadRequest, "input_file_id is required")
+		return
+	} else if !slices.Contains(batchEndpoints, req.Endpoint) {
+		abortWithError(c, http.StatusBadRequest, fmt.Sprintf("endpoint must be one of %v", batchEndpoints))
+		return
+	} else if req.CompletionWindow != "24h" {
+		abortWithError(c, http.StatusBadRequest, `completion_window must be "24h"`)
+		return
+	}
+
+	apiKey := c.GetString(apiKeyContextKey)
+	f, err := s.files.get(req.InputFileID, apiKey)
+	if errors.Is(err, errFileNotFound) {
+		abortWithError(c, http.StatusBadRequest, fmt.Sprintf("input file %q not found", req.InputFileID))
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	if f.Purpose != "batch" {
+		abortWithError(c, http.StatusBadRequest, `input file must have purpose "batch"`)
+		return
+	}
+
+	// requests of the batch are checked again when they run, but fail early
+	// if the key can't call the endpoint at all
+	if key, ok := s.apiKeys.named(apiKey); ok && !key.allows(batchScopes[req.Endpoint]) {
+		abortWithError(c, http.StatusForbidden, fmt.Sprintf("api key %q does not have the %q scope", apiKey, batchScopes[req.Endpoint]))
+		return
+	}
+
+	bt, err := s.batches.create(apiKey, c.ClientIP(), req.Endpoint, req.InputFileID, req.CompletionWindow, req.Metadata)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, bt)
+}
+
+func (s *Server) ListBatchesHandler(c *gin.Context) {
+	limit := 20
+	if v := c.Query("limit"); v != "" {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 1 || n > 100 {
+			abortWithError(c, http.StatusBadRequest, "limit must be between 1 and 100")
+			return
+		}
+		limit = n
+	}
+
+	batches := s.batches.list(c.GetString(apiKeyContextKey))
+	if after := c.Query("after"); after != "" {
+		i := slices.IndexFunc(batches, func(bt batch) bool { return bt.ID == after })
+		if i < 0 {
+			abortWithError(c, http.StatusBadRequest, fmt.Sprintf("batch %q not found", after))
+			return
+		}
+
+		batches = batches[i+1:]
+	}
+
+	hasMore := len(batches) > limit
+	batches = batches[:min(limit, len(batches))]
+
+	resp := gin.H{"object": "list", "data": batches, "has_more": hasMore, "first_id": nil, "last_id": nil}
+	if len(batches) > 0 {
+		resp["first_id"] = batches[0].ID
+		resp["last_id"] = batches[len(batches)-1].ID
+	} else {
+		resp["data"] = []batch{}
+	}
+
+	c.JSON(http.StatusOK, resp)
+}
+
+func (s *Server) RetrieveBatchHandler(c *gin.Context) {
+	bt, err := s.batches.get(c.Param("id"))
+	if err == nil && bt.apiKey != c.GetString(apiKeyContextKey) {
+		// batches of other keys aren't found
+		err = errBatchNotFound
+	}
+
+	if errors.Is(err, errBatchNotFound) {
+		abortWithError(c, http.StatusNotFound, fmt.Sprintf("batch %q not found", c.Param("id")))
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, bt)
+}
+
+func (s *Server) CancelBatchHandler(c *gin.Context) {
+	bt, err := s.batches.cancelBatch(c.Param("id"), c.GetString(apiKeyContextKey))
+	if errors.Is(err, errBatchNotFound) {
+		abortWithError(c, http.StatusNotFound, fmt.Sprintf("batch %q not found", c.Param("id")))
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusConflict, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, bt)
+}
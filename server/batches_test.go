@@ -0,0 +1,597 @@
+package server
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+)
+
+// newTestBatchQueue returns a queue whose requests are served by handler
+func newTestBatchQueue(t *testing.T, dir string, handler gin.HandlerFunc) (*batchQueue, *fileStore) {
+	t.Helper()
+
+	files, err := newFileStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	q, err := newBatchQueue(filepath.Join(dir, "batches"), files)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	gin.SetMode(gin.TestMode)
+	r := gin.New()
+	for _, endpoint := range batchEndpoints {
+		r.POST(endpoint, handler)
+	}
+	q.handler = r
+
+	return q, files
+}
+
+// waitForBatch waits until a batch has status
+func waitForBatch(t *testing.T, q *batchQueue, id, status string) batch {
+	t.Helper()
+
+	deadline := time.Now().Add(5 * time.Second)
+	for {
+		bt, err := q.get(id)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if bt.Status == status {
+			return bt
+		}
+
+		if time.Now().After(deadline) {
+			t.Fatalf("expected batch status %q, got %q", status, bt.Status)
+		}
+
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// readResults reads the batch output file id
+func readResults(t *testing.T, files *fileStore, id *string) []batchOutput {
+	t.Helper()
+	return readResultsAs(t, files, id, "")
+}
+
+// readResultsAs reads the batch output file id owned by apiKey
+func readResultsAs(t *testing.T, files *fileStore, id *string, apiKey string) []batchOutput {
+	t.Helper()
+
+	if id == nil {
+		t.Fatal("expected a results file")
+	}
+
+	f, obj, err := files.open(*id, apiKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+
+	if obj.Purpose != "batch_output" {
+		t.Errorf("expected purpose batch_output, got %q", obj.Purpose)
+	}
+
+	var outs []batchOutput
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var out batchOutput
+		if err := json.Unmarshal(scanner.Bytes(), &out); err != nil {
+			t.Fatal(err)
+		}
+		outs = append(outs, out)
+	}
+
+	return outs
+}
+
+func echoHandler(c *gin.Context) {
+	var req map[string]any
+	if err := c.ShouldBindJSON(&req); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req["model"] == "missing" {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "model not found"})
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"model": req["model"], "stream": req["stream"]})
+}
+
+func TestBatch(t *testing.T) {
+	q, files := newTestBatchQueue(t, t.TempDir(), echoHandler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.run(ctx)
+
+	input, err := files.create("input.jsonl", "batch", "", strings.NewReader(`{"custom_id": "a", "method": "POST", "url": "/v1/chat/completions", "body": {"model": "test", "stream": true}}
+{"custom_id": "b", "method": "POST", "url": "/v1/chat/completions", "body": {"model": "missing"}}
+
+{"custom_id": "c", "method": "POST", "url": "/v1/chat/completions", "body": {"model": "test"}}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bt, err := q.create("", "", "/v1/chat/completions", input.ID, "24h", map[string]string{"job": "nightly"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bt = waitForBatch(t, q, bt.ID, batchCompleted)
+	if bt.RequestCounts != (batchRequestCounts{Total: 3, Completed: 2, Failed: 1}) {
+		t.Errorf("unexpected request counts %+v", bt.RequestCounts)
+	}
+
+	if bt.InProgressAt == nil || bt.FinalizingAt == nil || bt.CompletedAt == nil || bt.Metadata["job"] != "nightly" {
+		t.Errorf("unexpected batch %+v", bt)
+	}
+
+	outputs := readResults(t, files, bt.OutputFileID)
+	if len(outputs) != 2 || outputs[0].CustomID != "a" || outputs[1].CustomID != "c" {
+		t.Fatalf("unexpected outputs %+v", outputs)
+	}
+
+	if body := string(outputs[0].Response.Body); body != `{"model":"test","stream":null}` {
+		t.Errorf("unexpected response body %s", body)
+	}
+
+	errs := readResults(t, files, bt.ErrorFileID)
+	if len(errs) != 1 || errs[0].CustomID != "b" || errs[0].Response.StatusCode != http.StatusNotFound {
+		t.Errorf("unexpected errors %+v", errs)
+	}
+
+	if matches, _ := filepath.Glob(filepath.Join(q.dir, "*.jsonl")); len(matches) > 0 {
+		t.Errorf("expected working files to be removed, got %v", matches)
+	}
+}
+
+func TestBatchValidation(t *testing.T) {
+	q, files := newTestBatchQueue(t, t.TempDir(), echoHandler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.run(ctx)
+
+	input, err := files.create("input.jsonl", "batch", "", strings.NewReader(`{"custom_id": "a", "method": "POST", "url": "/v1/chat/completions", "body": {}}
+{"custom_id": "a", "method": "POST", "url": "/v1/chat/completions", "body": {}}
+{"custom_id": "b", "method": "GET", "url": "/v1/chat/completions", "body": {}}
+{"custom_id": "c", "method": "POST", "url": "/v1/embeddings", "body": {}}
+not json
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bt, err := q.create("", "", "/v1/chat/completions", input.ID, "24h", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bt = waitForBatch(t, q, bt.ID, batchFailed)
+	if bt.Errors == nil {
+		t.Fatal("expected errors")
+	}
+
+	var codes []string
+	for _, e := range bt.Errors.Data {
+		codes = append(codes, e.Code)
+	}
+
+	expect := "duplicate_custom_id invalid_method mismatched_endpoint invalid_json_line"
+	if got := strings.Join(codes, " "); got != expect {
+		t.Errorf("expected errors %q, got %q", expect, got)
+	}
+
+	if *bt.Errors.Data[0].Line != 2 {
+		t.Errorf("expected error on line 2, got %d", *bt.Errors.Data[0].Line)
+	}
+}
+
+func TestBatchCancel(t *testing.T) {
+	started := make(chan struct{}, 1)
+	q, files := newTestBatchQueue(t, t.TempDir(), func(c *gin.Context) {
+		started <- struct{}{}
+		<-c.Request.Context().Done()
+		c.AbortWithStatus(http.StatusInternalServerError)
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.run(ctx)
+
+	input, err := files.create("input.jsonl", "batch", "", strings.NewReader(`{"custom_id": "a", "method": "POST", "url": "/v1/embeddings", "body": {}}
+{"custom_id": "b", "method": "POST", "url": "/v1/embeddings", "body": {}}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bt, err := q.create("", "", "/v1/embeddings", input.ID, "24h", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	<-started
+	if bt, err = q.cancelBatch(bt.ID, ""); err != nil {
+		t.Fatal(err)
+	} else if bt.Status != batchCancelling {
+		t.Errorf("expected status cancelling, got %q", bt.Status)
+	}
+
+	bt = waitForBatch(t, q, bt.ID, batchCancelled)
+	if bt.OutputFileID != nil || bt.ErrorFileID != nil || bt.RequestCounts.Completed+bt.RequestCounts.Failed != 0 {
+		t.Errorf("unexpected batch %+v", bt)
+	}
+
+	if _, err := q.cancelBatch(bt.ID, ""); err != nil {
+		t.Errorf("expected cancelling a cancelled batch to succeed, got %v", err)
+	}
+}
+
+func TestBatchResume(t *testing.T) {
+	dir := t.TempDir()
+
+	var calls atomic.Int32
+	handler := func(c *gin.Context) {
+		calls.Add(1)
+		echoHandler(c)
+	}
+
+	q, files := newTestBatchQueue(t, dir, handler)
+	input, err := files.create("input.jsonl", "batch", "", strings.NewReader(`{"custom_id": "a", "method": "POST", "url": "/v1/completions", "body": {"model": "test"}}
+{"custom_id": "b", "method": "POST", "url": "/v1/completions", "body": {"model": "test"}}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// simulate a restart after the first request and part of the second
+	bt, err := q.create("", "", "/v1/completions", input.ID, "24h", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := q.update(bt.ID, func(bt *batch) {
+		bt.Status = batchInProgress
+		bt.RequestCounts.Total = 2
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := os.WriteFile(q.path(bt.ID)+".output.jsonl", []byte(`{"id":"batch_req_1","custom_id":"a","response":{"status_code":200,"request_id":"req_1","body":{}},"error":null}
+{"id":"batch_req_2","cus`), 0o644); err != nil {
+		t.Fatal(err)
+	}
+
+	q, files = newTestBatchQueue(t, dir, handler)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.run(ctx)
+
+	bt = waitForBatch(t, q, bt.ID, batchCompleted)
+	if n := calls.Load(); n != 1 {
+		t.Errorf("expected 1 request, got %d", n)
+	}
+
+	if bt.RequestCounts != (batchRequestCounts{Total: 2, Completed: 2}) {
+		t.Errorf("unexpected request counts %+v", bt.RequestCounts)
+	}
+
+	outputs := readResults(t, files, bt.OutputFileID)
+	if len(outputs) != 2 || outputs[0].CustomID != "a" || outputs[1].CustomID != "b" {
+		t.Errorf("unexpected outputs %+v", outputs)
+	}
+}
+
+func TestCreateBatchHandler(t *testing.T) {
+	q, files := newTestBatchQueue(t, t.TempDir(), echoHandler)
+	s := &Server{files: files, batches: q}
+
+	input, err := files.create("input.jsonl", "batch", "", strings.NewReader("{}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	other, err := files.create("notes.txt", "assistants", "", strings.NewReader("notes"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	cases := []struct {
+		name   string
+		body   map[string]any
+		status int
+		error  string
+	}{
+		{"missing input file", map[string]any{"endpoint": "/v1/chat/completions", "completion_window": "24h"}, http.StatusBadRequest, "input_file_id is required"},
+		{"invalid endpoint", map[string]any{"input_file_id": input.ID, "endpoint": "/api/chat", "completion_window": "24h"}, http.StatusBadRequest, "endpoint must be one of [/v1/chat/completions /v1/completions /v1/embeddings]"},
+		{"invalid window", map[string]any{"input_file_id": input.ID, "endpoint": "/v1/chat/completions", "completion_window": "1h"}, http.StatusBadRequest, `completion_window must be "24h"`},
+		{"unknown file", map[string]any{"input_file_id": "file-abc", "endpoint": "/v1/chat/completions", "completion_window": "24h"}, http.StatusBadRequest, `input file "file-abc" not found`},
+		{"wrong purpose", map[string]any{"input_file_id": other.ID, "endpoint": "/v1/chat/completions", "completion_window": "24h"}, http.StatusBadRequest, `input file must have purpose "batch"`},
+		{"created", map[string]any{"input_file_id": input.ID, "endpoint": "/v1/chat/completions", "completion_window": "24h"}, http.StatusOK, ""},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			w := createRequest(t, s.CreateBatchHandler, tt.body)
+			if w.Code != tt.status {
+				t.Fatalf("expected status %d, got %d", tt.status, w.Code)
+			}
+
+			if tt.error != "" {
+				var resp struct {
+					Error string `json:"error"`
+				}
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+
+				if resp.Error != tt.error {
+					t.Errorf("expected error %q, got %q", tt.error, resp.Error)
+				}
+				return
+			}
+
+			var bt batch
+			if err := json.NewDecoder(w.Body).Decode(&bt); err != nil {
+				t.Fatal(err)
+			}
+
+			if bt.Object != "batch" || bt.Status != batchValidating || bt.InputFileID != input.ID {
+				t.Errorf("unexpected batch %+v", bt)
+			}
+		})
+	}
+}
+
+func TestBatchAPIKeys(t *testing.T) {
+	q, files := newTestBatchQueue(t, t.TempDir(), echoHandler)
+	s := &Server{files: files, batches: q}
+
+	input, err := files.create("input.jsonl", "batch", "alice", strings.NewReader("{}\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// the input file of another key can't be used
+	w := httptest.NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Set(apiKeyContextKey, "bob")
+	c.Request = httptest.NewRequest(http.MethodPost, "/v1/batches", strings.NewReader(`{"input_file_id": "`+input.ID+`", "endpoint": "/v1/chat/completions", "completion_window": "24h"}`))
+	s.CreateBatchHandler(c)
+	if w.Code != http.StatusBadRequest {
+		t.Errorf("expected status 400, got %d", w.Code)
+	}
+
+	bt, err := q.create("alice", "", "/v1/chat/completions", input.ID, "24h", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if batches := q.list("bob"); len(batches) != 0 {
+		t.Errorf("expected no batches, got %v", batches)
+	}
+
+	if _, err := q.cancelBatch(bt.ID, "bob"); err != errBatchNotFound {
+		t.Errorf("expected errBatchNotFound, got %v", err)
+	}
+
+	w = httptest.NewRecorder()
+	c, _ = gin.CreateTestContext(w)
+	c.Set(apiKeyContextKey, "bob")
+	c.Params = gin.Params{{Key: "id", Value: bt.ID}}
+	c.Request = httptest.NewRequest(http.MethodGet, "/v1/batches/"+bt.ID, nil)
+	s.RetrieveBatchHandler(c)
+	if w.Code != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", w.Code)
+	}
+
+	if batches := q.list("alice"); len(batches) != 1 || batches[0].ID != bt.ID {
+		t.Errorf("expected the batch of alice, got %v", batches)
+	}
+}
+
+func TestBatchRoutes(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	dir := t.TempDir()
+	q, files := newTestBatchQueue(t, dir, echoHandler)
+
+	p := filepath.Join(dir, "keys.json")
+	if err := os.WriteFile(p, []byte(`[{"name": "team", "key": "inference-key", "scopes": ["inference"]}]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := loadAPIKeys(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{apiKeys: keys, files: files, batches: q}
+	q.handler = s.batchRoutes()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.run(ctx)
+
+	run := func(t *testing.T, apiKey, endpoint string) batchOutput {
+		t.Helper()
+
+		input, err := files.create("input.jsonl", "batch", apiKey, strings.NewReader(`{"custom_id": "a", "method": "POST", "url": "`+endpoint+`", "body": {"model": "missing", "input": "hello", "messages": [{"role": "user", "content": "hello"}]}}`+"\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bt, err := q.create(apiKey, "", endpoint, input.ID, "24h", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		bt = waitForBatch(t, q, bt.ID, batchCompleted)
+		errs := readResultsAs(t, files, bt.ErrorFileID, apiKey)
+		if len(errs) != 1 {
+			t.Fatalf("expected 1 error, got %+v", errs)
+		}
+
+		return errs[0]
+	}
+
+	t.Run("allowed", func(t *testing.T) {
+		// the request reaches the handler, which doesn't find the model
+		if out := run(t, "team", "/v1/chat/completions"); out.Response.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d: %s", out.Response.StatusCode, out.Response.Body)
+		}
+	})
+
+	t.Run("missing scope", func(t *testing.T) {
+		if out := run(t, "team", "/v1/embeddings"); out.Response.StatusCode != http.StatusForbidden {
+			t.Errorf("expected status 403, got %d: %s", out.Response.StatusCode, out.Response.Body)
+		}
+	})
+
+	t.Run("removed key", func(t *testing.T) {
+		if out := run(t, "former", "/v1/chat/completions"); out.Response.StatusCode != http.StatusUnauthorized {
+			t.Errorf("expected status 401, got %d: %s", out.Response.StatusCode, out.Response.Body)
+		}
+	})
+
+	t.Run("create", func(t *testing.T) {
+		input, err := files.create("input.jsonl", "batch", "team", strings.NewReader("{}\n"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Set(apiKeyContextKey, "team")
+		c.Request = httptest.NewRequest(http.MethodPost, "/v1/batches", strings.NewReader(`{"input_file_id": "`+input.ID+`", "endpoint": "/v1/embeddings", "completion_window": "24h"}`))
+		s.CreateBatchHandler(c)
+		if w.Code != http.StatusForbidden {
+			t.Errorf("expected status 403, got %d", w.Code)
+		}
+	})
+}
+
+func TestBatchExpiry(t *testing.T) {
+	var calls atomic.Int32
+	q, files := newTestBatchQueue(t, t.TempDir(), func(c *gin.Context) {
+		// the second request runs until the batch expires
+		if calls.Add(1) == 2 {
+			<-c.Request.Context().Done()
+			c.AbortWithStatus(http.StatusInternalServerError)
+			return
+		}
+
+		echoHandler(c)
+	})
+
+	input, err := files.create("input.jsonl", "batch", "", strings.NewReader(`{"custom_id": "a", "method": "POST", "url": "/v1/completions", "body": {"model": "test"}}
+{"custom_id": "b", "method": "POST", "url": "/v1/completions", "body": {"model": "test"}}
+{"custom_id": "c", "method": "POST", "url": "/v1/completions", "body": {"model": "test"}}
+`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bt, err := q.create("", "", "/v1/completions", input.ID, "24h", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := q.update(bt.ID, func(bt *batch) {
+		expires := time.Now().Add(2 * time.Second).Unix()
+		bt.ExpiresAt = &expires
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go q.run(ctx)
+
+	bt = waitForBatch(t, q, bt.ID, batchExpired)
+	if bt.ExpiredAt == nil || bt.CompletedAt != nil || bt.RequestCounts != (batchRequestCounts{Total: 3, Completed: 1, Failed: 2}) {
+		t.Errorf("unexpected batch %+v", bt)
+	}
+
+	if outputs := readResults(t, files, bt.OutputFileID); len(outputs) != 1 || outputs[0].CustomID != "a" {
+		t.Errorf("unexpected outputs %+v", outputs)
+	}
+
+	errs := readResults(t, files, bt.ErrorFileID)
+	if len(errs) != 2 || errs[0].CustomID != "b" || errs[1].CustomID != "c" {
+		t.Fatalf("unexpected errors %+v", errs)
+	}
+
+	for _, e := range errs {
+		if e.Response != nil || e.Error == nil || e.Error.Code != "batch_expired" {
+			t.Errorf("unexpected error %+v", e)
+		}
+	}
+}
+
+func TestListBatchesHandler(t *testing.T) {
+	q, files := newTestBatchQueue(t, t.TempDir(), echoHandler)
+	s := &Server{files: files, batches: q}
+
+	var ids []string
+	for range 3 {
+		bt, err := q.create("", "", "/v1/completions", "file-abc", "24h", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		ids = append(ids, bt.ID)
+	}
+
+	list := func(query string) (int, []string) {
+		t.Helper()
+
+		w := httptest.NewRecorder()
+		c, _ := gin.CreateTestContext(w)
+		c.Request = httptest.NewRequest(http.MethodGet, "/v1/batches?"+query, nil)
+		s.ListBatchesHandler(c)
+
+		var resp struct {
+			Data []batch `json:"data"`
+		}
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		var got []string
+		for _, bt := range resp.Data {
+			got = append(got, bt.ID)
+		}
+
+		return w.Code, got
+	}
+
+	all := q.list("")
+	if code, got := list("after=" + all[0].ID); code != http.StatusOK || len(got) != 2 || got[0] != all[1].ID {
+		t.Errorf("unexpected page %d %v", code, got)
+	}
+
+	if code, got := list("after=batch_unknown"); code != http.StatusBadRequest || len(got) != 0 {
+		t.Errorf("expected status 400, got %d %v", code, got)
+	}
+}
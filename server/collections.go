@@ -0,0 +1,699 @@
+package server
+
+import (
+	"bufio"
+	"bytes"
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+var (
+	errCollectionNotFound = errors.New("collection not found")
+	errCollectionExists   = errors.New("collection already exists")
+)
+
+// defaultTopK is the number of documents a query returns unless it asks for
+// another number
+const defaultTopK = 10
+
+// collectionStore keeps collections of documents and their embeddings in a
+// directory. Each collection is a directory holding collection.json and
+// documents.jsonl, a log of added and deleted documents which is compacted
+// once most of it is stale. Collections are held in memory and queried by
+// comparing the query with every document.
+type collectionStore struct {
+	dir string
+
+	mu          sync.Mutex
+	collections map[string]*collection
+}
+
+type collection struct {
+	dir string
+
+	mu   sync.RWMutex
+	info collectionInfo
+	docs map[string]collectionRecord
+	// stale is the number of records in the log which were replaced or
+	// deleted since it was last compacted
+	stale int
+}
+
+// collectionInfo is stored in collection.json
+type collectionInfo struct {
+	Name  string `json:"name"`
+	Model string `json:"model"`
+
+	// Dimensions is the length of the collection's embeddings, or zero
+	// until a document is added
+	Dimensions int `json:"dimensions,omitempty"`
+
+	CreatedAt time.Time `json:"created_at"`
+	UpdatedAt time.Time `json:"updated_at"`
+
+	// APIKey is the name of the key which created the collection. Only
+	// requests made with it can use the collection.
+	APIKey string `json:"api_key,omitempty"`
+}
+
+// collectionRecord is a line of documents.jsonl
+type collectionRecord struct {
+	api.CollectionDocument
+	Embedding []float32 `json:"embedding,omitempty"`
+	Deleted   bool      `json:"deleted,omitempty"`
+}
+
+func newCollectionStore(dir string) (*collectionStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	cs := &collectionStore{dir: dir, collections: make(map[string]*collection)}
+
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, e := range entries {
+		if !e.IsDir() || !validCollectionName(e.Name()) {
+			continue
+		}
+
+		c, err := loadCollection(filepath.Join(dir, e.Name()))
+		if err != nil {
+			slog.Warn("failed to load collection", "name", e.Name(), "error", err)
+			continue
+		}
+
+		cs.collections[c.info.Name] = c
+	}
+
+	return cs, nil
+}
+
+// validCollectionName reports whether name is safe to use as a directory name
+func validCollectionName(name string) bool {
+	if name == "" || len(name) > 64 {
+		return false
+	}
+
+	for i, r := range name {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || i > 0 && (r == '_' || r == '-' || r == '.')) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func loadCollection(dir string) (*collection, error) {
+	c := &collection{dir: dir, docs: make(map[string]collectionRecord)}
+
+	b, err := os.ReadFile(filepath.Join(dir, "collection.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := json.Unmarshal(b, &c.info); err != nil {
+		return nil, err
+	}
+
+	f, err := os.Open(filepath.Join(dir, "documents.jsonl"))
+	if errors.Is(err, os.ErrNotExist) {
+		return c, nil
+	} else if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), math.MaxInt32)
+	for scanner.Scan() {
+		var rec collectionRecord
+		if err := json.Unmarshal(scanner.Bytes(), &rec); err != nil {
+			return nil, err
+		}
+
+		c.apply(rec)
+	}
+
+	return c, scanner.Err()
+}
+
+// apply adds or deletes the document of a record. It must be called with mu
+// held.
+func (c *collection) apply(rec collectionRecord) {
+	if _, ok := c.docs[rec.ID]; ok {
+		c.stale++
+	}
+
+	if rec.Deleted {
+		delete(c.docs, rec.ID)
+		c.stale++
+	} else {
+		c.docs[rec.ID] = rec
+	}
+}
+
+func (c *collection) collection() api.Collection {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	return api.Collection{
+		Name:      c.info.Name,
+		Model:     c.info.Model,
+		Documents: len(c.docs),
+		CreatedAt: c.info.CreatedAt,
+		UpdatedAt: c.info.UpdatedAt,
+	}
+}
+
+// writeFile replaces a file of the collection atomically
+func (c *collection) writeFile(name string, fn func(io.Writer) error) error {
+	f, err := os.CreateTemp(c.dir, "collection-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	w := bufio.NewWriter(f)
+	if err := fn(w); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), filepath.Join(c.dir, name))
+}
+
+// save writes collection.json. It must be called with mu held.
+func (c *collection) save() error {
+	return c.writeFile("collection.json", func(w io.Writer) error {
+		return json.NewEncoder(w).Encode(c.info)
+	})
+}
+
+// append adds records to the log and applies them. It must be called with mu
+// held.
+func (c *collection) append(recs []collectionRecord) error {
+	var b bytes.Buffer
+	enc := json.NewEncoder(&b)
+	for _, rec := range recs {
+		if !rec.Deleted && c.info.Dimensions != 0 && len(rec.Embedding) != c.info.Dimensions {
+			return fmt.Errorf("embedding length %d doesn't match the collection's %d", len(rec.Embedding), c.info.Dimensions)
+		}
+
+		if err := enc.Encode(rec); err != nil {
+			return err
+		}
+	}
+
+	f, err := os.OpenFile(filepath.Join(c.dir, "documents.jsonl"), os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0o644)
+	if err != nil {
+		return err
+	}
+
+	if _, err := f.Write(b.Bytes()); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	for _, rec := range recs {
+		c.apply(rec)
+		if !rec.Deleted {
+			c.info.Dimensions = len(rec.Embedding)
+		}
+	}
+
+	c.info.UpdatedAt = time.Now().UTC()
+	if err := c.save(); err != nil {
+		return err
+	}
+
+	if c.stale > len(c.docs) {
+		return c.compact()
+	}
+
+	return nil
+}
+
+// compact rewrites the log with only the documents in the collection. It must
+// be called with mu held.
+func (c *collection) compact() error {
+	ids := make([]string, 0, len(c.docs))
+	for id := range c.docs {
+		ids = append(ids, id)
+	}
+	slices.Sort(ids)
+
+	if err := c.writeFile("documents.jsonl", func(w io.Writer) error {
+		enc := json.NewEncoder(w)
+		for _, id := range ids {
+			if err := enc.Encode(c.docs[id]); err != nil {
+				return err
+			}
+		}
+
+		return nil
+	}); err != nil {
+		return err
+	}
+
+	c.stale = 0
+	return nil
+}
+
+// add adds documents and their embeddings, replacing documents with the same
+// IDs
+func (c *collection) add(docs []api.CollectionDocument, embeddings [][]float32) error {
+	recs := make([]collectionRecord, len(docs))
+	for i, doc := range docs {
+		recs[i] = collectionRecord{CollectionDocument: doc, Embedding: embeddings[i]}
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	return c.append(recs)
+}
+
+// remove deletes documents, returning how many were in the collection
+func (c *collection) remove(ids []string) (int, error) {
+	// documents are looked up under the same lock they are deleted with so
+	// that concurrent requests don't delete a document twice
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	var recs []collectionRecord
+	for _, id := range ids {
+		if _, ok := c.docs[id]; ok && !slices.ContainsFunc(recs, func(rec collectionRecord) bool { return rec.ID == id }) {
+			recs = append(recs, collectionRecord{CollectionDocument: api.CollectionDocument{ID: id}, Deleted: true})
+		}
+	}
+
+	if len(recs) == 0 {
+		return 0, nil
+	}
+
+	return len(recs), c.append(recs)
+}
+
+// query returns up to topK documents with all the metadata of filter, most
+// similar to embedding first
+func (c *collection) query(embedding []float32, topK int, filter map[string]any) []api.CollectionResult {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	results := make([]api.CollectionResult, 0, len(c.docs))
+	for _, doc := range c.docs {
+		if !matchMetadata(doc.Metadata, filter) {
+			continue
+		}
+
+		results = append(results, api.CollectionResult{
+			CollectionDocument: doc.CollectionDocument,
+			Score:              cosineSimilarity(embedding, doc.Embedding),
+		})
+	}
+
+	slices.SortFunc(results, func(a, b api.CollectionResult) int {
+		return cmp.Or(cmp.Compare(b.Score, a.Score), cmp.Compare(a.ID, b.ID))
+	})
+
+	return results[:min(topK, len(results))]
+}
+
+// matchMetadata reports whether metadata has every key and value of filter
+func matchMetadata(metadata, filter map[string]any) bool {
+	for k, v := range filter {
+		if mv, ok := metadata[k]; !ok || !reflect.DeepEqual(mv, v) {
+			return false
+		}
+	}
+
+	return true
+}
+
+func cosineSimilarity(a, b []float32) float32 {
+	if len(a) != len(b) {
+		return 0
+	}
+
+	var dot, na, nb float64
+	for i := range a {
+		dot += float64(a[i]) * float64(b[i])
+		na += float64(a[i]) * float64(a[i])
+		nb += float64(b[i]) * float64(b[i])
+	}
+
+	if na == 0 || nb == 0 {
+		return 0
+	}
+
+	return float32(dot / math.Sqrt(na*nb))
+}
+
+// create makes an empty collection owned by apiKey. Names are shared by every
+// key.
+func (cs *collectionStore) create(name, model, apiKey string) (*collection, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	if _, ok := cs.collections[name]; ok {
+		return nil, errCollectionExists
+	}
+
+	dir := filepath.Join(cs.dir, name)
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	now := time.Now().UTC()
+	c := &collection{
+		dir:  dir,
+		info: collectionInfo{Name: name, Model: model, CreatedAt: now, UpdatedAt: now, APIKey: apiKey},
+		docs: make(map[string]collectionRecord),
+	}
+
+	if err := c.save(); err != nil {
+		os.RemoveAll(dir)
+		return nil, err
+	}
+
+	cs.collections[name] = c
+	return c, nil
+}
+
+// get returns a collection owned by apiKey. Collections of other keys aren't
+// found.
+func (cs *collectionStore) get(name, apiKey string) (*collection, error) {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	c, ok := cs.collections[name]
+	if !ok || c.info.APIKey != apiKey {
+		return nil, errCollectionNotFound
+	}
+
+	return c, nil
+}
+
+// list returns the collections owned by apiKey sorted by name
+func (cs *collectionStore) list(apiKey string) []api.Collection {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	collections := make([]api.Collection, 0, len(cs.collections))
+	for _, c := range cs.collections {
+		if c.info.APIKey == apiKey {
+			collections = append(collections, c.collection())
+		}
+	}
+
+	slices.SortFunc(collections, func(a, b api.Collection) int {
+		return cmp.Compare(a.Name, b.Name)
+	})
+
+	return collections
+}
+
+func (cs *collectionStore) delete(name, apiKey string) error {
+	cs.mu.Lock()
+	defer cs.mu.Unlock()
+
+	c, ok := cs.collections[name]
+	if !ok || c.info.APIKey != apiKey {
+		return errCollectionNotFound
+	}
+
+	delete(cs.collections, name)
+	return os.RemoveAll(c.dir)
+}
+
+// collectionEmbed embeds texts through the embed endpoint so that they share
+// its cache and batching with other embed requests
+func (s *Server) collectionEmbed(c *gin.Context, model string, texts []string, keepAlive *api.Duration) (*api.EmbedResponse, error) {
+	b, err := json.Marshal(api.EmbedRequest{Model: model, Input: texts, KeepAlive: keepAlive})
+	if err != nil {
+		return nil, err
+	}
+
+	r, err := http.NewRequestWithContext(c.Request.Context(), http.MethodPost, "/api/embed", bytes.NewReader(b))
+	if err != nil {
+		return nil, err
+	}
+	r.Header.Set("Content-Type", "application/json")
+
+	router := gin.New()
+	router.POST("/api/embed", s.EmbedHandler)
+
+	w := httptest.NewRecorder()
+	router.ServeHTTP(w, r)
+
+	if w.Code != http.StatusOK {
+		e := api.StatusError{StatusCode: w.Code}
+		if err := json.Unmarshal(w.Body.Bytes(), &e); err != nil {
+			e.ErrorMessage = w.Body.String()
+		}
+
+		return nil, e
+	}
+
+	var resp api.EmbedResponse
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil {
+		return nil, err
+	}
+
+	// the embed request has no client to record usage for
+	s.recordUsage(c, resp.PromptEvalCount)
+	return &resp, nil
+}
+
+// abortWithCollectionError replies to a request for the collection name
+// with err
+func abortWithCollectionError(c *gin.Context, name string, err error) {
+	var se api.StatusError
+	switch {
+	case errors.Is(err, errCollectionNotFound):
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("collection %q not found", name)})
+	case errors.As(err, &se):
+		c.AbortWithStatusJSON(se.StatusCode, gin.H{"error": se.ErrorMessage})
+	default:
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+	}
+}
+
+func (s *Server) CreateCollectionHandler(c *gin.Context) {
+	var req api.CreateCollectionRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	switch {
+	case req.Name == "":
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "name is required"})
+		return
+	case !validCollectionName(req.Name):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "name must be at most 64 letters, digits, '_', '-' or '.' and start with a letter or digit"})
+		return
+	case req.Model == "":
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	// embeddings of different models can't be compared, so the collection
+	// stays on the model the alias resolves to now
+	name := s.resolveModel(c, req.Model)
+	_, name, err := getModel(c.Request.Context(), name)
+	if err != nil {
+		switch {
+		case os.IsNotExist(err):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		case err.Error() == "invalid model name":
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	coll, err := s.collections.create(req.Name, name, c.GetString(apiKeyContextKey))
+	if errors.Is(err, errCollectionExists) {
+		c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("collection %q already exists", req.Name)})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, coll.collection())
+}
+
+func (s *Server) ListCollectionsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, api.ListCollectionsResponse{Collections: s.collections.list(c.GetString(apiKeyContextKey))})
+}
+
+func (s *Server) CollectionHandler(c *gin.Context) {
+	coll, err := s.collections.get(c.Param("name"), c.GetString(apiKeyContextKey))
+	if err != nil {
+		abortWithCollectionError(c, c.Param("name"), err)
+		return
+	}
+
+	c.JSON(http.StatusOK, coll.collection())
+}
+
+func (s *Server) DeleteCollectionHandler(c *gin.Context) {
+	if err := s.collections.delete(c.Param("name"), c.GetString(apiKeyContextKey)); err != nil {
+		abortWithCollectionError(c, c.Param("name"), err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (s *Server) AddDocumentsHandler(c *gin.Context) {
+	var req api.AddDocumentsRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if len(req.Documents) == 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "documents are required"})
+		return
+	}
+
+	name := c.Param("name")
+	coll, err := s.collections.get(name, c.GetString(apiKeyContextKey))
+	if err != nil {
+		abortWithCollectionError(c, name, err)
+		return
+	}
+
+	texts := make([]string, len(req.Documents))
+	ids := make([]string, len(req.Documents))
+	for i, doc := range req.Documents {
+		if doc.Text == "" {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("document %d has no text", i)})
+			return
+		}
+
+		if doc.ID == "" {
+			req.Documents[i].ID = newID("doc-")
+		}
+
+		texts[i] = doc.Text
+		ids[i] = req.Documents[i].ID
+	}
+
+	resp, err := s.collectionEmbed(c, coll.collection().Model, texts, req.KeepAlive)
+	if err != nil {
+		abortWithCollectionError(c, name, err)
+		return
+	}
+
+	if err := coll.add(req.Documents, resp.Embeddings); err != nil {
+		abortWithCollectionError(c, name, err)
+		return
+	}
+
+	c.JSON(http.StatusOK, api.AddDocumentsResponse{IDs: ids, PromptEvalCount: resp.PromptEvalCount})
+}
+
+func (s *Server) DeleteDocumentsHandler(c *gin.Context) {
+	var req api.DeleteDocumentsRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	name := c.Param("name")
+	coll, err := s.collections.get(name, c.GetString(apiKeyContextKey))
+	if err != nil {
+		abortWithCollectionError(c, name, err)
+		return
+	}
+
+	if _, err := coll.remove(req.IDs); err != nil {
+		abortWithCollectionError(c, name, err)
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
+
+func (s *Server) QueryCollectionHandler(c *gin.Context) {
+	var req api.QueryCollectionRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Query == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "query is required"})
+		return
+	} else if req.TopK < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "top_k must not be negative"})
+		return
+	}
+
+	name := c.Param("name")
+	coll, err := s.collections.get(name, c.GetString(apiKeyContextKey))
+	if err != nil {
+		abortWithCollectionError(c, name, err)
+		return
+	}
+
+	resp, err := s.collectionEmbed(c, coll.collection().Model, []string{req.Query}, req.KeepAlive)
+	if err != nil {
+		abortWithCollectionError(c, name, err)
+		return
+	}
+
+	results := coll.query(resp.Embeddings[0], cmp.Or(req.TopK, defaultTopK), req.Filter)
+	c.JSON(http.StatusOK, api.QueryCollectionResponse{Results: results})
+}
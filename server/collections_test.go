@@ -0,0 +1,392 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestCollectionStore(t *testing.T) {
+	dir := t.TempDir()
+
+	cs, err := newCollectionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coll, err := cs.create("docs", "embedder", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cs.create("docs", "embedder", ""); err != errCollectionExists {
+		t.Fatalf("expected errCollectionExists, got %v", err)
+	}
+
+	if err := coll.add([]api.CollectionDocument{
+		{ID: "a", Text: "a", Metadata: map[string]any{"lang": "en"}},
+		{ID: "b", Text: "b", Metadata: map[string]any{"lang": "fr"}},
+		{ID: "c", Text: "c", Metadata: map[string]any{"lang": "en"}},
+	}, [][]float32{{1, 0}, {0.6, 0.8}, {0, 1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := coll.add([]api.CollectionDocument{{ID: "d", Text: "d"}}, [][]float32{{1, 0, 0}}); err == nil {
+		t.Fatal("expected an error for an embedding of another length")
+	}
+
+	ids := func(results []api.CollectionResult) []string {
+		var ids []string
+		for _, r := range results {
+			ids = append(ids, r.ID)
+		}
+		return ids
+	}
+
+	if diff := cmp.Diff(ids(coll.query([]float32{1, 0}, 10, nil)), []string{"a", "b", "c"}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(ids(coll.query([]float32{0, 1}, 2, nil)), []string{"c", "b"}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	if diff := cmp.Diff(ids(coll.query([]float32{0, 1}, 10, map[string]any{"lang": "en"})), []string{"c", "a"}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	// replace a and delete b
+	if err := coll.add([]api.CollectionDocument{{ID: "a", Text: "a2"}}, [][]float32{{0, -1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	if n, err := coll.remove([]string{"b", "missing"}); err != nil {
+		t.Fatal(err)
+	} else if n != 1 {
+		t.Errorf("expected 1 document removed, got %d", n)
+	}
+
+	// the log is compacted once it holds more stale records than documents
+	b, err := os.ReadFile(filepath.Join(dir, "docs", "documents.jsonl"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if lines := strings.Count(string(b), "\n"); lines != 2 {
+		t.Errorf("expected 2 records after compaction, got %d", lines)
+	}
+
+	cs, err = newCollectionStore(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coll, err = cs.get("docs", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	results := coll.query([]float32{0, 1}, 10, nil)
+	if diff := cmp.Diff(ids(results), []string{"c", "a"}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	if results[1].Text != "a2" || results[1].Score != -1 {
+		t.Errorf("unexpected result %+v", results[1])
+	}
+
+	if got := cs.list(""); len(got) != 1 || got[0].Documents != 2 {
+		t.Errorf("unexpected collections %+v", got)
+	}
+
+	if err := cs.delete("docs", ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := os.Stat(filepath.Join(dir, "docs")); !os.IsNotExist(err) {
+		t.Errorf("expected the collection to be removed, got %v", err)
+	}
+
+	if err := cs.delete("docs", ""); err != errCollectionNotFound {
+		t.Errorf("expected errCollectionNotFound, got %v", err)
+	}
+}
+
+func TestCollectionStoreAPIKeys(t *testing.T) {
+	cs, err := newCollectionStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cs.create("docs", "embedder", "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := cs.get("docs", "bob"); err != errCollectionNotFound {
+		t.Errorf("expected errCollectionNotFound, got %v", err)
+	}
+
+	if got := cs.list("bob"); len(got) != 0 {
+		t.Errorf("expected no collections, got %+v", got)
+	}
+
+	if err := cs.delete("docs", "bob"); err != errCollectionNotFound {
+		t.Errorf("expected errCollectionNotFound, got %v", err)
+	}
+
+	// the owner is kept when the collection is read back from disk
+	cs, err = newCollectionStore(cs.dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if got := cs.list("alice"); len(got) != 1 || got[0].Name != "docs" {
+		t.Errorf("expected the collection of alice, got %+v", got)
+	}
+}
+
+func TestCollectionRemoveConcurrent(t *testing.T) {
+	cs, err := newCollectionStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	coll, err := cs.create("docs", "embedder", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := coll.add([]api.CollectionDocument{{ID: "a", Text: "a"}, {ID: "b", Text: "b"}, {ID: "c", Text: "c"}}, [][]float32{{1}, {1}, {1}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// only one of the requests deleting a deletes it
+	var wg sync.WaitGroup
+	var removed atomic.Int64
+	for range 8 {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+
+			n, err := coll.remove([]string{"a"})
+			if err != nil {
+				t.Error(err)
+			}
+
+			removed.Add(int64(n))
+		}()
+	}
+	wg.Wait()
+
+	if n := removed.Load(); n != 1 {
+		t.Errorf("expected 1 document removed, got %d", n)
+	}
+
+	// the document and its deletion
+	if coll.stale != 2 {
+		t.Errorf("expected 2 stale records, got %d", coll.stale)
+	}
+}
+
+func TestValidCollectionName(t *testing.T) {
+	cases := map[string]bool{
+		"docs":                  true,
+		"Docs_v1.2-en":          true,
+		"":                      false,
+		"_docs":                 false,
+		"..":                    false,
+		"a/b":                   false,
+		strings.Repeat("a", 65): false,
+	}
+
+	for name, want := range cases {
+		if got := validCollectionName(name); got != want {
+			t.Errorf("validCollectionName(%q) = %t, want %t", name, got, want)
+		}
+	}
+}
+
+func TestCollections(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var mock mockRunner
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, ggml *llm.GGML, gpus discover.GpuInfoList, numParallel int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(context.TODO())
+
+	var err error
+	s.collections, err = newCollectionStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model: "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, llm.KV{
+			"general.architecture": "bert",
+			"bert.pooling_type":    uint32(1),
+			"bert.context_length":  uint32(512),
+		}, []llm.Tensor{})),
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	withName := func(name string, fn gin.HandlerFunc) gin.HandlerFunc {
+		return func(c *gin.Context) {
+			c.Params = gin.Params{{Key: "name", Value: name}}
+			fn(c)
+		}
+	}
+
+	t.Run("create", func(t *testing.T) {
+		w := createRequest(t, s.CreateCollectionHandler, api.CreateCollectionRequest{Name: "docs", Model: "test"})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		w = createRequest(t, s.CreateCollectionHandler, api.CreateCollectionRequest{Name: "docs", Model: "test"})
+		if w.Code != http.StatusConflict {
+			t.Errorf("expected status 409, got %d", w.Code)
+		}
+
+		w = createRequest(t, s.CreateCollectionHandler, api.CreateCollectionRequest{Name: "../docs", Model: "test"})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		w = createRequest(t, s.CreateCollectionHandler, api.CreateCollectionRequest{Name: "other", Model: "missing"})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("add", func(t *testing.T) {
+		w := createRequest(t, withName("docs", s.AddDocumentsHandler), api.AddDocumentsRequest{
+			Documents: []api.CollectionDocument{
+				{ID: "paris", Text: "Paris is the capital of France", Metadata: map[string]any{"country": "fr"}},
+				{Text: "Berlin is the capital of Germany", Metadata: map[string]any{"country": "de"}},
+			},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.AddDocumentsResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.IDs) != 2 || resp.IDs[0] != "paris" || !strings.HasPrefix(resp.IDs[1], "doc-") {
+			t.Errorf("unexpected ids %v", resp.IDs)
+		}
+
+		w = createRequest(t, withName("docs", s.AddDocumentsHandler), api.AddDocumentsRequest{
+			Documents: []api.CollectionDocument{{ID: "empty"}},
+		})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		w = createRequest(t, withName("missing", s.AddDocumentsHandler), api.AddDocumentsRequest{
+			Documents: []api.CollectionDocument{{Text: "text"}},
+		})
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+
+	t.Run("query", func(t *testing.T) {
+		w := createRequest(t, withName("docs", s.QueryCollectionHandler), api.QueryCollectionRequest{
+			Query:  "capital of France",
+			Filter: map[string]any{"country": "fr"},
+		})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.QueryCollectionResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.Results) != 1 || resp.Results[0].ID != "paris" || resp.Results[0].Text != "Paris is the capital of France" {
+			t.Errorf("unexpected results %+v", resp.Results)
+		}
+
+		w = createRequest(t, withName("docs", s.QueryCollectionHandler), api.QueryCollectionRequest{Query: "capital", TopK: 1})
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.Results) != 1 {
+			t.Errorf("expected 1 result, got %d", len(resp.Results))
+		}
+
+		w = createRequest(t, withName("docs", s.QueryCollectionHandler), api.QueryCollectionRequest{})
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		w := createRequest(t, withName("docs", s.DeleteDocumentsHandler), api.DeleteDocumentsRequest{IDs: []string{"paris"}})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		w = createRequest(t, withName("docs", s.CollectionHandler), nil)
+		var coll api.Collection
+		if err := json.NewDecoder(w.Body).Decode(&coll); err != nil {
+			t.Fatal(err)
+		}
+
+		if coll.Documents != 1 || coll.Model != "test" {
+			t.Errorf("unexpected collection %+v", coll)
+		}
+
+		w = createRequest(t, withName("docs", s.DeleteCollectionHandler), nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		w = createRequest(t, withName("docs", s.CollectionHandler), nil)
+		if w.Code != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", w.Code)
+		}
+	})
+}
@@ -0,0 +1,45 @@
+package server
+
+import (
+	"encoding/base64"
+	"encoding/binary"
+	"fmt"
+	"math"
+)
+
+// embeddingFormats are the encodings of embeddings a client can request
+var embeddingFormats = []string{"float", "base64", "int8", "binary"}
+
+// encodeEmbedding encodes vec in format as a base64 string.
+//
+//   - base64 is the little-endian float32 values of vec
+//   - int8 is the values of vec scaled by 127 and clamped to [-127, 127],
+//     which keeps nearly all the precision of a normalized embedding
+//   - binary is one bit per value, set if the value is positive, packed
+//     most significant bit first and padded with zeros to a whole byte
+func encodeEmbedding(vec []float32, format string) (string, error) {
+	var b []byte
+	switch format {
+	case "base64":
+		b = make([]byte, 4*len(vec))
+		for i, v := range vec {
+			binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(v))
+		}
+	case "int8":
+		b = make([]byte, len(vec))
+		for i, v := range vec {
+			b[i] = byte(int8(max(-127, min(127, math.Round(float64(v)*127)))))
+		}
+	case "binary":
+		b = make([]byte, (len(vec)+7)/8)
+		for i, v := range vec {
+			if v > 0 {
+				b[i/8] |= 0x80 >> (i % 8)
+			}
+		}
+	default:
+		return "", fmt.Errorf("unsupported encoding format %q", format)
+	}
+
+	return base64.StdEncoding.EncodeToString(b), nil
+}
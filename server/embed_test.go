@@ -0,0 +1,234 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/google/go-cmp/cmp/cmpopts"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestEncodeEmbedding(t *testing.T) {
+	vec := []float32{1, -0.5, 0, 0.25, -1, 0.1, 0.2, -0.3, 0.9}
+
+	cases := []struct {
+		format string
+		want   string
+	}{
+		// 1, -0.5, 0, ... as little-endian float32
+		{"base64", "AACAPwAAAL8AAAAAAACAPgAAgL/NzMw9zcxMPpqZmb5mZmY/"},
+		// 127, -64, 0, 32, -127, 13, 25, -38, 114
+		{"int8", "f8AAIIENGdpy"},
+		// 10010110 10000000
+		{"binary", "loA="},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.format, func(t *testing.T) {
+			got, err := encodeEmbedding(vec, tt.format)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if got != tt.want {
+				t.Errorf("expected %s, got %s", tt.want, got)
+			}
+		})
+	}
+
+	if _, err := encodeEmbedding(vec, "float16"); err == nil {
+		t.Error("expected error for an unsupported format")
+	}
+}
+
+func TestEmbed(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var mock mockRunner
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, ggml *llm.GGML, gpus discover.GpuInfoList, numParallel int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(context.TODO())
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model: "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, llm.KV{
+			"general.architecture": "bert",
+			"bert.pooling_type":    uint32(1),
+			"bert.context_length":  uint32(512),
+		}, []llm.Tensor{})),
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	embed := func(t *testing.T, req api.EmbedRequest) api.EmbedResponse {
+		t.Helper()
+
+		req.Model = "test"
+		req.Input = "hello"
+		w := createRequest(t, s.EmbedHandler, req)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.EmbedResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp
+	}
+
+	cmpFloats := cmpopts.EquateApprox(0, 1e-4)
+
+	t.Run("normalized", func(t *testing.T) {
+		resp := embed(t, api.EmbedRequest{})
+		if diff := cmp.Diff(resp.Embeddings, [][]float32{{3.0 / 13.0096, -4.0 / 13.0096, 12.0 / 13.0096, 0.5 / 13.0096}}, cmpFloats); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("dimensions", func(t *testing.T) {
+		resp := embed(t, api.EmbedRequest{Dimensions: 2})
+		if diff := cmp.Diff(resp.Embeddings, [][]float32{{0.6, -0.8}}, cmpFloats); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("unnormalized", func(t *testing.T) {
+		normalize := false
+		resp := embed(t, api.EmbedRequest{Dimensions: 3, Normalize: &normalize})
+		if diff := cmp.Diff(resp.Embeddings, [][]float32{{3, -4, 12}}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("binary", func(t *testing.T) {
+		resp := embed(t, api.EmbedRequest{EncodingFormat: "binary"})
+		if resp.Embeddings != nil {
+			t.Errorf("expected no float embeddings, got %v", resp.Embeddings)
+		}
+
+		if diff := cmp.Diff(resp.EncodedEmbeddings, []string{"sA=="}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("cache", func(t *testing.T) {
+		ec, err := newEmbedCache(t.TempDir(), 1024)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		s.embedCache = ec
+		defer func() { s.embedCache = nil }()
+
+		if resp := embed(t, api.EmbedRequest{}); resp.Cached != 0 || resp.PromptEvalCount != 1 {
+			t.Errorf("expected a miss, got %d cached and %d tokens", resp.Cached, resp.PromptEvalCount)
+		}
+
+		// a hit doesn't need a runner
+		cached := Server{embedCache: ec}
+		w := createRequest(t, cached.EmbedHandler, api.EmbedRequest{Model: "test", Input: "hello", Dimensions: 2})
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.EmbedResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if resp.Cached != 1 || resp.PromptEvalCount != 0 {
+			t.Errorf("expected a hit, got %d cached and %d tokens", resp.Cached, resp.PromptEvalCount)
+		}
+
+		if diff := cmp.Diff(resp.Embeddings, [][]float32{{0.6, -0.8}}, cmpFloats); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		// purging by an alias purges the models it resolves to
+		cached.aliases, err = newAliasStore(filepath.Join(t.TempDir(), "aliases.json"))
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := cached.aliases.set(api.Alias{
+			Name:      "text-embedding-3-small",
+			Targets:   []api.AliasTarget{{Model: "test"}},
+			Fallbacks: []string{"missing"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+
+		for _, tt := range []struct {
+			model string
+			code  int
+			want  string
+		}{
+			{"text-embedding-3-small", http.StatusOK, `{"purged":1}`},
+			{"test", http.StatusOK, `{"purged":0}`},
+			{"missing", http.StatusNotFound, `{"error":"model 'missing' not found"}`},
+		} {
+			w = createRequest(t, cached.PurgeEmbedCacheHandler, api.PurgeEmbedCacheRequest{Model: tt.model})
+			if w.Code != tt.code {
+				t.Errorf("expected status %d for %s, got %d", tt.code, tt.model, w.Code)
+			}
+
+			if diff := cmp.Diff(w.Body.String(), tt.want); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		}
+	})
+
+	for name, tt := range map[string]struct {
+		req  api.EmbedRequest
+		want string
+	}{
+		"invalid encoding format": {api.EmbedRequest{EncodingFormat: "float16"}, `{"error":"invalid encoding_format \"float16\""}`},
+		"negative dimensions":     {api.EmbedRequest{Dimensions: -1}, `{"error":"dimensions must not be negative"}`},
+		"too many dimensions":     {api.EmbedRequest{Dimensions: 5}, `{"error":"dimensions exceeds the embedding length of 4"}`},
+	} {
+		t.Run(name, func(t *testing.T) {
+			tt.req.Model = "test"
+			tt.req.Input = "hello"
+			w := createRequest(t, s.EmbedHandler, tt.req)
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", w.Code)
+			}
+
+			if diff := cmp.Diff(w.Body.String(), tt.want); diff != "" {
+				t.Errorf("mismatch (-got +want):\n%s", diff)
+			}
+		})
+	}
+}
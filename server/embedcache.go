@@ -0,0 +1,342 @@
+package server
+
+import (
+	"container/list"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+// embedCache keeps embeddings on disk so that inputs which were embedded
+// before don't need a runner. Each embedding is stored in
+// dir/<model digest>/<key> as little-endian float32 values, before it is
+// shortened or normalized. The least recently used embeddings are evicted
+// once the cache grows past maxSize.
+type embedCache struct {
+	dir     string
+	maxSize int64
+
+	mu   sync.Mutex
+	size int64
+	// lru holds the cached embeddings, most recently used first
+	lru     *list.List
+	entries map[string]*list.Element
+}
+
+type embedCacheEntry struct {
+	// path is the path of the embedding relative to dir
+	path string
+	size int64
+}
+
+// newEmbedCache returns the cache in dir, or nil if maxSize is zero
+func newEmbedCache(dir string, maxSize uint64) (*embedCache, error) {
+	if maxSize == 0 {
+		return nil, nil
+	}
+
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	ec := &embedCache{
+		dir:     dir,
+		maxSize: int64(min(maxSize, math.MaxInt64)),
+		lru:     list.New(),
+		entries: make(map[string]*list.Element),
+	}
+
+	type found struct {
+		embedCacheEntry
+		used time.Time
+	}
+
+	// embeddings are touched when they are used so that their modification
+	// times order them after a restart
+	var entries []found
+	if err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err != nil || d.IsDir() || strings.HasSuffix(path, ".tmp") {
+			return err
+		}
+
+		fi, err := d.Info()
+		if err != nil {
+			return err
+		}
+
+		rel, err := filepath.Rel(dir, path)
+		if err != nil {
+			return err
+		}
+
+		entries = append(entries, found{embedCacheEntry{path: rel, size: fi.Size()}, fi.ModTime()})
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	slices.SortFunc(entries, func(a, b found) int {
+		return b.used.Compare(a.used)
+	})
+
+	for _, e := range entries {
+		ec.entries[e.path] = ec.lru.PushBack(&e.embedCacheEntry)
+		ec.size += e.size
+	}
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+	ec.evict()
+
+	return ec, nil
+}
+
+// embedCacheKey identifies the embedding of input by a model given the
+// truncate flag and options of the request
+func embedCacheKey(input string, truncate bool, options map[string]any) string {
+	b, _ := json.Marshal(struct {
+		Input    string         `json:"input"`
+		Truncate bool           `json:"truncate"`
+		Options  map[string]any `json:"options"`
+	}{input, truncate, options})
+
+	sum := sha256.Sum256(b)
+	return hex.EncodeToString(sum[:])
+}
+
+func (ec *embedCache) path(digest, key string) string {
+	return filepath.Join(strings.ReplaceAll(digest, ":", "-"), key)
+}
+
+// get returns the cached embedding of key by the model with digest
+func (ec *embedCache) get(digest, key string) ([]float32, bool) {
+	if ec == nil {
+		return nil, false
+	}
+
+	path := ec.path(digest, key)
+
+	ec.mu.Lock()
+	el, ok := ec.entries[path]
+	if ok {
+		ec.lru.MoveToFront(el)
+	}
+	ec.mu.Unlock()
+
+	if !ok {
+		return nil, false
+	}
+
+	b, err := os.ReadFile(filepath.Join(ec.dir, path))
+	if err != nil || len(b)%4 != 0 {
+		slog.Warn("failed to read cached embedding", "path", path, "error", err)
+		ec.remove(path)
+		return nil, false
+	}
+
+	now := time.Now()
+	if err := os.Chtimes(filepath.Join(ec.dir, path), now, now); err != nil {
+		slog.Debug("failed to touch cached embedding", "path", path, "error", err)
+	}
+
+	embedding := make([]float32, len(b)/4)
+	for i := range embedding {
+		embedding[i] = math.Float32frombits(binary.LittleEndian.Uint32(b[4*i:]))
+	}
+
+	return embedding, true
+}
+
+// put caches the embedding of key by the model with digest
+func (ec *embedCache) put(digest, key string, embedding []float32) {
+	if ec == nil {
+		return
+	}
+
+	path := ec.path(digest, key)
+	tmp, err := ec.write(embedding)
+	if err != nil {
+		slog.Warn("failed to cache embedding", "path", path, "error", err)
+		return
+	}
+	defer os.Remove(tmp)
+
+	size := int64(4 * len(embedding))
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	// the embedding is only moved into place with mu held so that a purge
+	// of its model either removes it or happens before it is tracked
+	if err := os.MkdirAll(filepath.Join(ec.dir, filepath.Dir(path)), 0o755); err != nil {
+		slog.Warn("failed to cache embedding", "path", path, "error", err)
+		return
+	}
+
+	if err := os.Rename(tmp, filepath.Join(ec.dir, path)); err != nil {
+		slog.Warn("failed to cache embedding", "path", path, "error", err)
+		return
+	}
+
+	if el, ok := ec.entries[path]; ok {
+		e := el.Value.(*embedCacheEntry)
+		ec.size += size - e.size
+		e.size = size
+		ec.lru.MoveToFront(el)
+	} else {
+		ec.entries[path] = ec.lru.PushFront(&embedCacheEntry{path: path, size: size})
+		ec.size += size
+	}
+
+	ec.evict()
+}
+
+// write saves an embedding to a temporary file in dir, outside the
+// directories of models so that purging them doesn't race with it, and
+// returns its name
+func (ec *embedCache) write(embedding []float32) (string, error) {
+	b := make([]byte, 4*len(embedding))
+	for i, v := range embedding {
+		binary.LittleEndian.PutUint32(b[4*i:], math.Float32bits(v))
+	}
+
+	f, err := os.CreateTemp(ec.dir, "embedding-*.tmp")
+	if err != nil {
+		return "", err
+	}
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	if err := f.Close(); err != nil {
+		os.Remove(f.Name())
+		return "", err
+	}
+
+	return f.Name(), nil
+}
+
+func (ec *embedCache) remove(path string) {
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	if el, ok := ec.entries[path]; ok {
+		ec.removeElement(el)
+	}
+}
+
+// removeElement deletes a cached embedding. It must be called with mu held.
+func (ec *embedCache) removeElement(el *list.Element) {
+	e := ec.lru.Remove(el).(*embedCacheEntry)
+	delete(ec.entries, e.path)
+	ec.size -= e.size
+
+	if err := os.Remove(filepath.Join(ec.dir, e.path)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		slog.Warn("failed to remove cached embedding", "path", e.path, "error", err)
+	}
+}
+
+// evict removes the least recently used embeddings until the cache fits in
+// maxSize. It must be called with mu held.
+func (ec *embedCache) evict() {
+	for ec.size > ec.maxSize && ec.lru.Len() > 0 {
+		ec.removeElement(ec.lru.Back())
+	}
+}
+
+// purge removes the cached embeddings of the model with digest, returning
+// how many were removed
+func (ec *embedCache) purge(digest string) (int, error) {
+	dir := strings.ReplaceAll(digest, ":", "-")
+
+	ec.mu.Lock()
+	defer ec.mu.Unlock()
+
+	var n int
+	for path, el := range ec.entries {
+		if filepath.Dir(path) == dir {
+			e := ec.lru.Remove(el).(*embedCacheEntry)
+			delete(ec.entries, path)
+			ec.size -= e.size
+			n++
+		}
+	}
+
+	return n, os.RemoveAll(filepath.Join(ec.dir, dir))
+}
+
+func (s *Server) PurgeEmbedCacheHandler(c *gin.Context) {
+	var req api.PurgeEmbedCacheRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Model == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	if s.embedCache == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": "embedding cache is disabled"})
+		return
+	}
+
+	// an alias may have sent embeddings to any of its models
+	var purged int
+	var found bool
+	for _, name := range s.aliases.models(req.Model) {
+		m, err := GetModel(name)
+		if err != nil {
+			switch {
+			case os.IsNotExist(err):
+				continue
+			case err.Error() == "invalid model name":
+				c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			default:
+				c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			}
+			return
+		}
+
+		n, err := s.embedCache.purge(m.Digest)
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		purged += n
+		found = true
+	}
+
+	if !found {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.PurgeEmbedCacheResponse{Purged: purged})
+}
@@ -0,0 +1,124 @@
+package server
+
+import (
+	"fmt"
+	"io/fs"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/google/go-cmp/cmp"
+)
+
+func TestEmbedCache(t *testing.T) {
+	dir := t.TempDir()
+
+	// room for two embeddings of 4 values
+	ec, err := newEmbedCache(dir, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	a := embedCacheKey("a", true, nil)
+	b := embedCacheKey("b", true, nil)
+	c := embedCacheKey("c", true, nil)
+
+	if a == embedCacheKey("a", false, nil) || a == embedCacheKey("a", true, map[string]any{"num_ctx": 512}) {
+		t.Error("expected the truncate flag and options to change the key")
+	}
+
+	ec.put("sha256:1", a, []float32{1, 2, 3, 4})
+	ec.put("sha256:1", b, []float32{5, 6, 7, 8})
+
+	if got, ok := ec.get("sha256:1", a); !ok {
+		t.Error("expected a to be cached")
+	} else if diff := cmp.Diff(got, []float32{1, 2, 3, 4}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	if _, ok := ec.get("sha256:2", a); ok {
+		t.Error("expected a to be cached for one model only")
+	}
+
+	// b is now the least recently used
+	ec.put("sha256:1", c, []float32{9, 10, 11, 12})
+
+	if _, ok := ec.get("sha256:1", b); ok {
+		t.Error("expected b to be evicted")
+	}
+
+	// the cache and the order of its embeddings survive a restart
+	ec, err = newEmbedCache(dir, 32)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, key := range []string{a, c} {
+		if _, ok := ec.get("sha256:1", key); !ok {
+			t.Errorf("expected %s to be cached", key)
+		}
+	}
+
+	if n, err := ec.purge("sha256:1"); err != nil {
+		t.Fatal(err)
+	} else if n != 2 {
+		t.Errorf("expected 2 embeddings purged, got %d", n)
+	}
+
+	if _, ok := ec.get("sha256:1", a); ok {
+		t.Error("expected a to be purged")
+	}
+
+	if ec.size != 0 {
+		t.Errorf("expected an empty cache, got %d bytes", ec.size)
+	}
+
+	// a disabled cache is nil
+	if ec, err := newEmbedCache(dir, 0); err != nil || ec != nil {
+		t.Errorf("expected no cache, got %v, %v", ec, err)
+	}
+}
+
+func TestEmbedCachePurgeConcurrent(t *testing.T) {
+	dir := t.TempDir()
+
+	ec, err := newEmbedCache(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var wg sync.WaitGroup
+	for i := range 1000 {
+		wg.Add(2)
+		go func() {
+			defer wg.Done()
+			ec.put("sha256:1", embedCacheKey(fmt.Sprint(i), true, nil), []float32{1, 2, 3, 4})
+		}()
+		go func() {
+			defer wg.Done()
+			if _, err := ec.purge("sha256:1"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// every embedding left on disk is accounted for
+	var files int
+	if err := filepath.WalkDir(dir, func(path string, d fs.DirEntry, err error) error {
+		if err == nil && !d.IsDir() {
+			if strings.HasSuffix(path, ".tmp") {
+				t.Errorf("unexpected temporary file %s", path)
+			}
+			files++
+		}
+		return err
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	if files != len(ec.entries) || ec.size != int64(16*files) {
+		t.Errorf("expected %d files to be tracked, got %d entries of %d bytes", files, len(ec.entries), ec.size)
+	}
+}
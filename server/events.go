@@ -0,0 +1,250 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+// pullResumeTimeout is how long a pull streamed as server-sent events waits
+// for its client to reconnect, and how long a finished pull can be resumed
+const pullResumeTimeout = time.Minute
+
+// acceptsEvents reports whether the client of a request to a native endpoint
+// asked for its response to be streamed as server-sent events. Compatible
+// endpoints convert the native stream to events of their own.
+func acceptsEvents(c *gin.Context) bool {
+	return !strings.HasPrefix(c.FullPath(), "/v1/") && strings.Contains(c.GetHeader("Accept"), "text/event-stream")
+}
+
+// serverEvent is a streamed response with an ID by which a client can resume
+// the stream
+type serverEvent struct {
+	id   string
+	data any
+}
+
+// eventName names the server-sent event of a streamed response
+func eventName(val any) string {
+	switch r := val.(type) {
+	case api.ProgressResponse:
+		if r.Status == "success" {
+			return "done"
+		}
+
+		return "progress"
+	case api.GenerateResponse:
+		if r.Done {
+			return "done"
+		}
+	case api.ChatResponse:
+		if r.Done {
+			return "done"
+		}
+	case gin.H:
+		if _, ok := r["error"]; ok {
+			return "error"
+		}
+	}
+
+	return "delta"
+}
+
+// pullStream is a pull streamed as server-sent events. It outlives the
+// request which started it so that a client can reconnect and resume its
+// progress.
+type pullStream struct {
+	id   string
+	name string
+
+	mu  sync.Mutex
+	seq int
+	// latest holds the most recent event of each status, which is all a
+	// resuming client needs to catch up
+	latest map[string]pullEvent
+	done   bool
+	// changed is closed when an event is added or the pull finishes
+	changed chan struct{}
+
+	subscribers int
+	cancel      context.CancelFunc
+	idle        *time.Timer
+}
+
+type pullEvent struct {
+	seq  int
+	data any
+}
+
+// add records an event of the pull
+func (ps *pullStream) add(key string, data any) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.seq++
+	ps.latest[key] = pullEvent{seq: ps.seq, data: data}
+	close(ps.changed)
+	ps.changed = make(chan struct{})
+}
+
+func (ps *pullStream) finish() {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	ps.done = true
+	close(ps.changed)
+	ps.changed = make(chan struct{})
+}
+
+// since returns the events after seq in the order they happened
+func (ps *pullStream) since(seq int) (events []pullEvent, done bool, changed <-chan struct{}) {
+	ps.mu.Lock()
+	defer ps.mu.Unlock()
+
+	for _, e := range ps.latest {
+		if e.seq > seq {
+			events = append(events, e)
+		}
+	}
+
+	slices.SortFunc(events, func(a, b pullEvent) int {
+		return cmp.Compare(a.seq, b.seq)
+	})
+
+	return events, ps.done, ps.changed
+}
+
+// subscribe streams the events of the pull after seq until it finishes or
+// ctx is done. A pull left without subscribers is cancelled unless a client
+// reconnects within pullResumeTimeout.
+func (ps *pullStream) subscribe(ctx context.Context, seq int) chan any {
+	ps.mu.Lock()
+	ps.subscribers++
+	if ps.idle != nil {
+		ps.idle.Stop()
+		ps.idle = nil
+	}
+	ps.mu.Unlock()
+
+	ch := make(chan any)
+	go func() {
+		defer close(ch)
+		defer func() {
+			ps.mu.Lock()
+			defer ps.mu.Unlock()
+
+			ps.subscribers--
+			if ps.subscribers == 0 && !ps.done {
+				ps.idle = time.AfterFunc(pullResumeTimeout, ps.cancel)
+			}
+		}()
+
+		for {
+			events, done, changed := ps.since(seq)
+			for _, e := range events {
+				select {
+				case ch <- serverEvent{id: fmt.Sprintf("%s:%d", ps.id, e.seq), data: e.data}:
+					seq = e.seq
+				case <-ctx.Done():
+					return
+				}
+			}
+
+			if done {
+				return
+			}
+
+			select {
+			case <-changed:
+			case <-ctx.Done():
+				return
+			}
+		}
+	}()
+
+	return ch
+}
+
+// pullStreams holds the pulls streamed as server-sent events which are in
+// progress or recently finished
+type pullStreams struct {
+	mu      sync.Mutex
+	streams map[string]*pullStream
+}
+
+// resume returns the pull of name which the event ID lastEventID belongs to
+// and the sequence number of the event, or the pull of name in progress
+// from its start
+func (p *pullStreams) resume(name, lastEventID string) (*pullStream, int) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if id, s, ok := strings.Cut(lastEventID, ":"); ok {
+		if ps, ok := p.streams[id]; ok && ps.name == name {
+			if seq, err := strconv.Atoi(s); err == nil {
+				return ps, seq
+			}
+		}
+	}
+
+	for _, ps := range p.streams {
+		ps.mu.Lock()
+		done := ps.done
+		ps.mu.Unlock()
+
+		if ps.name == name && !done {
+			return ps, 0
+		}
+	}
+
+	return nil, 0
+}
+
+// start runs pull in the background, recording its progress
+func (p *pullStreams) start(name string, pull func(context.Context, func(api.ProgressResponse)) error) *pullStream {
+	ctx, cancel := context.WithCancel(context.Background())
+	ps := &pullStream{
+		id:      newID("pull_"),
+		name:    name,
+		latest:  make(map[string]pullEvent),
+		changed: make(chan struct{}),
+		cancel:  cancel,
+	}
+
+	p.mu.Lock()
+	if p.streams == nil {
+		p.streams = make(map[string]*pullStream)
+	}
+	p.streams[ps.id] = ps
+	p.mu.Unlock()
+
+	go func() {
+		defer cancel()
+
+		if err := pull(ctx, func(r api.ProgressResponse) {
+			ps.add(r.Status, r)
+		}); err != nil {
+			ps.add("error", gin.H{"error": err.Error()})
+		}
+
+		ps.finish()
+
+		// keep the result for clients which reconnect after the pull ends
+		time.AfterFunc(pullResumeTimeout, func() {
+			p.mu.Lock()
+			defer p.mu.Unlock()
+			delete(p.streams, ps.id)
+		})
+	}()
+
+	return ps
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestStreamResponseEvents(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	w := NewRecorder()
+	c, _ := gin.CreateTestContext(w)
+	c.Request = &http.Request{Header: http.Header{"Accept": {"text/event-stream"}}}
+
+	ch := make(chan any, 4)
+	ch <- serverEvent{id: "pull_1:1", data: api.ProgressResponse{Status: "pulling manifest"}}
+	ch <- api.ChatResponse{Message: api.Message{Role: "assistant", Content: "Hi"}}
+	ch <- api.GenerateResponse{Done: true}
+	ch <- gin.H{"error": "boom"}
+	close(ch)
+
+	streamResponse(c, ch)
+
+	if got := w.Header().Get("Content-Type"); got != "text/event-stream" {
+		t.Errorf("expected content type text/event-stream, got %q", got)
+	}
+
+	want := `id: pull_1:1
+event: progress
+data: {"status":"pulling manifest"}
+
+event: delta
+data: {"model":"","created_at":"0001-01-01T00:00:00Z","message":{"role":"assistant","content":"Hi"},"done":false}
+
+event: done
+data: {"model":"","created_at":"0001-01-01T00:00:00Z","response":"","done":true}
+
+event: error
+data: {"error":"boom"}
+
+`
+	if diff := cmp.Diff(w.Body.String(), want); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+}
+
+func TestPullStream(t *testing.T) {
+	var pulls pullStreams
+
+	step := make(chan string)
+	ps := pulls.start("test", func(ctx context.Context, fn func(api.ProgressResponse)) error {
+		for status := range step {
+			fn(api.ProgressResponse{Status: status})
+		}
+
+		return errors.New("registry unavailable")
+	})
+
+	next := func(ch chan any) serverEvent {
+		t.Helper()
+
+		e, ok := (<-ch).(serverEvent)
+		if !ok {
+			t.Fatal("expected an event")
+		}
+
+		return e
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	ch := ps.subscribe(ctx, 0)
+
+	step <- "pulling manifest"
+	first := next(ch)
+	if first.data.(api.ProgressResponse).Status != "pulling manifest" {
+		t.Errorf("unexpected event %+v", first)
+	}
+
+	// the client drops while the pull continues
+	cancel()
+	for range ch {
+	}
+
+	step <- "pulling abc"
+	step <- "pulling def"
+
+	if got, _ := pulls.resume("other", first.id); got != nil {
+		t.Error("expected no pull for another model")
+	}
+
+	resumed, seq := pulls.resume("test", first.id)
+	if resumed != ps || seq != 1 {
+		t.Fatalf("expected to resume after event 1, got %d", seq)
+	}
+
+	ch = resumed.subscribe(context.Background(), seq)
+	for _, want := range []string{"pulling abc", "pulling def"} {
+		if got := next(ch).data.(api.ProgressResponse).Status; got != want {
+			t.Errorf("expected %q, got %q", want, got)
+		}
+	}
+
+	close(step)
+	if got := next(ch).data; !cmp.Equal(got, gin.H{"error": "registry unavailable"}) {
+		t.Errorf("unexpected event %+v", got)
+	}
+
+	if _, ok := <-ch; ok {
+		t.Error("expected the stream to end with the pull")
+	}
+
+	// a finished pull is only resumed by ID
+	if got, _ := pulls.resume("test", ""); got != nil {
+		t.Error("expected no pull in progress")
+	}
+}
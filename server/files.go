@@ -0,0 +1,298 @@
+package server
+
+import (
+	"cmp"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+)
+
+var errFileNotFound = errors.New("file not found")
+
+// fileObject describes a file uploaded through the OpenAI compatible files
+// API or written by a batch
+type fileObject struct {
+	ID        string `json:"id"`
+	Object    string `json:"object"`
+	Bytes     int64  `json:"bytes"`
+	CreatedAt int64  `json:"created_at"`
+	Filename  string `json:"filename"`
+	Purpose   string `json:"purpose"`
+}
+
+// savedFile is the description of a file saved alongside its contents. Only
+// requests made with APIKey, the name of the key which created the file, can
+// access it.
+type savedFile struct {
+	fileObject
+	APIKey string `json:"api_key,omitempty"`
+}
+
+// fileStore keeps files in a directory. The contents of each file are stored
+// under its ID and its description alongside in ID.json.
+type fileStore struct {
+	dir string
+	mu  sync.Mutex
+}
+
+func newFileStore(dir string) (*fileStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &fileStore{dir: dir}, nil
+}
+
+// newID returns a random ID with prefix
+func newID(prefix string) string {
+	return prefix + strings.ReplaceAll(uuid.New().String(), "-", "")
+}
+
+// validID reports whether id has prefix and is safe to use as a file name
+func validID(prefix, id string) bool {
+	rest, ok := strings.CutPrefix(id, prefix)
+	if !ok || rest == "" {
+		return false
+	}
+
+	for _, r := range rest {
+		if !('a' <= r && r <= 'z' || 'A' <= r && r <= 'Z' || '0' <= r && r <= '9' || r == '_' || r == '-') {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (fs *fileStore) path(id string) string {
+	return filepath.Join(fs.dir, id)
+}
+
+// create stores the contents of r as a new file owned by apiKey
+func (fs *fileStore) create(filename, purpose, apiKey string, r io.Reader) (*fileObject, error) {
+	f, err := os.CreateTemp(fs.dir, "upload-*.tmp")
+	if err != nil {
+		return nil, err
+	}
+	defer os.Remove(f.Name())
+
+	n, err := io.Copy(f, r)
+	if err != nil {
+		f.Close()
+		return nil, err
+	}
+
+	if err := f.Close(); err != nil {
+		return nil, err
+	}
+
+	obj := fileObject{
+		ID:        newID("file-"),
+		Object:    "file",
+		Bytes:     n,
+		CreatedAt: time.Now().Unix(),
+		Filename:  filepath.Base(filename),
+		Purpose:   purpose,
+	}
+
+	b, err := json.Marshal(savedFile{fileObject: obj, APIKey: apiKey})
+	if err != nil {
+		return nil, err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := os.Rename(f.Name(), fs.path(obj.ID)); err != nil {
+		return nil, err
+	}
+
+	if err := os.WriteFile(fs.path(obj.ID)+".json", b, 0o644); err != nil {
+		os.Remove(fs.path(obj.ID))
+		return nil, err
+	}
+
+	return &obj, nil
+}
+
+// get returns a file owned by apiKey. Files of other keys aren't found.
+func (fs *fileStore) get(id, apiKey string) (*fileObject, error) {
+	if !validID("file-", id) {
+		return nil, errFileNotFound
+	}
+
+	b, err := os.ReadFile(fs.path(id) + ".json")
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errFileNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var saved savedFile
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, err
+	}
+
+	if saved.APIKey != apiKey {
+		return nil, errFileNotFound
+	}
+
+	return &saved.fileObject, nil
+}
+
+// list returns the files owned by apiKey with purpose, or all of them if
+// purpose is empty, newest first
+func (fs *fileStore) list(purpose, apiKey string) ([]fileObject, error) {
+	matches, err := filepath.Glob(filepath.Join(fs.dir, "file-*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	objs := []fileObject{}
+	for _, match := range matches {
+		obj, err := fs.get(strings.TrimSuffix(filepath.Base(match), ".json"), apiKey)
+		if errors.Is(err, errFileNotFound) {
+			// owned by another key or deleted since the directory was read
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		if purpose == "" || obj.Purpose == purpose {
+			objs = append(objs, *obj)
+		}
+	}
+
+	slices.SortStableFunc(objs, func(a, b fileObject) int {
+		return cmp.Or(cmp.Compare(b.CreatedAt, a.CreatedAt), strings.Compare(a.ID, b.ID))
+	})
+
+	return objs, nil
+}
+
+// open returns the contents of a file owned by apiKey
+func (fs *fileStore) open(id, apiKey string) (*os.File, *fileObject, error) {
+	obj, err := fs.get(id, apiKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	f, err := os.Open(fs.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, nil, errFileNotFound
+	} else if err != nil {
+		return nil, nil, err
+	}
+
+	return f, obj, nil
+}
+
+func (fs *fileStore) delete(id, apiKey string) error {
+	if _, err := fs.get(id, apiKey); err != nil {
+		return err
+	}
+
+	fs.mu.Lock()
+	defer fs.mu.Unlock()
+
+	if err := os.Remove(fs.path(id) + ".json"); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	if err := os.Remove(fs.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+func (s *Server) CreateFileHandler(c *gin.Context) {
+	purpose := c.PostForm("purpose")
+	if purpose == "" {
+		abortWithError(c, http.StatusBadRequest, "purpose is required")
+		return
+	}
+
+	header, err := c.FormFile("file")
+	if err != nil {
+		abortWithError(c, http.StatusBadRequest, "file is required")
+		return
+	}
+
+	f, err := header.Open()
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer f.Close()
+
+	obj, err := s.files.create(header.Filename, purpose, c.GetString(apiKeyContextKey), f)
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, obj)
+}
+
+func (s *Server) ListFilesHandler(c *gin.Context) {
+	objs, err := s.files.list(c.Query("purpose"), c.GetString(apiKeyContextKey))
+	if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"object": "list", "data": objs})
+}
+
+func (s *Server) RetrieveFileHandler(c *gin.Context) {
+	obj, err := s.files.get(c.Param("id"), c.GetString(apiKeyContextKey))
+	if errors.Is(err, errFileNotFound) {
+		abortWithError(c, http.StatusNotFound, fmt.Sprintf("file %q not found", c.Param("id")))
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, obj)
+}
+
+func (s *Server) DeleteFileHandler(c *gin.Context) {
+	err := s.files.delete(c.Param("id"), c.GetString(apiKeyContextKey))
+	if errors.Is(err, errFileNotFound) {
+		abortWithError(c, http.StatusNotFound, fmt.Sprintf("file %q not found", c.Param("id")))
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	c.JSON(http.StatusOK, gin.H{"id": c.Param("id"), "object": "file", "deleted": true})
+}
+
+func (s *Server) FileContentHandler(c *gin.Context) {
+	f, obj, err := s.files.open(c.Param("id"), c.GetString(apiKeyContextKey))
+	if errors.Is(err, errFileNotFound) {
+		abortWithError(c, http.StatusNotFound, fmt.Sprintf("file %q not found", c.Param("id")))
+		return
+	} else if err != nil {
+		abortWithError(c, http.StatusInternalServerError, err.Error())
+		return
+	}
+	defer f.Close()
+
+	c.Header("Content-Disposition", fmt.Sprintf("attachment; filename=%q", obj.Filename))
+	c.DataFromReader(http.StatusOK, obj.Bytes, "application/octet-stream", f, nil)
+}
@@ -0,0 +1,219 @@
+package server
+
+import (
+	"bytes"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// uploadFile uploads content through the files API
+func uploadFile(t *testing.T, url, purpose, filename, content string) (*http.Response, fileObject) {
+	t.Helper()
+
+	var b bytes.Buffer
+	w := multipart.NewWriter(&b)
+	if purpose != "" {
+		if err := w.WriteField("purpose", purpose); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if filename != "" {
+		part, err := w.CreateFormFile("file", filename)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := io.WriteString(part, content); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if err := w.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	resp, err := http.Post(url+"/v1/files", w.FormDataContentType(), &b)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	var obj fileObject
+	if resp.StatusCode == http.StatusOK {
+		if err := json.NewDecoder(resp.Body).Decode(&obj); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	return resp, obj
+}
+
+func TestFiles(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	files, err := newFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{files: files}
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	resp, obj := uploadFile(t, srv.URL, "batch", "input.jsonl", "{}\n")
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	if obj.Object != "file" || obj.Filename != "input.jsonl" || obj.Purpose != "batch" || obj.Bytes != 3 || !validID("file-", obj.ID) {
+		t.Errorf("unexpected file %+v", obj)
+	}
+
+	t.Run("missing purpose", func(t *testing.T) {
+		resp, _ := uploadFile(t, srv.URL, "", "input.jsonl", "{}\n")
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("missing file", func(t *testing.T) {
+		resp, _ := uploadFile(t, srv.URL, "batch", "", "")
+		if resp.StatusCode != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("list", func(t *testing.T) {
+		if _, err := files.create("other.txt", "assistants", "", bytes.NewReader(nil)); err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.Get(srv.URL + "/v1/files?purpose=batch")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var list struct {
+			Object string       `json:"object"`
+			Data   []fileObject `json:"data"`
+		}
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			t.Fatal(err)
+		}
+
+		if list.Object != "list" || len(list.Data) != 1 || list.Data[0] != obj {
+			t.Errorf("unexpected list %+v", list)
+		}
+	})
+
+	t.Run("retrieve", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/v1/files/" + obj.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var got fileObject
+		if err := json.NewDecoder(resp.Body).Decode(&got); err != nil {
+			t.Fatal(err)
+		}
+
+		if got != obj {
+			t.Errorf("expected %+v, got %+v", obj, got)
+		}
+	})
+
+	t.Run("content", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/v1/files/" + obj.ID + "/content")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		b, err := io.ReadAll(resp.Body)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if string(b) != "{}\n" {
+			t.Errorf("unexpected content %q", b)
+		}
+	})
+
+	t.Run("invalid id", func(t *testing.T) {
+		resp, err := http.Get(srv.URL + "/v1/files/..%2Fbatches")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", resp.StatusCode)
+		}
+	})
+
+	t.Run("delete", func(t *testing.T) {
+		req, err := http.NewRequest(http.MethodDelete, srv.URL+"/v1/files/"+obj.ID, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", resp.StatusCode)
+		}
+
+		resp, err = http.Get(srv.URL + "/v1/files/" + obj.ID)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != http.StatusNotFound {
+			t.Errorf("expected status 404, got %d", resp.StatusCode)
+		}
+	})
+}
+
+func TestFileStoreAPIKeys(t *testing.T) {
+	files, err := newFileStore(t.TempDir())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	obj, err := files.create("input.jsonl", "batch", "alice", bytes.NewReader([]byte("{}\n")))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := files.get(obj.ID, "bob"); err != errFileNotFound {
+		t.Errorf("expected errFileNotFound, got %v", err)
+	}
+
+	if _, _, err := files.open(obj.ID, "bob"); err != errFileNotFound {
+		t.Errorf("expected errFileNotFound, got %v", err)
+	}
+
+	if objs, err := files.list("", "bob"); err != nil || len(objs) != 0 {
+		t.Errorf("expected no files, got %v %v", objs, err)
+	}
+
+	if err := files.delete(obj.ID, "bob"); err != errFileNotFound {
+		t.Errorf("expected errFileNotFound, got %v", err)
+	}
+
+	if objs, err := files.list("", "alice"); err != nil || len(objs) != 1 || objs[0] != *obj {
+		t.Errorf("expected the file of alice, got %v %v", objs, err)
+	}
+}
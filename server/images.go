@@ -40,6 +40,7 @@ var (
 	errCapabilityCompletion = errors.New("completion")
 	errCapabilityTools      = errors.New("tools")
 	errCapabilityInsert     = errors.New("insert")
+	errCapabilityRerank     = errors.New("rerank")
 )
 
 type Capability string
@@ -48,8 +49,13 @@ const (
 	CapabilityCompletion = Capability("completion")
 	CapabilityTools      = Capability("tools")
 	CapabilityInsert     = Capability("insert")
+	CapabilityRerank     = Capability("rerank")
 )
 
+// poolingTypeRank is the pooling type of reranking models, which score
+// pairs of texts with a classification head
+const poolingTypeRank = 4
+
 type registryOptions struct {
 	Insecure bool
 	Username string
@@ -67,6 +73,7 @@ type Model struct {
 	ParentModel    string
 	AdapterPaths   []string
 	ProjectorPaths []string
+	DraftPath      string
 	System         string
 	License        []string
 	Digest         string
@@ -82,7 +89,7 @@ func (m *Model) CheckCapabilities(caps ...Capability) error {
 	var errs []error
 	for _, cap := range caps {
 		switch cap {
-		case CapabilityCompletion:
+		case CapabilityCompletion, CapabilityRerank:
 			f, err := os.Open(m.ModelPath)
 			if err != nil {
 				slog.Error("couldn't open model file", "error", err)
@@ -97,8 +104,11 @@ func (m *Model) CheckCapabilities(caps ...Capability) error {
 				continue
 			}
 
-			if _, ok := ggml.KV()[fmt.Sprintf("%s.pooling_type", ggml.KV().Architecture())]; ok {
+			pooling, ok := ggml.KV()[fmt.Sprintf("%s.pooling_type", ggml.KV().Architecture())]
+			if cap == CapabilityCompletion && ok {
 				errs = append(errs, errCapabilityCompletion)
+			} else if cap == CapabilityRerank && pooling != uint32(poolingTypeRank) {
+				errs = append(errs, errCapabilityRerank)
 			}
 		case CapabilityTools:
 			if !slices.Contains(m.Template.Vars(), "tools") {
@@ -529,6 +539,12 @@ func CreateModel(ctx context.Context, name model.Name, modelFileDir, quantizatio
 				return err
 			}
 
+			if c.Name == "grammar" {
+				if err := llama.ValidateGrammar(c.Args); err != nil {
+					return fmt.Errorf("%w: %s", errBadGrammar, err)
+				}
+			}
+
 			for k, v := range ps {
 				if ks, ok := parameters[k].([]string); ok {
 					parameters[k] = append(ks, v.([]string)...)
@@ -0,0 +1,339 @@
+package server
+
+import (
+	"fmt"
+	"io"
+	"math"
+	"net/http"
+	"slices"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+)
+
+// metricKind is the Prometheus metric type of a metricVec
+type metricKind string
+
+const (
+	metricCounter   metricKind = "counter"
+	metricGauge     metricKind = "gauge"
+	metricHistogram metricKind = "histogram"
+)
+
+// defaultBuckets are histogram buckets in seconds suitable for request latencies
+var defaultBuckets = []float64{0.005, 0.01, 0.025, 0.05, 0.1, 0.25, 0.5, 1, 2.5, 5, 10, 30, 60, 120, 300}
+
+type metricValue struct {
+	labels []string
+
+	// value is the counter or gauge value, or the sum of observations for histograms
+	value float64
+
+	// counts holds the cumulative count for each bucket, histograms only
+	counts []uint64
+	count  uint64
+}
+
+// metricVec is a named metric partitioned by a fixed set of labels. It
+// implements the subset of the Prometheus data model needed by the server
+// without pulling in the client library.
+type metricVec struct {
+	name    string
+	help    string
+	kind    metricKind
+	labels  []string
+	buckets []float64
+
+	mu     sync.Mutex
+	values map[string]*metricValue
+}
+
+func newMetricVec(kind metricKind, name, help string, labels ...string) *metricVec {
+	return &metricVec{
+		name:   name,
+		help:   help,
+		kind:   kind,
+		labels: labels,
+		values: make(map[string]*metricValue),
+	}
+}
+
+func newHistogramVec(name, help string, buckets []float64, labels ...string) *metricVec {
+	m := newMetricVec(metricHistogram, name, help, labels...)
+	m.buckets = buckets
+	return m
+}
+
+// with returns the value for the label values, creating it if necessary.
+// The caller must hold m.mu.
+func (m *metricVec) with(labels []string) *metricValue {
+	if len(labels) != len(m.labels) {
+		panic(fmt.Sprintf("metric %s: expected %d labels, got %d", m.name, len(m.labels), len(labels)))
+	}
+
+	key := strings.Join(labels, "\xff")
+	v, ok := m.values[key]
+	if !ok {
+		v = &metricValue{labels: slices.Clone(labels)}
+		if m.kind == metricHistogram {
+			v.counts = make([]uint64, len(m.buckets))
+		}
+		m.values[key] = v
+	}
+
+	return v
+}
+
+// Add increments a counter or gauge by delta
+func (m *metricVec) Add(delta float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.with(labels).value += delta
+}
+
+// Inc increments a counter or gauge by one
+func (m *metricVec) Inc(labels ...string) {
+	m.Add(1, labels...)
+}
+
+// Set sets a gauge to value
+func (m *metricVec) Set(value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.with(labels).value = value
+}
+
+// Observe records a single histogram observation
+func (m *metricVec) Observe(value float64, labels ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	v := m.with(labels)
+	for i, b := range m.buckets {
+		if value <= b {
+			v.counts[i]++
+		}
+	}
+	v.count++
+	v.value += value
+}
+
+// Reset removes all values. It's used for gauges which are recomputed on
+// every scrape so that label sets which no longer exist are dropped.
+func (m *metricVec) Reset() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	clear(m.values)
+}
+
+// WriteTo writes the metric in the Prometheus text exposition format
+func (m *metricVec) WriteTo(w io.Writer) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var sb strings.Builder
+	fmt.Fprintf(&sb, "# HELP %s %s\n", m.name, m.help)
+	fmt.Fprintf(&sb, "# TYPE %s %s\n", m.name, m.kind)
+
+	keys := make([]string, 0, len(m.values))
+	for k := range m.values {
+		keys = append(keys, k)
+	}
+	slices.Sort(keys)
+
+	for _, k := range keys {
+		v := m.values[k]
+		switch m.kind {
+		case metricHistogram:
+			for i, b := range m.buckets {
+				fmt.Fprintf(&sb, "%s_bucket%s %d\n", m.name, m.formatLabels(v.labels, "le", formatFloat(b)), v.counts[i])
+			}
+			fmt.Fprintf(&sb, "%s_bucket%s %d\n", m.name, m.formatLabels(v.labels, "le", "+Inf"), v.count)
+			fmt.Fprintf(&sb, "%s_sum%s %s\n", m.name, m.formatLabels(v.labels), formatFloat(v.value))
+			fmt.Fprintf(&sb, "%s_count%s %d\n", m.name, m.formatLabels(v.labels), v.count)
+		default:
+			fmt.Fprintf(&sb, "%s%s %s\n", m.name, m.formatLabels(v.labels), formatFloat(v.value))
+		}
+	}
+
+	n, err := io.WriteString(w, sb.String())
+	return int64(n), err
+}
+
+func (m *metricVec) formatLabels(values []string, extra ...string) string {
+	if len(values) == 0 && len(extra) == 0 {
+		return ""
+	}
+
+	pairs := make([]string, 0, len(values)+len(extra)/2)
+	for i, v := range values {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, m.labels[i], escapeLabelValue(v)))
+	}
+
+	for i := 0; i+1 < len(extra); i += 2 {
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, extra[i], escapeLabelValue(extra[i+1])))
+	}
+
+	return "{" + strings.Join(pairs, ",") + "}"
+}
+
+var labelValueReplacer = strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`)
+
+// escapeLabelValue escapes backslash, double quote and newline, the only
+// escapes the exposition format defines, and drops other control characters.
+func escapeLabelValue(s string) string {
+	return labelValueReplacer.Replace(strings.Map(func(r rune) rune {
+		if r < 0x20 && r != '\n' {
+			return -1
+		}
+		return r
+	}, s))
+}
+
+func formatFloat(f float64) string {
+	switch {
+	case math.IsInf(f, 1):
+		return "+Inf"
+	case math.IsInf(f, -1):
+		return "-Inf"
+	case math.IsNaN(f):
+		return "NaN"
+	}
+
+	return strconv.FormatFloat(f, 'g', -1, 64)
+}
+
+// serverMetrics are the metrics exported on /metrics
+type serverMetrics struct {
+	requests        *metricVec
+	requestDuration *metricVec
+
+	promptTokens       *metricVec
+	evalTokens         *metricVec
+	promptEvalDuration *metricVec
+	evalDuration       *metricVec
+
+	queueDepth    *metricVec
+	loadedRunners *metricVec
+	runnerVRAM    *metricVec
+	runnerTotal   *metricVec
+
+	loadDuration *metricVec
+	loadFailures *metricVec
+	evictions    *metricVec
+	unloads      *metricVec
+}
+
+func newServerMetrics() *serverMetrics {
+	return &serverMetrics{
+		requests:        newMetricVec(metricCounter, "ollama_http_requests_total", "Total number of HTTP requests by handler, method and status code.", "handler", "method", "code"),
+		requestDuration: newHistogramVec("ollama_http_request_duration_seconds", "HTTP request latency by handler.", defaultBuckets, "handler", "method"),
+
+		promptTokens:       newMetricVec(metricCounter, "ollama_prompt_tokens_total", "Total number of prompt tokens evaluated.", "model"),
+		evalTokens:         newMetricVec(metricCounter, "ollama_generated_tokens_total", "Total number of tokens generated.", "model"),
+		promptEvalDuration: newMetricVec(metricCounter, "ollama_prompt_eval_duration_seconds_total", "Total time spent evaluating prompts.", "model"),
+		evalDuration:       newMetricVec(metricCounter, "ollama_eval_duration_seconds_total", "Total time spent generating tokens.", "model"),
+
+		queueDepth:    newMetricVec(metricGauge, "ollama_scheduler_queue_depth", "Number of requests waiting to be scheduled."),
+		loadedRunners: newMetricVec(metricGauge, "ollama_loaded_runners", "Number of loaded model runners."),
+		runnerVRAM:    newMetricVec(metricGauge, "ollama_runner_estimated_vram_bytes", "Estimated VRAM used by a loaded runner.", "model"),
+		runnerTotal:   newMetricVec(metricGauge, "ollama_runner_estimated_total_bytes", "Estimated total memory used by a loaded runner.", "model"),
+
+		loadDuration: newHistogramVec("ollama_model_load_duration_seconds", "Time taken to load a model runner.", defaultBuckets, "model"),
+		loadFailures: newMetricVec(metricCounter, "ollama_model_load_failures_total", "Total number of failed model loads.", "model"),
+		evictions:    newMetricVec(metricCounter, "ollama_runner_evictions_total", "Total number of runners evicted to make room for another request.", "reason"),
+		unloads:      newMetricVec(metricCounter, "ollama_runner_unloads_total", "Total number of runners unloaded."),
+	}
+}
+
+func (m *serverMetrics) all() []*metricVec {
+	return []*metricVec{
+		m.requests,
+		m.requestDuration,
+		m.promptTokens,
+		m.evalTokens,
+		m.promptEvalDuration,
+		m.evalDuration,
+		m.queueDepth,
+		m.loadedRunners,
+		m.runnerVRAM,
+		m.runnerTotal,
+		m.loadDuration,
+		m.loadFailures,
+		m.evictions,
+		m.unloads,
+	}
+}
+
+// observeCompletion records token counts and durations for a finished completion
+func (m *serverMetrics) observeCompletion(model string, r api.Metrics) {
+	m.promptTokens.Add(float64(r.PromptEvalCount), model)
+	m.evalTokens.Add(float64(r.EvalCount), model)
+	m.promptEvalDuration.Add(r.PromptEvalDuration.Seconds(), model)
+	m.evalDuration.Add(r.EvalDuration.Seconds(), model)
+}
+
+// metrics holds the process wide metrics. Like the Prometheus default
+// registry it is global so the scheduler and handlers can record values
+// without threading it through every call.
+var metrics = newServerMetrics()
+
+func metricsMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		start := time.Now()
+		c.Next()
+
+		// unmatched routes are not recorded to avoid unbounded label values
+		handler := c.FullPath()
+		if handler == "" {
+			return
+		}
+
+		metrics.requests.Inc(handler, c.Request.Method, strconv.Itoa(c.Writer.Status()))
+		metrics.requestDuration.Observe(time.Since(start).Seconds(), handler, c.Request.Method)
+	}
+}
+
+// updateSchedulerMetrics refreshes gauges which are derived from the scheduler state
+func (s *Server) updateSchedulerMetrics() {
+	metrics.queueDepth.Reset()
+	metrics.loadedRunners.Reset()
+	metrics.runnerVRAM.Reset()
+	metrics.runnerTotal.Reset()
+
+	if s.sched == nil {
+		return
+	}
+
+	metrics.queueDepth.Set(float64(len(s.sched.pendingReqCh) + s.sched.queue.len()))
+
+	s.sched.loadedMu.Lock()
+	defer s.sched.loadedMu.Unlock()
+
+	metrics.loadedRunners.Set(float64(len(s.sched.loaded)))
+	for _, runner := range s.sched.loaded {
+		name := runner.modelPath
+		if runner.model != nil {
+			name = runner.model.ShortName
+		}
+
+		metrics.runnerVRAM.Set(float64(runner.estimatedVRAM), name)
+		metrics.runnerTotal.Set(float64(runner.estimatedTotal), name)
+	}
+}
+
+func (s *Server) MetricsHandler(c *gin.Context) {
+	s.updateSchedulerMetrics()
+
+	c.Header("Content-Type", "text/plain; version=0.0.4; charset=utf-8")
+	c.Status(http.StatusOK)
+	for _, m := range metrics.all() {
+		if _, err := m.WriteTo(c.Writer); err != nil {
+			return
+		}
+	}
+}
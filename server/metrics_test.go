@@ -0,0 +1,172 @@
+package server
+
+import (
+	"bytes"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestMetricVecWriteTo(t *testing.T) {
+	cases := []struct {
+		name   string
+		metric func() *metricVec
+		expect string
+	}{
+		{
+			name: "counter",
+			metric: func() *metricVec {
+				m := newMetricVec(metricCounter, "test_total", "A test counter.", "model")
+				m.Inc("b")
+				m.Add(2.5, "a")
+				m.Inc("b")
+				return m
+			},
+			expect: `# HELP test_total A test counter.
+# TYPE test_total counter
+test_total{model="a"} 2.5
+test_total{model="b"} 2
+`,
+		},
+		{
+			name: "gauge without labels",
+			metric: func() *metricVec {
+				m := newMetricVec(metricGauge, "test_depth", "A test gauge.")
+				m.Set(3)
+				return m
+			},
+			expect: `# HELP test_depth A test gauge.
+# TYPE test_depth gauge
+test_depth 3
+`,
+		},
+		{
+			name: "histogram",
+			metric: func() *metricVec {
+				m := newHistogramVec("test_seconds", "A test histogram.", []float64{0.1, 1}, "handler")
+				m.Observe(0.05, "/api/chat")
+				m.Observe(0.5, "/api/chat")
+				m.Observe(5, "/api/chat")
+				return m
+			},
+			expect: `# HELP test_seconds A test histogram.
+# TYPE test_seconds histogram
+test_seconds_bucket{handler="/api/chat",le="0.1"} 1
+test_seconds_bucket{handler="/api/chat",le="1"} 2
+test_seconds_bucket{handler="/api/chat",le="+Inf"} 3
+test_seconds_sum{handler="/api/chat"} 5.55
+test_seconds_count{handler="/api/chat"} 3
+`,
+		},
+		{
+			name: "escaped labels",
+			metric: func() *metricVec {
+				m := newMetricVec(metricCounter, "test_total", "A test counter.", "model")
+				m.Inc("quote\"back\\slash\nnewline\ttab")
+				return m
+			},
+			expect: `# HELP test_total A test counter.
+# TYPE test_total counter
+test_total{model="quote\"back\\slash\nnewlinetab"} 1
+`,
+		},
+		{
+			name: "unicode labels",
+			metric: func() *metricVec {
+				m := newMetricVec(metricCounter, "test_total", "A test counter.", "model")
+				m.Inc("caf\u00e9\u00a0\u200b")
+				return m
+			},
+			expect: "# HELP test_total A test counter.\n# TYPE test_total counter\ntest_total{model=\"caf\u00e9\u00a0\u200b\"} 1\n",
+		},
+	}
+
+	for _, tt := range cases {
+		t.Run(tt.name, func(t *testing.T) {
+			var b bytes.Buffer
+			if _, err := tt.metric().WriteTo(&b); err != nil {
+				t.Fatal(err)
+			}
+
+			if diff := cmp.Diff(tt.expect, b.String()); diff != "" {
+				t.Errorf("mismatch (-want +got):\n%s", diff)
+			}
+		})
+	}
+}
+
+func TestMetricsHandler(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	s := &Server{
+		sched: &Scheduler{
+			pendingReqCh: make(chan *LlmRequest, 2),
+			loaded: map[string]*runnerRef{
+				"/path/to/model": {
+					model:          &Model{ShortName: "test:latest"},
+					modelPath:      "/path/to/model",
+					estimatedVRAM:  1024,
+					estimatedTotal: 2048,
+				},
+			},
+		},
+	}
+	s.sched.pendingReqCh <- &LlmRequest{}
+
+	metrics.observeCompletion("test:latest", api.Metrics{
+		PromptEvalCount:    10,
+		PromptEvalDuration: time.Second,
+		EvalCount:          20,
+		EvalDuration:       2 * time.Second,
+	})
+
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	// make a request so there's at least one request metric
+	resp, err := http.Get(srv.URL + "/api/version")
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	resp, err = http.Get(srv.URL + "/metrics")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", resp.StatusCode)
+	}
+
+	if ct := resp.Header.Get("Content-Type"); !strings.HasPrefix(ct, "text/plain; version=0.0.4") {
+		t.Errorf("unexpected content type %q", ct)
+	}
+
+	var b bytes.Buffer
+	if _, err := b.ReadFrom(resp.Body); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, expect := range []string{
+		`ollama_http_requests_total{handler="/api/version",method="GET",code="200"}`,
+		`ollama_http_request_duration_seconds_count{handler="/api/version",method="GET"}`,
+		`ollama_scheduler_queue_depth 1`,
+		`ollama_loaded_runners 1`,
+		`ollama_runner_estimated_vram_bytes{model="test:latest"} 1024`,
+		`ollama_runner_estimated_total_bytes{model="test:latest"} 2048`,
+		`ollama_prompt_tokens_total{model="test:latest"}`,
+		`ollama_generated_tokens_total{model="test:latest"}`,
+	} {
+		if !strings.Contains(b.String(), expect) {
+			t.Errorf("expected metrics to contain %q", expect)
+		}
+	}
+}
@@ -0,0 +1,113 @@
+package server
+
+import (
+	"net/http"
+	"slices"
+	"sync"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/llm"
+)
+
+// priorityHeader sets the priority of a request, one of high, normal or low
+const priorityHeader = "X-Ollama-Priority"
+
+// priorityMiddleware adds the priority of a request to its context
+func priorityMiddleware() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		p, err := llm.ParsePriority(c.GetHeader(priorityHeader))
+		if err != nil {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+
+		c.Request = c.Request.WithContext(llm.WithPriority(c.Request.Context(), p))
+		c.Next()
+	}
+}
+
+// pendingQueue holds the requests waiting to be scheduled. Requests are
+// scheduled by priority, aging the longer they wait, and in the order they
+// arrived within a priority.
+type pendingQueue struct {
+	mu   sync.Mutex
+	reqs []*LlmRequest
+
+	// ready is signaled when a request is added
+	ready chan struct{}
+}
+
+func (q *pendingQueue) readyCh() chan struct{} {
+	if q.ready == nil {
+		q.ready = make(chan struct{}, 1)
+	}
+
+	return q.ready
+}
+
+func (q *pendingQueue) push(req *LlmRequest) {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.reqs = append(q.reqs, req)
+
+	select {
+	case q.readyCh() <- struct{}{}:
+	default:
+	}
+}
+
+// wait returns a channel which receives once a request may have been added
+func (q *pendingQueue) wait() <-chan struct{} {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return q.readyCh()
+}
+
+// pop removes and returns the request to schedule next, or nil if there
+// are none. Requests which have been canceled are dropped.
+func (q *pendingQueue) pop() *LlmRequest {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	q.reqs = slices.DeleteFunc(q.reqs, func(req *LlmRequest) bool {
+		return req.ctx.Err() != nil
+	})
+
+	if len(q.reqs) == 0 {
+		return nil
+	}
+
+	var best int
+	for i, req := range q.reqs[1:] {
+		if req.priority.Aged(req.queuedAt) < q.reqs[best].priority.Aged(q.reqs[best].queuedAt) {
+			best = i + 1
+		}
+	}
+
+	req := q.reqs[best]
+	q.reqs = append(q.reqs[:best], q.reqs[best+1:]...)
+	return req
+}
+
+func (q *pendingQueue) len() int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+	return len(q.reqs)
+}
+
+// queued counts the requests waiting to be scheduled by priority
+func (q *pendingQueue) queued() map[llm.Priority]int {
+	q.mu.Lock()
+	defer q.mu.Unlock()
+
+	queued := make(map[llm.Priority]int)
+	for _, req := range q.reqs {
+		if req.ctx.Err() == nil {
+			queued[req.priority]++
+		}
+	}
+
+	return queued
+}
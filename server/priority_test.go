@@ -0,0 +1,188 @@
+package server
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestPendingQueue(t *testing.T) {
+	ctx := context.Background()
+	canceled, cancel := context.WithCancel(ctx)
+	cancel()
+
+	now := time.Now()
+	reqs := []*LlmRequest{
+		{ctx: ctx, priority: llm.PriorityLow, queuedAt: now},
+		{ctx: ctx, priority: llm.PriorityNormal, queuedAt: now},
+		{ctx: canceled, priority: llm.PriorityHigh, queuedAt: now},
+		{ctx: ctx, priority: llm.PriorityHigh, queuedAt: now},
+		{ctx: ctx, priority: llm.PriorityNormal, queuedAt: now},
+		{ctx: ctx, priority: llm.PriorityLow, queuedAt: now.Add(-2 * llm.PriorityAging)},
+	}
+
+	var q pendingQueue
+	for _, req := range reqs {
+		q.push(req)
+	}
+
+	select {
+	case <-q.wait():
+	default:
+		t.Error("expected queue to be ready")
+	}
+
+	if got := q.queued(); got[llm.PriorityHigh] != 1 || got[llm.PriorityNormal] != 2 || got[llm.PriorityLow] != 2 {
+		t.Errorf("unexpected queue %v", got)
+	}
+
+	// the aged low priority request goes with the high priority ones, after
+	// those that are already high
+	for _, want := range []*LlmRequest{reqs[3], reqs[5], reqs[1], reqs[4], reqs[0]} {
+		if got := q.pop(); got != want {
+			t.Errorf("expected %+v, got %+v", want, got)
+		}
+	}
+
+	if got := q.pop(); got != nil {
+		t.Errorf("expected empty queue, got %+v", got)
+	}
+}
+
+func TestPriorityHeader(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	s := &Server{}
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	for priority, status := range map[string]int{
+		"":       http.StatusOK,
+		"high":   http.StatusOK,
+		"low":    http.StatusOK,
+		"urgent": http.StatusBadRequest,
+	} {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/version", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(priorityHeader, priority)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != status {
+			t.Errorf("%q: expected status %d, got %d", priority, status, resp.StatusCode)
+		}
+	}
+}
+
+func TestHighPriorityScope(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+
+	p := filepath.Join(t.TempDir(), "keys.json")
+	if err := os.WriteFile(p, []byte(`[
+		{"name": "team", "key": "inference-key", "scopes": ["inference"]},
+		{"name": "ops", "key": "manage-key", "scopes": ["manage-models"]}
+	]`), 0o600); err != nil {
+		t.Fatal(err)
+	}
+
+	keys, err := loadAPIKeys(p)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	s := &Server{apiKeys: keys}
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	cases := []struct {
+		key, priority string
+		status        int
+	}{
+		{"inference-key", "low", http.StatusOK},
+		{"inference-key", "normal", http.StatusOK},
+		{"inference-key", "high", http.StatusForbidden},
+		{"manage-key", "high", http.StatusOK},
+	}
+
+	for _, tt := range cases {
+		req, err := http.NewRequest(http.MethodGet, srv.URL+"/api/version", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Authorization", "Bearer "+tt.key)
+		req.Header.Set(priorityHeader, tt.priority)
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		if resp.StatusCode != tt.status {
+			t.Errorf("%s %s: expected status %d, got %d", tt.key, tt.priority, tt.status, resp.StatusCode)
+		}
+	}
+}
+
+func TestBatchPriority(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	// the scheduler isn't run so requests stay queued
+	s := &Server{
+		sched: &Scheduler{
+			pendingReqCh: make(chan *LlmRequest, 1),
+		},
+	}
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model: "test",
+		Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, llm.KV{
+			"general.architecture": "bert",
+			"bert.pooling_type":    uint32(1),
+			"bert.context_length":  uint32(512),
+		}, []llm.Tensor{})),
+		Stream: &stream,
+	})
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	q := &batchQueue{handler: s.batchRoutes()}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		q.do(ctx, batch{}, batchInput{CustomID: "a", URL: "/v1/embeddings", Body: []byte(`{"model": "test", "input": "hello"}`)})
+	}()
+
+	select {
+	case req := <-s.sched.pendingReqCh:
+		if req.priority != llm.PriorityLow {
+			t.Errorf("expected low priority, got %s", req.priority)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("timed out waiting for the request to be queued")
+	}
+
+	cancel()
+	<-done
+}
@@ -0,0 +1,199 @@
+package server
+
+import (
+	"fmt"
+	"math"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/envconfig"
+)
+
+// rateLimitContextKey is the gin context key holding the client a request
+// is accounted to
+const rateLimitContextKey = "ollama.rateLimitClient"
+
+type tokenUsage struct {
+	at     time.Time
+	tokens uint64
+}
+
+type clientUsage struct {
+	// requests are the start times of requests within the last minute
+	requests []time.Time
+
+	inflight uint
+
+	// tokens are the token counts recorded within the quota window
+	tokens []tokenUsage
+	total  uint64
+}
+
+// rateLimiter enforces per client limits on requests per minute, in-flight
+// requests and tokens per quota window. A zero limit disables that check.
+type rateLimiter struct {
+	rpm         uint
+	concurrency uint
+	quota       uint64
+	window      time.Duration
+
+	mu      sync.Mutex
+	clients map[string]*clientUsage
+
+	// now is overridden in tests
+	now func() time.Time
+}
+
+// newRateLimiter returns a limiter configured from the environment or nil
+// if no limits are set
+func newRateLimiter() *rateLimiter {
+	rpm, concurrency, quota := envconfig.RateLimit(), envconfig.MaxClientRequests(), envconfig.TokenQuota()
+	if rpm == 0 && concurrency == 0 && quota == 0 {
+		return nil
+	}
+
+	return &rateLimiter{
+		rpm:         rpm,
+		concurrency: concurrency,
+		quota:       quota,
+		window:      envconfig.TokenQuotaWindow(),
+		clients:     make(map[string]*clientUsage),
+		now:         time.Now,
+	}
+}
+
+// prune drops usage which has fallen out of the limit windows. The caller
+// must hold l.mu.
+func (l *rateLimiter) prune(u *clientUsage, now time.Time) {
+	i := 0
+	for i < len(u.requests) && now.Sub(u.requests[i]) >= time.Minute {
+		i++
+	}
+	u.requests = u.requests[i:]
+
+	i = 0
+	for i < len(u.tokens) && now.Sub(u.tokens[i].at) >= l.window {
+		u.total -= u.tokens[i].tokens
+		i++
+	}
+	u.tokens = u.tokens[i:]
+}
+
+// acquire admits a request from client. If a limit has been reached it
+// returns an error and how long the client should wait before retrying.
+// Otherwise the caller must call release once the request completes.
+func (l *rateLimiter) acquire(client string) (time.Duration, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	now := l.now()
+	u, ok := l.clients[client]
+	if !ok {
+		u = &clientUsage{}
+		l.clients[client] = u
+	}
+
+	l.prune(u, now)
+
+	if l.rpm > 0 && uint(len(u.requests)) >= l.rpm {
+		return u.requests[0].Add(time.Minute).Sub(now), fmt.Errorf("rate limit of %d requests per minute exceeded", l.rpm)
+	}
+
+	if l.concurrency > 0 && u.inflight >= l.concurrency {
+		return time.Second, fmt.Errorf("limit of %d concurrent requests exceeded", l.concurrency)
+	}
+
+	if l.quota > 0 && u.total >= l.quota {
+		// wait until enough of the oldest usage expires to go below the quota
+		var freed uint64
+		for _, t := range u.tokens {
+			freed += t.tokens
+			if u.total-freed < l.quota {
+				return t.at.Add(l.window).Sub(now), fmt.Errorf("token quota of %d tokens per %s exceeded", l.quota, l.window)
+			}
+		}
+	}
+
+	if l.rpm > 0 {
+		u.requests = append(u.requests, now)
+	}
+
+	u.inflight++
+	return 0, nil
+}
+
+func (l *rateLimiter) release(client string) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	u, ok := l.clients[client]
+	if !ok {
+		return
+	}
+
+	u.inflight--
+	l.prune(u, l.now())
+	if u.inflight == 0 && len(u.requests) == 0 && len(u.tokens) == 0 {
+		delete(l.clients, client)
+	}
+}
+
+// record adds tokens used by client towards its quota
+func (l *rateLimiter) record(client string, tokens int) {
+	if l == nil || l.quota == 0 || client == "" || tokens <= 0 {
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+
+	u, ok := l.clients[client]
+	if !ok {
+		u = &clientUsage{}
+		l.clients[client] = u
+	}
+
+	u.tokens = append(u.tokens, tokenUsage{at: l.now(), tokens: uint64(tokens)})
+	u.total += uint64(tokens)
+}
+
+// rateLimitClient identifies the client of a request by its API key if
+// authenticated and its remote address otherwise
+func rateLimitClient(c *gin.Context) string {
+	if name := c.GetString(apiKeyContextKey); name != "" {
+		return "key:" + name
+	}
+
+	return "ip:" + c.ClientIP()
+}
+
+// rateLimit rejects requests from clients which have exceeded their limits
+func (s *Server) rateLimit() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		if s.limiter == nil {
+			c.Next()
+			return
+		}
+
+		client := rateLimitClient(c)
+		retryAfter, err := s.limiter.acquire(client)
+		if err != nil {
+			c.Header("Retry-After", strconv.Itoa(int(math.Ceil(max(retryAfter, time.Second).Seconds()))))
+			abortWithError(c, http.StatusTooManyRequests, err.Error())
+			return
+		}
+		defer s.limiter.release(client)
+
+		c.Set(rateLimitContextKey, client)
+		c.Next()
+	}
+}
+
+// recordUsage counts tokens used by a request towards its client's quota
+func (s *Server) recordUsage(c *gin.Context, tokens int) {
+	s.limiter.record(c.GetString(rateLimitContextKey), tokens)
+}
@@ -0,0 +1,166 @@
+package server
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestRateLimiter(t *testing.T) {
+	now := time.Date(2024, 1, 1, 0, 0, 0, 0, time.UTC)
+	newLimiter := func(rpm, concurrency uint, quota uint64) *rateLimiter {
+		return &rateLimiter{
+			rpm:         rpm,
+			concurrency: concurrency,
+			quota:       quota,
+			window:      time.Hour,
+			clients:     make(map[string]*clientUsage),
+			now:         func() time.Time { return now },
+		}
+	}
+
+	t.Run("requests per minute", func(t *testing.T) {
+		l := newLimiter(2, 0, 0)
+		for range 2 {
+			if _, err := l.acquire("a"); err != nil {
+				t.Fatal(err)
+			}
+			l.release("a")
+		}
+
+		retryAfter, err := l.acquire("a")
+		if err == nil {
+			t.Fatal("expected rate limit error")
+		}
+
+		if retryAfter != time.Minute {
+			t.Errorf("expected retry after 1m, got %s", retryAfter)
+		}
+
+		// other clients are unaffected
+		if _, err := l.acquire("b"); err != nil {
+			t.Fatal(err)
+		}
+		l.release("b")
+
+		now = now.Add(time.Minute)
+		if _, err := l.acquire("a"); err != nil {
+			t.Fatal(err)
+		}
+		l.release("a")
+	})
+
+	t.Run("concurrency", func(t *testing.T) {
+		l := newLimiter(0, 1, 0)
+		if _, err := l.acquire("a"); err != nil {
+			t.Fatal(err)
+		}
+
+		if _, err := l.acquire("a"); err == nil {
+			t.Fatal("expected concurrency error")
+		}
+
+		l.release("a")
+		if _, err := l.acquire("a"); err != nil {
+			t.Fatal(err)
+		}
+		l.release("a")
+
+		if len(l.clients) != 0 {
+			t.Errorf("expected idle clients to be removed, got %d", len(l.clients))
+		}
+	})
+
+	t.Run("token quota", func(t *testing.T) {
+		l := newLimiter(0, 0, 100)
+		l.record("a", 60)
+		now = now.Add(10 * time.Minute)
+		l.record("a", 60)
+
+		retryAfter, err := l.acquire("a")
+		if err == nil {
+			t.Fatal("expected quota error")
+		}
+
+		if retryAfter != 50*time.Minute {
+			t.Errorf("expected retry after 50m, got %s", retryAfter)
+		}
+
+		now = now.Add(50 * time.Minute)
+		if _, err := l.acquire("a"); err != nil {
+			t.Fatal(err)
+		}
+		l.release("a")
+	})
+}
+
+func TestRateLimitMiddleware(t *testing.T) {
+	t.Setenv("OLLAMA_MODELS", t.TempDir())
+	t.Setenv("OLLAMA_RATE_LIMIT", "1")
+
+	s := &Server{limiter: newRateLimiter()}
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	// the first request is admitted and fails validation
+	resp, err := http.Post(srv.URL+"/api/embed", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	resp.Body.Close()
+
+	if resp.StatusCode != http.StatusBadRequest {
+		t.Fatalf("expected status 400, got %d", resp.StatusCode)
+	}
+
+	resp, err = http.Post(srv.URL+"/api/embed", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", resp.StatusCode)
+	}
+
+	if retryAfter := resp.Header.Get("Retry-After"); retryAfter == "" {
+		t.Error("expected Retry-After header")
+	}
+
+	var body struct {
+		Error string `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&body); err != nil {
+		t.Fatal(err)
+	}
+
+	if body.Error != "rate limit of 1 requests per minute exceeded" {
+		t.Errorf("unexpected error %q", body.Error)
+	}
+
+	resp, err = http.Post(srv.URL+"/v1/embeddings", "application/json", strings.NewReader(`{}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusTooManyRequests {
+		t.Fatalf("expected status 429, got %d", resp.StatusCode)
+	}
+
+	var openaiBody struct {
+		Error struct {
+			Type string `json:"type"`
+		} `json:"error"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&openaiBody); err != nil {
+		t.Fatal(err)
+	}
+
+	if openaiBody.Error.Type != "rate_limit_error" {
+		t.Errorf("expected rate_limit_error, got %q", openaiBody.Error.Type)
+	}
+}
@@ -0,0 +1,187 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"fmt"
+	"net/http"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/uuid"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+// activeRequest is a generate, chat or embed request which can be listed
+// and cancelled while it is queued or running. All methods accept a nil
+// receiver.
+type activeRequest struct {
+	id       string
+	endpoint string
+	apiKey   string
+	priority llm.Priority
+	created  time.Time
+	cancel   context.CancelCauseFunc
+
+	mu      sync.Mutex
+	model   string
+	started time.Time
+}
+
+type activeRequestContextKey struct{}
+
+func activeRequestFromContext(ctx context.Context) *activeRequest {
+	req, _ := ctx.Value(activeRequestContextKey{}).(*activeRequest)
+	return req
+}
+
+// setModel records the model the request is waiting for
+func (r *activeRequest) setModel(name string) {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	r.model = name
+}
+
+// setRunning records that the request has been scheduled on a runner
+func (r *activeRequest) setRunning() {
+	if r == nil {
+		return
+	}
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.started.IsZero() {
+		r.started = time.Now().UTC()
+	}
+}
+
+func (r *activeRequest) describe() api.ActiveRequest {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	desc := api.ActiveRequest{
+		ID:        r.id,
+		Endpoint:  r.endpoint,
+		Model:     r.model,
+		Priority:  r.priority.String(),
+		Status:    "queued",
+		CreatedAt: r.created,
+	}
+
+	if !r.started.IsZero() {
+		started := r.started
+		desc.Status = "running"
+		desc.StartedAt = &started
+	}
+
+	return desc
+}
+
+// activeRequests tracks the requests that are queued or running
+type activeRequests struct {
+	mu   sync.Mutex
+	reqs map[string]*activeRequest
+}
+
+func (ar *activeRequests) add(req *activeRequest) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	if ar.reqs == nil {
+		ar.reqs = make(map[string]*activeRequest)
+	}
+
+	ar.reqs[req.id] = req
+}
+
+func (ar *activeRequests) remove(id string) {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+	delete(ar.reqs, id)
+}
+
+// get returns a request made with apiKey
+func (ar *activeRequests) get(id, apiKey string) *activeRequest {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	if req, ok := ar.reqs[id]; ok && req.apiKey == apiKey {
+		return req
+	}
+
+	return nil
+}
+
+// list returns the requests made with apiKey, oldest first
+func (ar *activeRequests) list(apiKey string) []api.ActiveRequest {
+	ar.mu.Lock()
+	defer ar.mu.Unlock()
+
+	reqs := []api.ActiveRequest{}
+	for _, req := range ar.reqs {
+		if req.apiKey == apiKey {
+			reqs = append(reqs, req.describe())
+		}
+	}
+
+	slices.SortStableFunc(reqs, func(a, b api.ActiveRequest) int {
+		return cmp.Or(a.CreatedAt.Compare(b.CreatedAt), strings.Compare(a.ID, b.ID))
+	})
+
+	return reqs
+}
+
+// trackRequest assigns a request an ID, returned in the X-Request-Id
+// header, by which it can be listed and cancelled until it completes
+func (s *Server) trackRequest() gin.HandlerFunc {
+	return func(c *gin.Context) {
+		ctx, cancel := context.WithCancelCause(c.Request.Context())
+		defer cancel(nil)
+
+		req := &activeRequest{
+			id:       uuid.New().String(),
+			endpoint: c.Request.URL.Path,
+			apiKey:   c.GetString(apiKeyContextKey),
+			priority: llm.PriorityFromContext(ctx),
+			created:  time.Now().UTC(),
+			cancel:   cancel,
+		}
+
+		// share the ID of the audit record if there is one
+		if rec := auditFromContext(ctx); rec != nil {
+			req.id = rec.ID
+		}
+
+		c.Header("X-Request-Id", req.id)
+		c.Request = c.Request.WithContext(context.WithValue(ctx, activeRequestContextKey{}, req))
+
+		s.requests.add(req)
+		defer s.requests.remove(req.id)
+
+		c.Next()
+	}
+}
+
+func (s *Server) ListRequestsHandler(c *gin.Context) {
+	c.JSON(http.StatusOK, api.ListRequestsResponse{Requests: s.requests.list(c.GetString(apiKeyContextKey))})
+}
+
+func (s *Server) CancelRequestHandler(c *gin.Context) {
+	req := s.requests.get(c.Param("id"), c.GetString(apiKeyContextKey))
+	if req == nil {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("request %q not found", c.Param("id"))})
+		return
+	}
+
+	req.cancel(llm.ErrCancelled)
+	c.Status(http.StatusOK)
+}
@@ -0,0 +1,123 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestCancelRequest(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	s := &Server{}
+	r := gin.New()
+	r.Use(priorityMiddleware())
+	r.POST("/api/generate", s.trackRequest(), func(c *gin.Context) {
+		ctx := c.Request.Context()
+		activeRequestFromContext(ctx).setModel("test")
+
+		<-ctx.Done()
+		if !errors.Is(context.Cause(ctx), llm.ErrCancelled) {
+			t.Errorf("expected cancelled, got %v", context.Cause(ctx))
+		}
+
+		c.Status(http.StatusOK)
+	})
+	r.GET("/api/requests", s.ListRequestsHandler)
+	r.DELETE("/api/requests/:id", s.CancelRequestHandler)
+
+	srv := httptest.NewServer(r)
+	defer srv.Close()
+
+	list := func() []api.ActiveRequest {
+		t.Helper()
+
+		resp, err := http.Get(srv.URL + "/api/requests")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+
+		var list api.ListRequestsResponse
+		if err := json.NewDecoder(resp.Body).Decode(&list); err != nil {
+			t.Fatal(err)
+		}
+
+		return list.Requests
+	}
+
+	cancel := func(id string) int {
+		t.Helper()
+
+		req, err := http.NewRequest(http.MethodDelete, srv.URL+"/api/requests/"+id, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+
+		return resp.StatusCode
+	}
+
+	done := make(chan *http.Response)
+	go func() {
+		req, err := http.NewRequest(http.MethodPost, srv.URL+"/api/generate", nil)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		req.Header.Set(priorityHeader, "low")
+
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		resp.Body.Close()
+
+		done <- resp
+	}()
+
+	var reqs []api.ActiveRequest
+	for len(reqs) == 0 {
+		reqs = list()
+	}
+
+	if len(reqs) != 1 {
+		t.Fatalf("expected 1 request, got %d", len(reqs))
+	}
+
+	active := reqs[0]
+	if active.ID == "" || active.Endpoint != "/api/generate" || active.Model != "test" || active.Priority != "low" || active.Status != "queued" {
+		t.Errorf("unexpected request %+v", active)
+	}
+
+	if status := cancel("unknown"); status != http.StatusNotFound {
+		t.Errorf("expected status 404, got %d", status)
+	}
+
+	if status := cancel(active.ID); status != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", status)
+	}
+
+	resp := <-done
+	if got := resp.Header.Get("X-Request-Id"); got != active.ID {
+		t.Errorf("expected request id %q, got %q", active.ID, got)
+	}
+
+	if reqs := list(); len(reqs) != 0 {
+		t.Errorf("expected no requests, got %+v", reqs)
+	}
+}
@@ -18,6 +18,7 @@ import (
 	"path/filepath"
 	"slices"
 	"strings"
+	"sync"
 	"syscall"
 	"time"
 
@@ -25,10 +26,13 @@ import (
 	"github.com/gin-gonic/gin"
 	"golang.org/x/sync/errgroup"
 
+	"github.com/ollama/ollama/anthropic"
 	"github.com/ollama/ollama/api"
 	"github.com/ollama/ollama/build"
 	"github.com/ollama/ollama/discover"
 	"github.com/ollama/ollama/envconfig"
+	"github.com/ollama/ollama/grammar"
+	"github.com/ollama/ollama/llama"
 	"github.com/ollama/ollama/llm"
 	"github.com/ollama/ollama/openai"
 	"github.com/ollama/ollama/parser"
@@ -44,6 +48,43 @@ var mode string = gin.DebugMode
 type Server struct {
 	addr  net.Addr
 	sched *Scheduler
+
+	// apiKeys are the keys accepted by the server. Authentication is
+	// disabled when nil.
+	apiKeys apiKeys
+
+	// limiter enforces per client limits. Limits are disabled when nil.
+	limiter *rateLimiter
+
+	// audit records every request. Auditing is disabled when nil.
+	audit *auditLog
+
+	// files stores files uploaded through the OpenAI compatible files API
+	files *fileStore
+
+	// batches runs batches of OpenAI compatible requests
+	batches *batchQueue
+
+	// sessions stores the messages of chat sessions
+	sessions *sessionStore
+
+	// embedCache stores embeddings so that repeated inputs skip the runner.
+	// Caching is disabled when nil.
+	embedCache *embedCache
+
+	// collections stores documents and their embeddings for retrieval
+	collections *collectionStore
+
+	// aliases maps names to the models requests for them are sent to
+	aliases *aliasStore
+
+	// requests tracks the generate, chat and embed requests in progress so
+	// that they can be cancelled
+	requests activeRequests
+
+	// pulls holds the pulls streamed as server-sent events so that their
+	// clients can reconnect
+	pulls pullStreams
 }
 
 func init() {
@@ -61,6 +102,8 @@ func init() {
 var (
 	errRequired    = errors.New("is required")
 	errBadTemplate = errors.New("template error")
+	errBadGrammar  = errors.New("grammar error")
+	errDraftModel  = errors.New("draft model")
 )
 
 func modelOptions(model *Model, requestOpts map[string]interface{}) (api.Options, error) {
@@ -78,7 +121,33 @@ func modelOptions(model *Model, requestOpts map[string]interface{}) (api.Options
 
 // scheduleRunner schedules a runner after validating inputs such as capabilities and model options.
 // It returns the allocated runner, model instance, and consolidated options if successful and error otherwise.
+// If the model fails to load, the fallbacks of the alias the request was made for are tried in order.
 func (s *Server) scheduleRunner(ctx context.Context, name string, caps []Capability, requestOpts map[string]any, keepAlive *api.Duration) (llm.LlamaServer, *Model, *api.Options, error) {
+	r, m, opts, err := s.loadRunner(ctx, name, caps, requestOpts, keepAlive)
+	if err == nil {
+		return r, m, opts, nil
+	}
+
+	failed, ferr := name, err
+	for _, fallback := range aliasFallbacksFromContext(ctx) {
+		if !fallbackable(ctx, ferr) {
+			break
+		}
+
+		slog.Warn("failed to load model, trying fallback", "model", failed, "fallback", fallback, "error", ferr)
+		r, m, opts, ferr = s.loadRunner(ctx, fallback, caps, requestOpts, keepAlive)
+		if ferr == nil {
+			return r, m, opts, nil
+		}
+
+		failed = fallback
+	}
+
+	return nil, nil, nil, err
+}
+
+// loadRunner schedules a runner for the model name
+func (s *Server) loadRunner(ctx context.Context, name string, caps []Capability, requestOpts map[string]any, keepAlive *api.Duration) (llm.LlamaServer, *Model, *api.Options, error) {
 	if name == "" {
 		return nil, nil, nil, fmt.Errorf("model %w", errRequired)
 	}
@@ -97,14 +166,37 @@ func (s *Server) scheduleRunner(ctx context.Context, name string, caps []Capabil
 		return nil, nil, nil, err
 	}
 
+	if opts.DraftModel != "" {
+		draft, err := GetModel(opts.DraftModel)
+		if errors.Is(err, os.ErrNotExist) {
+			return nil, nil, nil, fmt.Errorf("%w %q not found", errDraftModel, opts.DraftModel)
+		} else if err != nil {
+			return nil, nil, nil, fmt.Errorf("%w %q: %w", errDraftModel, opts.DraftModel, err)
+		}
+
+		if err := draft.CheckCapabilities(CapabilityCompletion); err != nil {
+			return nil, nil, nil, fmt.Errorf("%w %s %w", errDraftModel, opts.DraftModel, err)
+		}
+
+		model.DraftPath = draft.ModelPath
+	}
+
+	active := activeRequestFromContext(ctx)
+	active.setModel(name)
+
 	runnerCh, errCh := s.sched.GetRunner(ctx, model, opts, keepAlive)
 	var runner *runnerRef
 	select {
 	case runner = <-runnerCh:
 	case err = <-errCh:
 		return nil, nil, nil, err
+	case <-ctx.Done():
+		return nil, nil, nil, ctx.Err()
 	}
 
+	active.setRunning()
+
+	auditFromContext(ctx).setModel(name, model, &opts)
 	return runner.llama, model, &opts, nil
 }
 
@@ -119,7 +211,9 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		return
 	}
 
-	model, err := GetModel(req.Model)
+	req.Model = s.resolveModel(c, req.Model)
+
+	model, name, err := getModel(c.Request.Context(), req.Model)
 	if err != nil {
 		switch {
 		case os.IsNotExist(err):
@@ -132,6 +226,8 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		return
 	}
 
+	req.Model = name
+
 	// expire the runner
 	if req.Prompt == "" && req.KeepAlive != nil && int(req.KeepAlive.Seconds()) == 0 {
 		s.sched.expireRunner(model)
@@ -146,8 +242,15 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		return
 	}
 
-	if req.Format != "" && req.Format != "json" {
-		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "format must be empty or \"json\""})
+	grammar, err := requestGrammar(req.Format, req.Grammar, req.Regex, req.Choices)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err := checkLogprobs(req.Logprobs, req.TopLogprobs); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if req.N < 0 || req.N > maxCompletions {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("n must be between 1 and %d", maxCompletions)})
 		return
 	} else if req.Raw && (req.Template != "" || req.System != "" || len(req.Context) > 0) {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "raw mode does not support template, system, or context"})
@@ -168,6 +271,15 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		return
 	}
 
+	// fall back to the grammar set in the model's or request's options
+	if grammar == "" && opts.Grammar != "" {
+		if err := llama.ValidateGrammar(opts.Grammar); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		grammar = opts.Grammar
+	}
+
 	checkpointLoaded := time.Now()
 
 	// load the model
@@ -181,7 +293,7 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 		return
 	}
 
-	isMllama := checkMllamaModelFamily(model)
+	isMllama := checkMllamaModelFamily(m)
 	if isMllama && len(req.Images) > 1 {
 		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "this model only supports one image: more than one image sent"})
 		return
@@ -250,23 +362,36 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 
 	slog.Debug("generate request", "prompt", prompt, "images", images)
 
+	audit := auditFromContext(c.Request.Context())
+	audit.setPrompt(prompt)
+
+	n := max(req.N, 1)
+
 	ch := make(chan any)
 	go func() {
 		// TODO (jmorganca): avoid building the response twice both here and below
-		var sb strings.Builder
+		sbs := make([]strings.Builder, n)
+		var promptCounted bool
 		defer close(ch)
 		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
-			Prompt:  prompt,
-			Images:  images,
-			Format:  req.Format,
-			Options: opts,
+			Prompt:      prompt,
+			Images:      images,
+			Format:      req.Format,
+			Grammar:     grammar,
+			Options:     opts,
+			Logprobs:    req.Logprobs,
+			TopLogprobs: req.TopLogprobs,
+			N:           n,
 		}, func(cr llm.CompletionResponse) {
 			res := api.GenerateResponse{
-				Model:      req.Model,
-				CreatedAt:  time.Now().UTC(),
-				Response:   cr.Content,
-				Done:       cr.Done,
-				DoneReason: cr.DoneReason,
+				Model:        req.Model,
+				CreatedAt:    time.Now().UTC(),
+				Response:     cr.Content,
+				Done:         cr.Done,
+				DoneReason:   cr.DoneReason,
+				StopSequence: cr.StopSequence,
+				Logprobs:     cr.Logprobs,
+				Index:        cr.Index,
 				Metrics: api.Metrics{
 					PromptEvalCount:    cr.PromptEvalCount,
 					PromptEvalDuration: cr.PromptEvalDuration,
@@ -275,16 +400,34 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 				},
 			}
 
-			if _, err := sb.WriteString(cr.Content); err != nil {
+			if _, err := sbs[cr.Index].WriteString(cr.Content); err != nil {
 				ch <- gin.H{"error": err.Error()}
 			}
 
+			if cr.Index == 0 {
+				audit.appendResponse(cr.Content)
+			}
+
 			if cr.Done {
 				res.TotalDuration = time.Since(checkpointStart)
 				res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+				metrics.observeCompletion(req.Model, res.Metrics)
+
+				// the prompt is shared by every completion
+				tokens := res.EvalCount
+				if !promptCounted {
+					tokens += res.PromptEvalCount
+					promptCounted = true
+				}
+				s.recordUsage(c, tokens)
+
+				if cr.Index == 0 {
+					audit.setMetrics(res.Metrics, res.DoneReason)
+				}
 
 				if !req.Raw {
-					tokens, err := r.Tokenize(c.Request.Context(), prompt+sb.String())
+					// a cancelled request still returns its context
+					tokens, err := r.Tokenize(context.WithoutCancel(c.Request.Context()), prompt+sbs[cr.Index].String())
 					if err != nil {
 						ch <- gin.H{"error": err.Error()}
 						return
@@ -300,13 +443,15 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 	}()
 
 	if req.Stream != nil && !*req.Stream {
-		var r api.GenerateResponse
-		var sb strings.Builder
+		rs := make([]api.GenerateResponse, n)
+		sbs := make([]strings.Builder, n)
+		logprobs := make([][]api.TokenLogprob, n)
 		for rr := range ch {
 			switch t := rr.(type) {
 			case api.GenerateResponse:
-				sb.WriteString(t.Response)
-				r = t
+				sbs[t.Index].WriteString(t.Response)
+				logprobs[t.Index] = append(logprobs[t.Index], t.Logprobs...)
+				rs[t.Index] = t
 			case gin.H:
 				msg, ok := t["error"].(string)
 				if !ok {
@@ -321,7 +466,16 @@ func (s *Server) GenerateHandler(c *gin.Context) {
 			}
 		}
 
-		r.Response = sb.String()
+		for i := range rs {
+			rs[i].Response = sbs[i].String()
+			rs[i].Logprobs = logprobs[i]
+		}
+
+		r := rs[0]
+		if n > 1 {
+			r.Choices = rs
+		}
+
 		c.JSON(http.StatusOK, r)
 		return
 	}
@@ -348,6 +502,15 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		truncate = false
 	}
 
+	format := cmp.Or(req.EncodingFormat, "float")
+	if !slices.Contains(embeddingFormats, format) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("invalid encoding_format %q", req.EncodingFormat)})
+		return
+	} else if req.Dimensions < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "dimensions must not be negative"})
+		return
+	}
+
 	var input []string
 
 	switch i := req.Input.(type) {
@@ -370,45 +533,162 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		}
 	}
 
+	req.Model = s.resolveModel(c, req.Model)
+
+	embeddings := make([][]float32, len(input))
+
+	// look up the cache before scheduling so that a request which is
+	// entirely cached doesn't need a runner
+	var digest string
+	var cached int
+	keys := make([]string, len(input))
+	if s.embedCache != nil {
+		if m, err := GetModel(req.Model); err == nil {
+			digest = m.Digest
+			for i, text := range input {
+				key := embedCacheKey(text, truncate, req.Options)
+				if embedding, ok := s.embedCache.get(digest, key); ok {
+					embeddings[i] = embedding
+					cached++
+				} else {
+					// the embedding from the runner is cached by key
+					keys[i] = key
+				}
+			}
+		}
+	}
+
+	checkpointLoaded := checkpointStart
+
+	var count int
+	if len(input) == 0 || cached < len(input) {
+		var used string
+		var ok bool
+		checkpointLoaded, count, used, ok = s.embedInputs(c, req, input, truncate, digest, embeddings)
+		if !ok {
+			return
+		}
+
+		if len(input) == 0 {
+			c.JSON(http.StatusOK, api.EmbedResponse{Model: req.Model, Embeddings: [][]float32{}})
+			return
+		}
+
+		if s.embedCache != nil && used != digest {
+			// a fallback model embedded every input
+			digest, cached = used, 0
+			for i, text := range input {
+				keys[i] = embedCacheKey(text, truncate, req.Options)
+			}
+		}
+
+		for i, key := range keys {
+			if key != "" {
+				s.embedCache.put(digest, key, embeddings[i])
+			}
+		}
+	}
+
+	for i, embedding := range embeddings {
+		if req.Dimensions > 0 {
+			if req.Dimensions > len(embedding) {
+				c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("dimensions exceeds the embedding length of %d", len(embedding))})
+				return
+			}
+
+			embedding = embedding[:req.Dimensions]
+		}
+
+		if req.Normalize == nil || *req.Normalize {
+			embedding = normalize(embedding)
+		}
+
+		embeddings[i] = embedding
+	}
+
+	resp := api.EmbedResponse{
+		Model:           req.Model,
+		Embeddings:      embeddings,
+		TotalDuration:   time.Since(checkpointStart),
+		LoadDuration:    checkpointLoaded.Sub(checkpointStart),
+		PromptEvalCount: count,
+		Cached:          cached,
+	}
+
+	if format != "float" {
+		resp.Embeddings = nil
+		for _, embedding := range embeddings {
+			encoded, err := encodeEmbedding(embedding, format)
+			if err != nil {
+				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+				return
+			}
+
+			resp.EncodedEmbeddings = append(resp.EncodedEmbeddings, encoded)
+		}
+	}
+	metrics.promptTokens.Add(float64(count), req.Model)
+	s.recordUsage(c, count)
+	auditFromContext(c.Request.Context()).setMetrics(api.Metrics{
+		LoadDuration:    resp.LoadDuration,
+		PromptEvalCount: resp.PromptEvalCount,
+	}, "")
+	c.JSON(http.StatusOK, resp)
+}
+
+// embedInputs schedules a runner and embeds the inputs which don't have an
+// embedding yet, returning when the runner was loaded, the number of tokens
+// evaluated and the digest of the model. The existing embeddings are
+// discarded if they are from a model with another digest. It replies with an
+// error and returns false if that fails.
+func (s *Server) embedInputs(c *gin.Context, req api.EmbedRequest, input []string, truncate bool, digest string, embeddings [][]float32) (time.Time, int, string, bool) {
 	r, m, opts, err := s.scheduleRunner(c.Request.Context(), req.Model, []Capability{}, req.Options, req.KeepAlive)
 	if err != nil {
 		handleScheduleError(c, req.Model, err)
-		return
+		return time.Time{}, 0, "", false
 	}
 
 	checkpointLoaded := time.Now()
 
+	// the request fell back from the model it found cached embeddings for
+	if m.Digest != digest {
+		clear(embeddings)
+	}
+
 	if len(input) == 0 {
-		c.JSON(http.StatusOK, api.EmbedResponse{Model: req.Model, Embeddings: [][]float32{}})
-		return
+		return checkpointLoaded, 0, m.Digest, true
 	}
 
 	kvData, err := getKVData(m.ModelPath, false)
 	if err != nil {
 		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-		return
+		return time.Time{}, 0, "", false
 	}
 
 	var count int
 	for i, s := range input {
+		if embeddings[i] != nil {
+			continue
+		}
+
 		tokens, err := r.Tokenize(c.Request.Context(), s)
 		if err != nil {
 			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-			return
+			return time.Time{}, 0, "", false
 		}
 
 		ctxLen := min(opts.NumCtx, int(kvData.ContextLength()))
 		if len(tokens) > ctxLen {
 			if !truncate {
 				c.JSON(http.StatusBadRequest, gin.H{"error": "input length exceeds maximum context length"})
-				return
+				return time.Time{}, 0, "", false
 			}
 
 			tokens = tokens[:ctxLen]
 			s, err = r.Detokenize(c.Request.Context(), tokens)
 			if err != nil {
 				c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
-				return
+				return time.Time{}, 0, "", false
 			}
 		}
 
@@ -417,35 +697,127 @@ func (s *Server) EmbedHandler(c *gin.Context) {
 		input[i] = s
 	}
 
-	var g errgroup.Group
-	embeddings := make([][]float32, len(input))
+	var missing []int
+	var texts []string
 	for i, text := range input {
+		if embeddings[i] == nil {
+			missing = append(missing, i)
+			texts = append(texts, text)
+		}
+	}
+
+	results, err := r.Embedding(c.Request.Context(), texts)
+	if err != nil {
+		slog.Error("embedding generation failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("failed to generate embeddings: %v", err)})
+		return time.Time{}, 0, "", false
+	}
+
+	for i, embedding := range results {
+		embeddings[missing[i]] = embedding
+	}
+
+	return checkpointLoaded, count, m.Digest, true
+}
+
+func (s *Server) RerankHandler(c *gin.Context) {
+	checkpointStart := time.Now()
+	var req api.RerankRequest
+	err := c.ShouldBindJSON(&req)
+	switch {
+	case errors.Is(err, io.EOF):
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	case err != nil:
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Query == "" && len(req.Documents) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "query is required"})
+		return
+	} else if req.TopN < 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "top_n must not be negative"})
+		return
+	}
+
+	req.Model = s.resolveModel(c, req.Model)
+
+	r, _, _, err := s.scheduleRunner(c.Request.Context(), req.Model, []Capability{CapabilityRerank}, req.Options, req.KeepAlive)
+	if errors.Is(err, errCapabilityRerank) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support rerank", req.Model)})
+		return
+	} else if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	checkpointLoaded := time.Now()
+
+	var count int
+	for _, text := range append([]string{req.Query}, req.Documents...) {
+		tokens, err := r.Tokenize(c.Request.Context(), text)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		count += len(tokens)
+	}
+
+	var g errgroup.Group
+	results := make([]api.RerankResult, len(req.Documents))
+	for i, document := range req.Documents {
 		g.Go(func() error {
-			embedding, err := r.Embedding(c.Request.Context(), text)
+			score, err := r.Rerank(c.Request.Context(), req.Query, document)
 			if err != nil {
 				return err
 			}
-			embeddings[i] = normalize(embedding)
+
+			results[i] = api.RerankResult{
+				Index:          i,
+				Document:       document,
+				RelevanceScore: sigmoid(score),
+			}
 			return nil
 		})
 	}
 
 	if err := g.Wait(); err != nil {
-		slog.Error("embedding generation failed", "error", err)
-		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Errorf("failed to generate embeddings: %v", err)})
+		slog.Error("rerank failed", "error", err)
+		c.JSON(http.StatusInternalServerError, gin.H{"error": fmt.Sprintf("failed to rerank documents: %v", err)})
 		return
 	}
 
-	resp := api.EmbedResponse{
+	slices.SortStableFunc(results, func(a, b api.RerankResult) int {
+		return cmp.Compare(b.RelevanceScore, a.RelevanceScore)
+	})
+
+	if req.TopN > 0 && req.TopN < len(results) {
+		results = results[:req.TopN]
+	}
+
+	resp := api.RerankResponse{
 		Model:           req.Model,
-		Embeddings:      embeddings,
+		Results:         results,
 		TotalDuration:   time.Since(checkpointStart),
 		LoadDuration:    checkpointLoaded.Sub(checkpointStart),
 		PromptEvalCount: count,
 	}
+	metrics.promptTokens.Add(float64(count), req.Model)
+	s.recordUsage(c, count)
+	auditFromContext(c.Request.Context()).setMetrics(api.Metrics{
+		LoadDuration:    resp.LoadDuration,
+		PromptEvalCount: resp.PromptEvalCount,
+	}, "")
 	c.JSON(http.StatusOK, resp)
 }
 
+// sigmoid maps the logit of a reranking model to a score between 0 and 1
+func sigmoid(x float32) float32 {
+	return float32(1 / (1 + math.Exp(-float64(x))))
+}
+
 func normalize(vec []float32) []float32 {
 	var sum float32
 	for _, v := range vec {
@@ -473,7 +845,21 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 		return
 	}
 
-	r, _, _, err := s.scheduleRunner(c.Request.Context(), req.Model, []Capability{}, req.Options, req.KeepAlive)
+	req.Model = s.resolveModel(c, req.Model)
+
+	// this endpoint doesn't truncate its input
+	var digest, key string
+	if s.embedCache != nil && req.Prompt != "" {
+		if m, err := GetModel(req.Model); err == nil {
+			digest, key = m.Digest, embedCacheKey(req.Prompt, false, req.Options)
+			if embedding, ok := s.embedCache.get(digest, key); ok {
+				c.JSON(http.StatusOK, api.EmbeddingResponse{Embedding: float64s(embedding), Cached: 1})
+				return
+			}
+		}
+	}
+
+	r, m, _, err := s.scheduleRunner(c.Request.Context(), req.Model, []Capability{}, req.Options, req.KeepAlive)
 	if err != nil {
 		handleScheduleError(c, req.Model, err)
 		return
@@ -485,22 +871,167 @@ func (s *Server) EmbeddingsHandler(c *gin.Context) {
 		return
 	}
 
-	embedding, err := r.Embedding(c.Request.Context(), req.Prompt)
+	embeddings, err := r.Embedding(c.Request.Context(), []string{req.Prompt})
 	if err != nil {
 		slog.Info(fmt.Sprintf("embedding generation failed: %v", err))
 		c.JSON(http.StatusInternalServerError, gin.H{"error": "failed to generate embedding"})
 		return
 	}
 
+	embedding := embeddings[0]
+
+	// the model is another one than the cache was looked up for if the
+	// request fell back from it
+	if key != "" {
+		s.embedCache.put(m.Digest, key, embedding)
+	}
+
+	resp := api.EmbeddingResponse{
+		Embedding: float64s(embedding),
+	}
+	c.JSON(http.StatusOK, resp)
+}
+
+func float64s(embedding []float32) []float64 {
 	var e []float64
 	for _, v := range embedding {
 		e = append(e, float64(v))
 	}
 
-	resp := api.EmbeddingResponse{
-		Embedding: e,
+	return e
+}
+
+func (s *Server) TokenizeHandler(c *gin.Context) {
+	var req api.TokenizeRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
 	}
-	c.JSON(http.StatusOK, resp)
+
+	if req.Prompt != "" && len(req.Messages) > 0 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "prompt and messages are mutually exclusive"})
+		return
+	}
+
+	req.Model = s.resolveModel(c, req.Model)
+
+	r, m, opts, err := s.scheduleRunner(c.Request.Context(), req.Model, []Capability{}, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	prompt := req.Prompt
+	if len(req.Messages) > 0 {
+		msgs := append(slices.Clone(m.Messages), req.Messages...)
+		if req.Messages[0].Role != "system" && m.System != "" {
+			msgs = append([]api.Message{{Role: "system", Content: m.System}}, msgs...)
+		}
+
+		// render every message so the count reflects prompts which exceed
+		// the context window rather than the truncated prompt
+		untruncated := *opts
+		untruncated.NumCtx = math.MaxInt
+		prompt, _, err = chatPrompt(c.Request.Context(), m, r.Tokenize, &untruncated, msgs, req.Tools)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	tokens := []int{}
+	if prompt != "" {
+		tokens, err = r.Tokenize(c.Request.Context(), prompt)
+		if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, api.TokenizeResponse{Model: req.Model, Tokens: tokens, Count: len(tokens)})
+}
+
+func (s *Server) CacheHandler(c *gin.Context) {
+	checkpointStart := time.Now()
+
+	var req api.CacheRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Prompt == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "prompt is required"})
+		return
+	}
+
+	req.Model = s.resolveModel(c, req.Model)
+
+	r, _, _, err := s.scheduleRunner(c.Request.Context(), req.Model, []Capability{CapabilityCompletion}, req.Options, req.KeepAlive)
+	if errors.Is(err, errCapabilityCompletion) {
+		c.JSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("%q does not support generate", req.Model)})
+		return
+	} else if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	checkpointLoaded := time.Now()
+
+	resp, err := r.CachePrompt(c.Request.Context(), req.Prompt)
+	if err != nil {
+		c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.CacheResponse{
+		Model:              req.Model,
+		Tokens:             resp.Tokens,
+		TotalDuration:      time.Since(checkpointStart),
+		LoadDuration:       checkpointLoaded.Sub(checkpointStart),
+		PromptEvalCount:    resp.PromptN,
+		PromptEvalDuration: time.Duration(resp.PromptMS * float64(time.Millisecond)),
+	})
+}
+
+func (s *Server) DetokenizeHandler(c *gin.Context) {
+	var req api.DetokenizeRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	req.Model = s.resolveModel(c, req.Model)
+
+	r, _, _, err := s.scheduleRunner(c.Request.Context(), req.Model, []Capability{}, req.Options, req.KeepAlive)
+	if err != nil {
+		handleScheduleError(c, req.Model, err)
+		return
+	}
+
+	var content string
+	if len(req.Tokens) > 0 {
+		content, err = r.Detokenize(c.Request.Context(), req.Tokens)
+		var invalid *llm.InvalidTokenError
+		if errors.As(err, &invalid) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		} else if err != nil {
+			c.JSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+	}
+
+	c.JSON(http.StatusOK, api.DetokenizeResponse{Model: req.Model, Content: content})
 }
 
 func (s *Server) PullHandler(c *gin.Context) {
@@ -526,6 +1057,23 @@ func (s *Server) PullHandler(c *gin.Context) {
 		return
 	}
 
+	regOpts := &registryOptions{
+		Insecure: req.Insecure,
+	}
+
+	// pulls streamed as events continue while their client reconnects
+	if (req.Stream == nil || *req.Stream) && acceptsEvents(c) {
+		ps, seq := s.pulls.resume(name.DisplayShortest(), c.GetHeader("Last-Event-ID"))
+		if ps == nil {
+			ps = s.pulls.start(name.DisplayShortest(), func(ctx context.Context, fn func(api.ProgressResponse)) error {
+				return PullModel(ctx, name.DisplayShortest(), regOpts, fn)
+			})
+		}
+
+		streamResponse(c, ps.subscribe(c.Request.Context(), seq))
+		return
+	}
+
 	ch := make(chan any)
 	go func() {
 		defer close(ch)
@@ -533,10 +1081,6 @@ func (s *Server) PullHandler(c *gin.Context) {
 			ch <- r
 		}
 
-		regOpts := &registryOptions{
-			Insecure: req.Insecure,
-		}
-
 		ctx, cancel := context.WithCancel(c.Request.Context())
 		defer cancel()
 
@@ -672,7 +1216,7 @@ func (s *Server) CreateHandler(c *gin.Context) {
 		defer cancel()
 
 		quantization := cmp.Or(r.Quantize, r.Quantization)
-		if err := CreateModel(ctx, name, filepath.Dir(r.Path), strings.ToUpper(quantization), f, fn); errors.Is(err, errBadTemplate) {
+		if err := CreateModel(ctx, name, filepath.Dir(r.Path), strings.ToUpper(quantization), f, fn); errors.Is(err, errBadTemplate) || errors.Is(err, errBadGrammar) {
 			ch <- gin.H{"error": err.Error(), "status": http.StatusBadRequest}
 		} else if err != nil {
 			ch <- gin.H{"error": err.Error()}
@@ -746,6 +1290,8 @@ func (s *Server) ShowHandler(c *gin.Context) {
 		return
 	}
 
+	req.Model = s.resolveModel(c, req.Model)
+
 	resp, err := GetModelInfo(req)
 	if err != nil {
 		switch {
@@ -916,6 +1462,16 @@ func (s *Server) ListHandler(c *gin.Context) {
 		})
 	}
 
+	for _, a := range s.aliases.list() {
+		models = append(models, api.ListModelResponse{
+			Model:      a.Name,
+			Name:       a.Name,
+			ModifiedAt: a.UpdatedAt,
+			Targets:    a.Targets,
+			Fallbacks:  a.Fallbacks,
+		})
+	}
+
 	slices.SortStableFunc(models, func(i, j api.ListModelResponse) int {
 		// most recently modified first
 		return cmp.Compare(j.ModifiedAt.Unix(), i.ModifiedAt.Unix())
@@ -1113,7 +1669,7 @@ func (s *Server) GenerateRoutes() http.Handler {
 	config := cors.DefaultConfig()
 	config.AllowWildcard = true
 	config.AllowBrowserExtensions = true
-	config.AllowHeaders = []string{"Authorization", "Content-Type", "User-Agent", "Accept", "X-Requested-With"}
+	config.AllowHeaders = []string{"Authorization", "X-Api-Key", "Content-Type", "User-Agent", "Accept", "X-Requested-With", "Last-Event-ID", priorityHeader}
 	openAIProperties := []string{"lang", "package-version", "os", "arch", "runtime", "runtime-version", "async"}
 	for _, prop := range openAIProperties {
 		config.AllowHeaders = append(config.AllowHeaders, "x-stainless-"+prop)
@@ -1123,37 +1679,81 @@ func (s *Server) GenerateRoutes() http.Handler {
 	r := gin.Default()
 	r.Use(
 		cors.New(config),
+		s.auditMiddleware(),
 		allowedHostsMiddleware(s.addr),
+		metricsMiddleware(),
+		priorityMiddleware(),
 	)
 
-	r.POST("/api/pull", s.PullHandler)
-	r.POST("/api/generate", s.GenerateHandler)
-	r.POST("/api/chat", s.ChatHandler)
-	r.POST("/api/embed", s.EmbedHandler)
-	r.POST("/api/embeddings", s.EmbeddingsHandler)
-	r.POST("/api/create", s.CreateHandler)
-	r.POST("/api/push", s.PushHandler)
-	r.POST("/api/copy", s.CopyHandler)
-	r.DELETE("/api/delete", s.DeleteHandler)
-	r.POST("/api/show", s.ShowHandler)
-	r.POST("/api/blobs/:digest", s.CreateBlobHandler)
-	r.HEAD("/api/blobs/:digest", s.HeadBlobHandler)
-	r.GET("/api/ps", s.PsHandler)
+	limit := s.rateLimit()
+	inference := s.requireScope(scopeInference)
+	embed := s.requireScope(scopeEmbed)
+	manage := s.requireScope(scopeManageModels)
+	read := s.requireScope(scopeAny)
+	track := s.trackRequest()
+
+	r.POST("/api/pull", manage, s.PullHandler)
+	r.POST("/api/generate", inference, limit, track, s.GenerateHandler)
+	r.POST("/api/chat", inference, limit, track, s.ChatHandler)
+	r.POST("/api/embed", embed, limit, track, s.EmbedHandler)
+	r.POST("/api/embeddings", embed, limit, track, s.EmbeddingsHandler)
+	r.DELETE("/api/embed/cache", manage, s.PurgeEmbedCacheHandler)
+	r.POST("/api/rerank", embed, limit, track, s.RerankHandler)
+	r.POST("/api/tokenize", inference, limit, s.TokenizeHandler)
+	r.POST("/api/detokenize", inference, limit, s.DetokenizeHandler)
+	r.POST("/api/cache", inference, limit, s.CacheHandler)
+	r.POST("/api/create", manage, s.CreateHandler)
+	r.POST("/api/push", manage, s.PushHandler)
+	r.POST("/api/copy", manage, s.CopyHandler)
+	r.DELETE("/api/delete", manage, s.DeleteHandler)
+	r.POST("/api/show", read, s.ShowHandler)
+	r.POST("/api/aliases", manage, s.CreateAliasHandler)
+	r.GET("/api/aliases", read, s.ListAliasesHandler)
+	r.DELETE("/api/aliases", manage, s.DeleteAliasHandler)
+	r.POST("/api/blobs/:digest", manage, s.CreateBlobHandler)
+	r.HEAD("/api/blobs/:digest", manage, s.HeadBlobHandler)
+	r.GET("/api/ps", read, s.PsHandler)
+	r.POST("/api/sessions", inference, s.CreateSessionHandler)
+	r.GET("/api/sessions", inference, s.ListSessionsHandler)
+	r.GET("/api/sessions/:id", inference, s.SessionHandler)
+	r.DELETE("/api/sessions/:id", inference, s.DeleteSessionHandler)
+	r.POST("/api/collections", embed, s.CreateCollectionHandler)
+	r.GET("/api/collections", embed, s.ListCollectionsHandler)
+	r.GET("/api/collections/:name", embed, s.CollectionHandler)
+	r.DELETE("/api/collections/:name", embed, s.DeleteCollectionHandler)
+	r.POST("/api/collections/:name/documents", embed, limit, track, s.AddDocumentsHandler)
+	r.DELETE("/api/collections/:name/documents", embed, s.DeleteDocumentsHandler)
+	r.POST("/api/collections/:name/query", embed, limit, track, s.QueryCollectionHandler)
+	r.GET("/api/requests", inference, s.ListRequestsHandler)
+	r.DELETE("/api/requests/:id", inference, s.CancelRequestHandler)
+	r.GET("/api/ws", inference, s.WebSocketHandler)
+	r.GET("/metrics", s.requireScope(scopeAdmin), s.MetricsHandler)
 
 	// Compatibility endpoints
-	r.POST("/v1/chat/completions", openai.ChatMiddleware(), s.ChatHandler)
-	r.POST("/v1/completions", openai.CompletionsMiddleware(), s.GenerateHandler)
-	r.POST("/v1/embeddings", openai.EmbeddingsMiddleware(), s.EmbedHandler)
-	r.GET("/v1/models", openai.ListMiddleware(), s.ListHandler)
-	r.GET("/v1/models/:model", openai.RetrieveMiddleware(), s.ShowHandler)
+	r.POST("/v1/chat/completions", inference, limit, track, openai.ChatMiddleware(), s.ChatHandler)
+	r.POST("/v1/completions", inference, limit, track, openai.CompletionsMiddleware(), s.GenerateHandler)
+	r.POST("/v1/embeddings", embed, limit, track, openai.EmbeddingsMiddleware(), s.EmbedHandler)
+	r.POST("/v1/rerank", embed, limit, track, openai.RerankMiddleware(), s.RerankHandler)
+	r.GET("/v1/models", read, openai.ListMiddleware(), s.ListHandler)
+	r.GET("/v1/models/:model", read, openai.RetrieveMiddleware(), s.ShowHandler)
+	r.POST("/v1/messages", inference, limit, track, anthropic.MessagesMiddleware(), s.ChatHandler)
+	r.POST("/v1/files", inference, s.CreateFileHandler)
+	r.GET("/v1/files", inference, s.ListFilesHandler)
+	r.GET("/v1/files/:id", inference, s.RetrieveFileHandler)
+	r.DELETE("/v1/files/:id", inference, s.DeleteFileHandler)
+	r.GET("/v1/files/:id/content", inference, s.FileContentHandler)
+	r.POST("/v1/batches", inference, limit, s.CreateBatchHandler)
+	r.GET("/v1/batches", inference, s.ListBatchesHandler)
+	r.GET("/v1/batches/:id", inference, s.RetrieveBatchHandler)
+	r.POST("/v1/batches/:id/cancel", inference, s.CancelBatchHandler)
 
 	for _, method := range []string{http.MethodGet, http.MethodHead} {
 		r.Handle(method, "/", func(c *gin.Context) {
 			c.String(http.StatusOK, "Ollama is running")
 		})
 
-		r.Handle(method, "/api/tags", s.ListHandler)
-		r.Handle(method, "/api/version", func(c *gin.Context) {
+		r.Handle(method, "/api/tags", read, s.ListHandler)
+		r.Handle(method, "/api/version", read, func(c *gin.Context) {
 			c.JSON(http.StatusOK, gin.H{"version": version.Version})
 		})
 	}
@@ -1207,10 +1807,56 @@ func Serve(ln net.Listener) error {
 		}
 	}
 
+	var keys apiKeys
+	if path := envconfig.APIKeysFile(); path != "" {
+		keys, err = loadAPIKeys(path)
+		if err != nil {
+			return err
+		}
+
+		slog.Info("api key authentication enabled", "keys", len(keys))
+	}
+
+	audit, err := newAuditLog()
+	if err != nil {
+		return err
+	}
+
+	files, err := newFileStore(envconfig.Files())
+	if err != nil {
+		return err
+	}
+
+	batches, err := newBatchQueue(filepath.Join(envconfig.Files(), "batches"), files)
+	if err != nil {
+		return err
+	}
+
+	sessions, err := newSessionStore(envconfig.Sessions(), envconfig.SessionTTL())
+	if err != nil {
+		return err
+	}
+
+	embedCache, err := newEmbedCache(envconfig.EmbedCache(), envconfig.EmbedCacheSize())
+	if err != nil {
+		return err
+	}
+
+	collections, err := newCollectionStore(envconfig.Collections())
+	if err != nil {
+		return err
+	}
+
+	aliases, err := newAliasStore(filepath.Join(envconfig.Models(), "aliases.json"))
+	if err != nil {
+		return err
+	}
+
 	ctx, done := context.WithCancel(context.Background())
 	schedCtx, schedDone := context.WithCancel(ctx)
 	sched := InitScheduler(schedCtx)
-	s := &Server{addr: ln.Addr(), sched: sched}
+	s := &Server{addr: ln.Addr(), sched: sched, apiKeys: keys, limiter: newRateLimiter(), audit: audit, files: files, batches: batches, sessions: sessions, embedCache: embedCache, collections: collections, aliases: aliases}
+	batches.handler = s.batchRoutes()
 
 	http.Handle("/", s.GenerateRoutes())
 
@@ -1233,6 +1879,9 @@ func Serve(ln net.Listener) error {
 	go func() {
 		<-signals
 		srvr.Close()
+		if audit != nil {
+			audit.Close()
+		}
 		schedDone()
 		sched.unloadAllRunners()
 		runners.Cleanup(build.EmbedFS)
@@ -1244,6 +1893,8 @@ func Serve(ln net.Listener) error {
 	}
 
 	s.sched.Run(schedCtx)
+	go s.batches.run(schedCtx)
+	go s.sessions.run(schedCtx)
 
 	// At startup we retrieve GPU information so we can get log messages before loading a model
 	// This will log warnings to the log in case we have problems with detected GPUs
@@ -1289,22 +1940,51 @@ func waitForStream(c *gin.Context, ch chan interface{}) {
 	c.JSON(http.StatusInternalServerError, gin.H{"error": "unexpected end of progress response"})
 }
 
+// streamResponse streams the responses sent on ch as newline delimited JSON,
+// or as server-sent events if the client accepts them
 func streamResponse(c *gin.Context, ch chan any) {
-	c.Header("Content-Type", "application/x-ndjson")
+	events := acceptsEvents(c)
+	if events {
+		c.Header("Content-Type", "text/event-stream")
+		c.Header("Cache-Control", "no-cache")
+	} else {
+		c.Header("Content-Type", "application/x-ndjson")
+	}
+
 	c.Stream(func(w io.Writer) bool {
 		val, ok := <-ch
 		if !ok {
 			return false
 		}
 
+		var id string
+		if e, ok := val.(serverEvent); ok {
+			id, val = e.id, e.data
+		}
+
+		if h, ok := val.(gin.H); ok {
+			if msg, ok := h["error"].(string); ok {
+				auditFromContext(c.Request.Context()).setError(msg)
+			}
+		}
+
 		bts, err := json.Marshal(val)
 		if err != nil {
 			slog.Info(fmt.Sprintf("streamResponse: json.Marshal failed with %s", err))
 			return false
 		}
 
-		// Delineate chunks with new-line delimiter
-		bts = append(bts, '\n')
+		if events {
+			var sb strings.Builder
+			if id != "" {
+				fmt.Fprintf(&sb, "id: %s\n", id)
+			}
+			fmt.Fprintf(&sb, "event: %s\ndata: %s\n\n", eventName(val), bts)
+			bts = []byte(sb.String())
+		} else {
+			// Delineate chunks with new-line delimiter
+			bts = append(bts, '\n')
+		}
 		if _, err := w.Write(bts); err != nil {
 			slog.Info(fmt.Sprintf("streamResponse: w.Write failed with %s", err))
 			return false
@@ -1317,7 +1997,22 @@ func streamResponse(c *gin.Context, ch chan any) {
 func (s *Server) PsHandler(c *gin.Context) {
 	models := []api.ProcessModelResponse{}
 
+	queue := make(map[string]int)
+	for _, p := range llm.Priorities {
+		queue[p.String()] = 0
+	}
+
+	for p, n := range s.sched.queue.queued() {
+		queue[p.String()] += n
+	}
+
 	for _, v := range s.sched.loaded {
+		if v.llama != nil {
+			for p, n := range v.llama.Queued() {
+				queue[p.String()] += n
+			}
+		}
+
 		model := v.model
 		modelDetails := api.ModelDetails{
 			Format:            model.Config.ModelFormat,
@@ -1344,6 +2039,19 @@ func (s *Server) PsHandler(c *gin.Context) {
 			mr.ExpiresAt = time.Now().Add(v.sessionDuration)
 		}
 
+		if v.Options != nil && v.DraftModel != "" && v.llama != nil {
+			mr.Draft = &api.ProcessDraftResponse{Model: v.DraftModel}
+			if stats, err := v.llama.DraftStats(c.Request.Context()); err != nil {
+				slog.Debug("failed to get draft stats", "model", model.ShortName, "error", err)
+			} else {
+				mr.Draft.Proposed = stats.Proposed
+				mr.Draft.Accepted = stats.Accepted
+				if stats.Proposed > 0 {
+					mr.Draft.AcceptanceRate = float64(stats.Accepted) / float64(stats.Proposed)
+				}
+			}
+		}
+
 		models = append(models, mr)
 	}
 
@@ -1352,7 +2060,7 @@ func (s *Server) PsHandler(c *gin.Context) {
 		return cmp.Compare(j.ExpiresAt.Unix(), i.ExpiresAt.Unix())
 	})
 
-	c.JSON(http.StatusOK, api.ProcessResponse{Models: models})
+	c.JSON(http.StatusOK, api.ProcessResponse{Models: models, Queue: queue})
 }
 
 func (s *Server) ChatHandler(c *gin.Context) {
@@ -1367,9 +2075,62 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
+	grammar, err := requestGrammar(req.Format, req.Grammar, req.Regex, req.Choices)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if err := checkLogprobs(req.Logprobs, req.TopLogprobs); err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	} else if req.N < 0 || req.N > maxCompletions {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("n must be between 1 and %d", maxCompletions)})
+		return
+	} else if req.Session != "" && req.N > 1 {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "n must be 1 when continuing a session"})
+		return
+	}
+
+	var session *api.Session
+	// finishSession releases the session, adding the messages of the turn
+	// once its response is done
+	finishSession := func(...api.Message) {}
+	if req.Session != "" {
+		session, err = s.sessions.acquire(req.Session, c.GetString(apiKeyContextKey))
+		if errors.Is(err, errSessionNotFound) {
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("session %q not found", req.Session)})
+			return
+		} else if errors.Is(err, errSessionInUse) {
+			c.AbortWithStatusJSON(http.StatusConflict, gin.H{"error": fmt.Sprintf("session %q is in use by another request", req.Session)})
+			return
+		} else if err != nil {
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+			return
+		}
+
+		var once sync.Once
+		finishSession = func(msgs ...api.Message) {
+			once.Do(func() {
+				if err := s.sessions.release(req.Session, c.GetString(apiKeyContextKey), msgs...); err != nil {
+					slog.Error("failed to save session", "id", req.Session, "error", err)
+				}
+			})
+		}
+		defer finishSession()
+
+		if req.Model != "" && !sameModel(req.Model, session.Model) && !s.aliases.resolvesTo(req.Model, session.Model) {
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": fmt.Sprintf("session %q uses model %q", req.Session, session.Model)})
+			return
+		}
+
+		// the session stays on the model it was created with
+		req.Model = session.Model
+	}
+
+	req.Model = s.resolveModel(c, req.Model)
+
 	// expire the runner
 	if len(req.Messages) == 0 && req.KeepAlive != nil && int(req.KeepAlive.Seconds()) == 0 {
-		model, err := GetModel(req.Model)
+		model, _, err := getModel(c.Request.Context(), req.Model)
 		if err != nil {
 			switch {
 			case os.IsNotExist(err):
@@ -1407,6 +2168,15 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
+	// fall back to the grammar set in the model's or request's options
+	if grammar == "" && opts.Grammar != "" {
+		if err := llama.ValidateGrammar(opts.Grammar); err != nil {
+			c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+			return
+		}
+		grammar = opts.Grammar
+	}
+
 	checkpointLoaded := time.Now()
 
 	if len(req.Messages) == 0 {
@@ -1420,8 +2190,13 @@ func (s *Server) ChatHandler(c *gin.Context) {
 		return
 	}
 
-	msgs := append(m.Messages, req.Messages...)
-	if req.Messages[0].Role != "system" && m.System != "" {
+	history := req.Messages
+	if session != nil {
+		history = append(slices.Clone(session.Messages), req.Messages...)
+	}
+
+	msgs := append(m.Messages, history...)
+	if history[0].Role != "system" && m.System != "" {
 		msgs = append([]api.Message{{Role: "system", Content: m.System}}, msgs...)
 	}
 
@@ -1433,21 +2208,38 @@ func (s *Server) ChatHandler(c *gin.Context) {
 
 	slog.Debug("chat request", "images", len(images), "prompt", prompt)
 
+	audit := auditFromContext(c.Request.Context())
+	audit.setPrompt(prompt)
+
+	n := max(req.N, 1)
+
 	ch := make(chan any)
 	go func() {
+		var promptCounted bool
+		var reply strings.Builder
+		var turn []api.Message
 		defer close(ch)
+		defer func() { finishSession(turn...) }()
 		if err := r.Completion(c.Request.Context(), llm.CompletionRequest{
-			Prompt:  prompt,
-			Images:  images,
-			Format:  req.Format,
-			Options: opts,
+			Prompt:      prompt,
+			Images:      images,
+			Format:      req.Format,
+			Grammar:     grammar,
+			Options:     opts,
+			Logprobs:    req.Logprobs,
+			TopLogprobs: req.TopLogprobs,
+			N:           n,
+			Session:     req.Session,
 		}, func(r llm.CompletionResponse) {
 			res := api.ChatResponse{
-				Model:      req.Model,
-				CreatedAt:  time.Now().UTC(),
-				Message:    api.Message{Role: "assistant", Content: r.Content},
-				Done:       r.Done,
-				DoneReason: r.DoneReason,
+				Model:        req.Model,
+				CreatedAt:    time.Now().UTC(),
+				Message:      api.Message{Role: "assistant", Content: r.Content},
+				Done:         r.Done,
+				DoneReason:   r.DoneReason,
+				StopSequence: r.StopSequence,
+				Logprobs:     r.Logprobs,
+				Index:        r.Index,
 				Metrics: api.Metrics{
 					PromptEvalCount:    r.PromptEvalCount,
 					PromptEvalDuration: r.PromptEvalDuration,
@@ -1456,9 +2248,41 @@ func (s *Server) ChatHandler(c *gin.Context) {
 				},
 			}
 
+			if r.Index == 0 {
+				audit.appendResponse(r.Content)
+				if session != nil {
+					reply.WriteString(r.Content)
+				}
+			}
+
 			if r.Done {
+				if session != nil && r.Index == 0 {
+					msg := api.Message{Role: "assistant", Content: reply.String()}
+					if len(req.Tools) > 0 {
+						if toolCalls, ok := m.parseToolCalls(msg.Content); ok {
+							msg.ToolCalls = toolCalls
+							msg.Content = ""
+						}
+					}
+
+					turn = append(slices.Clone(req.Messages), msg)
+				}
+
 				res.TotalDuration = time.Since(checkpointStart)
 				res.LoadDuration = checkpointLoaded.Sub(checkpointStart)
+				metrics.observeCompletion(req.Model, res.Metrics)
+
+				// the prompt is shared by every completion
+				tokens := res.EvalCount
+				if !promptCounted {
+					tokens += res.PromptEvalCount
+					promptCounted = true
+				}
+				s.recordUsage(c, tokens)
+
+				if r.Index == 0 {
+					audit.setMetrics(res.Metrics, res.DoneReason)
+				}
 			}
 
 			ch <- res
@@ -1468,13 +2292,15 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	}()
 
 	if req.Stream != nil && !*req.Stream {
-		var resp api.ChatResponse
-		var sb strings.Builder
+		resps := make([]api.ChatResponse, n)
+		sbs := make([]strings.Builder, n)
+		logprobs := make([][]api.TokenLogprob, n)
 		for rr := range ch {
 			switch t := rr.(type) {
 			case api.ChatResponse:
-				sb.WriteString(t.Message.Content)
-				resp = t
+				sbs[t.Index].WriteString(t.Message.Content)
+				logprobs[t.Index] = append(logprobs[t.Index], t.Logprobs...)
+				resps[t.Index] = t
 			case gin.H:
 				msg, ok := t["error"].(string)
 				if !ok {
@@ -1489,15 +2315,23 @@ func (s *Server) ChatHandler(c *gin.Context) {
 			}
 		}
 
-		resp.Message.Content = sb.String()
+		for i := range resps {
+			resps[i].Message.Content = sbs[i].String()
+			resps[i].Logprobs = logprobs[i]
 
-		if len(req.Tools) > 0 {
-			if toolCalls, ok := m.parseToolCalls(sb.String()); ok {
-				resp.Message.ToolCalls = toolCalls
-				resp.Message.Content = ""
+			if len(req.Tools) > 0 {
+				if toolCalls, ok := m.parseToolCalls(sbs[i].String()); ok {
+					resps[i].Message.ToolCalls = toolCalls
+					resps[i].Message.Content = ""
+				}
 			}
 		}
 
+		resp := resps[0]
+		if n > 1 {
+			resp.Choices = resps
+		}
+
 		c.JSON(http.StatusOK, resp)
 		return
 	}
@@ -1505,9 +2339,71 @@ func (s *Server) ChatHandler(c *gin.Context) {
 	streamResponse(c, ch)
 }
 
+// maxTopLogprobs is the most alternatives that can be requested per token
+const maxTopLogprobs = 20
+
+// maxCompletions is the most completions that can be requested at once
+const maxCompletions = 128
+
+// requestGrammar returns the grammar constraining a response from its
+// format, GBNF grammar, regex or choices, at most one of which may be set
+func requestGrammar(format json.RawMessage, gbnf, regex string, choices []string) (string, error) {
+	var set []string
+
+	g, err := llm.FormatGrammar(format)
+	if err != nil {
+		return "", err
+	} else if g != "" {
+		set = append(set, "format")
+	}
+
+	if gbnf != "" {
+		set = append(set, "grammar")
+		g = gbnf
+	}
+
+	if regex != "" {
+		set = append(set, "regex")
+		if g, err = grammar.FromRegex(regex); err != nil {
+			return "", err
+		}
+	}
+
+	if choices != nil {
+		set = append(set, "choices")
+		if g, err = grammar.FromChoices(choices); err != nil {
+			return "", err
+		}
+	}
+
+	if len(set) > 1 {
+		return "", fmt.Errorf("only one of format, grammar, regex or choices may be set, got %s", strings.Join(set, " and "))
+	}
+
+	if g != "" {
+		if err := llama.ValidateGrammar(g); err != nil {
+			return "", err
+		}
+	}
+
+	return g, nil
+}
+
+func checkLogprobs(logprobs bool, topLogprobs int) error {
+	if topLogprobs < 0 || topLogprobs > maxTopLogprobs {
+		return fmt.Errorf("top_logprobs must be between 0 and %d", maxTopLogprobs)
+	}
+
+	if topLogprobs > 0 && !logprobs {
+		return errors.New("top_logprobs requires logprobs")
+	}
+
+	return nil
+}
+
 func handleScheduleError(c *gin.Context, name string, err error) {
 	switch {
-	case errors.Is(err, errCapabilities), errors.Is(err, errRequired):
+	case errors.Is(err, errCapabilities), errors.Is(err, errRequired), errors.Is(err, errDraftModel):
 		c.JSON(http.StatusBadRequest, gin.H{"error": err.Error()})
 	case errors.Is(err, context.Canceled):
 		c.JSON(499, gin.H{"error": "request canceled"})
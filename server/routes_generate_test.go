@@ -7,6 +7,7 @@ import (
 	"fmt"
 	"io"
 	"net/http"
+	"slices"
 	"strings"
 	"testing"
 	"time"
@@ -29,7 +30,11 @@ type mockRunner struct {
 
 func (m *mockRunner) Completion(_ context.Context, r llm.CompletionRequest, fn func(r llm.CompletionResponse)) error {
 	m.CompletionRequest = r
-	fn(m.CompletionResponse)
+	for i := range max(r.N, 1) {
+		cr := m.CompletionResponse
+		cr.Index = i
+		fn(cr)
+	}
 	return nil
 }
 
@@ -41,8 +46,56 @@ func (mockRunner) Tokenize(_ context.Context, s string) (tokens []int, err error
 	return
 }
 
-func newMockServer(mock *mockRunner) func(discover.GpuInfoList, string, *llm.GGML, []string, []string, api.Options, int) (llm.LlamaServer, error) {
-	return func(gpus discover.GpuInfoList, model string, ggml *llm.GGML, projectors, system []string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
+func (mockRunner) CachePrompt(_ context.Context, prompt string) (*llm.CacheResponse, error) {
+	n := len(strings.Fields(prompt))
+	return &llm.CacheResponse{Tokens: n - 1, PromptN: n, PromptMS: 1}, nil
+}
+
+func (mockRunner) Queued() map[llm.Priority]int {
+	return map[llm.Priority]int{llm.PriorityLow: 2}
+}
+
+func (mockRunner) DraftStats(context.Context) (*llm.DraftStats, error) {
+	return &llm.DraftStats{Proposed: 8, Accepted: 6}, nil
+}
+
+func (mockRunner) Detokenize(_ context.Context, tokens []int) (string, error) {
+	s := make([]string, len(tokens))
+	for i, t := range tokens {
+		// the vocabulary of the model in TestTokenize
+		if t < 0 || t >= 4 {
+			return "", &llm.InvalidTokenError{Token: t, VocabSize: 4}
+		}
+
+		s[i] = fmt.Sprintf("[%d]", t)
+	}
+
+	return strings.Join(s, " "), nil
+}
+
+func (mockRunner) Embedding(_ context.Context, inputs []string) ([][]float32, error) {
+	embeddings := make([][]float32, len(inputs))
+	for i := range inputs {
+		embeddings[i] = []float32{3, -4, 12, 0.5}
+	}
+
+	return embeddings, nil
+}
+
+// Rerank scores a document by the number of words it shares with the query
+func (mockRunner) Rerank(_ context.Context, query, document string) (float32, error) {
+	var score float32
+	for _, w := range strings.Fields(document) {
+		if slices.Contains(strings.Fields(query), w) {
+			score++
+		}
+	}
+
+	return score, nil
+}
+
+func newMockServer(mock *mockRunner) func(discover.GpuInfoList, string, *llm.GGML, []string, []string, string, api.Options, int) (llm.LlamaServer, error) {
+	return func(gpus discover.GpuInfoList, model string, ggml *llm.GGML, projectors, system []string, draft string, opts api.Options, numParallel int) (llm.LlamaServer, error) {
 		return mock, nil
 	}
 }
@@ -270,6 +323,350 @@ func TestGenerateChat(t *testing.T) {
 		checkChatResponse(t, w.Body, "test", "Hi!")
 	})
 
+	t.Run("session", func(t *testing.T) {
+		sessions, err := newSessionStore(t.TempDir(), time.Hour)
+		if err != nil {
+			t.Fatal(err)
+		}
+		s.sessions = sessions
+		defer func() { s.sessions = nil }()
+
+		w := createRequest(t, s.CreateSessionHandler, api.CreateSessionRequest{
+			Model: "test",
+			Messages: []api.Message{
+				{Role: "user", Content: "Hello!"},
+				{Role: "assistant", Content: "Hi!"},
+			},
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var session api.Session
+		if err := json.NewDecoder(w.Body).Decode(&session); err != nil {
+			t.Fatal(err)
+		}
+
+		w = createRequest(t, s.ChatHandler, api.ChatRequest{
+			Session:  session.ID,
+			Messages: []api.Message{{Role: "user", Content: "How are you?"}},
+			Stream:   &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(mock.CompletionRequest.Prompt, "User: Hello! Assistant: Hi! User: How are you? "); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if mock.CompletionRequest.Session != session.ID {
+			t.Errorf("expected session %q, got %q", session.ID, mock.CompletionRequest.Session)
+		}
+
+		checkChatResponse(t, w.Body, "test", "Hi!")
+
+		got, err := sessions.get(session.ID, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(got.Messages, []api.Message{
+			{Role: "user", Content: "Hello!"},
+			{Role: "assistant", Content: "Hi!"},
+			{Role: "user", Content: "How are you?"},
+			{Role: "assistant", Content: "Hi!"},
+		}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		t.Run("different model", func(t *testing.T) {
+			w := createRequest(t, s.ChatHandler, api.ChatRequest{
+				Model:    "test-other",
+				Session:  session.ID,
+				Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+				Stream:   &stream,
+			})
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", w.Code)
+			}
+		})
+
+		t.Run("multiple completions", func(t *testing.T) {
+			w := createRequest(t, s.ChatHandler, api.ChatRequest{
+				Session:  session.ID,
+				Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+				Stream:   &stream,
+				N:        2,
+			})
+
+			if w.Code != http.StatusBadRequest {
+				t.Errorf("expected status 400, got %d", w.Code)
+			}
+		})
+
+		t.Run("in use", func(t *testing.T) {
+			if _, err := sessions.acquire(session.ID, ""); err != nil {
+				t.Fatal(err)
+			}
+			defer sessions.release(session.ID, "")
+
+			w := createRequest(t, s.ChatHandler, api.ChatRequest{
+				Session:  session.ID,
+				Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+				Stream:   &stream,
+			})
+
+			if w.Code != http.StatusConflict {
+				t.Errorf("expected status 409, got %d", w.Code)
+			}
+		})
+
+		t.Run("not found", func(t *testing.T) {
+			w := createRequest(t, s.ChatHandler, api.ChatRequest{
+				Session:  "session-missing",
+				Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+				Stream:   &stream,
+			})
+
+			if w.Code != http.StatusNotFound {
+				t.Errorf("expected status 404, got %d", w.Code)
+			}
+		})
+	})
+
+	t.Run("logprobs", func(t *testing.T) {
+		logprobs := []api.TokenLogprob{{Token: "Hi!", Logprob: -0.5, TopLogprobs: []api.TokenLogprob{{Token: "Hi!", Logprob: -0.5}}}}
+		mock.CompletionResponse.Logprobs = logprobs
+		defer func() { mock.CompletionResponse.Logprobs = nil }()
+
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:       "test",
+			Messages:    []api.Message{{Role: "user", Content: "Hello!"}},
+			Stream:      &stream,
+			Logprobs:    true,
+			TopLogprobs: 1,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if !mock.CompletionRequest.Logprobs || mock.CompletionRequest.TopLogprobs != 1 {
+			t.Errorf("expected logprobs to be requested, got %v %d", mock.CompletionRequest.Logprobs, mock.CompletionRequest.TopLogprobs)
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(logprobs, resp.Logprobs); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("multiple completions", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+			Stream:   &stream,
+			N:        2,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if mock.CompletionRequest.N != 2 {
+			t.Errorf("expected 2 completions to be requested, got %d", mock.CompletionRequest.N)
+		}
+
+		var resp api.ChatResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.Choices) != 2 {
+			t.Fatalf("expected 2 choices, got %d", len(resp.Choices))
+		}
+
+		for i, choice := range resp.Choices {
+			if choice.Index != i || choice.Message.Content != "Hi!" || !choice.Done {
+				t.Errorf("unexpected choice %d: %+v", i, choice)
+			}
+		}
+
+		if resp.Message.Content != "Hi!" {
+			t.Errorf("expected first choice at top level, got %q", resp.Message.Content)
+		}
+	})
+
+	t.Run("multiple completions streaming", func(t *testing.T) {
+		stream := true
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+			Stream:   &stream,
+			N:        3,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var indexes []int
+		decoder := json.NewDecoder(w.Body)
+		for decoder.More() {
+			var resp api.ChatResponse
+			if err := decoder.Decode(&resp); err != nil {
+				t.Fatal(err)
+			}
+			indexes = append(indexes, resp.Index)
+		}
+
+		if diff := cmp.Diff([]int{0, 1, 2}, indexes); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("too many completions", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+			Stream:   &stream,
+			N:        129,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"n must be between 1 and 128"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("top logprobs without logprobs", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:       "test",
+			Messages:    []api.Message{{Role: "user", Content: "Hello!"}},
+			Stream:      &stream,
+			TopLogprobs: 1,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"top_logprobs requires logprobs"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("json schema format", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+			Stream:   &stream,
+			Format:   json.RawMessage(`{"type": "object", "properties": {"name": {"type": "string"}}, "required": ["name"]}`),
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if !strings.Contains(mock.CompletionRequest.Grammar, `name-kv ::= "\"name\"" space ":" space string`) {
+			t.Errorf("unexpected grammar %q", mock.CompletionRequest.Grammar)
+		}
+	})
+
+	t.Run("invalid format", func(t *testing.T) {
+		w := createRequest(t, s.ChatHandler, api.ChatRequest{
+			Model:    "test",
+			Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+			Stream:   &stream,
+			Format:   json.RawMessage(`"xml"`),
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"invalid format \"xml\": must be \"json\" or a JSON schema object"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("grammar", func(t *testing.T) {
+		cases := []struct {
+			name    string
+			req     api.ChatRequest
+			grammar string
+		}{
+			{"gbnf", api.ChatRequest{Grammar: `root ::= "yes" | "no"`}, `root ::= "yes" | "no"`},
+			{"regex", api.ChatRequest{Regex: `[0-9]+`}, "root ::= ([0-9])+\n"},
+			{"choices", api.ChatRequest{Choices: []string{"positive", "negative"}}, "root ::= \"positive\" | \"negative\"\n"},
+		}
+
+		for _, tt := range cases {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.req.Model = "test"
+				tt.req.Messages = []api.Message{{Role: "user", Content: "Hello!"}}
+				tt.req.Stream = &stream
+
+				w := createRequest(t, s.ChatHandler, tt.req)
+				if w.Code != http.StatusOK {
+					t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body.String())
+				}
+
+				if mock.CompletionRequest.Grammar != tt.grammar {
+					t.Errorf("expected grammar %q, got %q", tt.grammar, mock.CompletionRequest.Grammar)
+				}
+			})
+		}
+	})
+
+	t.Run("invalid grammar", func(t *testing.T) {
+		cases := []struct {
+			name string
+			req  api.ChatRequest
+			err  string
+		}{
+			{"undefined rule", api.ChatRequest{Grammar: `root ::= answer`}, "invalid grammar: undefined rule 'answer'"},
+			{"regex", api.ChatRequest{Regex: `\bword`}, "invalid regex: word boundaries are not supported"},
+			{"options", api.ChatRequest{Options: map[string]any{"grammar": "answer ::= [0-9]"}}, "invalid grammar: missing 'root' rule"},
+			{"format and regex", api.ChatRequest{Format: json.RawMessage(`"json"`), Regex: "[0-9]+"}, "only one of format, grammar, regex or choices may be set, got format and regex"},
+		}
+
+		for _, tt := range cases {
+			t.Run(tt.name, func(t *testing.T) {
+				tt.req.Model = "test"
+				tt.req.Messages = []api.Message{{Role: "user", Content: "Hello!"}}
+				tt.req.Stream = &stream
+
+				w := createRequest(t, s.ChatHandler, tt.req)
+				if w.Code != http.StatusBadRequest {
+					t.Fatalf("expected status 400, got %d", w.Code)
+				}
+
+				var resp struct {
+					Error string `json:"error"`
+				}
+				if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+					t.Fatal(err)
+				}
+
+				if resp.Error != tt.err {
+					t.Errorf("expected error %q, got %q", tt.err, resp.Error)
+				}
+			})
+		}
+	})
+
 	w = createRequest(t, s.CreateHandler, api.CreateRequest{
 		Model:     "test-system",
 		Modelfile: "FROM test\nSYSTEM You are a helpful assistant.",
@@ -583,6 +980,170 @@ func TestGenerate(t *testing.T) {
 		checkGenerateResponse(t, w.Body, "test", "Hi!")
 	})
 
+	t.Run("logprobs", func(t *testing.T) {
+		logprobs := []api.TokenLogprob{{Token: "Hi!", Logprob: -0.5}}
+		mock.CompletionResponse.Logprobs = logprobs
+		defer func() { mock.CompletionResponse.Logprobs = nil }()
+
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:    "test",
+			Prompt:   "Hello!",
+			Stream:   &stream,
+			Logprobs: true,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if !mock.CompletionRequest.Logprobs {
+			t.Error("expected logprobs to be requested")
+		}
+
+		var resp api.GenerateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if diff := cmp.Diff(logprobs, resp.Logprobs); diff != "" {
+			t.Errorf("mismatch (-want +got):\n%s", diff)
+		}
+	})
+
+	t.Run("multiple completions", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test",
+			Prompt: "Hello!",
+			Stream: &stream,
+			N:      2,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if mock.CompletionRequest.N != 2 {
+			t.Errorf("expected 2 completions to be requested, got %d", mock.CompletionRequest.N)
+		}
+
+		var resp api.GenerateResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.Choices) != 2 {
+			t.Fatalf("expected 2 choices, got %d", len(resp.Choices))
+		}
+
+		for i, choice := range resp.Choices {
+			if choice.Index != i || choice.Response != "Hi!" || !choice.Done {
+				t.Errorf("unexpected choice %d: %+v", i, choice)
+			}
+		}
+	})
+
+	t.Run("too many top logprobs", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:       "test",
+			Prompt:      "Hello!",
+			Stream:      &stream,
+			Logprobs:    true,
+			TopLogprobs: 21,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"top_logprobs must be between 0 and 20"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("json format", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test",
+			Prompt: "Respond in JSON",
+			Stream: &stream,
+			Format: json.RawMessage(`"json"`),
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if !strings.HasPrefix(strings.TrimSpace(mock.CompletionRequest.Grammar), "root   ::= object") {
+			t.Errorf("unexpected grammar %q", mock.CompletionRequest.Grammar)
+		}
+	})
+
+	t.Run("grammar parameter", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:     "test-grammar",
+			Modelfile: "FROM test\nPARAMETER grammar root ::= [ab]",
+			Stream:    &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		w = createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test-grammar",
+			Prompt: "Hello!",
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if mock.CompletionRequest.Grammar != "root ::= [ab]" {
+			t.Errorf("unexpected grammar %q", mock.CompletionRequest.Grammar)
+		}
+
+		// the request's grammar takes precedence over the model's
+		w = createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:   "test-grammar",
+			Prompt:  "Hello!",
+			Stream:  &stream,
+			Choices: []string{"c"},
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		if mock.CompletionRequest.Grammar != "root ::= \"c\"\n" {
+			t.Errorf("unexpected grammar %q", mock.CompletionRequest.Grammar)
+		}
+	})
+
+	t.Run("invalid grammar parameter", func(t *testing.T) {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model:     "test-bad-grammar",
+			Modelfile: "FROM test\nPARAMETER grammar root ::= answer",
+			Stream:    &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Fatalf("expected status 400, got %d", w.Code)
+		}
+	})
+
+	t.Run("invalid json schema", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:  "test",
+			Prompt: "Hello!",
+			Stream: &stream,
+			Format: json.RawMessage(`{"$ref": "#/definitions/missing"}`),
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+	})
+
 	w = createRequest(t, s.CreateHandler, api.CreateRequest{
 		Model:     "test-system",
 		Modelfile: "FROM test\nSYSTEM You are a helpful assistant.",
@@ -711,4 +1272,76 @@ TEMPLATE """{{- if .Suffix }}<PRE> {{ .Prompt }} <SUF>{{ .Suffix }} <MID>
 			t.Errorf("mismatch (-got +want):\n%s", diff)
 		}
 	})
+
+	t.Run("draft model", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:   "test-system",
+			Prompt:  "Hello!",
+			Options: map[string]any{"draft_model": "test"},
+			Stream:  &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Errorf("expected status 200, got %d", w.Code)
+		}
+	})
+
+	t.Run("missing draft model", func(t *testing.T) {
+		w := createRequest(t, s.GenerateHandler, api.GenerateRequest{
+			Model:   "test-system",
+			Prompt:  "Hello!",
+			Options: map[string]any{"draft_model": "missing"},
+			Stream:  &stream,
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"draft model \"missing\" not found"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("ps", func(t *testing.T) {
+		opts := api.DefaultOptions()
+		opts.DraftModel = "test"
+
+		s.sched.loadedMu.Lock()
+		s.sched.loaded["test-system"] = &runnerRef{
+			llama:     &mock,
+			model:     &Model{ShortName: "test-system:latest"},
+			Options:   &opts,
+			expiresAt: time.Now().Add(time.Minute),
+		}
+		s.sched.loadedMu.Unlock()
+
+		defer func() {
+			s.sched.loadedMu.Lock()
+			delete(s.sched.loaded, "test-system")
+			s.sched.loadedMu.Unlock()
+		}()
+
+		w := createRequest(t, s.PsHandler, nil)
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+
+		var resp api.ProcessResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.Models) != 1 {
+			t.Fatalf("expected 1 model, got %d", len(resp.Models))
+		}
+
+		if diff := cmp.Diff(resp.Models[0].Draft, &api.ProcessDraftResponse{Model: "test", Proposed: 8, Accepted: 6, AcceptanceRate: 0.75}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if diff := cmp.Diff(resp.Queue, map[string]int{"high": 0, "normal": 0, "low": 2}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
 }
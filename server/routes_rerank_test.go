@@ -0,0 +1,153 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestRerank(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	var mock mockRunner
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, ggml *llm.GGML, gpus discover.GpuInfoList, numParallel int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(context.TODO())
+
+	for name, pooling := range map[string]uint32{"reranker": poolingTypeRank, "embedder": 1} {
+		w := createRequest(t, s.CreateHandler, api.CreateRequest{
+			Model: name,
+			Modelfile: fmt.Sprintf("FROM %s", createBinFile(t, llm.KV{
+				"general.architecture": "bert",
+				"bert.pooling_type":    pooling,
+			}, []llm.Tensor{})),
+			Stream: &stream,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d", w.Code)
+		}
+	}
+
+	t.Run("rank", func(t *testing.T) {
+		w := createRequest(t, s.RerankHandler, api.RerankRequest{
+			Model: "reranker",
+			Query: "capital of France",
+			Documents: []string{
+				"Berlin is in Germany",
+				"Paris is the capital of France",
+				"Lyon is in France",
+			},
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.RerankResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		var indices []int
+		for _, r := range resp.Results {
+			indices = append(indices, r.Index)
+		}
+
+		if diff := cmp.Diff(indices, []int{1, 2, 0}); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+
+		if got := resp.Results[0].Document; got != "Paris is the capital of France" {
+			t.Errorf("unexpected document %q", got)
+		}
+
+		if got := resp.Results[2].RelevanceScore; got != 0.5 {
+			t.Errorf("expected score 0.5 for an unrelated document, got %f", got)
+		}
+
+		if resp.PromptEvalCount != 17 {
+			t.Errorf("expected 17 prompt tokens, got %d", resp.PromptEvalCount)
+		}
+	})
+
+	t.Run("top n", func(t *testing.T) {
+		w := createRequest(t, s.RerankHandler, api.RerankRequest{
+			Model:     "reranker",
+			Query:     "capital of France",
+			Documents: []string{"Berlin is in Germany", "Paris is the capital of France"},
+			TopN:      1,
+		})
+
+		if w.Code != http.StatusOK {
+			t.Fatalf("expected status 200, got %d: %s", w.Code, w.Body)
+		}
+
+		var resp api.RerankResponse
+		if err := json.NewDecoder(w.Body).Decode(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		if len(resp.Results) != 1 || resp.Results[0].Index != 1 {
+			t.Errorf("unexpected results %+v", resp.Results)
+		}
+	})
+
+	t.Run("missing query", func(t *testing.T) {
+		w := createRequest(t, s.RerankHandler, api.RerankRequest{
+			Model:     "reranker",
+			Documents: []string{"Paris"},
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"query is required"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+
+	t.Run("missing capabilities", func(t *testing.T) {
+		w := createRequest(t, s.RerankHandler, api.RerankRequest{
+			Model:     "embedder",
+			Query:     "capital of France",
+			Documents: []string{"Paris"},
+		})
+
+		if w.Code != http.StatusBadRequest {
+			t.Errorf("expected status 400, got %d", w.Code)
+		}
+
+		if diff := cmp.Diff(w.Body.String(), `{"error":"\"embedder\" does not support rerank"}`); diff != "" {
+			t.Errorf("mismatch (-got +want):\n%s", diff)
+		}
+	})
+}
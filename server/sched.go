@@ -30,10 +30,13 @@ type LlmRequest struct {
 	successCh       chan *runnerRef
 	errCh           chan error
 	schedAttempts   uint
+	priority        llm.Priority
+	queuedAt        time.Time
 }
 
 type Scheduler struct {
 	pendingReqCh  chan *LlmRequest
+	queue         pendingQueue
 	finishedReqCh chan *LlmRequest
 	expiredCh     chan *runnerRef
 	unloadedCh    chan interface{}
@@ -42,7 +45,7 @@ type Scheduler struct {
 	loadedMu sync.Mutex
 
 	loadFn       func(req *LlmRequest, ggml *llm.GGML, gpus discover.GpuInfoList, numParallel int)
-	newServerFn  func(gpus discover.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, opts api.Options, numParallel int) (llm.LlamaServer, error)
+	newServerFn  func(gpus discover.GpuInfoList, model string, ggml *llm.GGML, adapters []string, projectors []string, draft string, opts api.Options, numParallel int) (llm.LlamaServer, error)
 	getGpuFn     func() discover.GpuInfoList
 	getCpuFn     func() discover.GpuInfoList
 	reschedDelay time.Duration
@@ -88,8 +91,15 @@ func (s *Scheduler) GetRunner(c context.Context, model *Model, opts api.Options,
 		model:           model,
 		opts:            opts,
 		sessionDuration: sessionDuration,
-		successCh:       make(chan *runnerRef),
+		successCh:       make(chan *runnerRef, 1),
 		errCh:           make(chan error, 1),
+		priority:        llm.PriorityFromContext(c),
+		queuedAt:        time.Now(),
+	}
+
+	if s.queue.len() >= cap(s.pendingReqCh) {
+		req.errCh <- ErrMaxQueue
+		return req.successCh, req.errCh
 	}
 
 	select {
@@ -103,6 +113,10 @@ func (s *Scheduler) GetRunner(c context.Context, model *Model, opts api.Options,
 // Returns immediately, spawns go routines for the scheduler which will shutdown when ctx is done
 func (s *Scheduler) Run(ctx context.Context) {
 	slog.Debug("starting llm scheduler")
+	go func() {
+		s.queuePending(ctx)
+	}()
+
 	go func() {
 		s.processPending(ctx)
 	}()
@@ -112,200 +126,221 @@ func (s *Scheduler) Run(ctx context.Context) {
 	}()
 }
 
-func (s *Scheduler) processPending(ctx context.Context) {
+// queuePending moves new requests into the queue so that they are
+// scheduled by priority
+func (s *Scheduler) queuePending(ctx context.Context) {
 	for {
 		select {
 		case <-ctx.Done():
-			slog.Debug("shutting down scheduler pending loop")
 			return
 		case pending := <-s.pendingReqCh:
-			// Block other requests until we get this pending request running
-			pending.schedAttempts++
-			if pending.origNumCtx == 0 {
-				pending.origNumCtx = pending.opts.NumCtx
-			}
+			s.queue.push(pending)
+		}
+	}
+}
 
-			if pending.ctx.Err() != nil {
-				slog.Debug("pending request cancelled or timed out, skipping scheduling")
-				continue
-			}
-			numParallel := int(envconfig.NumParallel())
-			// TODO (jmorganca): multimodal models don't support parallel yet
-			// see https://github.com/ollama/ollama/issues/4165
-			if len(pending.model.ProjectorPaths) > 0 && numParallel != 1 {
-				numParallel = 1
-				slog.Warn("multimodal models don't support parallel requests yet")
+func (s *Scheduler) processPending(ctx context.Context) {
+	for {
+		pending := s.queue.pop()
+		if pending == nil {
+			select {
+			case <-ctx.Done():
+				slog.Debug("shutting down scheduler pending loop")
+				return
+			case <-s.queue.wait():
+			case <-s.unloadedCh:
+				// An unload request when there are no pending request can be ignored
+				slog.Debug("ignoring unload event with no pending requests")
 			}
+			continue
+		}
 
-			for {
-				var runnerToExpire *runnerRef
-				s.loadedMu.Lock()
-				runner := s.loaded[pending.model.ModelPath]
-				loadedCount := len(s.loaded)
-				s.loadedMu.Unlock()
-				if runner != nil {
-					if runner.needsReload(ctx, pending) {
-						runnerToExpire = runner
-					} else {
-						// Runner is usable, return it
-						pending.useLoadedRunner(runner, s.finishedReqCh)
-						break
-					}
-				} else if envconfig.MaxRunners() > 0 && loadedCount >= int(envconfig.MaxRunners()) {
-					slog.Debug("max runners achieved, unloading one to make room", "runner_count", loadedCount)
-					runnerToExpire = s.findRunnerToUnload()
+		// Block other requests until we get this pending request running
+		pending.schedAttempts++
+		if pending.origNumCtx == 0 {
+			pending.origNumCtx = pending.opts.NumCtx
+		}
+
+		if pending.ctx.Err() != nil {
+			slog.Debug("pending request cancelled or timed out, skipping scheduling")
+			continue
+		}
+		numParallel := int(envconfig.NumParallel())
+		// TODO (jmorganca): multimodal models don't support parallel yet
+		// see https://github.com/ollama/ollama/issues/4165
+		if len(pending.model.ProjectorPaths) > 0 && numParallel != 1 {
+			numParallel = 1
+			slog.Warn("multimodal models don't support parallel requests yet")
+		}
+
+		for {
+			var runnerToExpire *runnerRef
+			s.loadedMu.Lock()
+			runner := s.loaded[pending.model.ModelPath]
+			loadedCount := len(s.loaded)
+			s.loadedMu.Unlock()
+			if runner != nil {
+				if runner.needsReload(ctx, pending) {
+					runnerToExpire = runner
+					metrics.evictions.Inc("reload")
 				} else {
-					// Either no models are loaded or below envconfig.MaxRunners
-					// Get a refreshed GPU list
-					var gpus discover.GpuInfoList
-					if pending.opts.NumGPU == 0 {
-						gpus = s.getCpuFn()
-					} else {
-						gpus = s.getGpuFn()
-					}
+					// Runner is usable, return it
+					pending.useLoadedRunner(runner, s.finishedReqCh)
+					break
+				}
+			} else if envconfig.MaxRunners() > 0 && loadedCount >= int(envconfig.MaxRunners()) {
+				slog.Debug("max runners achieved, unloading one to make room", "runner_count", loadedCount)
+				runnerToExpire = s.findRunnerToUnload()
+				if runnerToExpire != nil {
+					metrics.evictions.Inc("max_runners")
+				}
+			} else {
+				// Either no models are loaded or below envconfig.MaxRunners
+				// Get a refreshed GPU list
+				var gpus discover.GpuInfoList
+				if pending.opts.NumGPU == 0 {
+					gpus = s.getCpuFn()
+				} else {
+					gpus = s.getGpuFn()
+				}
 
-					if envconfig.MaxRunners() <= 0 {
-						// No user specified MaxRunners, so figure out what automatic setting to use
-						// If all GPUs have reliable free memory reporting, defaultModelsPerGPU * the number of GPUs
-						// if any GPU has unreliable free memory reporting, 1x the number of GPUs
-						allReliable := true
-						for _, gpu := range gpus {
-							if gpu.UnreliableFreeMemory {
-								allReliable = false
-								break
-							}
-						}
-						if allReliable {
-							// HACK
-							os.Setenv("OLLAMA_MAX_LOADED_MODELS", strconv.Itoa(defaultModelsPerGPU*len(gpus)))
-							slog.Debug("updating default concurrency", "OLLAMA_MAX_LOADED_MODELS", envconfig.MaxRunners, "gpu_count", len(gpus))
-						} else {
-							// HACK
-							os.Setenv("OLLAMA_MAX_LOADED_MODELS", strconv.Itoa(len(gpus)))
-							slog.Info("one or more GPUs detected that are unable to accurately report free memory - disabling default concurrency")
+				if envconfig.MaxRunners() <= 0 {
+					// No user specified MaxRunners, so figure out what automatic setting to use
+					// If all GPUs have reliable free memory reporting, defaultModelsPerGPU * the number of GPUs
+					// if any GPU has unreliable free memory reporting, 1x the number of GPUs
+					allReliable := true
+					for _, gpu := range gpus {
+						if gpu.UnreliableFreeMemory {
+							allReliable = false
+							break
 						}
 					}
-
-					// Load model for fitting
-					ggml, err := llm.LoadModel(pending.model.ModelPath, 0)
-					if err != nil {
-						pending.errCh <- err
-						break
+					if allReliable {
+						// HACK
+						os.Setenv("OLLAMA_MAX_LOADED_MODELS", strconv.Itoa(defaultModelsPerGPU*len(gpus)))
+						slog.Debug("updating default concurrency", "OLLAMA_MAX_LOADED_MODELS", envconfig.MaxRunners, "gpu_count", len(gpus))
+					} else {
+						// HACK
+						os.Setenv("OLLAMA_MAX_LOADED_MODELS", strconv.Itoa(len(gpus)))
+						slog.Info("one or more GPUs detected that are unable to accurately report free memory - disabling default concurrency")
 					}
+				}
 
-					// Embedding models should always be loaded with parallel=1
-					if pending.model.CheckCapabilities(CapabilityCompletion) != nil {
-						numParallel = 1
-					}
+				// Load model for fitting
+				ggml, err := llm.LoadModel(pending.model.ModelPath, 0)
+				if err != nil {
+					pending.errCh <- err
+					break
+				}
 
-					// Evaluate if the model will fit in the available system memory, or if we should unload a model first
-					if len(gpus) == 1 && gpus[0].Library == "cpu" {
-						// simplifying assumption of defaultParallel when in CPU mode
-						if numParallel <= 0 {
-							numParallel = defaultParallel
-						}
+				// Evaluate if the model will fit in the available system memory, or if we should unload a model first
+				if len(gpus) == 1 && gpus[0].Library == "cpu" {
+					// simplifying assumption of defaultParallel when in CPU mode
+					if numParallel <= 0 {
+						numParallel = defaultParallel
+					}
 
-						pending.opts.NumCtx = pending.origNumCtx * numParallel
+					pending.opts.NumCtx = pending.origNumCtx * numParallel
 
-						if loadedCount == 0 {
-							slog.Debug("cpu mode with first model, loading")
-							s.loadFn(pending, ggml, gpus, numParallel)
-							break
-						}
-						runnerToExpire = s.maybeFindCPURunnerToUnload(pending, ggml, gpus)
-						if runnerToExpire == nil {
-							slog.Debug("cpu mode with available system memory or first model, loading")
-							s.loadFn(pending, ggml, gpus, numParallel)
-							break
-						}
-						// else we need to expire a runner
-					} else if loadedCount == 0 {
-						// No models loaded. Load the model but prefer the best fit.
-						slog.Debug("loading first model", "model", pending.model.ModelPath)
-						g := pickBestFullFitByLibrary(pending, ggml, gpus, &numParallel)
-						if g != nil {
-							gpus = g
-						} else {
-							// Only allow partial loads when this is the first model
-							gpus = pickBestPartialFitByLibrary(pending, ggml, gpus, &numParallel)
-						}
+					if loadedCount == 0 {
+						slog.Debug("cpu mode with first model, loading")
 						s.loadFn(pending, ggml, gpus, numParallel)
 						break
 					}
-
+					runnerToExpire = s.maybeFindCPURunnerToUnload(pending, ggml, gpus)
 					if runnerToExpire == nil {
-						// More than one loaded model, so we have to see if the
-						// new one fits
-						//
-						// We want to avoid loading on any GPUs that have other
-						// models still loading on them to avoid potential races
-						// with VRAM consumption ramping up during load
-						availGpus := s.filterGPUsWithoutLoadingModels(gpus)
-
-						// Update free memory from currently loaded models
-						s.updateFreeSpace(availGpus)
-						fitGpus := pickBestFullFitByLibrary(pending, ggml, availGpus, &numParallel)
-						if fitGpus != nil {
-							slog.Debug("new model fits with existing models, loading")
-							s.loadFn(pending, ggml, fitGpus, numParallel)
-							break
-						}
-
-						// We couldn't find a set of GPUs to fully load the new
-						// model. If no other models are loading (both GPU lists
-						// are the same) then we need to unload another model to
-						// make room
-						if len(availGpus) < len(gpus) {
-							// There are other requests pending, and this one
-							// needs more time, so put it on the back of the
-							// queue so that we might satisfy other pending
-							// requests that aren't blocked
-							go func() {
-								// Process in a go routine to avoid deadlocking
-								// the scheduler if our queue is full
-								slog.Debug("delaying scheduling while other models finish loading", "attempts", pending.schedAttempts, "model", pending.model.ModelPath)
-								time.Sleep(s.reschedDelay)
-								s.pendingReqCh <- pending
-							}()
-							break
-						}
-						runnerToExpire = s.findRunnerToUnload()
+						slog.Debug("cpu mode with available system memory or first model, loading")
+						s.loadFn(pending, ggml, gpus, numParallel)
+						break
+					}
+					metrics.evictions.Inc("memory")
+					// else we need to expire a runner
+				} else if loadedCount == 0 {
+					// No models loaded. Load the model but prefer the best fit.
+					slog.Debug("loading first model", "model", pending.model.ModelPath)
+					g := pickBestFullFitByLibrary(pending, ggml, gpus, &numParallel)
+					if g != nil {
+						gpus = g
+					} else {
+						// Only allow partial loads when this is the first model
+						gpus = pickBestPartialFitByLibrary(pending, ggml, gpus, &numParallel)
 					}
+					s.loadFn(pending, ggml, gpus, numParallel)
+					break
 				}
 
 				if runnerToExpire == nil {
-					// Shouildn't happen
-					slog.Error("runner to expire was nil!")
-					continue
-				}
-				// Trigger an expiration to unload once it's done
-				runnerToExpire.refMu.Lock()
-				slog.Debug("resetting model to expire immediately to make room", "modelPath", runnerToExpire.modelPath, "refCount", runnerToExpire.refCount)
-				if runnerToExpire.expireTimer != nil {
-					runnerToExpire.expireTimer.Stop()
-					runnerToExpire.expireTimer = nil
-				}
-				runnerToExpire.sessionDuration = 0
-				if runnerToExpire.refCount <= 0 {
-					s.expiredCh <- runnerToExpire
-				}
-				runnerToExpire.refMu.Unlock()
-				// Wait for the unload to happen
-				// Note: at this point we're queueing up all incoming requests, even if they were for
-				// a different model that's loaded and not scheduled to be removed.
-				slog.Debug("waiting for pending requests to complete and unload to occur", "modelPath", runnerToExpire.modelPath)
-				select {
-				case <-ctx.Done():
-					slog.Debug("shutting down scheduler pending loop")
-					return
-				case <-s.unloadedCh:
-					slog.Debug("unload completed", "modelPath", runnerToExpire.modelPath)
-					continue
+					// More than one loaded model, so we have to see if the
+					// new one fits
+					//
+					// We want to avoid loading on any GPUs that have other
+					// models still loading on them to avoid potential races
+					// with VRAM consumption ramping up during load
+					availGpus := s.filterGPUsWithoutLoadingModels(gpus)
+
+					// Update free memory from currently loaded models
+					s.updateFreeSpace(availGpus)
+					fitGpus := pickBestFullFitByLibrary(pending, ggml, availGpus, &numParallel)
+					if fitGpus != nil {
+						slog.Debug("new model fits with existing models, loading")
+						s.loadFn(pending, ggml, fitGpus, numParallel)
+						break
+					}
+
+					// We couldn't find a set of GPUs to fully load the new
+					// model. If no other models are loading (both GPU lists
+					// are the same) then we need to unload another model to
+					// make room
+					if len(availGpus) < len(gpus) {
+						// There are other requests pending, and this one
+						// needs more time, so put it on the back of the
+						// queue so that we might satisfy other pending
+						// requests that aren't blocked
+						go func() {
+							// Process in a go routine to avoid deadlocking
+							// the scheduler if our queue is full
+							slog.Debug("delaying scheduling while other models finish loading", "attempts", pending.schedAttempts, "model", pending.model.ModelPath)
+							time.Sleep(s.reschedDelay)
+							s.pendingReqCh <- pending
+						}()
+						break
+					}
+					runnerToExpire = s.findRunnerToUnload()
+					if runnerToExpire != nil {
+						metrics.evictions.Inc("memory")
+					}
 				}
 			}
-		case <-s.unloadedCh:
-			// An unload request when there are no pending request can be ignored
-			slog.Debug("ignoring unload event with no pending requests")
+
+			if runnerToExpire == nil {
+				// Shouildn't happen
+				slog.Error("runner to expire was nil!")
+				continue
+			}
+			// Trigger an expiration to unload once it's done
+			runnerToExpire.refMu.Lock()
+			slog.Debug("resetting model to expire immediately to make room", "modelPath", runnerToExpire.modelPath, "refCount", runnerToExpire.refCount)
+			if runnerToExpire.expireTimer != nil {
+				runnerToExpire.expireTimer.Stop()
+				runnerToExpire.expireTimer = nil
+			}
+			runnerToExpire.sessionDuration = 0
+			if runnerToExpire.refCount <= 0 {
+				s.expiredCh <- runnerToExpire
+			}
+			runnerToExpire.refMu.Unlock()
+			// Wait for the unload to happen
+			// Note: at this point we're queueing up all incoming requests, even if they were for
+			// a different model that's loaded and not scheduled to be removed.
+			slog.Debug("waiting for pending requests to complete and unload to occur", "modelPath", runnerToExpire.modelPath)
+			select {
+			case <-ctx.Done():
+				slog.Debug("shutting down scheduler pending loop")
+				return
+			case <-s.unloadedCh:
+				slog.Debug("unload completed", "modelPath", runnerToExpire.modelPath)
+				continue
+			}
 		}
 	}
 }
@@ -377,6 +412,7 @@ func (s *Scheduler) processCompleted(ctx context.Context) {
 			runner.unload()
 			delete(s.loaded, runner.modelPath)
 			s.loadedMu.Unlock()
+			metrics.unloads.Inc()
 			slog.Debug("runner released", "modelPath", runner.modelPath)
 			runner.refMu.Unlock()
 
@@ -417,7 +453,8 @@ func (s *Scheduler) load(req *LlmRequest, ggml *llm.GGML, gpus discover.GpuInfoL
 	if req.sessionDuration != nil {
 		sessionDuration = req.sessionDuration.Duration
 	}
-	llama, err := s.newServerFn(gpus, req.model.ModelPath, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts, numParallel)
+	start := time.Now()
+	llama, err := s.newServerFn(gpus, req.model.ModelPath, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.model.DraftPath, req.opts, numParallel)
 	if err != nil {
 		// some older models are not compatible with newer versions of llama.cpp
 		// show a generalized compatibility error until there is a better way to
@@ -426,6 +463,7 @@ func (s *Scheduler) load(req *LlmRequest, ggml *llm.GGML, gpus discover.GpuInfoL
 			err = fmt.Errorf("%v: this model may be incompatible with your version of Ollama. If you previously pulled this model, try updating it by running `ollama pull %s`", err, req.model.ShortName)
 		}
 		slog.Info("NewLlamaServer failed", "model", req.model.ModelPath, "error", err)
+		metrics.loadFailures.Inc(req.model.ShortName)
 		req.errCh <- err
 		return
 	}
@@ -453,6 +491,7 @@ func (s *Scheduler) load(req *LlmRequest, ggml *llm.GGML, gpus discover.GpuInfoL
 		defer runner.refMu.Unlock()
 		if err = llama.WaitUntilRunning(req.ctx); err != nil {
 			slog.Error("error loading llama server", "error", err)
+			metrics.loadFailures.Inc(req.model.ShortName)
 			runner.refCount--
 			req.errCh <- err
 			slog.Debug("triggering expiration for failed load", "model", runner.modelPath)
@@ -460,6 +499,7 @@ func (s *Scheduler) load(req *LlmRequest, ggml *llm.GGML, gpus discover.GpuInfoL
 			return
 		}
 		slog.Debug("finished setting up runner", "model", req.model.ModelPath)
+		metrics.loadDuration.Observe(time.Since(start).Seconds(), req.model.ShortName)
 		runner.loading = false
 		go func() {
 			<-req.ctx.Done()
@@ -600,6 +640,7 @@ func (runner *runnerRef) needsReload(ctx context.Context, req *LlmRequest) bool
 	defer cancel()
 	if !reflect.DeepEqual(runner.model.AdapterPaths, req.model.AdapterPaths) || // have the adapters changed?
 		!reflect.DeepEqual(runner.model.ProjectorPaths, req.model.ProjectorPaths) || // have the projectors changed?
+		runner.model.DraftPath != req.model.DraftPath || // has the draft model changed?
 		!reflect.DeepEqual(optsExisting, optsNew) || // have the runner options changed?
 		runner.llama.Ping(ctx) != nil {
 		return true
@@ -710,7 +751,7 @@ func pickBestFullFitByLibrary(req *LlmRequest, ggml *llm.GGML, gpus discover.Gpu
 			req.opts.NumCtx = req.origNumCtx * p
 			if !envconfig.SchedSpread() {
 				for _, g := range sgl {
-					if ok, estimatedVRAM = llm.PredictServerFit([]discover.GpuInfo{g}, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts); ok {
+					if ok, estimatedVRAM = llm.PredictServerFit([]discover.GpuInfo{g}, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.model.DraftPath, req.opts); ok {
 						slog.Info("new model will fit in available VRAM in single GPU, loading", "model", req.model.ModelPath, "gpu", g.ID, "parallel", p, "available", g.FreeMemory, "required", format.HumanBytes2(estimatedVRAM))
 						*numParallel = p
 						return []discover.GpuInfo{g}
@@ -726,7 +767,7 @@ func pickBestFullFitByLibrary(req *LlmRequest, ggml *llm.GGML, gpus discover.Gpu
 		// Now try all the GPUs
 		for _, p := range numParallelToTry {
 			req.opts.NumCtx = req.origNumCtx * p
-			if ok, estimatedVRAM = llm.PredictServerFit(sgl, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts); ok {
+			if ok, estimatedVRAM = llm.PredictServerFit(sgl, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.model.DraftPath, req.opts); ok {
 				slog.Info("new model will fit in available VRAM, loading", "model", req.model.ModelPath, "library", sgl[0].Library, "parallel", p, "required", format.HumanBytes2(estimatedVRAM))
 				*numParallel = p
 				return sgl
@@ -749,7 +790,7 @@ func pickBestPartialFitByLibrary(req *LlmRequest, ggml *llm.GGML, gpus discover.
 	var bestEstimate uint64
 	var bestFit int
 	for i, gl := range byLibrary {
-		_, estimatedVRAM := llm.PredictServerFit(gl, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.opts)
+		_, estimatedVRAM := llm.PredictServerFit(gl, ggml, req.model.AdapterPaths, req.model.ProjectorPaths, req.model.DraftPath, req.opts)
 		if estimatedVRAM > bestEstimate {
 			bestEstimate = estimatedVRAM
 			bestFit = i
@@ -824,7 +865,7 @@ func (s *Scheduler) expireRunner(model *Model) {
 // If not, pick a runner to unload, else return nil and the request can be loaded
 func (s *Scheduler) maybeFindCPURunnerToUnload(req *LlmRequest, ggml *llm.GGML, gpus discover.GpuInfoList) *runnerRef {
 	slog.Debug("evaluating if CPU model load will fit in available system memory")
-	estimate := llm.EstimateGPULayers(gpus, ggml, req.model.ProjectorPaths, req.opts)
+	estimate := llm.EstimateGPULayers(gpus, ggml, req.model.ProjectorPaths, req.model.DraftPath, req.opts)
 	if estimate.TotalSize <= gpus[0].FreeMemory {
 		slog.Debug("cpu inference mode, model fits in available system memory", "model", format.HumanBytes2(estimate.TotalSize), "available", format.HumanBytes2(gpus[0].FreeMemory))
 		return nil
@@ -0,0 +1,390 @@
+package server
+
+import (
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"math"
+	"net/http"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/types/model"
+)
+
+var (
+	errSessionNotFound = errors.New("session not found")
+	errSessionInUse    = errors.New("session is in use")
+)
+
+// savedSession is a session as it is saved. Only requests made with APIKey,
+// the name of the key which created the session, can use it.
+type savedSession struct {
+	api.Session
+	APIKey string `json:"api_key,omitempty"`
+}
+
+// sessionStore keeps chat sessions in a directory, each in ID.json. Sessions
+// that go unused for longer than ttl are deleted.
+type sessionStore struct {
+	dir string
+	ttl time.Duration
+
+	mu sync.Mutex
+
+	// inUse holds the sessions with a chat request in progress
+	inUse map[string]bool
+}
+
+func newSessionStore(dir string, ttl time.Duration) (*sessionStore, error) {
+	if err := os.MkdirAll(dir, 0o755); err != nil {
+		return nil, err
+	}
+
+	return &sessionStore{dir: dir, ttl: ttl, inUse: make(map[string]bool)}, nil
+}
+
+func (ss *sessionStore) path(id string) string {
+	return filepath.Join(ss.dir, id+".json")
+}
+
+func (ss *sessionStore) expired(sess *api.Session) bool {
+	return time.Since(sess.UpdatedAt) > ss.ttl
+}
+
+// withExpiry sets when sess expires unless it is used again
+func (ss *sessionStore) withExpiry(sess *api.Session) *api.Session {
+	if ss.ttl < time.Duration(math.MaxInt64) {
+		expiresAt := sess.UpdatedAt.Add(ss.ttl)
+		sess.ExpiresAt = &expiresAt
+	}
+
+	return sess
+}
+
+// read returns a session owned by apiKey. Sessions of other keys aren't
+// found. It must be called with mu held.
+func (ss *sessionStore) read(id, apiKey string) (*api.Session, error) {
+	if !validID("session-", id) {
+		return nil, errSessionNotFound
+	}
+
+	b, err := os.ReadFile(ss.path(id))
+	if errors.Is(err, os.ErrNotExist) {
+		return nil, errSessionNotFound
+	} else if err != nil {
+		return nil, err
+	}
+
+	var saved savedSession
+	if err := json.Unmarshal(b, &saved); err != nil {
+		return nil, err
+	}
+
+	if saved.APIKey != apiKey {
+		return nil, errSessionNotFound
+	}
+
+	if ss.expired(&saved.Session) && !ss.inUse[id] {
+		return nil, errSessionNotFound
+	}
+
+	return &saved.Session, nil
+}
+
+// write saves a session owned by apiKey, replacing it atomically. It must be
+// called with mu held.
+func (ss *sessionStore) write(sess *api.Session, apiKey string) error {
+	stored := savedSession{Session: *sess, APIKey: apiKey}
+	stored.ExpiresAt = nil
+
+	b, err := json.Marshal(stored)
+	if err != nil {
+		return err
+	}
+
+	f, err := os.CreateTemp(ss.dir, "session-*.tmp")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+
+	if _, err := f.Write(b); err != nil {
+		f.Close()
+		return err
+	}
+
+	if err := f.Close(); err != nil {
+		return err
+	}
+
+	return os.Rename(f.Name(), ss.path(sess.ID))
+}
+
+// create starts a session owned by apiKey
+func (ss *sessionStore) create(model, apiKey string, msgs []api.Message) (*api.Session, error) {
+	now := time.Now().UTC()
+	sess := api.Session{
+		ID:        newID("session-"),
+		Model:     model,
+		Messages:  msgs,
+		CreatedAt: now,
+		UpdatedAt: now,
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if err := ss.write(&sess, apiKey); err != nil {
+		return nil, err
+	}
+
+	return ss.withExpiry(&sess), nil
+}
+
+func (ss *sessionStore) get(id, apiKey string) (*api.Session, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sess, err := ss.read(id, apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	return ss.withExpiry(sess), nil
+}
+
+// list returns the sessions owned by apiKey without their messages, most
+// recently used first
+func (ss *sessionStore) list(apiKey string) ([]api.Session, error) {
+	matches, err := filepath.Glob(filepath.Join(ss.dir, "session-*.json"))
+	if err != nil {
+		return nil, err
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sessions := []api.Session{}
+	for _, match := range matches {
+		sess, err := ss.read(strings.TrimSuffix(filepath.Base(match), ".json"), apiKey)
+		if errors.Is(err, errSessionNotFound) {
+			// owned by another key or expired
+			continue
+		} else if err != nil {
+			return nil, err
+		}
+
+		sess.Messages = nil
+		sessions = append(sessions, *ss.withExpiry(sess))
+	}
+
+	slices.SortStableFunc(sessions, func(a, b api.Session) int {
+		return cmp.Or(b.UpdatedAt.Compare(a.UpdatedAt), strings.Compare(a.ID, b.ID))
+	})
+
+	return sessions, nil
+}
+
+func (ss *sessionStore) delete(id, apiKey string) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	if _, err := ss.read(id, apiKey); err != nil {
+		return err
+	}
+
+	if err := os.Remove(ss.path(id)); err != nil && !errors.Is(err, os.ErrNotExist) {
+		return err
+	}
+
+	return nil
+}
+
+// acquire returns a session owned by apiKey for a chat request, which must
+// call release once it is done. A session serves one chat request at a time
+// so that its messages stay in order.
+func (ss *sessionStore) acquire(id, apiKey string) (*api.Session, error) {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	sess, err := ss.read(id, apiKey)
+	if err != nil {
+		return nil, err
+	}
+
+	if ss.inUse[id] {
+		return nil, errSessionInUse
+	}
+
+	ss.inUse[id] = true
+	return sess, nil
+}
+
+// release ends a chat request on a session, adding msgs to it. A session
+// deleted in the meantime is not recreated.
+func (ss *sessionStore) release(id, apiKey string, msgs ...api.Message) error {
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	defer delete(ss.inUse, id)
+
+	if len(msgs) == 0 {
+		return nil
+	}
+
+	sess, err := ss.read(id, apiKey)
+	if errors.Is(err, errSessionNotFound) {
+		return nil
+	} else if err != nil {
+		return err
+	}
+
+	sess.Messages = append(sess.Messages, msgs...)
+	sess.UpdatedAt = time.Now().UTC()
+	return ss.write(sess, apiKey)
+}
+
+// expire deletes sessions that have gone unused for longer than the TTL
+func (ss *sessionStore) expire() {
+	matches, err := filepath.Glob(filepath.Join(ss.dir, "session-*.json"))
+	if err != nil {
+		slog.Warn("failed to list sessions", "error", err)
+		return
+	}
+
+	ss.mu.Lock()
+	defer ss.mu.Unlock()
+
+	for _, match := range matches {
+		id := strings.TrimSuffix(filepath.Base(match), ".json")
+		if ss.inUse[id] {
+			continue
+		}
+
+		b, err := os.ReadFile(match)
+		if err != nil {
+			continue
+		}
+
+		var sess api.Session
+		if err := json.Unmarshal(b, &sess); err != nil {
+			slog.Warn("failed to read session", "id", id, "error", err)
+			continue
+		}
+
+		if ss.expired(&sess) {
+			slog.Debug("expiring session", "id", id, "updated_at", sess.UpdatedAt)
+			if err := os.Remove(match); err != nil && !errors.Is(err, os.ErrNotExist) {
+				slog.Warn("failed to expire session", "id", id, "error", err)
+			}
+		}
+	}
+}
+
+// run expires sessions periodically until ctx is done
+func (ss *sessionStore) run(ctx context.Context) {
+	ticker := time.NewTicker(time.Minute)
+	defer ticker.Stop()
+
+	for {
+		ss.expire()
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+// sameModel reports whether a and b name the same model
+func sameModel(a, b string) bool {
+	return strings.EqualFold(model.ParseName(a).String(), model.ParseName(b).String())
+}
+
+func (s *Server) CreateSessionHandler(c *gin.Context) {
+	var req api.CreateSessionRequest
+	if err := c.ShouldBindJSON(&req); errors.Is(err, io.EOF) {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "missing request body"})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		return
+	}
+
+	if req.Model == "" {
+		c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": "model is required"})
+		return
+	}
+
+	// the session stays on the model the alias resolves to now
+	name := s.resolveModel(c, req.Model)
+	_, name, err := getModel(c.Request.Context(), name)
+	if err != nil {
+		switch {
+		case os.IsNotExist(err):
+			c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("model '%s' not found", req.Model)})
+		case err.Error() == "invalid model name":
+			c.AbortWithStatusJSON(http.StatusBadRequest, gin.H{"error": err.Error()})
+		default:
+			c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		}
+		return
+	}
+
+	sess, err := s.sessions.create(name, c.GetString(apiKeyContextKey), req.Messages)
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, sess)
+}
+
+func (s *Server) ListSessionsHandler(c *gin.Context) {
+	sessions, err := s.sessions.list(c.GetString(apiKeyContextKey))
+	if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, api.ListSessionsResponse{Sessions: sessions})
+}
+
+func (s *Server) SessionHandler(c *gin.Context) {
+	sess, err := s.sessions.get(c.Param("id"), c.GetString(apiKeyContextKey))
+	if errors.Is(err, errSessionNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("session %q not found", c.Param("id"))})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.JSON(http.StatusOK, sess)
+}
+
+func (s *Server) DeleteSessionHandler(c *gin.Context) {
+	err := s.sessions.delete(c.Param("id"), c.GetString(apiKeyContextKey))
+	if errors.Is(err, errSessionNotFound) {
+		c.AbortWithStatusJSON(http.StatusNotFound, gin.H{"error": fmt.Sprintf("session %q not found", c.Param("id"))})
+		return
+	} else if err != nil {
+		c.AbortWithStatusJSON(http.StatusInternalServerError, gin.H{"error": err.Error()})
+		return
+	}
+
+	c.Status(http.StatusOK)
+}
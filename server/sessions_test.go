@@ -0,0 +1,167 @@
+package server
+
+import (
+	"errors"
+	"os"
+	"testing"
+	"time"
+
+	"github.com/google/go-cmp/cmp"
+
+	"github.com/ollama/ollama/api"
+)
+
+func TestSessionStore(t *testing.T) {
+	dir := t.TempDir()
+	ss, err := newSessionStore(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sess, err := ss.create("test", "", []api.Message{{Role: "system", Content: "Be brief."}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !validID("session-", sess.ID) || sess.ExpiresAt == nil || !sess.ExpiresAt.Equal(sess.UpdatedAt.Add(time.Hour)) {
+		t.Errorf("unexpected session %+v", sess)
+	}
+
+	if _, err := ss.acquire(sess.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ss.acquire(sess.ID, ""); !errors.Is(err, errSessionInUse) {
+		t.Errorf("expected %v, got %v", errSessionInUse, err)
+	}
+
+	if err := ss.release(sess.ID, "", api.Message{Role: "user", Content: "Hello!"}, api.Message{Role: "assistant", Content: "Hi!"}); err != nil {
+		t.Fatal(err)
+	}
+
+	// sessions are read back from disk
+	ss, err = newSessionStore(dir, time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	got, err := ss.get(sess.ID, "")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if diff := cmp.Diff(got.Messages, []api.Message{
+		{Role: "system", Content: "Be brief."},
+		{Role: "user", Content: "Hello!"},
+		{Role: "assistant", Content: "Hi!"},
+	}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	if !got.UpdatedAt.After(sess.UpdatedAt) {
+		t.Errorf("expected updated_at to advance, got %v", got.UpdatedAt)
+	}
+
+	list, err := ss.list("")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(list) != 1 || list[0].ID != sess.ID || list[0].Messages != nil {
+		t.Errorf("unexpected list %+v", list)
+	}
+
+	if _, err := ss.get("session-../../etc", ""); !errors.Is(err, errSessionNotFound) {
+		t.Errorf("expected %v, got %v", errSessionNotFound, err)
+	}
+
+	if err := ss.delete(sess.ID, ""); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ss.get(sess.ID, ""); !errors.Is(err, errSessionNotFound) {
+		t.Errorf("expected %v, got %v", errSessionNotFound, err)
+	}
+}
+
+func TestSessionExpiry(t *testing.T) {
+	ss, err := newSessionStore(t.TempDir(), time.Minute)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	idle, err := ss.create("test", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	active, err := ss.create("test", "", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// age both sessions past the TTL
+	for _, sess := range []*api.Session{idle, active} {
+		sess.UpdatedAt = time.Now().Add(-2 * time.Minute)
+		if err := ss.write(sess, ""); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if _, err := ss.get(idle.ID, ""); !errors.Is(err, errSessionNotFound) {
+		t.Errorf("expected %v, got %v", errSessionNotFound, err)
+	}
+
+	// a session is not expired while a request is using it
+	ss.inUse[active.ID] = true
+	ss.expire()
+
+	if _, err := os.Stat(ss.path(idle.ID)); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected idle session to be deleted, got %v", err)
+	}
+
+	if _, err := os.Stat(ss.path(active.ID)); err != nil {
+		t.Errorf("expected active session to be kept, got %v", err)
+	}
+}
+
+func TestSessionStoreAPIKeys(t *testing.T) {
+	ss, err := newSessionStore(t.TempDir(), time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	sess, err := ss.create("test", "alice", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := ss.get(sess.ID, "bob"); !errors.Is(err, errSessionNotFound) {
+		t.Errorf("expected %v, got %v", errSessionNotFound, err)
+	}
+
+	if _, err := ss.acquire(sess.ID, "bob"); !errors.Is(err, errSessionNotFound) {
+		t.Errorf("expected %v, got %v", errSessionNotFound, err)
+	}
+
+	if list, err := ss.list("bob"); err != nil || len(list) != 0 {
+		t.Errorf("expected no sessions, got %v %v", list, err)
+	}
+
+	if err := ss.delete(sess.ID, "bob"); !errors.Is(err, errSessionNotFound) {
+		t.Errorf("expected %v, got %v", errSessionNotFound, err)
+	}
+
+	// the owner keeps the session across turns
+	if _, err := ss.acquire(sess.ID, "alice"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := ss.release(sess.ID, "alice", api.Message{Role: "user", Content: "Hello!"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if list, err := ss.list("alice"); err != nil || len(list) != 1 || list[0].ID != sess.ID {
+		t.Errorf("expected the session of alice, got %v %v", list, err)
+	}
+}
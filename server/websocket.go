@@ -0,0 +1,342 @@
+package server
+
+import (
+	"bytes"
+	"cmp"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/gorilla/websocket"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/llm"
+)
+
+const (
+	// websocketPingPeriod is how often the server pings a connection
+	websocketPingPeriod = 30 * time.Second
+
+	// websocketPongWait is how long a connection may go without a message
+	// or pong before it is closed
+	websocketPongWait = 2 * websocketPingPeriod
+
+	// websocketWriteWait is how long writing a message may take
+	websocketWriteWait = 10 * time.Second
+)
+
+var upgrader = websocket.Upgrader{
+	// cross-origin connections are already checked by the CORS middleware
+	CheckOrigin: func(*http.Request) bool { return true },
+}
+
+// websocketEndpoints maps the types of WebSocket requests to the endpoints
+// which serve them
+var websocketEndpoints = map[string]string{
+	"chat":     "/api/chat",
+	"generate": "/api/generate",
+}
+
+// websocketRoutes serves requests made over a WebSocket with the same
+// handlers as their HTTP endpoints
+func (s *Server) websocketRoutes() http.Handler {
+	limit := s.rateLimit()
+	inference := s.requireScope(scopeInference)
+	track := s.trackRequest()
+
+	r := gin.New()
+	r.Use(s.auditMiddleware(), metricsMiddleware(), priorityMiddleware())
+	r.POST("/api/chat", inference, limit, track, s.ChatHandler)
+	r.POST("/api/generate", inference, limit, track, s.GenerateHandler)
+	return r
+}
+
+// websocketConn is a WebSocket connection serving several requests at once
+type websocketConn struct {
+	conn    *websocket.Conn
+	handler http.Handler
+
+	// upgrade is the request which opened the connection. Its headers,
+	// including credentials, are sent with each request on the connection.
+	upgrade *http.Request
+
+	// writeMu serializes writes, of which the connection allows one at a time
+	writeMu sync.Mutex
+
+	mu sync.Mutex
+	// active holds the cancel functions of requests in progress by ID
+	active map[string]context.CancelCauseFunc
+	wg     sync.WaitGroup
+}
+
+func (s *Server) WebSocketHandler(c *gin.Context) {
+	conn, err := upgrader.Upgrade(c.Writer, c.Request, nil)
+	if err != nil {
+		// the upgrader has already replied with an error
+		slog.Debug("websocket upgrade failed", "error", err)
+		return
+	}
+	defer conn.Close()
+
+	ws := &websocketConn{
+		conn:    conn,
+		handler: s.websocketRoutes(),
+		upgrade: c.Request,
+		active:  make(map[string]context.CancelCauseFunc),
+	}
+
+	ctx, cancel := context.WithCancel(c.Request.Context())
+	defer cancel()
+
+	go ws.ping(ctx)
+
+	ws.read(ctx)
+
+	// stop the requests still in progress once the client goes away
+	cancel()
+	ws.wg.Wait()
+}
+
+// ping keeps the connection alive until ctx is done
+func (ws *websocketConn) ping(ctx context.Context) {
+	ticker := time.NewTicker(websocketPingPeriod)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			if err := ws.conn.WriteControl(websocket.PingMessage, nil, time.Now().Add(websocketWriteWait)); err != nil {
+				slog.Debug("websocket ping failed", "error", err)
+				return
+			}
+		}
+	}
+}
+
+// read handles messages from the client until the connection is closed
+func (ws *websocketConn) read(ctx context.Context) {
+	ws.conn.SetReadDeadline(time.Now().Add(websocketPongWait))
+	ws.conn.SetPongHandler(func(string) error {
+		return ws.conn.SetReadDeadline(time.Now().Add(websocketPongWait))
+	})
+
+	for {
+		_, data, err := ws.conn.ReadMessage()
+		if err != nil {
+			if websocket.IsUnexpectedCloseError(err, websocket.CloseNormalClosure, websocket.CloseGoingAway) {
+				slog.Debug("websocket read failed", "error", err)
+			}
+			return
+		}
+
+		ws.conn.SetReadDeadline(time.Now().Add(websocketPongWait))
+
+		var req api.WebSocketRequest
+		if err := json.Unmarshal(data, &req); err != nil {
+			ws.write(api.WebSocketResponse{Type: "error", Error: err.Error()})
+			continue
+		}
+
+		switch req.Type {
+		case "ping":
+			ws.write(api.WebSocketResponse{ID: req.ID, Type: "pong"})
+		case "cancel":
+			ws.mu.Lock()
+			cancel, ok := ws.active[req.ID]
+			ws.mu.Unlock()
+
+			if !ok {
+				ws.write(api.WebSocketResponse{ID: req.ID, Type: "error", Error: fmt.Sprintf("request %q not found", req.ID)})
+				continue
+			}
+
+			cancel(llm.ErrCancelled)
+		case "chat", "generate":
+			if err := ws.start(ctx, req); err != nil {
+				ws.write(api.WebSocketResponse{ID: req.ID, Type: "error", Error: err.Error()})
+			}
+		default:
+			ws.write(api.WebSocketResponse{ID: req.ID, Type: "error", Error: fmt.Sprintf("unknown message type %q", req.Type)})
+		}
+	}
+}
+
+// start serves req in the background, streaming its responses to the client
+func (ws *websocketConn) start(ctx context.Context, req api.WebSocketRequest) error {
+	var body any
+	switch {
+	case req.ID == "":
+		return errors.New("missing id")
+	case req.Type == "chat" && req.Chat != nil:
+		body = req.Chat
+	case req.Type == "generate" && req.Generate != nil:
+		body = req.Generate
+	default:
+		return fmt.Errorf("missing %s request", req.Type)
+	}
+
+	b, err := json.Marshal(body)
+	if err != nil {
+		return err
+	}
+
+	ws.mu.Lock()
+	defer ws.mu.Unlock()
+
+	if _, ok := ws.active[req.ID]; ok {
+		return fmt.Errorf("request %q is already in progress", req.ID)
+	}
+
+	ctx, cancel := context.WithCancelCause(ctx)
+	ws.active[req.ID] = cancel
+
+	r, err := http.NewRequestWithContext(ctx, http.MethodPost, websocketEndpoints[req.Type], bytes.NewReader(b))
+	if err != nil {
+		delete(ws.active, req.ID)
+		cancel(nil)
+		return err
+	}
+	r.Header = ws.upgrade.Header.Clone()
+	r.Header.Set("Content-Type", "application/json")
+	r.RemoteAddr = ws.upgrade.RemoteAddr
+
+	ws.wg.Add(1)
+	go func() {
+		defer ws.wg.Done()
+		defer func() {
+			ws.mu.Lock()
+			delete(ws.active, req.ID)
+			ws.mu.Unlock()
+			cancel(nil)
+		}()
+
+		w := &websocketResponseWriter{header: make(http.Header), closed: ctx.Done(), send: func(line []byte, status int) {
+			ws.write(websocketResponse(req.ID, req.Type, line, status))
+		}}
+		ws.handler.ServeHTTP(w, r)
+		w.flush()
+	}()
+
+	return nil
+}
+
+func (ws *websocketConn) write(resp api.WebSocketResponse) {
+	ws.writeMu.Lock()
+	defer ws.writeMu.Unlock()
+
+	ws.conn.SetWriteDeadline(time.Now().Add(websocketWriteWait))
+	if err := ws.conn.WriteJSON(resp); err != nil {
+		slog.Debug("websocket write failed", "error", err)
+	}
+}
+
+// websocketResponse converts a response of the endpoint serving a request
+// of type typ to a WebSocket message
+func websocketResponse(id, typ string, line []byte, status int) api.WebSocketResponse {
+	var e struct {
+		Error string `json:"error"`
+	}
+	if err := json.Unmarshal(line, &e); err != nil {
+		return api.WebSocketResponse{ID: id, Type: "error", Error: fmt.Sprintf("unexpected response: %s", line)}
+	} else if e.Error != "" {
+		return api.WebSocketResponse{ID: id, Type: "error", Error: e.Error}
+	} else if status >= http.StatusBadRequest {
+		return api.WebSocketResponse{ID: id, Type: "error", Error: http.StatusText(status)}
+	}
+
+	resp := api.WebSocketResponse{ID: id, Type: typ}
+
+	var err error
+	switch typ {
+	case "chat":
+		resp.Chat = &api.ChatResponse{}
+		err = json.Unmarshal(line, resp.Chat)
+	case "generate":
+		resp.Generate = &api.GenerateResponse{}
+		err = json.Unmarshal(line, resp.Generate)
+	}
+
+	if err != nil {
+		return api.WebSocketResponse{ID: id, Type: "error", Error: err.Error()}
+	}
+
+	return resp
+}
+
+// websocketResponseWriter passes each line written by a handler, or the
+// whole response if it isn't streamed, to send
+type websocketResponseWriter struct {
+	header http.Header
+	status int
+	buf    bytes.Buffer
+	sent   bool
+	closed <-chan struct{}
+	send   func(line []byte, status int)
+}
+
+func (w *websocketResponseWriter) Header() http.Header {
+	return w.header
+}
+
+func (w *websocketResponseWriter) WriteHeader(status int) {
+	if w.status == 0 {
+		w.status = status
+	}
+}
+
+func (w *websocketResponseWriter) Write(b []byte) (int, error) {
+	w.WriteHeader(http.StatusOK)
+	w.buf.Write(b)
+
+	for {
+		line, err := w.buf.ReadBytes('\n')
+		if err != nil {
+			// keep the incomplete line for the next write
+			w.buf.Write(line)
+			break
+		}
+
+		if line = bytes.TrimSpace(line); len(line) > 0 {
+			w.send(line, w.status)
+			w.sent = true
+		}
+	}
+
+	return len(b), nil
+}
+
+func (w *websocketResponseWriter) Flush() {}
+
+func (w *websocketResponseWriter) CloseNotify() <-chan bool {
+	ch := make(chan bool, 1)
+	go func() {
+		<-w.closed
+		ch <- true
+	}()
+
+	return ch
+}
+
+// flush sends what remains of a response which didn't end with a newline,
+// or an empty response if nothing was written
+func (w *websocketResponseWriter) flush() {
+	line := bytes.TrimSpace(w.buf.Bytes())
+	if len(line) > 0 || !w.sent {
+		if len(line) == 0 {
+			line = []byte("{}")
+		}
+
+		w.send(line, cmp.Or(w.status, http.StatusOK))
+		w.sent = true
+	}
+
+	w.buf.Reset()
+}
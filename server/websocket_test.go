@@ -0,0 +1,154 @@
+package server
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/gin-gonic/gin"
+	"github.com/google/go-cmp/cmp"
+	"github.com/gorilla/websocket"
+
+	"github.com/ollama/ollama/api"
+	"github.com/ollama/ollama/discover"
+	"github.com/ollama/ollama/llm"
+)
+
+func TestWebSocket(t *testing.T) {
+	gin.SetMode(gin.TestMode)
+
+	mock := mockRunner{
+		CompletionResponse: llm.CompletionResponse{
+			Content:    "Hi!",
+			Done:       true,
+			DoneReason: "stop",
+		},
+	}
+
+	s := Server{
+		sched: &Scheduler{
+			pendingReqCh:  make(chan *LlmRequest, 1),
+			finishedReqCh: make(chan *LlmRequest, 1),
+			expiredCh:     make(chan *runnerRef, 1),
+			unloadedCh:    make(chan any, 1),
+			loaded:        make(map[string]*runnerRef),
+			newServerFn:   newMockServer(&mock),
+			getGpuFn:      discover.GetGPUInfo,
+			getCpuFn:      discover.GetCPUInfo,
+			reschedDelay:  250 * time.Millisecond,
+			loadFn: func(req *LlmRequest, ggml *llm.GGML, gpus discover.GpuInfoList, numParallel int) {
+				req.successCh <- &runnerRef{
+					llama: &mock,
+				}
+			},
+		},
+	}
+
+	go s.sched.Run(context.TODO())
+
+	w := createRequest(t, s.CreateHandler, api.CreateRequest{
+		Model: "test",
+		Modelfile: fmt.Sprintf(`FROM %s
+		TEMPLATE """
+{{- if .Prompt }}User: {{ .Prompt }} {{ end }}
+{{- if .Response }}Assistant: {{ .Response }} {{ end }}"""
+`, createBinFile(t, llm.KV{
+			"general.architecture":          "llama",
+			"llama.block_count":             uint32(1),
+			"llama.context_length":          uint32(8192),
+			"llama.embedding_length":        uint32(4096),
+			"llama.attention.head_count":    uint32(32),
+			"llama.attention.head_count_kv": uint32(8),
+			"tokenizer.ggml.tokens":         []string{""},
+			"tokenizer.ggml.scores":         []float32{0},
+			"tokenizer.ggml.token_type":     []int32{0},
+		}, []llm.Tensor{
+			{Name: "token_embd.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+			{Name: "blk.0.attn_norm.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+			{Name: "blk.0.ffn_down.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+			{Name: "blk.0.ffn_gate.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+			{Name: "blk.0.ffn_up.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+			{Name: "blk.0.ffn_norm.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+			{Name: "blk.0.attn_k.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+			{Name: "blk.0.attn_output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+			{Name: "blk.0.attn_q.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+			{Name: "blk.0.attn_v.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+			{Name: "output.weight", Shape: []uint64{1}, WriterTo: bytes.NewReader(make([]byte, 4))},
+		})),
+		Stream: &stream,
+	})
+
+	if w.Code != http.StatusOK {
+		t.Fatalf("expected status 200, got %d", w.Code)
+	}
+
+	srv := httptest.NewServer(s.GenerateRoutes())
+	defer srv.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(srv.URL, "http")+"/api/ws", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	roundTrip := func(req api.WebSocketRequest) api.WebSocketResponse {
+		t.Helper()
+
+		if err := conn.WriteJSON(req); err != nil {
+			t.Fatal(err)
+		}
+
+		var resp api.WebSocketResponse
+		if err := conn.ReadJSON(&resp); err != nil {
+			t.Fatal(err)
+		}
+
+		return resp
+	}
+
+	if diff := cmp.Diff(roundTrip(api.WebSocketRequest{ID: "1", Type: "ping"}), api.WebSocketResponse{ID: "1", Type: "pong"}); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	resp := roundTrip(api.WebSocketRequest{ID: "2", Type: "chat", Chat: &api.ChatRequest{
+		Model:    "test",
+		Messages: []api.Message{{Role: "user", Content: "Hello!"}},
+	}})
+	if resp.ID != "2" || resp.Type != "chat" || resp.Chat == nil {
+		t.Fatalf("unexpected response %+v", resp)
+	}
+
+	if resp.Chat.Message.Content != "Hi!" || !resp.Chat.Done {
+		t.Errorf("unexpected chat response %+v", resp.Chat)
+	}
+
+	if diff := cmp.Diff(mock.CompletionRequest.Prompt, "User: Hello! "); diff != "" {
+		t.Errorf("mismatch (-got +want):\n%s", diff)
+	}
+
+	resp = roundTrip(api.WebSocketRequest{ID: "3", Type: "generate", Generate: &api.GenerateRequest{Model: "test", Prompt: "Hello!"}})
+	if resp.ID != "3" || resp.Type != "generate" || resp.Generate == nil || resp.Generate.Response != "Hi!" {
+		t.Errorf("unexpected response %+v", resp)
+	}
+
+	for _, tt := range []struct {
+		req  api.WebSocketRequest
+		want string
+	}{
+		{api.WebSocketRequest{ID: "4", Type: "chat", Chat: &api.ChatRequest{Model: "missing"}}, `model "missing" not found, try pulling it first`},
+		{api.WebSocketRequest{ID: "5", Type: "chat"}, "missing chat request"},
+		{api.WebSocketRequest{Type: "chat", Chat: &api.ChatRequest{Model: "test"}}, "missing id"},
+		{api.WebSocketRequest{ID: "6", Type: "cancel"}, `request "6" not found`},
+		{api.WebSocketRequest{ID: "7", Type: "embed"}, `unknown message type "embed"`},
+	} {
+		resp := roundTrip(tt.req)
+		if resp.ID != tt.req.ID || resp.Type != "error" || resp.Error != tt.want {
+			t.Errorf("expected error %q, got %+v", tt.want, resp)
+		}
+	}
+}